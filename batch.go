@@ -0,0 +1,38 @@
+package gocan
+
+// Reads up to len(buf) buffered messages into caller owned storage and returns the amount of messages read
+// Uses ReadInto() if the receiver supports it, otherwise falls back to ReadBuffer() and copies the messages into buf
+func ReadInto(r Receiver, buf []Message) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if br, ok := r.(BatchReceiver); ok {
+		return br.ReadInto(buf)
+	}
+
+	limit := len(buf)
+	if limit > 0xFFFF {
+		limit = 0xFFFF
+	}
+	msgs, err := r.ReadBuffer(uint16(limit))
+	for i := range msgs {
+		buf[i] = msgs[i]
+		buf[i].SetData(msgs[i].Data)
+	}
+	return len(msgs), err
+}
+
+// Sends all messages in order and returns the amount of messages sent until an error occurred
+// Uses SendBatch() if the sender supports it, otherwise calls Send() for each message
+func SendBatch(s Sender, msgs []Message) (int, error) {
+	if bs, ok := s.(BatchSender); ok {
+		return bs.SendBatch(msgs)
+	}
+
+	for i := range msgs {
+		if err := s.Send(&msgs[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,182 @@
+package bitfield
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+type ByteOrder uint8
+
+// Byte order of a field
+// Intel fields start with their least significant bit, counted from bit 0 of byte 0 upwards
+// Motorola fields start with their most significant bit, bits are numbered from bit 0 of byte 0 upwards within a byte
+// and continue with the most significant bit of the following byte (DBC numbering)
+const (
+	LittleEndian ByteOrder = iota // Intel
+	BigEndian    ByteOrder = iota // Motorola
+)
+
+// Maximum length of a field in bits
+const MaxLength = 64
+
+// errors
+var (
+	ErrLength      = errors.New("invalid field length")
+	ErrOutOfBounds = errors.New("field exceeds the data")
+)
+
+// Returns the name used by DBC files, "Intel" or "Motorola"
+func (o ByteOrder) String() string {
+	if o == BigEndian {
+		return "Motorola"
+	}
+	return "Intel"
+}
+
+// Returns the position of the bit following pos within a field
+func next(pos int, order ByteOrder) int {
+	if order == LittleEndian {
+		return pos + 1
+	}
+	if pos%8 == 0 {
+		return pos + 15
+	}
+	return pos - 1
+}
+
+// Returns the absolute bit positions (byte*8 + bit) of a field starting with its first bit
+// For Intel fields the first bit is the least significant bit, for Motorola fields the most significant bit
+func Positions(startBit int, length int, order ByteOrder) []int {
+	positions := make([]int, 0, max(length, 0))
+	pos := startBit
+	for i := 0; i < length; i++ {
+		positions = append(positions, pos)
+		pos = next(pos, order)
+	}
+	return positions
+}
+
+// Returns the amount of bytes a field needs, e.g. to determine the length of a message
+func Size(startBit int, length int, order ByteOrder) int {
+	size := 0
+	for _, pos := range Positions(startBit, length, order) {
+		size = max(size, pos/8+1)
+	}
+	return size
+}
+
+// Checks if a field has a valid length and fits into size bytes
+func Check(startBit int, length int, order ByteOrder, size int) error {
+	if length < 1 || length > MaxLength {
+		return fmt.Errorf("%w: %v", ErrLength, length)
+	}
+	if startBit < 0 {
+		return fmt.Errorf("%w: start bit %v", ErrOutOfBounds, startBit)
+	}
+	if need := Size(startBit, length, order); need > size {
+		return fmt.Errorf("%w: field needs %v bytes, data has %v bytes", ErrOutOfBounds, need, size)
+	}
+	return nil
+}
+
+// Reads the raw unsigned value of a field, bits outside of data are read as zero
+func Extract(data []byte, startBit int, length int, order ByteOrder) uint64 {
+	var raw uint64
+	pos := startBit
+	for i := 0; i < length; i++ {
+		var bit uint64
+		if pos >= 0 && pos/8 < len(data) {
+			bit = uint64(data[pos/8]>>(pos%8)) & 1
+		}
+		if order == LittleEndian {
+			raw |= bit << i
+		} else {
+			raw = raw<<1 | bit
+		}
+		pos = next(pos, order)
+	}
+	return raw
+}
+
+// Writes the lowest length bits of value into a field, bits outside of data are ignored
+func Insert(data []byte, startBit int, length int, order ByteOrder, value uint64) {
+	pos := startBit
+	for i := 0; i < length; i++ {
+		shift := i
+		if order == BigEndian {
+			shift = length - 1 - i
+		}
+		if pos >= 0 && pos/8 < len(data) {
+			if value>>shift&1 != 0 {
+				data[pos/8] |= 1 << (pos % 8)
+			} else {
+				data[pos/8] &^= 1 << (pos % 8)
+			}
+		}
+		pos = next(pos, order)
+	}
+}
+
+// Interprets the lowest length bits of raw as two's complement value
+func SignExtend(raw uint64, length int) int64 {
+	if length <= 0 || length >= 64 {
+		return int64(raw)
+	}
+	shift := 64 - length
+	return int64(raw<<shift) >> shift
+}
+
+// Returns the lowest length bits of a signed value in two's complement
+func Truncate(value int64, length int) uint64 {
+	if length >= 64 {
+		return uint64(value)
+	}
+	return uint64(value) & (1<<length - 1)
+}
+
+// Returns the range of raw values of a field
+func Range(length int, signed bool) (int64, uint64) {
+	if length >= 64 {
+		if signed {
+			return math.MinInt64, math.MaxInt64
+		}
+		return 0, math.MaxUint64
+	}
+	if signed {
+		return -(1 << (length - 1)), 1<<(length-1) - 1
+	}
+	return 0, 1<<length - 1
+}
+
+// Reads a float field of 32 or 64 bits
+func ExtractFloat(data []byte, startBit int, length int, order ByteOrder) float64 {
+	raw := Extract(data, startBit, length, order)
+	if length == 32 {
+		return float64(math.Float32frombits(uint32(raw)))
+	}
+	return math.Float64frombits(raw)
+}
+
+// Writes a float field of 32 or 64 bits
+func InsertFloat(data []byte, startBit int, length int, order ByteOrder, value float64) {
+	raw := math.Float64bits(value)
+	if length == 32 {
+		raw = uint64(math.Float32bits(float32(value)))
+	}
+	Insert(data, startBit, length, order, raw)
+}
+
+// Returns true if two fields share a bit
+func Overlaps(startA int, lengthA int, orderA ByteOrder, startB int, lengthB int, orderB ByteOrder) bool {
+	used := make(map[int]bool, lengthA)
+	for _, pos := range Positions(startA, lengthA, orderA) {
+		used[pos] = true
+	}
+	for _, pos := range Positions(startB, lengthB, orderB) {
+		if used[pos] {
+			return true
+		}
+	}
+	return false
+}
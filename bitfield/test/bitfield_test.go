@@ -0,0 +1,107 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/morgadow/gocan/bitfield"
+)
+
+func TestExtractInsert(t *testing.T) {
+	tests := []struct {
+		start  int
+		length int
+		order  bitfield.ByteOrder
+		data   []byte
+		raw    uint64
+	}{
+		{0, 16, bitfield.LittleEndian, []byte{0x34, 0x12, 0, 0}, 0x1234},
+		{8, 12, bitfield.LittleEndian, []byte{0, 0xBC, 0x0A, 0}, 0xABC},
+		{4, 4, bitfield.LittleEndian, []byte{0xA0}, 0xA},
+		{7, 16, bitfield.BigEndian, []byte{0x12, 0x34, 0, 0}, 0x1234},
+		{3, 12, bitfield.BigEndian, []byte{0x0A, 0xBC, 0, 0}, 0xABC},
+		{13, 3, bitfield.BigEndian, []byte{0, 0x38}, 0x7},
+		{0, 64, bitfield.LittleEndian, []byte{8, 7, 6, 5, 4, 3, 2, 1}, 0x0102030405060708},
+		{7, 64, bitfield.BigEndian, []byte{1, 2, 3, 4, 5, 6, 7, 8}, 0x0102030405060708},
+	}
+	for i, tt := range tests {
+		if raw := bitfield.Extract(tt.data, tt.start, tt.length, tt.order); raw != tt.raw {
+			t.Errorf("test %v: got 0x%X, expected 0x%X", i, raw, tt.raw)
+		}
+		data := make([]byte, len(tt.data))
+		bitfield.Insert(data, tt.start, tt.length, tt.order, tt.raw)
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("test %v: got % X, expected % X", i, data, tt.data)
+		}
+	}
+
+	// neighbouring bits are kept
+	data := []byte{0xFF, 0xFF}
+	bitfield.Insert(data, 4, 8, bitfield.LittleEndian, 0)
+	if !bytes.Equal(data, []byte{0x0F, 0xF0}) {
+		t.Errorf("got % X, expected 0F F0", data)
+	}
+}
+
+func TestSigned(t *testing.T) {
+	if v := bitfield.SignExtend(0xFFB, 12); v != -5 {
+		t.Errorf("got %v, expected -5", v)
+	}
+	if v := bitfield.SignExtend(0x7FF, 12); v != 2047 {
+		t.Errorf("got %v, expected 2047", v)
+	}
+	if raw := bitfield.Truncate(-5, 12); raw != 0xFFB {
+		t.Errorf("got 0x%X, expected 0xFFB", raw)
+	}
+	if low, high := bitfield.Range(8, true); low != -128 || high != 127 {
+		t.Errorf("got %v..%v, expected -128..127", low, high)
+	}
+	if low, high := bitfield.Range(12, false); low != 0 || high != 4095 {
+		t.Errorf("got %v..%v, expected 0..4095", low, high)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	data := make([]byte, 12)
+	bitfield.InsertFloat(data, 0, 32, bitfield.LittleEndian, 1.5)
+	bitfield.InsertFloat(data, 39, 64, bitfield.BigEndian, -2.25)
+	if v := bitfield.ExtractFloat(data, 0, 32, bitfield.LittleEndian); v != 1.5 {
+		t.Errorf("got %v, expected 1.5", v)
+	}
+	if v := bitfield.ExtractFloat(data, 39, 64, bitfield.BigEndian); v != -2.25 {
+		t.Errorf("got %v, expected -2.25", v)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	if pos := bitfield.Positions(6, 4, bitfield.BigEndian); len(pos) != 4 || pos[0] != 6 || pos[1] != 5 || pos[2] != 4 || pos[3] != 3 {
+		t.Errorf("got %v, expected [6 5 4 3]", pos)
+	}
+	if pos := bitfield.Positions(1, 3, bitfield.BigEndian); pos[2] != 15 {
+		t.Errorf("got %v, expected [1 0 15]", pos)
+	}
+	if size := bitfield.Size(7, 16, bitfield.BigEndian); size != 2 {
+		t.Errorf("got %v, expected 2", size)
+	}
+	if size := bitfield.Size(60, 8, bitfield.LittleEndian); size != 9 {
+		t.Errorf("got %v, expected 9", size)
+	}
+
+	if !bitfield.Overlaps(0, 8, bitfield.LittleEndian, 7, 2, bitfield.BigEndian) {
+		t.Errorf("expected overlap of bit 7")
+	}
+	if bitfield.Overlaps(0, 8, bitfield.LittleEndian, 15, 8, bitfield.BigEndian) {
+		t.Errorf("expected no overlap")
+	}
+
+	if err := bitfield.Check(60, 8, bitfield.LittleEndian, 8); !errors.Is(err, bitfield.ErrOutOfBounds) {
+		t.Errorf("expected ErrOutOfBounds, got %v", err)
+	}
+	if err := bitfield.Check(0, 65, bitfield.LittleEndian, 16); !errors.Is(err, bitfield.ErrLength) {
+		t.Errorf("expected ErrLength, got %v", err)
+	}
+	if err := bitfield.Check(7, 64, bitfield.BigEndian, 8); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
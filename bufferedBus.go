@@ -0,0 +1,270 @@
+package gocan
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Amount of messages the background reader of a BufferedBus drains from the device in a single call
+const bufferedBusChunkSize = 64
+
+// Receive statistics of a BufferedBus
+type RxStats struct {
+	HardwareOverruns uint64 // overruns reported by the device since creation
+	SoftwareDrops    uint64 // messages dropped since creation because the software buffer was full
+	HighWaterMark    int    // highest amount of messages held in the software buffer at once
+	Buffered         int    // amount of messages currently held in the software buffer
+	Capacity         int    // size of the software buffer
+}
+
+// BufferedBus drains a bus in the background into a software ring buffer, so a slow reader does not overrun the device queue
+// Lost messages are reported once as OverrunError through Recv(), ReadBuffer() and ReadInto()
+type BufferedBus struct {
+	Bus
+
+	mu        sync.Mutex
+	ring      []Message
+	head      int
+	count     int
+	notify    chan struct{}
+	stats     RxStats
+	pendingHW uint64 // hardware overruns not reported to the reader yet
+	pendingSW uint64 // software drops not reported to the reader yet
+	readerErr error  // error which stopped the background reader
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	chunk     []Message // storage of the background reader
+}
+
+// Wraps bus into a BufferedBus with a ring buffer of the given size and starts the background reader
+func NewBufferedBus(bus Bus, size int) *BufferedBus {
+	if size <= 0 {
+		size = 1
+	}
+	b := &BufferedBus{
+		Bus:    bus,
+		ring:   make([]Message, size),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		chunk:  make([]Message, bufferedBusChunkSize),
+	}
+	b.stats.Capacity = size
+	go b.run()
+	return b
+}
+
+// background reader: waits for a message with Recv() and drains all further messages with ReadInto()
+func (b *BufferedBus) run() {
+	defer close(b.done)
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+
+		msg, err := b.Bus.Recv(IterPollTimeout)
+		if msg != nil {
+			b.chunk[0] = *msg
+			b.push(b.chunk[:1])
+		}
+		if err == nil && msg != nil {
+			var n int
+			n, err = ReadInto(b.Bus, b.chunk)
+			b.push(b.chunk[:n])
+		}
+		if err != nil && !b.handleErr(err) {
+			return
+		}
+	}
+}
+
+// Stores the overrun counts of an OverrunError or the error itself if it is fatal, returns false if the reader must stop
+func (b *BufferedBus) handleErr(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.signal()
+
+	var overrun *OverrunError
+	if errors.As(err, &overrun) {
+		b.stats.HardwareOverruns += overrun.Hardware
+		b.pendingHW += overrun.Hardware
+		b.stats.SoftwareDrops += overrun.Software
+		b.pendingSW += overrun.Software
+		return true
+	}
+	b.readerErr = err
+	return false
+}
+
+// Copies messages into the ring buffer, messages not fitting are dropped
+func (b *BufferedBus) push(msgs []Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.signal()
+
+	for i := range msgs {
+		if b.count == len(b.ring) {
+			b.stats.SoftwareDrops++
+			b.pendingSW++
+			continue
+		}
+		slot := &b.ring[(b.head+b.count)%len(b.ring)]
+		*slot = msgs[i]
+		slot.SetData(msgs[i].Data)
+		b.count++
+	}
+	if b.count > b.stats.HighWaterMark {
+		b.stats.HighWaterMark = b.count
+	}
+}
+
+// Wakes up a waiting Recv() call
+func (b *BufferedBus) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns an OverrunError once after messages got lost, or the error which stopped the background reader if the buffer is empty
+// Note: b.mu must be locked
+func (b *BufferedBus) takeErr() error {
+	if b.pendingHW != 0 || b.pendingSW != 0 {
+		err := &OverrunError{Hardware: b.pendingHW, Software: b.pendingSW}
+		b.pendingHW = 0
+		b.pendingSW = 0
+		return err
+	}
+	if b.count == 0 {
+		return b.readerErr
+	}
+	return nil
+}
+
+// Removes the oldest message from the ring buffer and copies it into dst, returns false if the buffer is empty
+// Note: b.mu must be locked
+func (b *BufferedBus) pop(dst *Message) bool {
+	if b.count == 0 {
+		return false
+	}
+	src := &b.ring[b.head]
+	*dst = *src
+	dst.SetData(src.Data)
+	b.head = (b.head + 1) % len(b.ring)
+	b.count--
+	return true
+}
+
+// Returns message from the software buffer
+// timeout: Timeout for receiving message in milliseconds (if set below zero, no timeout is set)
+func (b *BufferedBus) Recv(timeout int) (*Message, error) {
+
+	var timer <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		b.mu.Lock()
+		if err := b.takeErr(); err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+		var msg Message
+		if b.pop(&msg) {
+			b.mu.Unlock()
+			return &msg, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.notify:
+		case <-timer:
+			return nil, nil
+		}
+	}
+}
+
+// Empties the software buffer with an optional message limit
+// If limit is set to zero, no limit will will be used
+func (b *BufferedBus) ReadBuffer(limit uint16) ([]Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.count
+	if limit != 0 && n > int(limit) {
+		n = int(limit)
+	}
+	var msgs []Message
+	if n > 0 {
+		msgs = make([]Message, n)
+		var msg Message
+		for i := range msgs {
+			b.pop(&msg)
+			msgs[i] = msg.Clone()
+		}
+	}
+	return msgs, b.takeErr()
+}
+
+// Reads from the software buffer into caller owned storage until it is empty or buf is full
+func (b *BufferedBus) ReadInto(buf []Message) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for n < len(buf) && b.pop(&buf[n]) {
+		n++
+	}
+	return n, b.takeErr()
+}
+
+// Sends all messages with SendBatch() of the wrapped bus, which would otherwise be hidden by the embedded Bus
+func (b *BufferedBus) SendBatch(msgs []Message) (int, error) {
+	return SendBatch(b.Bus, msgs)
+}
+
+// Returns a snapshot of the receive statistics
+func (b *BufferedBus) Stats() RxStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Buffered = b.count
+	return stats
+}
+
+// Empties the software buffer and resets the device buffers, statistics are kept
+func (b *BufferedBus) Reset() error {
+	b.mu.Lock()
+	b.head = 0
+	b.count = 0
+	b.pendingHW = 0
+	b.pendingSW = 0
+	b.mu.Unlock()
+	return b.Bus.Reset()
+}
+
+// Stops the background reader and disconnects from device
+func (b *BufferedBus) Shutdown() error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return b.Bus.Shutdown()
+}
+
+// Closes the connection, same as Shutdown()
+func (b *BufferedBus) Close() error {
+	return b.Shutdown()
+}
@@ -0,0 +1,431 @@
+package canstruct
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+)
+
+// Name of the struct tag
+const TagName = "can"
+
+// errors
+var (
+	ErrNotStruct  = errors.New("value is not a struct")
+	ErrTag        = errors.New("invalid can tag")
+	ErrOverlap    = errors.New("overlapping fields")
+	ErrOutOfRange = errors.New("value out of range")
+	ErrLength     = errors.New("message too short")
+	ErrFormat     = errors.New("message does not fit the frame format")
+)
+
+// Field of a struct described by a can tag
+//
+// The tag holds comma separated options, e.g. `can:"start=7,len=12,order=motorola,scale=0.1,offset=-40,min=-40,max=100"`
+//   - start: start bit, lsb for Intel and msb for Motorola like in DBC files, default 0
+//   - len: length in bits, default 1 for bool and the size of integer types
+//   - order: intel (default) or motorola, le and be are accepted as well
+//   - scale, offset: physical = raw * scale + offset, default 1 and 0
+//   - signed / unsigned: signedness of the raw value, default from the Go type
+//   - ieee: float32 and float64 fields stored as IEEE 754 value with length 32 or 64
+//   - min, max: allowed range of the physical value
+//
+// Fields without tag or with tag "-" are ignored.
+type Field struct {
+	Name      string
+	StartBit  int
+	Length    int
+	ByteOrder bitfield.ByteOrder
+	Scale     float64
+	Offset    float64
+	Signed    bool
+	IEEE      bool
+	Min       float64
+	Max       float64
+	HasRange  bool // Min and Max are given
+	index     []int
+	kind      reflect.Kind
+}
+
+// Layout of a struct type
+type Layout struct {
+	Type   reflect.Type
+	Fields []*Field
+	Length int // data length in bytes, rounded up to the next valid CAN FD length above 8 bytes
+}
+
+// Parsed layouts by struct type
+var layouts sync.Map
+
+// Returns the layout of a struct type, given as struct value or pointer
+// The layout is validated for invalid tags, fields exceeding 64 bytes and overlapping fields, and cached per type.
+func LayoutOf(v any) (*Layout, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %v", ErrNotStruct, t)
+	}
+	if layout, ok := layouts.Load(t); ok {
+		return layout.(*Layout), nil
+	}
+	layout, err := parseLayout(t)
+	if err != nil {
+		return nil, err
+	}
+	layouts.Store(t, layout)
+	return layout, nil
+}
+
+func parseLayout(t reflect.Type) (*Layout, error) {
+	layout := &Layout{Type: t}
+	size := 0
+	for _, sf := range reflect.VisibleFields(t) {
+		tag, ok := sf.Tag.Lookup(TagName)
+		if !ok || tag == "-" || !sf.IsExported() {
+			continue
+		}
+		f, err := parseField(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range layout.Fields {
+			if bitfield.Overlaps(f.StartBit, f.Length, f.ByteOrder, other.StartBit, other.Length, other.ByteOrder) {
+				return nil, fmt.Errorf("%w: %v.%v (%v|%v) and %v (%v|%v)", ErrOverlap, t.Name(), other.Name, other.StartBit, other.Length,
+					f.Name, f.StartBit, f.Length)
+			}
+		}
+		layout.Fields = append(layout.Fields, f)
+		size = max(size, bitfield.Size(f.StartBit, f.Length, f.ByteOrder))
+	}
+	layout.Length = gocan.LengthFromDLC(gocan.DLCFromLength(size), true)
+	return layout, nil
+}
+
+func parseField(sf reflect.StructField, tag string) (*Field, error) {
+	f := &Field{Name: sf.Name, Scale: 1, ByteOrder: bitfield.LittleEndian, index: sf.Index, kind: sf.Type.Kind()}
+	tagErr := func(format string, args ...any) error {
+		return fmt.Errorf("%w: field %v: %v", ErrTag, sf.Name, fmt.Sprintf(format, args...))
+	}
+
+	switch f.kind {
+	case reflect.Bool:
+		f.Length = 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.Length, f.Signed = sf.Type.Bits(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.Length = sf.Type.Bits()
+	case reflect.Float32, reflect.Float64:
+	default:
+		return nil, tagErr("unsupported type %v", sf.Type)
+	}
+	if f.Length > bitfield.MaxLength {
+		f.Length = bitfield.MaxLength
+	}
+
+	var hasMin, hasMax, hasLength bool
+	for _, option := range strings.Split(tag, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(option), "=")
+		var number float64
+		var err error
+		switch key {
+		case "start", "len":
+			var n int
+			if n, err = strconv.Atoi(value); err == nil && n < 0 {
+				err = errors.New("negative")
+			}
+			if key == "start" {
+				f.StartBit = n
+			} else {
+				f.Length, hasLength = n, true
+			}
+		case "scale", "offset", "min", "max":
+			number, err = strconv.ParseFloat(value, 64)
+			switch key {
+			case "scale":
+				if number == 0 {
+					err = errors.New("zero scale")
+				}
+				f.Scale = number
+			case "offset":
+				f.Offset = number
+			case "min":
+				f.Min, hasMin = number, true
+			case "max":
+				f.Max, hasMax = number, true
+			}
+		case "order":
+			switch strings.ToLower(value) {
+			case "intel", "le", "little":
+				f.ByteOrder = bitfield.LittleEndian
+			case "motorola", "be", "big":
+				f.ByteOrder = bitfield.BigEndian
+			default:
+				err = errors.New("unknown byte order")
+			}
+		case "signed", "unsigned", "ieee":
+			if hasValue {
+				err = errors.New("unexpected value")
+			}
+			switch key {
+			case "signed", "unsigned":
+				f.Signed = key == "signed"
+			case "ieee":
+				f.IEEE = true
+			}
+		case "":
+			continue
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return nil, tagErr("%q: %v", option, err)
+		}
+	}
+
+	if hasMin != hasMax {
+		return nil, tagErr("min and max must be given together")
+	}
+	if f.HasRange = hasMin; f.HasRange && f.Min > f.Max {
+		return nil, tagErr("min %v above max %v", f.Min, f.Max)
+	}
+	if f.IEEE {
+		if f.kind != reflect.Float32 && f.kind != reflect.Float64 {
+			return nil, tagErr("ieee requires a float type")
+		}
+		if !hasLength {
+			f.Length = sf.Type.Bits()
+		}
+		if f.Length != 32 && f.Length != 64 {
+			return nil, tagErr("ieee requires a length of 32 or 64")
+		}
+	}
+	if f.Length == 0 {
+		return nil, tagErr("missing len")
+	}
+	if f.kind == reflect.Bool && (f.Scale != 1 || f.Offset != 0) {
+		return nil, tagErr("bool fields can not be scaled")
+	}
+	if err := bitfield.Check(f.StartBit, f.Length, f.ByteOrder, gocan.MaxDataLength); err != nil {
+		return nil, tagErr("%v", err)
+	}
+	return f, nil
+}
+
+// Encodes a struct into a message with the given id and frame format
+// The id must fit the standard or extended format and layouts longer than 8 bytes need a CAN FD frame. All values are
+// checked against their range and the size of their bit field.
+func Marshal(id gocan.MessageID, isExtended bool, isFD bool, v any) (*gocan.Message, error) {
+	if (!isExtended && id > 0x7FF) || id > 0x1FFFFFFF {
+		return nil, fmt.Errorf("%w: id 0x%X, extended %v", ErrFormat, uint32(id), isExtended)
+	}
+	layout, err := LayoutOf(v)
+	if err != nil {
+		return nil, err
+	}
+	if !isFD && layout.Length > 8 {
+		return nil, fmt.Errorf("%w: %v bytes need a CAN FD frame", ErrFormat, layout.Length)
+	}
+	data := make([]byte, layout.Length)
+	if err := layout.Encode(data, v); err != nil {
+		return nil, err
+	}
+	msg := &gocan.Message{ID: id, IsExtended: isExtended, IsFD: isFD, DLC: gocan.DLCFromLength(layout.Length)}
+	msg.SetData(data)
+	return msg, nil
+}
+
+// Decodes a message into the struct v points to
+func Unmarshal(msg *gocan.Message, v any) error {
+	layout, err := LayoutOf(v)
+	if err != nil {
+		return err
+	}
+	return layout.Decode(msg.Data, v)
+}
+
+// Returns the struct value of v, which must be a struct of the layout type or a pointer to it
+func (l *Layout) value(v any, settable bool) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if settable && rv.Kind() != reflect.Pointer {
+		return reflect.Value{}, fmt.Errorf("%w: expected pointer, got %T", ErrNotStruct, v)
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return reflect.Value{}, fmt.Errorf("%w: nil pointer", ErrNotStruct)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Type() != l.Type {
+		return reflect.Value{}, fmt.Errorf("%w: got %v, expected %v", ErrNotStruct, rv.Type(), l.Type)
+	}
+	return rv, nil
+}
+
+// Encodes the fields of v into data, which must hold at least Length bytes
+func (l *Layout) Encode(data []byte, v any) error {
+	rv, err := l.value(v, false)
+	if err != nil {
+		return err
+	}
+	if len(data) < l.Length {
+		return fmt.Errorf("%w: got %v bytes, expected %v", ErrLength, len(data), l.Length)
+	}
+	for _, f := range l.Fields {
+		raw, err := f.toRaw(rv.FieldByIndex(f.index))
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", l.Type.Name(), f.Name, err)
+		}
+		bitfield.Insert(data, f.StartBit, f.Length, f.ByteOrder, raw)
+	}
+	return nil
+}
+
+// Decodes data into the fields of the struct v points to, data must cover all fields
+func (l *Layout) Decode(data []byte, v any) error {
+	rv, err := l.value(v, true)
+	if err != nil {
+		return err
+	}
+	for _, f := range l.Fields {
+		if bitfield.Size(f.StartBit, f.Length, f.ByteOrder) > len(data) {
+			return fmt.Errorf("%w: %v.%v needs %v bytes, got %v", ErrLength, l.Type.Name(), f.Name,
+				bitfield.Size(f.StartBit, f.Length, f.ByteOrder), len(data))
+		}
+		if err := f.fromRaw(rv.FieldByIndex(f.index), bitfield.Extract(data, f.StartBit, f.Length, f.ByteOrder)); err != nil {
+			return fmt.Errorf("%v.%v: %w", l.Type.Name(), f.Name, err)
+		}
+	}
+	return nil
+}
+
+// Converts the physical value of a field into its raw bits
+func (f *Field) toRaw(fv reflect.Value) (uint64, error) {
+	var phys float64
+	switch f.kind {
+	case reflect.Bool:
+		if fv.Bool() {
+			phys = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// integers without scaling are converted exactly
+		if f.Scale == 1 && f.Offset == 0 && !f.HasRange {
+			return f.checkInt(fv.Int())
+		}
+		phys = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f.Scale == 1 && f.Offset == 0 && !f.HasRange && !f.Signed {
+			if _, high := bitfield.Range(f.Length, false); fv.Uint() > high {
+				return 0, fmt.Errorf("%w: %v does not fit into %v bits", ErrOutOfRange, fv.Uint(), f.Length)
+			}
+			return fv.Uint(), nil
+		}
+		phys = float64(fv.Uint())
+	default:
+		phys = fv.Float()
+	}
+
+	if f.HasRange && (phys < f.Min || phys > f.Max) {
+		return 0, fmt.Errorf("%w: %v, allowed %v to %v", ErrOutOfRange, phys, f.Min, f.Max)
+	}
+	value := (phys - f.Offset) / f.Scale
+	if f.IEEE {
+		if f.Length == 32 {
+			return uint64(math.Float32bits(float32(value))), nil
+		}
+		return math.Float64bits(value), nil
+	}
+	value = math.Round(value)
+	low, high := bitfield.Range(f.Length, f.Signed)
+	if math.IsNaN(value) || value < float64(low) || value > float64(high) {
+		return 0, fmt.Errorf("%w: %v does not fit into %v bits", ErrOutOfRange, phys, f.Length)
+	}
+	if f.Signed {
+		return bitfield.Truncate(int64(value), f.Length), nil
+	}
+	return uint64(value), nil
+}
+
+// Checks an unscaled integer against the size of the field
+func (f *Field) checkInt(v int64) (uint64, error) {
+	low, high := bitfield.Range(f.Length, f.Signed)
+	if v < low || (v >= 0 && uint64(v) > high) {
+		return 0, fmt.Errorf("%w: %v does not fit into %v bits", ErrOutOfRange, v, f.Length)
+	}
+	return bitfield.Truncate(v, f.Length), nil
+}
+
+// Sets the field from its raw bits
+func (f *Field) fromRaw(fv reflect.Value, raw uint64) error {
+	if f.Scale == 1 && f.Offset == 0 && !f.IEEE {
+		switch f.kind {
+		case reflect.Bool:
+			fv.SetBool(raw != 0)
+			return nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v := int64(raw)
+			if f.Signed {
+				v = bitfield.SignExtend(raw, f.Length)
+			}
+			if fv.OverflowInt(v) {
+				return fmt.Errorf("%w: %v does not fit into %v", ErrOutOfRange, v, fv.Type())
+			}
+			fv.SetInt(v)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if f.Signed {
+				v := bitfield.SignExtend(raw, f.Length)
+				if v < 0 {
+					return fmt.Errorf("%w: %v does not fit into %v", ErrOutOfRange, v, fv.Type())
+				}
+				raw = uint64(v)
+			}
+			if fv.OverflowUint(raw) {
+				return fmt.Errorf("%w: %v does not fit into %v", ErrOutOfRange, raw, fv.Type())
+			}
+			fv.SetUint(raw)
+			return nil
+		}
+	}
+
+	var value float64
+	switch {
+	case f.IEEE && f.Length == 32:
+		value = float64(math.Float32frombits(uint32(raw)))
+	case f.IEEE:
+		value = math.Float64frombits(raw)
+	case f.Signed:
+		value = float64(bitfield.SignExtend(raw, f.Length))
+	default:
+		value = float64(raw)
+	}
+	phys := value*f.Scale + f.Offset
+
+	switch f.kind {
+	case reflect.Bool:
+		fv.SetBool(phys != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v := int64(math.Round(phys))
+		if fv.OverflowInt(v) {
+			return fmt.Errorf("%w: %v does not fit into %v", ErrOutOfRange, phys, fv.Type())
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v := math.Round(phys)
+		if v < 0 || fv.OverflowUint(uint64(v)) {
+			return fmt.Errorf("%w: %v does not fit into %v", ErrOutOfRange, phys, fv.Type())
+		}
+		fv.SetUint(uint64(v))
+	default:
+		fv.SetFloat(phys)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/canstruct"
+)
+
+type auxEngine struct {
+	Speed   float64 `can:"start=0,len=16,scale=0.01,min=0,max=655.35"`
+	Temp    int     `can:"start=16,len=8,offset=-40,signed"`
+	Rpm     float32 `can:"start=31,len=16,order=motorola,scale=0.25"`
+	State   uint8   `can:"start=40,len=2"`
+	Active  bool    `can:"start=42"`
+	Counter int8    `can:"start=60,len=4"`
+	Note    string  // ignored
+	Skipped int     `can:"-"`
+}
+
+type auxFD struct {
+	Value float64 `can:"start=0,ieee"`
+	Small float32 `can:"start=64,ieee"`
+	Last  uint8   `can:"start=96"`
+}
+
+func TestMarshal(t *testing.T) {
+	in := auxEngine{Speed: 46.6, Temp: -80, Rpm: 1000, State: 2, Active: true, Counter: -2, Note: "x", Skipped: 5}
+	msg, err := canstruct.Marshal(0x100, false, false, &in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []byte{0x34, 0x12, 0xD8, 0x0F, 0xA0, 0x06, 0, 0xE0}
+	if msg.ID != 0x100 || msg.IsExtended || msg.IsFD || msg.DLC != 8 || !bytes.Equal(msg.Data, expected) {
+		t.Errorf("got %+v, expected data % X", msg, expected)
+	}
+
+	var out auxEngine
+	if err := canstruct.Unmarshal(msg, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	in.Note, in.Skipped = "", 0
+	if out != in {
+		t.Errorf("got %+v, expected %+v", out, in)
+	}
+
+	// struct values are accepted for marshaling
+	if msg, err := canstruct.Marshal(0x12345, true, false, in); err != nil || !msg.IsExtended {
+		t.Errorf("got %+v, %v, expected extended message", msg, err)
+	}
+	if msg, err := canstruct.Marshal(0x100, true, false, in); err != nil || !msg.IsExtended {
+		t.Errorf("got %+v, %v, expected extended message with a low id", msg, err)
+	}
+	if _, err := canstruct.Marshal(0x800, false, false, in); !errors.Is(err, canstruct.ErrFormat) {
+		t.Errorf("expected ErrFormat for an extended id in standard format, got %v", err)
+	}
+	if _, err := canstruct.Marshal(0x20000000, true, false, in); !errors.Is(err, canstruct.ErrFormat) {
+		t.Errorf("expected ErrFormat for an id above 29 bit, got %v", err)
+	}
+}
+
+func TestMarshalFD(t *testing.T) {
+	msg, err := canstruct.Marshal(0x200, false, true, &auxFD{Value: -2.5, Small: 1.5, Last: 7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 13 bytes are needed, rounded up to the next CAN FD length
+	if !msg.IsFD || len(msg.Data) != 16 || msg.DLC != 10 || msg.Data[12] != 7 {
+		t.Errorf("got %+v, expected CAN FD message with 16 bytes", msg)
+	}
+	var out auxFD
+	if err := canstruct.Unmarshal(msg, &out); err != nil || out.Value != -2.5 || out.Small != 1.5 || out.Last != 7 {
+		t.Errorf("got %+v, %v", out, err)
+	}
+
+	// short layouts may be sent as CAN FD frame, long layouts need one
+	if msg, err := canstruct.Marshal(0x100, false, true, &auxEngine{}); err != nil || !msg.IsFD || msg.DLC != 8 {
+		t.Errorf("got %+v, %v, expected CAN FD message with 8 bytes", msg, err)
+	}
+	if _, err := canstruct.Marshal(0x200, false, false, &auxFD{}); !errors.Is(err, canstruct.ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+
+	short := &gocan.Message{ID: 0x200, Data: make([]byte, 8)}
+	if err := canstruct.Unmarshal(short, &out); !errors.Is(err, canstruct.ErrLength) {
+		t.Errorf("expected ErrLength, got %v", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []auxEngine{
+		{Speed: 700},
+		{Speed: -1},
+		{Temp: 100},
+		{State: 4},
+		{Counter: 8},
+	}
+	for i, tt := range tests {
+		if _, err := canstruct.Marshal(0x100, false, false, &tt); !errors.Is(err, canstruct.ErrOutOfRange) {
+			t.Errorf("test %v: expected ErrOutOfRange, got %v", i, err)
+		}
+	}
+}
+
+func TestLayoutErrors(t *testing.T) {
+	type overlap struct {
+		A uint8 `can:"start=0"`
+		B uint8 `can:"start=7,len=2"`
+	}
+	_, err := canstruct.Marshal(1, false, false, &overlap{})
+	if !errors.Is(err, canstruct.ErrOverlap) || !strings.Contains(err.Error(), "A (0|8) and B (7|2)") {
+		t.Errorf("expected ErrOverlap naming A and B, got %v", err)
+	}
+
+	tests := []any{
+		&struct {
+			A float64 `can:"start=0"`
+		}{},
+		&struct {
+			A uint8 `can:"start=510"`
+		}{},
+		&struct {
+			A uint8 `can:"order=mixed"`
+		}{},
+		&struct {
+			A uint16 `can:"min=1"`
+		}{},
+		&struct {
+			A int `can:"ieee"`
+		}{},
+		&struct {
+			A string `can:"start=0"`
+		}{},
+	}
+	for i, tt := range tests {
+		if _, err := canstruct.Marshal(1, false, false, tt); !errors.Is(err, canstruct.ErrTag) {
+			t.Errorf("test %v: expected ErrTag, got %v", i, err)
+		}
+	}
+
+	if _, err := canstruct.Marshal(1, false, false, 5); !errors.Is(err, canstruct.ErrNotStruct) {
+		t.Errorf("expected ErrNotStruct, got %v", err)
+	}
+	if err := canstruct.Unmarshal(&gocan.Message{Data: make([]byte, 8)}, auxEngine{}); !errors.Is(err, canstruct.ErrNotStruct) {
+		t.Errorf("expected ErrNotStruct, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/morgadow/gocan/dbc"
+	"github.com/morgadow/gocan/dbc/codegen"
+)
+
+// Generates typed Go structs from a DBC file, usable with go:generate:
+//
+//	//go:generate go run github.com/morgadow/gocan/cmd/dbcgen -in vehicle.dbc -out vehicle_gen.go -pkg vehicle
+func main() {
+	in := flag.String("in", "", "DBC file to read")
+	out := flag.String("out", "", "Go file to write, stdout if empty")
+	pkg := flag.String("pkg", "messages", "package name of the generated file")
+	messages := flag.String("messages", "", "comma separated names of the messages to generate, all if empty")
+	flag.Parse()
+
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "usage: dbcgen -in file.dbc [-out file.go] [-pkg name] [-messages A,B]")
+		os.Exit(2)
+	}
+	if err := run(*in, *out, *pkg, *messages); err != nil {
+		fmt.Fprintf(os.Stderr, "dbcgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, pkg, messages string) error {
+	db, err := dbc.ParseFile(in)
+	if err != nil {
+		return err
+	}
+	for _, warning := range db.Warnings {
+		fmt.Fprintf(os.Stderr, "dbcgen: skipped: %v\n", warning)
+	}
+	cfg := &codegen.Config{Package: pkg, Source: filepath.Base(in)}
+	if messages != "" {
+		cfg.Messages = strings.Split(messages, ",")
+	}
+	src, err := codegen.Generate(db, cfg)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
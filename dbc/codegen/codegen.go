@@ -0,0 +1,563 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+	"github.com/morgadow/gocan/dbc"
+)
+
+// errors
+var (
+	ErrNoMessages     = errors.New("no messages to generate")
+	ErrUnknownMessage = errors.New("unknown message")
+	ErrNameCollision  = errors.New("generated names collide")
+)
+
+// Config of the generated code
+type Config struct {
+	Package  string   // package name of the generated file, default "messages"
+	Source   string   // name of the DBC file mentioned in the header
+	Messages []string // names of the messages to generate, all if empty
+}
+
+// Kind of the Go field of a signal
+type kind uint8
+
+const (
+	kindFloat kind = iota // float64 holding the physical value
+	kindInt   kind = iota // integer holding the physical value, factor and offset are integers
+	kindRaw   kind = iota // integer holding the raw value, factor 1 and offset 0
+	kindBool  kind = iota // bool of a single bit without scaling
+	kindEnum  kind = iota // enum type holding the raw value
+)
+
+// Generated field of a signal
+type field struct {
+	signal *dbc.Signal
+	name   string // Go field name
+	kind   kind
+	typ    string // Go type
+}
+
+type generator struct {
+	sb    strings.Builder
+	names map[string]string // generated identifiers and their origin
+}
+
+// Generates the Go source of typed structs for the messages of a database
+// Every message gets a struct with one field per signal, the constants <Name>ID, <Name>Extended, <Name>Length and
+// <Name>CycleTime, a constructor setting the start values and the methods Marshal, Unmarshal and Validate.
+// Messages marked as unsupported by the parser are skipped unless they are selected by name, which fails.
+// Signals with value descriptions get an enum type holding the raw value. The generated code only depends on gocan
+// and gocan/bitfield.
+func Generate(db *dbc.Database, cfg *Config) ([]byte, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	pkg := cfg.Package
+	if pkg == "" {
+		pkg = "messages"
+	}
+
+	var messages []*dbc.Message
+	if len(cfg.Messages) > 0 {
+		for _, name := range cfg.Messages {
+			m, ok := db.MessageByName(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: %v", ErrUnknownMessage, name)
+			}
+			if m.Unsupported != nil {
+				return nil, m.Unsupported
+			}
+			messages = append(messages, m)
+		}
+	} else {
+		for _, m := range db.Messages {
+			if m.Unsupported == nil {
+				messages = append(messages, m)
+			}
+		}
+	}
+	if len(messages) == 0 {
+		return nil, ErrNoMessages
+	}
+
+	g := &generator{names: make(map[string]string)}
+	source := ""
+	if cfg.Source != "" {
+		source = " from " + cfg.Source
+	}
+	g.printf("// Code generated by dbcgen%v. DO NOT EDIT.\n\n", source)
+	g.printf("package %v\n\n", pkg)
+	g.printf("import (\n\"errors\"\n\"fmt\"\n\"math\"\n\"time\"\n\n\"github.com/morgadow/gocan\"\n\"github.com/morgadow/gocan/bitfield\"\n)\n\n")
+	g.printHelpers()
+
+	for _, m := range messages {
+		if err := g.message(m); err != nil {
+			return nil, err
+		}
+	}
+
+	src, err := format.Source([]byte(g.sb.String()))
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.sb, format, args...)
+}
+
+// Registers an identifier, identifiers generated twice from different origins are an error
+func (g *generator) register(name, origin string) error {
+	if other, ok := g.names[name]; ok && other != origin {
+		return fmt.Errorf("%w: %v from %v and %v", ErrNameCollision, name, other, origin)
+	}
+	g.names[name] = origin
+	return nil
+}
+
+// Prints the errors and conversion helpers shared by all messages
+func (g *generator) printHelpers() {
+	g.printf(`// errors
+var (
+	ErrID         = errors.New("unexpected message id")
+	ErrLength     = errors.New("message too short")
+	ErrOutOfRange = errors.New("value out of range")
+)
+
+// Converts a physical value into the raw bits of an integer signal
+func toRaw(name string, value, factor, offset float64, length int, signed bool) (uint64, error) {
+	raw := math.Round((value - offset) / factor)
+	low, high := bitfield.Range(length, signed)
+	if raw < float64(low) || raw > float64(high) {
+		return 0, fmt.Errorf("%%w: %%v = %%v does not fit into %%v bits", ErrOutOfRange, name, value, length)
+	}
+	if signed {
+		return bitfield.Truncate(int64(raw), length), nil
+	}
+	return uint64(raw), nil
+}
+
+// Converts the raw bits of an integer signal into the physical value
+func fromRaw(raw uint64, factor, offset float64, length int, signed bool) float64 {
+	if signed {
+		return float64(bitfield.SignExtend(raw, length))*factor + offset
+	}
+	return float64(raw)*factor + offset
+}
+
+// Checks a raw integer value against the size of a signal
+func checkRaw(name string, value int64, length int, signed bool) (uint64, error) {
+	low, high := bitfield.Range(length, signed)
+	if value < low || (value >= 0 && uint64(value) > high) {
+		return 0, fmt.Errorf("%%w: %%v = %%v does not fit into %%v bits", ErrOutOfRange, name, value, length)
+	}
+	return bitfield.Truncate(value, length), nil
+}
+
+// Checks a physical value against the range of a signal
+func checkRange(name string, value, min, max float64) error {
+	if value < min || value > max {
+		return fmt.Errorf("%%w: %%v = %%v, allowed %%v to %%v", ErrOutOfRange, name, value, min, max)
+	}
+	return nil
+}
+
+`)
+}
+
+// Converts a DBC name into an exported Go identifier, e.g. "engine_speed" into "EngineSpeed"
+func exportedName(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	result := sb.String()
+	if result == "" || unicode.IsDigit(rune(result[0])) {
+		result = "X" + result
+	}
+	return result
+}
+
+// Returns true if v is an integer
+func isInteger(v float64) bool {
+	return v == math.Trunc(v) && !math.IsInf(v, 0)
+}
+
+// Returns the smallest integer type holding the range
+func intType(low, high float64) string {
+	if low >= 0 {
+		for _, bits := range []int{8, 16, 32} {
+			if high <= float64(uint64(1)<<bits-1) {
+				return fmt.Sprintf("uint%v", bits)
+			}
+		}
+		return "uint64"
+	}
+	for _, bits := range []int{8, 16, 32} {
+		if low >= -float64(int64(1)<<(bits-1)) && high <= float64(int64(1)<<(bits-1)-1) {
+			return fmt.Sprintf("int%v", bits)
+		}
+	}
+	return "int64"
+}
+
+// Returns the field of a signal
+func newField(msgName string, s *dbc.Signal) field {
+	f := field{signal: s, name: exportedName(s.Name)}
+	if slices.Contains([]string{"Marshal", "Unmarshal", "Validate"}, f.name) {
+		f.name += "Value"
+	}
+	low, high := bitfield.Range(s.Length, s.Signed)
+	rawLow, rawHigh := float64(low), float64(high)
+	switch {
+	case s.Type != dbc.Integer:
+		f.kind, f.typ = kindFloat, "float64"
+	case len(s.Values) > 0:
+		f.kind, f.typ = kindEnum, msgName+f.name
+	case s.Factor == 1 && s.Offset == 0 && s.Length == 1 && !s.Signed:
+		f.kind, f.typ = kindBool, "bool"
+	case s.Factor == 1 && s.Offset == 0:
+		f.kind, f.typ = kindRaw, intType(rawLow, rawHigh)
+	case isInteger(s.Factor) && isInteger(s.Offset) && s.Factor != 0 && s.Length < 53:
+		physLow, physHigh := rawLow*s.Factor+s.Offset, rawHigh*s.Factor+s.Offset
+		if physLow > physHigh {
+			physLow, physHigh = physHigh, physLow
+		}
+		f.kind, f.typ = kindInt, intType(physLow, physHigh)
+	default:
+		f.kind, f.typ = kindFloat, "float64"
+	}
+	return f
+}
+
+// Returns the name of the bitfield byte order constant
+func orderName(order bitfield.ByteOrder) string {
+	if order == bitfield.BigEndian {
+		return "bitfield.BigEndian"
+	}
+	return "bitfield.LittleEndian"
+}
+
+// Formats a float constant
+func number(v float64) string {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// Formats a comment on a single line
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (g *generator) message(m *dbc.Message) error {
+	name := exportedName(m.Name)
+	for _, ident := range []string{name, name + "ID", name + "Extended", name + "Length", name + "CycleTime", "New" + name} {
+		if err := g.register(ident, m.Name); err != nil {
+			return err
+		}
+	}
+
+	fields := make([]field, len(m.Signals))
+	for i, s := range m.Signals {
+		fields[i] = newField(name, s)
+		if err := g.register(name+"."+fields[i].name, m.Name+"."+s.Name); err != nil {
+			return err
+		}
+	}
+
+	// enums
+	for _, f := range fields {
+		if f.kind != kindEnum {
+			continue
+		}
+		if err := g.register(f.typ, m.Name+"."+f.signal.Name); err != nil {
+			return err
+		}
+		low, high := bitfield.Range(f.signal.Length, f.signal.Signed)
+		g.printf("// Raw values of %v.%v\n", m.Name, f.signal.Name)
+		g.printf("type %v %v\n\n", f.typ, intType(float64(low), float64(high)))
+		keys := make([]int64, 0, len(f.signal.Values))
+		for k := range f.signal.Values {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		g.printf("const (\n")
+		for _, k := range keys {
+			if k < low || (k >= 0 && uint64(k) > high) {
+				// descriptions of values the signal cannot hold are skipped
+				continue
+			}
+			constName := f.typ + exportedName(f.signal.Values[k])
+			if _, ok := g.names[constName]; ok || exportedName(f.signal.Values[k]) == "X" {
+				constName = fmt.Sprintf("%v%v", f.typ, strings.ReplaceAll(strconv.FormatInt(k, 10), "-", "Minus"))
+			}
+			if err := g.register(constName, m.Name+"."+f.signal.Name); err != nil {
+				return err
+			}
+			g.printf("%v %v = %v // %v\n", constName, f.typ, k, strconv.Quote(f.signal.Values[k]))
+		}
+		g.printf(")\n\n")
+	}
+
+	// constants
+	comment := m.Name
+	if m.Comment != "" {
+		comment += ": " + oneLine(m.Comment)
+	}
+	g.printf("// Frame of %v\n", comment)
+	g.printf("const (\n")
+	g.printf("%vID gocan.MessageID = 0x%X\n", name, uint32(m.ID))
+	g.printf("%vExtended = %v\n", name, m.IsExtended)
+	g.printf("%vLength = %v\n", name, m.Length)
+	if m.CycleTime > 0 {
+		g.printf("%vCycleTime = %v * time.Millisecond\n", name, m.CycleTime.Milliseconds())
+	} else {
+		g.printf("%vCycleTime time.Duration = 0 // event message\n", name)
+	}
+	g.printf(")\n\n")
+
+	// struct
+	g.printf("// %v\n", comment)
+	g.printf("type %v struct {\n", name)
+	for _, f := range fields {
+		g.printf("%v %v", f.name, f.typ)
+		var notes []string
+		s := f.signal
+		if f.kind == kindEnum {
+			notes = append(notes, "raw value")
+		}
+		if s.Unit != "" {
+			notes = append(notes, s.Unit)
+		}
+		if s.Min != 0 || s.Max != 0 {
+			notes = append(notes, fmt.Sprintf("%v to %v", strconv.FormatFloat(s.Min, 'g', -1, 64), strconv.FormatFloat(s.Max, 'g', -1, 64)))
+		}
+		if s.IsMultiplexer {
+			notes = append(notes, "multiplexer")
+		}
+		if s.IsMultiplexed {
+			notes = append(notes, fmt.Sprintf("present if multiplexer is %v", s.MuxValue))
+		}
+		if s.Comment != "" {
+			notes = append(notes, oneLine(s.Comment))
+		}
+		if len(notes) > 0 {
+			g.printf(" // %v", strings.Join(notes, ", "))
+		}
+		g.printf("\n")
+	}
+	g.printf("}\n\n")
+
+	g.constructor(name, fields)
+	g.marshal(name, m, fields)
+	g.unmarshal(name, m, fields)
+
+	g.printf("// Checks all values against their range and size\n")
+	g.printf("func (m *%v) Validate() error {\n_, err := m.Marshal()\nreturn err\n}\n\n", name)
+	return nil
+}
+
+// Prints the constructor setting the start values
+func (g *generator) constructor(name string, fields []field) {
+	g.printf("// Creates a new %v with the start values of all signals\n", name)
+	g.printf("func New%v() *%v {\n", name, name)
+	g.printf("m := &%v{}\n", name)
+	for _, f := range fields {
+		s := f.signal
+		if s.StartValue == 0 {
+			continue
+		}
+		switch f.kind {
+		case kindFloat:
+			g.printf("m.%v = %v\n", f.name, number(s.StartValue*s.Factor+s.Offset))
+		case kindInt:
+			g.printf("m.%v = %v\n", f.name, int64(s.StartValue*s.Factor+s.Offset))
+		case kindBool:
+			g.printf("m.%v = true\n", f.name)
+		default:
+			g.printf("m.%v = %v\n", f.name, int64(s.StartValue))
+		}
+	}
+	g.printf("return m\n}\n\n")
+}
+
+// Returns the multiplexer field or nil
+func multiplexer(fields []field) *field {
+	for i := range fields {
+		if fields[i].signal.IsMultiplexer {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// Prints the encoding of a field into data, the raw value is checked against the range and the size
+func (g *generator) encodeField(f field, msgName string) {
+	s := f.signal
+	qualified := strconv.Quote(msgName + "." + s.Name)
+	insert := func(raw string) {
+		g.printf("bitfield.Insert(data, %v, %v, %v, %v)\n", s.StartBit, s.Length, orderName(s.ByteOrder), raw)
+	}
+	value := "m." + f.name
+	if f.kind == kindInt {
+		value = "float64(" + value + ")"
+	}
+	if s.Min != 0 || s.Max != 0 {
+		if f.kind == kindFloat || f.kind == kindInt {
+			g.printf("if err := checkRange(%v, %v, %v, %v); err != nil {\nreturn nil, err\n}\n",
+				qualified, value, number(s.Min), number(s.Max))
+		}
+	}
+	switch {
+	case s.Type == dbc.Float32:
+		g.printf("bitfield.InsertFloat(data, %v, 32, %v, float64(float32((m.%v - %v) / %v)))\n",
+			s.StartBit, orderName(s.ByteOrder), f.name, number(s.Offset), number(s.Factor))
+	case s.Type == dbc.Float64:
+		g.printf("bitfield.InsertFloat(data, %v, 64, %v, (m.%v - %v) / %v)\n",
+			s.StartBit, orderName(s.ByteOrder), f.name, number(s.Offset), number(s.Factor))
+	case f.kind == kindBool:
+		g.printf("if m.%v {\n", f.name)
+		insert("1")
+		g.printf("}\n")
+	case f.kind == kindFloat || f.kind == kindInt:
+		g.printf("if raw, err = toRaw(%v, %v, %v, %v, %v, %v); err != nil {\nreturn nil, err\n}\n",
+			qualified, value, number(s.Factor), number(s.Offset), s.Length, s.Signed)
+		insert("raw")
+	case strings.HasPrefix(f.typ, "uint") || (f.kind == kindEnum && !s.Signed):
+		if !slices.Contains([]int{8, 16, 32, 64}, s.Length) {
+			g.printf("if uint64(m.%v) > %v {\nreturn nil, fmt.Errorf(\"%%w: %%v = %%v does not fit into %v bits\", ErrOutOfRange, %v, m.%v)\n}\n",
+				f.name, uint64(1)<<s.Length-1, s.Length, qualified, f.name)
+		}
+		insert(fmt.Sprintf("uint64(m.%v)", f.name))
+	default:
+		g.printf("if raw, err = checkRaw(%v, int64(m.%v), %v, %v); err != nil {\nreturn nil, err\n}\n",
+			qualified, f.name, s.Length, s.Signed)
+		insert("raw")
+	}
+}
+
+// Returns true if the encoding of a field converts the value over the variable raw
+func usesRaw(f field) bool {
+	s := f.signal
+	return s.Type == dbc.Integer && (f.kind == kindFloat || f.kind == kindInt || ((f.kind == kindRaw || f.kind == kindEnum) && s.Signed))
+}
+
+// Prints the expression of the raw value of a multiplexer field
+func (g *generator) muxRaw(f *field, msgName string, prefix string) {
+	s := f.signal
+	switch f.kind {
+	case kindFloat, kindInt:
+		g.printf("mux, err := toRaw(%v, float64(m.%v), %v, %v, %v, %v)\nif err != nil {\nreturn %verr\n}\n",
+			strconv.Quote(msgName+"."+s.Name), f.name, number(s.Factor), number(s.Offset), s.Length, s.Signed, prefix)
+	case kindBool:
+		g.printf("mux := uint64(0)\nif m.%v {\nmux = 1\n}\n", f.name)
+	default:
+		if s.Signed {
+			g.printf("mux := bitfield.Truncate(int64(m.%v), %v)\n", f.name, s.Length)
+		} else {
+			g.printf("mux := uint64(m.%v)\n", f.name)
+		}
+	}
+}
+
+// Returns the raw multiplexer value of a multiplexed signal as seen by the generated code
+func muxCompare(s *dbc.Signal, mux *field) string {
+	if mux.signal.Signed {
+		return fmt.Sprintf("%v", bitfield.Truncate(int64(s.MuxValue), mux.signal.Length))
+	}
+	return fmt.Sprintf("%v", s.MuxValue)
+}
+
+// Prints the Marshal method
+func (g *generator) marshal(name string, m *dbc.Message, fields []field) {
+	g.printf("// Encodes the physical values into a message ready to be sent, multiplexed signals are only written if the multiplexer matches\n")
+	g.printf("func (m *%v) Marshal() (*gocan.Message, error) {\n", name)
+	g.printf("data := make([]byte, %vLength)\n", name)
+	if slices.ContainsFunc(fields, usesRaw) {
+		g.printf("var raw uint64\nvar err error\n")
+	}
+	mux := multiplexer(fields)
+	if mux != nil {
+		g.muxRaw(mux, m.Name, "nil, ")
+	}
+	for _, f := range fields {
+		if f.signal.IsMultiplexed && mux != nil {
+			g.printf("if mux == %v {\n", muxCompare(f.signal, mux))
+			g.encodeField(f, m.Name)
+			g.printf("}\n")
+			continue
+		}
+		g.encodeField(f, m.Name)
+	}
+	g.printf("msg := &gocan.Message{ID: %vID, IsExtended: %vExtended, IsFD: %v, DLC: %v}\n",
+		name, name, m.IsFD || m.Length > 8, gocan.DLCFromLength(m.Length))
+	g.printf("msg.SetData(data)\nreturn msg, nil\n}\n\n")
+}
+
+// Prints the decoding of a field from data
+func (g *generator) decodeField(f field) {
+	s := f.signal
+	extract := fmt.Sprintf("bitfield.Extract(msg.Data, %v, %v, %v)", s.StartBit, s.Length, orderName(s.ByteOrder))
+	switch {
+	case s.Type != dbc.Integer:
+		g.printf("m.%v = bitfield.ExtractFloat(msg.Data, %v, %v, %v)*%v + %v\n",
+			f.name, s.StartBit, s.Length, orderName(s.ByteOrder), number(s.Factor), number(s.Offset))
+	case f.kind == kindBool:
+		g.printf("m.%v = %v == 1\n", f.name, extract)
+	case f.kind == kindFloat:
+		g.printf("m.%v = fromRaw(%v, %v, %v, %v, %v)\n", f.name, extract, number(s.Factor), number(s.Offset), s.Length, s.Signed)
+	case f.kind == kindInt:
+		g.printf("m.%v = %v(math.Round(fromRaw(%v, %v, %v, %v, %v)))\n", f.name, f.typ, extract, number(s.Factor), number(s.Offset), s.Length, s.Signed)
+	case s.Signed:
+		g.printf("m.%v = %v(bitfield.SignExtend(%v, %v))\n", f.name, f.typ, extract, s.Length)
+	default:
+		g.printf("m.%v = %v(%v)\n", f.name, f.typ, extract)
+	}
+}
+
+// Prints the Unmarshal method
+func (g *generator) unmarshal(name string, m *dbc.Message, fields []field) {
+	g.printf("// Decodes a received message, multiplexed signals not present are set to zero\n")
+	g.printf("func (m *%v) Unmarshal(msg *gocan.Message) error {\n", name)
+	g.printf("if msg.ID != %vID || msg.IsExtended != %vExtended {\nreturn fmt.Errorf(\"%%w: got 0x%%X, expected 0x%%X\", ErrID, uint32(msg.ID), uint32(%vID))\n}\n", name, name, name)
+	g.printf("if len(msg.Data) < %vLength {\nreturn fmt.Errorf(\"%%w: got %%v bytes, expected %%v\", ErrLength, len(msg.Data), %vLength)\n}\n", name, name)
+	g.printf("*m = %v{}\n", name)
+	mux := multiplexer(fields)
+	if mux != nil {
+		g.printf("mux := bitfield.Extract(msg.Data, %v, %v, %v)\n", mux.signal.StartBit, mux.signal.Length, orderName(mux.signal.ByteOrder))
+	}
+	for _, f := range fields {
+		if f.signal.IsMultiplexed && mux != nil {
+			g.printf("if mux == %v {\n", muxCompare(f.signal, mux))
+			g.decodeField(f)
+			g.printf("}\n")
+			continue
+		}
+		g.decodeField(f)
+	}
+	g.printf("return nil\n}\n\n")
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/morgadow/gocan/dbc"
+	"github.com/morgadow/gocan/dbc/codegen"
+)
+
+const auxDBC = `VERSION ""
+
+BU_: ECU Tester
+
+BO_ 256 Engine: 8 ECU
+ SG_ Speed : 0|16@1+ (0.01,0) [0|655.35] "km/h" Tester
+ SG_ Temp : 16|8@1- (1,-40) [-80|87] "degC" Tester
+ SG_ Rpm : 31|16@0+ (0.25,0) [0|16383.75] "rpm" Tester
+ SG_ State : 40|2@1+ (1,0) [0|3] "" Tester
+ SG_ Active : 42|1@1+ (1,0) [0|1] "" Tester
+
+BO_ 2147484672 Diag: 8 Tester
+ SG_ Mode M : 0|8@1+ (1,0) [0|255] "" ECU
+ SG_ ValueA m1 : 8|16@1+ (0.5,0) [0|1000] "V" ECU
+ SG_ ValueB m2 : 15|16@0- (1,0) [-100|100] "A" ECU
+
+BO_ 512 Floats: 12 ECU
+ SG_ F32 : 0|32@1+ (1,0) [0|0] "" Tester
+ SG_ F64 : 32|64@1+ (2,1) [0|0] "" Tester
+
+CM_ BO_ 256 "Engine status";
+BA_DEF_ BO_ "GenMsgCycleTime" INT 0 10000;
+BA_DEF_ SG_ "GenSigStartValue" INT 0 65535;
+BA_ "GenMsgCycleTime" BO_ 256 100;
+BA_ "GenSigStartValue" SG_ 256 Temp 40;
+VAL_ 256 State 0 "Off" 1 "On" 2 "Error" 3 "Not available" ;
+SIG_VALTYPE_ 512 F32 : 1;
+SIG_VALTYPE_ 512 F64 : 2;
+`
+
+// Program using the generated code, its output is compared
+const auxMain = `package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func main() {
+	e := NewEngine()
+	fmt.Println(e.Temp)
+	e.Speed, e.Temp, e.Rpm, e.State = 46.6, -80, 1000, EngineStateError
+	msg, err := e.Marshal()
+	fmt.Printf("% X %v %v\n", msg.Data, msg.DLC, err)
+
+	var d Engine
+	err = d.Unmarshal(msg)
+	fmt.Println(d.Speed, d.Temp, d.Rpm, d.State == EngineStateError, d.Active, err)
+
+	e.Speed = 700
+	fmt.Println(errors.Is(e.Validate(), ErrOutOfRange))
+	e.Speed = 0
+	e.State = 4
+	fmt.Println(errors.Is(e.Validate(), ErrOutOfRange))
+
+	diag := Diag{Mode: 2, ValueB: -5}
+	msg, _ = diag.Marshal()
+	fmt.Printf("% X %v\n", msg.Data, msg.IsExtended)
+	var dd Diag
+	fmt.Println(dd.Unmarshal(msg), dd.Mode, dd.ValueB)
+	fmt.Println(errors.Is(d.Unmarshal(msg), ErrID))
+
+	f := Floats{F32: 1.5, F64: 7}
+	msg, _ = f.Marshal()
+	var df Floats
+	fmt.Println(len(msg.Data), msg.DLC, msg.IsFD, df.Unmarshal(msg), df.F32, df.F64)
+
+	fmt.Println(EngineID, EngineLength, EngineCycleTime, DiagExtended, DiagCycleTime)
+}
+`
+
+const auxExpected = `0
+34 12 D8 0F A0 02 00 00 8 <nil>
+46.6 -80 1000 true false <nil>
+true
+true
+02 FF FB 00 00 00 00 00 true
+<nil> 2 -5
+true
+12 9 true <nil> 1.5 7
+256 8 100ms true 0s
+`
+
+func auxParse(t *testing.T) *dbc.Database {
+	db, err := dbc.Parse(strings.NewReader(auxDBC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return db
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := codegen.Generate(auxParse(t), &codegen.Config{Package: "vehicle", Source: "vehicle.dbc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"// Code generated by dbcgen from vehicle.dbc. DO NOT EDIT.",
+		"package vehicle",
+		"= 100 * time.Millisecond",
+		"EngineStateNotAvailable EngineState = 3",
+		"Temp   int16",
+		"Active bool",
+		"func (m *Diag) Unmarshal(msg *gocan.Message) error",
+	} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("missing %q in generated code", expected)
+		}
+	}
+	if strings.Contains(string(src), "gocan/dbc") {
+		t.Errorf("generated code depends on package dbc")
+	}
+
+	if _, err := codegen.Generate(auxParse(t), &codegen.Config{Messages: []string{"Gearbox"}}); !errors.Is(err, codegen.ErrUnknownMessage) {
+		t.Errorf("expected ErrUnknownMessage, got %v", err)
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	db, err := dbc.Parse(strings.NewReader(auxDBC + "BO_ 1 Extended: 8 ECU\n SG_ A M : 0|8@1+ (1,0) [0|0] \"\" Tester\n SG_ B m1M : 8|8@1+ (1,0) [0|0] \"\" Tester\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	src, err := codegen.Generate(db, nil)
+	if err != nil || strings.Contains(string(src), "type Extended struct") || !strings.Contains(string(src), "type Engine struct") {
+		t.Errorf("expected the unsupported message to be skipped, got %v", err)
+	}
+	if _, err := codegen.Generate(db, &codegen.Config{Messages: []string{"Extended"}}); !errors.Is(err, dbc.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestGeneratedCode(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("go command not available")
+	}
+	src, err := codegen.Generate(auxParse(t), &codegen.Config{Package: "main"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the program is built inside the module to resolve the gocan imports
+	dir, err := os.MkdirTemp(".", "generated")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "messages.go"), src, 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(auxMain), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := exec.Command(goBin, "run", "./"+filepath.Base(dir)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running generated code: %v\n%s", err, out)
+	}
+	if string(out) != auxExpected {
+		t.Errorf("got\n%s\nexpected\n%s", out, auxExpected)
+	}
+}
@@ -0,0 +1,380 @@
+package dbc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+)
+
+type ValueType uint8
+
+// Value type of a signal
+const (
+	Integer ValueType = iota // signed or unsigned integer
+	Float32 ValueType = iota // IEEE 754 single precision, length 32
+	Float64 ValueType = iota // IEEE 754 double precision, length 64
+)
+
+// Flag of extended ids in DBC message ids
+const ExtendedFlag = 0x80000000
+
+// Attributes holding the cycle time, the frame format and the initial value of signals
+const (
+	AttrCycleTime   = "GenMsgCycleTime"
+	AttrFrameFormat = "VFrameFormat"
+	AttrStartValue  = "GenSigStartValue"
+)
+
+// errors
+var (
+	ErrSyntax         = errors.New("invalid DBC syntax")
+	ErrUnknownMessage = errors.New("unknown message")
+	ErrUnknownSignal  = errors.New("unknown signal")
+	ErrOutOfRange     = errors.New("value out of range")
+	ErrMultiplexer    = errors.New("multiplexer value missing")
+	ErrUnsupported    = errors.New("unsupported DBC feature")
+)
+
+// Database holds all definitions of a DBC file
+type Database struct {
+	Version       string
+	Nodes         []*Node
+	Messages      []*Message
+	ValueTables   map[string]map[int64]string // VAL_TABLE_ definitions by name
+	AttributeDefs []*AttributeDef
+	Attributes    map[string]any // network attributes, values are float64 or string
+	Comment       string
+	Warnings      []error // problems found by Parse which only affect single messages, e.g. unsupported features
+}
+
+// Node of the network
+type Node struct {
+	Name       string
+	Comment    string
+	Attributes map[string]any
+}
+
+// Message definition
+type Message struct {
+	ID          gocan.MessageID
+	IsExtended  bool
+	IsFD        bool // set by the attribute VFrameFormat
+	Name        string
+	Length      int // length in bytes
+	Sender      string
+	Signals     []*Signal
+	CycleTime   time.Duration // set by the attribute GenMsgCycleTime, zero for event messages
+	Comment     string
+	Attributes  map[string]any
+	Unsupported error // set by Parse for messages using unsupported features like extended multiplexing or signals exceeding the message length, wraps ErrUnsupported
+}
+
+// Signal definition
+type Signal struct {
+	Name          string
+	StartBit      int // DBC start bit, lsb for Intel and msb for Motorola
+	Length        int
+	ByteOrder     bitfield.ByteOrder
+	Signed        bool
+	Type          ValueType
+	Factor        float64
+	Offset        float64
+	Min           float64
+	Max           float64 // no range check if Min and Max are both zero
+	Unit          string
+	Receivers     []string
+	IsMultiplexer bool             // M, selects the active multiplexed signals, also set for m<value>M of extended multiplexing
+	IsMultiplexed bool             // m<value>, only present if the multiplexer equals MuxValue
+	MuxValue      uint64           // value of the multiplexer for a multiplexed signal
+	StartValue    float64          // raw initial value, set by the attribute GenSigStartValue
+	Values        map[int64]string // value descriptions of raw values
+	Comment       string
+	Attributes    map[string]any
+}
+
+// Object type of an attribute definition
+const (
+	ObjectNetwork = ""
+	ObjectNode    = "BU_"
+	ObjectMessage = "BO_"
+	ObjectSignal  = "SG_"
+	ObjectEnvVar  = "EV_"
+)
+
+// Attribute definition (BA_DEF_)
+type AttributeDef struct {
+	Object  string // ObjectNetwork, ObjectNode, ObjectMessage, ObjectSignal or ObjectEnvVar
+	Name    string
+	Type    string // INT, HEX, FLOAT, STRING or ENUM
+	Min     float64
+	Max     float64
+	Enum    []string
+	Default any // float64 or string, nil if not defined
+}
+
+// Decoded value of a signal
+type Value struct {
+	Signal      *Signal
+	Raw         uint64  // raw bits of the signal
+	Physical    float64 // raw * factor + offset
+	Description string  // value description of the raw value, empty if none
+}
+
+// Returns the message with the given id
+func (db *Database) MessageByID(id gocan.MessageID, isExtended bool) (*Message, bool) {
+	for _, m := range db.Messages {
+		if m.ID == id && m.IsExtended == isExtended {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the message with the given name
+func (db *Database) MessageByName(name string) (*Message, bool) {
+	for _, m := range db.Messages {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the node with the given name
+func (db *Database) Node(name string) (*Node, bool) {
+	for _, n := range db.Nodes {
+		if n.Name == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the attribute definition with the given name
+func (db *Database) AttributeDef(name string) (*AttributeDef, bool) {
+	for _, def := range db.AttributeDefs {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+// Decodes all active signals of a received message
+func (db *Database) Decode(msg *gocan.Message) (*Message, []Value, error) {
+	m, ok := db.MessageByID(msg.ID, msg.IsExtended)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: 0x%X", ErrUnknownMessage, uint32(msg.ID))
+	}
+	return m, m.Decode(msg.Data), nil
+}
+
+// Encodes physical signal values into a message of the given name
+func (db *Database) Encode(name string, values map[string]float64) (*gocan.Message, error) {
+	m, ok := db.MessageByName(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownMessage, name)
+	}
+	return m.Encode(values)
+}
+
+// Returns the signal with the given name
+func (m *Message) Signal(name string) (*Signal, bool) {
+	for _, s := range m.Signals {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the multiplexer signal or nil if the message is not multiplexed
+// Only simple multiplexing with a single multiplexer is supported, Parse marks messages with extended multiplexing
+// as unsupported
+func (m *Message) Multiplexer() *Signal {
+	for _, s := range m.Signals {
+		if s.IsMultiplexer {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns true if a signal is present for the given multiplexer value
+func (s *Signal) active(mux uint64, hasMux bool) bool {
+	return !s.IsMultiplexed || (hasMux && s.MuxValue == mux)
+}
+
+// Decodes all signals present in data, multiplexed signals are only decoded if their multiplexer value matches
+// Multiplexed signals of unsupported messages are never decoded
+func (m *Message) Decode(data []byte) []Value {
+	var mux uint64
+	muxSignal := m.Multiplexer()
+	if muxSignal != nil && m.Unsupported == nil {
+		mux = muxSignal.Raw(data)
+	} else {
+		muxSignal = nil
+	}
+
+	values := make([]Value, 0, len(m.Signals))
+	for _, s := range m.Signals {
+		if !s.active(mux, muxSignal != nil) {
+			continue
+		}
+		raw := s.Raw(data)
+		values = append(values, Value{Signal: s, Raw: raw, Physical: s.Physical(raw), Description: s.Values[s.rawInt(raw)]})
+	}
+	return values
+}
+
+// Decodes all signals present in data into a map of physical values by signal name
+func (m *Message) DecodeMap(data []byte) map[string]float64 {
+	values := make(map[string]float64, len(m.Signals))
+	for _, v := range m.Decode(data) {
+		values[v.Signal.Name] = v.Physical
+	}
+	return values
+}
+
+// Encodes physical signal values into a message ready to be sent
+// Signals without a value are set to their start value, multiplexed signals are only written if their multiplexer
+// value matches, which must be given if any multiplexed signal is given
+// Unsupported messages are not encoded
+func (m *Message) Encode(values map[string]float64) (*gocan.Message, error) {
+	if m.Unsupported != nil {
+		return nil, m.Unsupported
+	}
+	data := make([]byte, m.Length)
+
+	var mux uint64
+	muxSignal := m.Multiplexer()
+	hasMux := false
+	if muxSignal != nil {
+		if phys, ok := values[muxSignal.Name]; ok {
+			raw, err := muxSignal.ToRaw(phys)
+			if err != nil {
+				return nil, err
+			}
+			mux, hasMux = raw, true
+		}
+	}
+
+	for name := range values {
+		s, ok := m.Signal(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v.%v", ErrUnknownSignal, m.Name, name)
+		}
+		if s.IsMultiplexed && !hasMux {
+			return nil, fmt.Errorf("%w: %v.%v", ErrMultiplexer, m.Name, name)
+		}
+	}
+
+	for _, s := range m.Signals {
+		if !s.active(mux, hasMux) {
+			continue
+		}
+		phys, ok := values[s.Name]
+		if !ok {
+			s.insert(data, s.startRaw())
+			continue
+		}
+		raw, err := s.ToRaw(phys)
+		if err != nil {
+			return nil, err
+		}
+		s.insert(data, raw)
+	}
+
+	msg := &gocan.Message{ID: m.ID, IsExtended: m.IsExtended, IsFD: m.IsFD || m.Length > 8, DLC: gocan.DLCFromLength(m.Length)}
+	msg.SetData(data)
+	return msg, nil
+}
+
+// Returns the raw bits of the signal in data
+func (s *Signal) Raw(data []byte) uint64 {
+	return bitfield.Extract(data, s.StartBit, s.Length, s.ByteOrder)
+}
+
+// Returns the raw value as integer, considering signedness
+func (s *Signal) rawInt(raw uint64) int64 {
+	if s.Signed {
+		return bitfield.SignExtend(raw, s.Length)
+	}
+	return int64(raw)
+}
+
+// Converts raw bits into the physical value
+func (s *Signal) Physical(raw uint64) float64 {
+	var value float64
+	switch {
+	case s.Type == Float32:
+		value = float64(math.Float32frombits(uint32(raw)))
+	case s.Type == Float64:
+		value = math.Float64frombits(raw)
+	case s.Signed:
+		value = float64(bitfield.SignExtend(raw, s.Length))
+	default:
+		value = float64(raw)
+	}
+	return value*s.Factor + s.Offset
+}
+
+// Converts a physical value into the raw bits, the value must be within Min and Max and fit into the signal
+func (s *Signal) ToRaw(phys float64) (uint64, error) {
+	if (s.Min != 0 || s.Max != 0) && (phys < s.Min-s.tolerance() || phys > s.Max+s.tolerance()) {
+		return 0, fmt.Errorf("%w: %v = %v, allowed %v to %v", ErrOutOfRange, s.Name, phys, s.Min, s.Max)
+	}
+	factor := s.Factor
+	if factor == 0 {
+		factor = 1
+	}
+	value := (phys - s.Offset) / factor
+
+	switch s.Type {
+	case Float32:
+		return uint64(math.Float32bits(float32(value))), nil
+	case Float64:
+		return math.Float64bits(value), nil
+	}
+
+	value = math.Round(value)
+	low, high := bitfield.Range(s.Length, s.Signed)
+	if value < float64(low) || value > float64(high) {
+		return 0, fmt.Errorf("%w: %v = %v does not fit into %v bits", ErrOutOfRange, s.Name, phys, s.Length)
+	}
+	if s.Signed {
+		return bitfield.Truncate(int64(value), s.Length), nil
+	}
+	return uint64(value), nil
+}
+
+// Allowed deviation from Min and Max caused by floating point rounding
+func (s *Signal) tolerance() float64 {
+	return 1e-9 * max(1, math.Abs(s.Min), math.Abs(s.Max))
+}
+
+// Returns the raw start value
+func (s *Signal) startRaw() uint64 {
+	if s.Signed {
+		return bitfield.Truncate(int64(s.StartValue), s.Length)
+	}
+	return uint64(s.StartValue)
+}
+
+func (s *Signal) insert(data []byte, raw uint64) {
+	bitfield.Insert(data, s.StartBit, s.Length, s.ByteOrder, raw)
+}
+
+// Returns the description of a physical value from the value descriptions, e.g. "Off"
+func (s *Signal) Describe(phys float64) (string, bool) {
+	raw, err := s.ToRaw(phys)
+	if err != nil {
+		return "", false
+	}
+	desc, ok := s.Values[s.rawInt(raw)]
+	return desc, ok
+}
@@ -0,0 +1,845 @@
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+)
+
+type tokenKind uint8
+
+// Kinds of tokens of a DBC file
+const (
+	tokIdent  tokenKind = iota // keywords, names and numbers
+	tokString tokenKind = iota // quoted string without quotes
+	tokPunct  tokenKind = iota // single character : ; | @ + - ( ) [ ] ,
+	tokEOF    tokenKind = iota
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// Splits a DBC file into tokens
+func tokenize(r io.Reader) ([]token, error) {
+	br := bufio.NewReader(r)
+	var tokens []token
+	line := 1
+	afterWord := false // the previous character ended a name or number
+	for {
+		wasAfterWord := afterWord
+		afterWord = false
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return append(tokens, token{kind: tokEOF, line: line}), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case c == '\n':
+			line++
+		case unicode.IsSpace(c) || c == '\uFEFF':
+		case c == '"':
+			var sb strings.Builder
+			start := line
+			for {
+				c, _, err = br.ReadRune()
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %w: unterminated string", start, ErrSyntax)
+				}
+				if c == '\\' {
+					next, _, err := br.ReadRune()
+					if err != nil {
+						return nil, fmt.Errorf("line %v: %w: unterminated string", start, ErrSyntax)
+					}
+					if next != '"' && next != '\\' {
+						sb.WriteRune(c)
+					}
+					c = next
+				} else if c == '"' {
+					break
+				}
+				if c == '\n' {
+					line++
+				}
+				sb.WriteRune(c)
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), line: start})
+		case c == '/' && peekRune(br) == '/':
+			// comment until end of line
+			for c != '\n' {
+				if c, _, err = br.ReadRune(); err != nil {
+					break
+				}
+			}
+			line++
+		case c == '-' && !wasAfterWord && (unicode.IsDigit(peekRune(br)) || peekRune(br) == '.'):
+			// a '-' directly after a word separates a range like "1-5"
+			tokens = append(tokens, token{kind: tokIdent, text: "-" + readWord(br), line: line})
+			afterWord = true
+		case strings.ContainsRune(":;|@+-()[],", c):
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), line: line})
+		default:
+			br.UnreadRune()
+			word := readWord(br)
+			if word == "" {
+				return nil, fmt.Errorf("line %v: %w: unexpected character %q", line, ErrSyntax, c)
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: word, line: line})
+			afterWord = true
+		}
+	}
+}
+
+func peekRune(br *bufio.Reader) rune {
+	c, _, err := br.ReadRune()
+	if err != nil {
+		return 0
+	}
+	br.UnreadRune()
+	return c
+}
+
+// Reads a name or number, numbers may hold a signed exponent like 1E+05
+func readWord(br *bufio.Reader) string {
+	var sb strings.Builder
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			return sb.String()
+		}
+		isExpSign := (c == '+' || c == '-') && sb.Len() > 0 && isNumberWithExp(sb.String())
+		if !isExpSign && !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' {
+			br.UnreadRune()
+			return sb.String()
+		}
+		sb.WriteRune(c)
+	}
+}
+
+// Returns true for a number ending with an exponent marker, e.g. "1.5E"
+func isNumberWithExp(s string) bool {
+	last := s[len(s)-1]
+	if last != 'e' && last != 'E' {
+		return false
+	}
+	_, err := strconv.ParseFloat(strings.TrimPrefix(s[:len(s)-1], "-"), 64)
+	return err == nil
+}
+
+// parser state over all tokens of a file
+type parser struct {
+	tokens []token
+	pos    int
+	db     *Database
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %v: %w: %v", p.peek().line, ErrSyntax, fmt.Sprintf(format, args...))
+}
+
+// Consumes a punctuation token
+func (p *parser) expect(punct string) error {
+	if t := p.peek(); t.kind != tokPunct || t.text != punct {
+		return p.errorf("expected %q, got %q", punct, t.text)
+	}
+	p.next()
+	return nil
+}
+
+// Consumes a punctuation token if present
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == punct {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf("expected name, got %q", t.text)
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *parser) str() (string, error) {
+	t := p.peek()
+	if t.kind != tokString {
+		return "", p.errorf("expected string, got %q", t.text)
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *parser) number() (float64, error) {
+	t := p.peek()
+	sign := 1.0
+	if t.kind == tokPunct && (t.text == "-" || t.text == "+") {
+		if t.text == "-" {
+			sign = -1
+		}
+		p.next()
+		t = p.peek()
+	}
+	if t.kind != tokIdent {
+		return 0, p.errorf("expected number, got %q", t.text)
+	}
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", t.text)
+	}
+	p.next()
+	return sign * v, nil
+}
+
+func (p *parser) integer() (int64, error) {
+	t := p.peek()
+	v, err := strconv.ParseInt(t.text, 10, 64)
+	if err != nil {
+		if u, errU := strconv.ParseUint(t.text, 10, 64); errU == nil {
+			p.next()
+			return int64(u), nil
+		}
+		return 0, p.errorf("invalid integer %q", t.text)
+	}
+	p.next()
+	return v, nil
+}
+
+// Skips all tokens of a statement until its terminating semicolon
+func (p *parser) skipStatement() {
+	for t := p.next(); t.kind != tokEOF && !(t.kind == tokPunct && t.text == ";"); t = p.next() {
+	}
+}
+
+// Skips the remaining tokens of a line
+func (p *parser) skipLine(line int) {
+	for p.peek().kind != tokEOF && p.peek().line == line {
+		p.next()
+	}
+}
+
+// Parses a DBC database
+func Parse(r io.Reader) (*Database, error) {
+	tokens, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, db: &Database{ValueTables: make(map[string]map[int64]string), Attributes: make(map[string]any)}}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	p.db.resolve()
+	return p.db, nil
+}
+
+// Parses a DBC file
+func ParseFile(path string) (*Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func (p *parser) parse() error {
+	var msg *Message
+	for {
+		t := p.next()
+		if t.kind == tokEOF {
+			return nil
+		}
+		if t.kind != tokIdent {
+			return fmt.Errorf("line %v: %w: unexpected %q", t.line, ErrSyntax, t.text)
+		}
+
+		var err error
+		switch t.text {
+		case "VERSION":
+			p.db.Version, err = p.str()
+		case "NS_":
+			// list of new symbols until the bit timing section
+			for p.peek().kind != tokEOF && p.peek().text != "BS_" {
+				p.next()
+			}
+		case "BS_":
+			p.skipLine(t.line)
+		case "BU_":
+			err = p.parseNodes(t.line)
+		case "BO_":
+			msg, err = p.parseMessage(t.line)
+		case "SG_":
+			if msg == nil {
+				return fmt.Errorf("line %v: %w: signal without message", t.line, ErrSyntax)
+			}
+			err = p.parseSignal(msg, t.line)
+		case "VAL_TABLE_":
+			err = p.parseValueTable()
+		case "VAL_":
+			err = p.parseValues()
+		case "CM_":
+			err = p.parseComment()
+		case "BA_DEF_", "BA_DEF_REL_":
+			err = p.parseAttributeDef(t.text == "BA_DEF_REL_")
+		case "BA_DEF_DEF_", "BA_DEF_DEF_REL_":
+			err = p.parseAttributeDefault()
+		case "BA_":
+			err = p.parseAttribute()
+		case "SIG_VALTYPE_":
+			err = p.parseValueType()
+		case "SG_MUL_VAL_":
+			err = p.parseMuxValues(t.line)
+		default:
+			// e.g. EV_, BO_TX_BU_, BA_REL_, SIG_GROUP_
+			p.skipStatement()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// BU_: node node ...
+func (p *parser) parseNodes(line int) error {
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+	for p.peek().kind == tokIdent && p.peek().line == line {
+		p.db.Nodes = append(p.db.Nodes, &Node{Name: p.next().text, Attributes: make(map[string]any)})
+	}
+	return nil
+}
+
+// BO_ id name: length sender
+func (p *parser) parseMessage(line int) (*Message, error) {
+	id, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Attributes: make(map[string]any)}
+	m.ID = gocan.MessageID(uint32(id) &^ ExtendedFlag)
+	m.IsExtended = uint32(id)&ExtendedFlag != 0
+	if m.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	length, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+	m.Length = int(length)
+	if p.peek().kind == tokIdent && p.peek().line == line {
+		m.Sender = p.next().text
+	}
+	p.db.Messages = append(p.db.Messages, m)
+	return m, nil
+}
+
+// SG_ name [M|m<value>] : start|length@order sign (factor,offset) [min|max] "unit" receiver,receiver
+func (p *parser) parseSignal(m *Message, line int) error {
+	s := &Signal{Factor: 1, Attributes: make(map[string]any)}
+	var err error
+	if s.Name, err = p.ident(); err != nil {
+		return err
+	}
+	if t := p.peek(); t.kind == tokIdent {
+		p.next()
+		mux := t.text
+		if strings.HasSuffix(mux, "M") {
+			if strings.HasPrefix(mux, "m") {
+				// extended multiplexing with a multiplexed multiplexer, e.g. "m1M"
+				p.unsupported(m, line, "extended multiplexing, signal %v is a multiplexed multiplexer", s.Name)
+			} else if other := m.Multiplexer(); other != nil {
+				p.unsupported(m, line, "extended multiplexing, message %v has the multiplexers %v and %v", m.Name, other.Name, s.Name)
+			}
+			s.IsMultiplexer = true
+			mux = strings.TrimSuffix(mux, "M")
+		}
+		if strings.HasPrefix(mux, "m") {
+			value, err := strconv.ParseUint(mux[1:], 10, 64)
+			if err != nil {
+				return p.errorf("invalid multiplexer %q", t.text)
+			}
+			s.IsMultiplexed = true
+			s.MuxValue = value
+		} else if mux != "" {
+			return p.errorf("invalid multiplexer %q", t.text)
+		}
+	}
+	if err := p.expect(":"); err != nil {
+		return err
+	}
+
+	start, err := p.integer()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("|"); err != nil {
+		return err
+	}
+	length, err := p.integer()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("@"); err != nil {
+		return err
+	}
+	order, err := p.integer()
+	if err != nil {
+		return err
+	}
+	s.StartBit, s.Length = int(start), int(length)
+	switch order {
+	case 0:
+		s.ByteOrder = bitfield.BigEndian
+	case 1:
+		s.ByteOrder = bitfield.LittleEndian
+	default:
+		return p.errorf("invalid byte order %v", order)
+	}
+	switch {
+	case p.accept("-"):
+		s.Signed = true
+	case p.accept("+"):
+	default:
+		return p.errorf("expected sign, got %q", p.peek().text)
+	}
+
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if s.Factor, err = p.number(); err != nil {
+		return err
+	}
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	if s.Offset, err = p.number(); err != nil {
+		return err
+	}
+	if err := p.expect(")"); err != nil {
+		return err
+	}
+	if err := p.expect("["); err != nil {
+		return err
+	}
+	if s.Min, err = p.number(); err != nil {
+		return err
+	}
+	if err := p.expect("|"); err != nil {
+		return err
+	}
+	if s.Max, err = p.number(); err != nil {
+		return err
+	}
+	if err := p.expect("]"); err != nil {
+		return err
+	}
+	if s.Unit, err = p.str(); err != nil {
+		return err
+	}
+	for p.peek().line == line && p.peek().kind != tokEOF {
+		if t := p.next(); t.kind == tokIdent && t.text != "Vector__XXX" {
+			s.Receivers = append(s.Receivers, t.text)
+		}
+	}
+	// some files hold signals exceeding the message length, such messages are kept as unsupported,
+	// only signals exceeding a CAN FD frame are rejected
+	if err := bitfield.Check(s.StartBit, s.Length, s.ByteOrder, max(m.Length, gocan.MaxDataLength)); err != nil {
+		return fmt.Errorf("line %v: %w: signal %v: %w", line, ErrSyntax, s.Name, err)
+	}
+	if m.Length > 0 {
+		if err := bitfield.Check(s.StartBit, s.Length, s.ByteOrder, m.Length); err != nil {
+			p.unsupported(m, line, "signal %v exceeds the message length of %v bytes: %v", s.Name, m.Length, err)
+		}
+	}
+	m.Signals = append(m.Signals, s)
+	return nil
+}
+
+// Parses value descriptions "value "description" ..." until the semicolon
+func (p *parser) parseDescriptions() (map[int64]string, error) {
+	values := make(map[int64]string)
+	for !p.accept(";") {
+		v, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		desc, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		values[int64(v)] = desc
+	}
+	return values, nil
+}
+
+// VAL_TABLE_ name value "description" ... ;
+func (p *parser) parseValueTable() error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	values, err := p.parseDescriptions()
+	if err != nil {
+		return err
+	}
+	p.db.ValueTables[name] = values
+	return nil
+}
+
+// VAL_ id signal value "description" ... ; or VAL_ envvar value "description" ... ;
+func (p *parser) parseValues() error {
+	first := p.peek()
+	id, err := strconv.ParseUint(first.text, 10, 32)
+	if err != nil {
+		// value descriptions of environment variables
+		p.skipStatement()
+		return nil
+	}
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	values, err := p.parseDescriptions()
+	if err != nil {
+		return err
+	}
+	if s := p.db.signal(uint32(id), name); s != nil {
+		s.Values = values
+	}
+	return nil
+}
+
+// CM_ [BU_ node|BO_ id|SG_ id signal|EV_ name] "comment";
+func (p *parser) parseComment() error {
+	var target *string
+	if t := p.peek(); t.kind == tokIdent {
+		p.next()
+		switch t.text {
+		case "BU_":
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if n, ok := p.db.Node(name); ok {
+				target = &n.Comment
+			}
+		case "BO_":
+			id, err := p.integer()
+			if err != nil {
+				return err
+			}
+			if m := p.db.message(uint32(id)); m != nil {
+				target = &m.Comment
+			}
+		case "SG_":
+			id, err := p.integer()
+			if err != nil {
+				return err
+			}
+			name, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if s := p.db.signal(uint32(id), name); s != nil {
+				target = &s.Comment
+			}
+		default:
+			p.skipStatement()
+			return nil
+		}
+	} else {
+		target = &p.db.Comment
+	}
+
+	comment, err := p.str()
+	if err != nil {
+		return err
+	}
+	if target != nil {
+		*target = comment
+	}
+	return p.expect(";")
+}
+
+// BA_DEF_ [BU_|BO_|SG_|EV_] "name" type [min max|"a","b"];
+func (p *parser) parseAttributeDef(isRel bool) error {
+	if isRel {
+		// relation attributes are not supported
+		p.skipStatement()
+		return nil
+	}
+	def := &AttributeDef{}
+	if t := p.peek(); t.kind == tokIdent {
+		def.Object = p.next().text
+	}
+	var err error
+	if def.Name, err = p.str(); err != nil {
+		return err
+	}
+	if def.Type, err = p.ident(); err != nil {
+		return err
+	}
+	switch def.Type {
+	case "INT", "HEX", "FLOAT":
+		if def.Min, err = p.number(); err != nil {
+			return err
+		}
+		if def.Max, err = p.number(); err != nil {
+			return err
+		}
+	case "ENUM":
+		for p.peek().kind == tokString {
+			def.Enum = append(def.Enum, p.next().text)
+			p.accept(",")
+		}
+	}
+	p.db.AttributeDefs = append(p.db.AttributeDefs, def)
+	return p.expect(";")
+}
+
+// Parses an attribute value, a number or a string
+func (p *parser) attributeValue() (any, error) {
+	if p.peek().kind == tokString {
+		return p.next().text, nil
+	}
+	return p.number()
+}
+
+// BA_DEF_DEF_ "name" value;
+func (p *parser) parseAttributeDefault() error {
+	name, err := p.str()
+	if err != nil {
+		return err
+	}
+	value, err := p.attributeValue()
+	if err != nil {
+		return err
+	}
+	if def, ok := p.db.AttributeDef(name); ok {
+		def.Default = value
+	}
+	return p.expect(";")
+}
+
+// BA_ "name" [BU_ node|BO_ id|SG_ id signal|EV_ name] value;
+func (p *parser) parseAttribute() error {
+	name, err := p.str()
+	if err != nil {
+		return err
+	}
+	attrs := p.db.Attributes
+	if t := p.peek(); t.kind == tokIdent && strings.HasSuffix(t.text, "_") {
+		p.next()
+		attrs = nil
+		switch t.text {
+		case "BU_":
+			node, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if n, ok := p.db.Node(node); ok {
+				attrs = n.Attributes
+			}
+		case "BO_":
+			id, err := p.integer()
+			if err != nil {
+				return err
+			}
+			if m := p.db.message(uint32(id)); m != nil {
+				attrs = m.Attributes
+			}
+		case "SG_":
+			id, err := p.integer()
+			if err != nil {
+				return err
+			}
+			signal, err := p.ident()
+			if err != nil {
+				return err
+			}
+			if s := p.db.signal(uint32(id), signal); s != nil {
+				attrs = s.Attributes
+			}
+		default:
+			p.skipStatement()
+			return nil
+		}
+	}
+	value, err := p.attributeValue()
+	if err != nil {
+		return err
+	}
+	if attrs != nil {
+		attrs[name] = value
+	}
+	return p.expect(";")
+}
+
+// SIG_VALTYPE_ id signal : type;
+func (p *parser) parseValueType() error {
+	id, err := p.integer()
+	if err != nil {
+		return err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	p.accept(":")
+	valueType, err := p.integer()
+	if err != nil {
+		return err
+	}
+	if s := p.db.signal(uint32(id), name); s != nil {
+		switch valueType {
+		case 1:
+			s.Type = Float32
+		case 2:
+			s.Type = Float64
+		}
+	}
+	return p.expect(";")
+}
+
+// SG_MUL_VAL_ id signal multiplexer low-high, low-high ;
+// Only the single value of simple multiplexing is supported, messages with other ranges are marked as unsupported
+func (p *parser) parseMuxValues(line int) error {
+	id, err := p.integer()
+	if err != nil {
+		return err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	muxName, err := p.ident()
+	if err != nil {
+		return err
+	}
+	m := p.db.message(uint32(id))
+	var s, mux *Signal
+	if m != nil {
+		s, _ = m.Signal(name)
+		mux = m.Multiplexer()
+	}
+	for !p.accept(";") {
+		low, err := p.integer()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("-"); err != nil {
+			return err
+		}
+		high, err := p.integer()
+		if err != nil {
+			return err
+		}
+		p.accept(",")
+		if m != nil && (s == nil || mux == nil || mux.Name != muxName || !s.IsMultiplexed || low != high || uint64(low) != s.MuxValue) {
+			p.unsupported(m, line, "extended multiplexing of signal %v by %v", name, muxName)
+		}
+	}
+	return nil
+}
+
+// Marks a message as unsupported and adds a warning, the first reason of a message is kept
+func (p *parser) unsupported(m *Message, line int, format string, args ...any) {
+	if m.Unsupported != nil {
+		return
+	}
+	m.Unsupported = fmt.Errorf("line %v: %w: %v", line, ErrUnsupported, fmt.Sprintf(format, args...))
+	p.db.Warnings = append(p.db.Warnings, m.Unsupported)
+}
+
+// Returns the message with the given DBC id including the extended flag
+func (db *Database) message(id uint32) *Message {
+	m, _ := db.MessageByID(gocan.MessageID(id&^ExtendedFlag), id&ExtendedFlag != 0)
+	return m
+}
+
+// Returns the signal of the message with the given DBC id
+func (db *Database) signal(id uint32, name string) *Signal {
+	if m := db.message(id); m != nil {
+		s, _ := m.Signal(name)
+		return s
+	}
+	return nil
+}
+
+// Returns the value of an attribute or its default
+func (db *Database) attribute(attrs map[string]any, name string) (any, bool) {
+	if value, ok := attrs[name]; ok {
+		return value, true
+	}
+	if def, ok := db.AttributeDef(name); ok && def.Default != nil {
+		return def.Default, true
+	}
+	return nil, false
+}
+
+// Returns the string of an enum attribute, which may be given by its index
+func (db *Database) enumValue(name string, value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		if def, ok := db.AttributeDef(name); ok && int(v) >= 0 && int(v) < len(def.Enum) {
+			return def.Enum[int(v)]
+		}
+	}
+	return ""
+}
+
+// Applies the attributes with a meaning for decoding and encoding
+func (db *Database) resolve() {
+	for _, m := range db.Messages {
+		if v, ok := db.attribute(m.Attributes, AttrCycleTime); ok {
+			if ms, ok := v.(float64); ok {
+				m.CycleTime = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+		if v, ok := db.attribute(m.Attributes, AttrFrameFormat); ok {
+			m.IsFD = strings.HasSuffix(db.enumValue(AttrFrameFormat, v), "_FD")
+		}
+		for _, s := range m.Signals {
+			if v, ok := db.attribute(s.Attributes, AttrStartValue); ok {
+				if raw, ok := v.(float64); ok {
+					s.StartValue = raw
+				}
+			}
+		}
+	}
+}
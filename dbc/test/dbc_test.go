@@ -0,0 +1,379 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+	"github.com/morgadow/gocan/dbc"
+)
+
+const auxDBC = `VERSION "1.0"
+
+NS_ :
+	NS_DESC_
+	CM_
+	BA_DEF_
+	VAL_
+
+BS_:
+
+BU_: ECU Tester
+
+VAL_TABLE_ OnOff 0 "Off" 1 "On" ;
+
+BO_ 256 Engine: 8 ECU
+ SG_ Speed : 0|16@1+ (0.01,0) [0|655.35] "km/h" Tester
+ SG_ Temp : 16|8@1- (1,-40) [-80|87] "degC" Tester
+ SG_ Rpm : 31|16@0+ (0.25,0) [0|16383.75] "rpm" Tester,ECU
+ SG_ State : 40|2@1+ (1,0) [0|3] "" Vector__XXX
+
+BO_ 2147484672 Diag: 8 Tester
+ SG_ Mode M : 0|8@1+ (1,0) [0|255] "" ECU
+ SG_ ValueA m1 : 8|16@1+ (0.5,0) [0|1000] "V" ECU
+ SG_ ValueB m2 : 15|16@0- (1,0) [-100|100] "A" ECU
+
+BO_ 512 Floats: 12 ECU
+ SG_ F32 : 0|32@1+ (1,0) [0|0] "" Tester
+ SG_ F64 : 32|64@1+ (2,1) [0|0] "" Tester
+
+BO_TX_BU_ 256 : ECU,Tester;
+
+EV_ EnvVar: 0 [0|100] "" 0 1 DUMMY_NODE_VECTOR0 Vector__XXX;
+
+CM_ "Test network";
+CM_ BU_ ECU "Engine control";
+CM_ BO_ 256 "Engine status";
+CM_ SG_ 256 Speed "Vehicle
+speed \"filtered\"";
+BA_DEF_ BO_ "GenMsgCycleTime" INT 0 10000;
+BA_DEF_ BO_ "VFrameFormat" ENUM "StandardCAN","ExtendedCAN","StandardCAN_FD","ExtendedCAN_FD";
+BA_DEF_ SG_ "GenSigStartValue" INT 0 65535;
+BA_DEF_ "BusType" STRING ;
+BA_DEF_ BU_ "NodeLayer" FLOAT 0 1E+02;
+BA_DEF_DEF_ "GenMsgCycleTime" 0;
+BA_DEF_DEF_ "VFrameFormat" "StandardCAN";
+BA_DEF_DEF_ "GenSigStartValue" 0;
+BA_DEF_DEF_ "BusType" "CAN";
+BA_DEF_DEF_ "NodeLayer" 1.5;
+BA_ "BusType" "CAN FD";
+BA_ "NodeLayer" BU_ ECU 2.5;
+BA_ "GenMsgCycleTime" BO_ 256 100;
+BA_ "VFrameFormat" BO_ 512 2;
+BA_ "GenSigStartValue" SG_ 256 Temp 40;
+VAL_ 256 State 0 "Off" 1 "On" 2 "Error" 3 "SNA" ;
+VAL_ EnvVar 0 "Zero" ;
+SIG_VALTYPE_ 512 F32 : 1;
+SIG_VALTYPE_ 512 F64 : 2;
+SG_MUL_VAL_ 2147484672 ValueA Mode 1-1;
+`
+
+func auxParse(t *testing.T) *dbc.Database {
+	db, err := dbc.Parse(strings.NewReader(auxDBC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return db
+}
+
+func auxMessage(t *testing.T, db *dbc.Database, name string) *dbc.Message {
+	m, ok := db.MessageByName(name)
+	if !ok {
+		t.Fatalf("missing message %v", name)
+	}
+	return m
+}
+
+func TestParse(t *testing.T) {
+	db := auxParse(t)
+
+	if db.Version != "1.0" || db.Comment != "Test network" || len(db.Nodes) != 2 || len(db.Messages) != 3 {
+		t.Fatalf("unexpected database: version %q, comment %q, %v nodes, %v messages", db.Version, db.Comment, len(db.Nodes), len(db.Messages))
+	}
+	if node, _ := db.Node("ECU"); node.Comment != "Engine control" || node.Attributes["NodeLayer"] != 2.5 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	if db.Attributes["BusType"] != "CAN FD" || db.ValueTables["OnOff"][1] != "On" {
+		t.Errorf("unexpected attributes %v or value tables %v", db.Attributes, db.ValueTables)
+	}
+
+	engine := auxMessage(t, db, "Engine")
+	if engine.ID != 0x100 || engine.IsExtended || engine.IsFD || engine.Length != 8 || engine.Sender != "ECU" ||
+		engine.CycleTime != 100*time.Millisecond || engine.Comment != "Engine status" {
+		t.Errorf("unexpected message: %+v", engine)
+	}
+	speed, _ := engine.Signal("Speed")
+	if speed.Factor != 0.01 || speed.Max != 655.35 || speed.Unit != "km/h" || speed.Comment != "Vehicle\nspeed \"filtered\"" ||
+		len(speed.Receivers) != 1 || speed.Receivers[0] != "Tester" {
+		t.Errorf("unexpected signal: %+v", speed)
+	}
+	temp, _ := engine.Signal("Temp")
+	if !temp.Signed || temp.Offset != -40 || temp.Min != -80 || temp.StartValue != 40 {
+		t.Errorf("unexpected signal: %+v", temp)
+	}
+	rpm, _ := engine.Signal("Rpm")
+	if rpm.ByteOrder != bitfield.BigEndian || rpm.StartBit != 31 || len(rpm.Receivers) != 2 {
+		t.Errorf("unexpected signal: %+v", rpm)
+	}
+	state, _ := engine.Signal("State")
+	if state.Values[3] != "SNA" || len(state.Receivers) != 0 {
+		t.Errorf("unexpected signal: %+v", state)
+	}
+
+	diag := auxMessage(t, db, "Diag")
+	if diag.ID != 0x400 || !diag.IsExtended || diag.CycleTime != 0 || diag.Multiplexer().Name != "Mode" {
+		t.Errorf("unexpected message: %+v", diag)
+	}
+	if valueB, _ := diag.Signal("ValueB"); !valueB.IsMultiplexed || valueB.MuxValue != 2 {
+		t.Errorf("unexpected signal: %+v", valueB)
+	}
+
+	floats := auxMessage(t, db, "Floats")
+	if !floats.IsFD {
+		t.Errorf("expected CAN FD message")
+	}
+	if f64, _ := floats.Signal("F64"); f64.Type != dbc.Float64 {
+		t.Errorf("unexpected signal: %+v", f64)
+	}
+	if def, ok := db.AttributeDef("VFrameFormat"); !ok || len(def.Enum) != 4 || def.Default != "StandardCAN" {
+		t.Errorf("unexpected attribute definition: %+v", def)
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	db := auxParse(t)
+	data := []byte{0x34, 0x12, 0xD8, 0x0F, 0xA0, 0x02, 0, 0}
+
+	m, values, err := db.Decode(&gocan.Message{ID: 0x100, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Name != "Engine" || len(values) != 4 {
+		t.Fatalf("got %v with %v values, expected Engine with 4 values", m.Name, len(values))
+	}
+	expected := map[string]float64{"Speed": 46.6, "Temp": -80, "Rpm": 1000, "State": 2}
+	for _, v := range values {
+		if diff := v.Physical - expected[v.Signal.Name]; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%v: got %v, expected %v", v.Signal.Name, v.Physical, expected[v.Signal.Name])
+		}
+	}
+	if values[3].Description != "Error" {
+		t.Errorf("got description %q, expected Error", values[3].Description)
+	}
+
+	msg, err := db.Encode("Engine", expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.ID != 0x100 || msg.IsExtended || msg.IsFD || msg.DLC != 8 || !bytes.Equal(msg.Data, data) {
+		t.Errorf("got %+v, expected data % X", msg, data)
+	}
+
+	// missing signals use their start value
+	msg, _ = db.Encode("Engine", map[string]float64{"Speed": 1})
+	if !bytes.Equal(msg.Data, []byte{100, 0, 40, 0, 0, 0, 0, 0}) {
+		t.Errorf("got % X", msg.Data)
+	}
+
+	if _, err := db.Encode("Engine", map[string]float64{"Speed": 700}); !errors.Is(err, dbc.ErrOutOfRange) {
+		t.Errorf("expected ErrOutOfRange, got %v", err)
+	}
+	if _, err := db.Encode("Engine", map[string]float64{"Gear": 1}); !errors.Is(err, dbc.ErrUnknownSignal) {
+		t.Errorf("expected ErrUnknownSignal, got %v", err)
+	}
+	if _, err := db.Encode("Gearbox", nil); !errors.Is(err, dbc.ErrUnknownMessage) {
+		t.Errorf("expected ErrUnknownMessage, got %v", err)
+	}
+	if _, _, err := db.Decode(&gocan.Message{ID: 0x100, IsExtended: true}); !errors.Is(err, dbc.ErrUnknownMessage) {
+		t.Errorf("expected ErrUnknownMessage, got %v", err)
+	}
+}
+
+func TestMultiplexed(t *testing.T) {
+	db := auxParse(t)
+	diag := auxMessage(t, db, "Diag")
+
+	msg, err := diag.Encode(map[string]float64{"Mode": 2, "ValueB": -5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !msg.IsExtended || msg.ID != 0x400 || !bytes.Equal(msg.Data, []byte{2, 0xFF, 0xFB, 0, 0, 0, 0, 0}) {
+		t.Errorf("got %+v", msg)
+	}
+	values := diag.DecodeMap(msg.Data)
+	if len(values) != 2 || values["Mode"] != 2 || values["ValueB"] != -5 {
+		t.Errorf("got %v, expected Mode and ValueB", values)
+	}
+
+	values = diag.DecodeMap([]byte{1, 0x10, 0x00, 0, 0, 0, 0, 0})
+	if len(values) != 2 || values["ValueA"] != 8 {
+		t.Errorf("got %v, expected Mode and ValueA", values)
+	}
+
+	if _, err := diag.Encode(map[string]float64{"ValueA": 1}); !errors.Is(err, dbc.ErrMultiplexer) {
+		t.Errorf("expected ErrMultiplexer, got %v", err)
+	}
+}
+
+func TestFloatSignals(t *testing.T) {
+	db := auxParse(t)
+	msg, err := db.Encode("Floats", map[string]float64{"F32": 1.5, "F64": 7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !msg.IsFD || len(msg.Data) != 12 || msg.DLC != 9 {
+		t.Errorf("got %+v, expected CAN FD message with 12 bytes", msg)
+	}
+	values := auxMessage(t, db, "Floats").DecodeMap(msg.Data)
+	if values["F32"] != 1.5 || values["F64"] != 7 {
+		t.Errorf("got %v", values)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := dbc.Parse(strings.NewReader("BO_ 1 X: 8 A\n SG_ S : 0|8@2+ (1,0) [0|0] \"\" A\n"))
+	if !errors.Is(err, dbc.ErrSyntax) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected ErrSyntax in line 2, got %v", err)
+	}
+	_, err = dbc.Parse(strings.NewReader("BO_ 1 X: 8 A\n SG_ S : 510|8@1+ (1,0) [0|0] \"\" A\n"))
+	if !errors.Is(err, dbc.ErrSyntax) {
+		t.Errorf("expected ErrSyntax, got %v", err)
+	}
+}
+
+func TestSignalExceedingMessage(t *testing.T) {
+	const dbcFile = "BO_ 1 X: 2 A\n SG_ S : 0|8@1+ (1,0) [0|0] \"\" A\n SG_ Far : 16|8@1+ (1,0) [0|0] \"\" A\n" +
+		"BO_ 2 Y: 2 A\n SG_ V : 8|8@1+ (1,0) [0|0] \"\" A\n"
+
+	// the message is kept with a warning, other messages are not affected
+	db, err := dbc.Parse(strings.NewReader(dbcFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	x, _ := db.MessageByName("X")
+	if !errors.Is(x.Unsupported, dbc.ErrUnsupported) || len(db.Warnings) != 1 || len(x.Signals) != 2 {
+		t.Errorf("expected X marked as unsupported, got %v, warnings %v, signals %v", x.Unsupported, db.Warnings, len(x.Signals))
+	}
+	if _, err := db.Encode("X", map[string]float64{"S": 1}); !errors.Is(err, dbc.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported on encode, got %v", err)
+	}
+	if y, _ := db.MessageByName("Y"); y.Unsupported != nil {
+		t.Errorf("unexpected unsupported Y: %v", y.Unsupported)
+	}
+}
+
+func TestExtendedMultiplexing(t *testing.T) {
+	const msg = "BO_ 1 X: 8 A\n SG_ Mux M : 0|8@1+ (1,0) [0|0] \"\" A\n SG_ S m1 : 8|8@1+ (1,0) [0|0] \"\" A\n"
+	const other = "BO_ 2 Y: 8 A\n SG_ V : 0|8@1+ (1,0) [0|0] \"\" A\n"
+	for _, dbcFile := range []string{
+		msg + " SG_ Sub m2M : 16|8@1+ (1,0) [0|0] \"\" A\n" + other,
+		msg + " SG_ Mux2 M : 16|8@1+ (1,0) [0|0] \"\" A\n" + other,
+		msg + other + "SG_MUL_VAL_ 1 S Mux 1-3;\n",
+		msg + other + "SG_MUL_VAL_ 1 S Mux 1-1, 4-4;\n",
+	} {
+		// only the message with extended multiplexing is affected
+		db, err := dbc.Parse(strings.NewReader(dbcFile))
+		if err != nil {
+			t.Fatalf("unexpected error: %v for:\n%v", err, dbcFile)
+		}
+		x, _ := db.MessageByName("X")
+		if !errors.Is(x.Unsupported, dbc.ErrUnsupported) || len(db.Warnings) != 1 || !errors.Is(db.Warnings[0], dbc.ErrUnsupported) {
+			t.Errorf("expected X marked as unsupported, got %v, warnings %v for:\n%v", x.Unsupported, db.Warnings, dbcFile)
+		}
+		if _, err := db.Encode("X", map[string]float64{"Mux": 1}); !errors.Is(err, dbc.ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported on encode, got %v", err)
+		}
+		_, values, _ := db.Decode(&gocan.Message{ID: 1, Data: []byte{1, 2, 3, 0, 0, 0, 0, 0}})
+		for _, v := range values {
+			if v.Signal.IsMultiplexed {
+				t.Errorf("expected only the unmultiplexed signals, got %v", v.Signal.Name)
+			}
+		}
+		if len(values) == 0 || values[0].Signal.Name != "Mux" {
+			t.Errorf("expected the multiplexer to be decoded, got %+v", values)
+		}
+		if _, values, err := db.Decode(&gocan.Message{ID: 2, Data: []byte{7, 0, 0, 0, 0, 0, 0, 0}}); err != nil || len(values) != 1 || values[0].Physical != 7 {
+			t.Errorf("unexpected decode of Y: %+v, %v", values, err)
+		}
+	}
+
+	// simple multiplexing written with SG_MUL_VAL_
+	for _, mulVal := range []string{"SG_MUL_VAL_ 1 S Mux 1-1;\n", "SG_MUL_VAL_ 1 S Mux 1 - 1;\n"} {
+		db, err := dbc.Parse(strings.NewReader(msg + mulVal))
+		if err != nil || len(db.Warnings) != 0 || db.Messages[0].Unsupported != nil {
+			t.Errorf("unexpected error: %v, warnings %v for %q", err, db.Warnings, mulVal)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	original := auxParse(t)
+	var buf bytes.Buffer
+	if err := dbc.Write(&buf, original); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, err := dbc.Parse(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if db.Version != original.Version || db.Comment != original.Comment || len(db.Nodes) != len(original.Nodes) ||
+		db.Attributes["BusType"] != "CAN FD" || db.ValueTables["OnOff"][1] != "On" {
+		t.Errorf("unexpected database: %+v", db)
+	}
+	if node, _ := db.Node("ECU"); node.Comment != "Engine control" || node.Attributes["NodeLayer"] != 2.5 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	for _, m := range original.Messages {
+		written := auxMessage(t, db, m.Name)
+		if written.ID != m.ID || written.IsExtended != m.IsExtended || written.IsFD != m.IsFD || written.Length != m.Length ||
+			written.Sender != m.Sender || written.CycleTime != m.CycleTime || written.Comment != m.Comment || len(written.Signals) != len(m.Signals) {
+			t.Errorf("got %+v, expected %+v", written, m)
+			continue
+		}
+		for _, s := range m.Signals {
+			w, ok := written.Signal(s.Name)
+			if !ok || w.StartBit != s.StartBit || w.Length != s.Length || w.ByteOrder != s.ByteOrder || w.Signed != s.Signed ||
+				w.Type != s.Type || w.Factor != s.Factor || w.Offset != s.Offset || w.Min != s.Min || w.Max != s.Max ||
+				w.Unit != s.Unit || w.StartValue != s.StartValue || w.IsMultiplexer != s.IsMultiplexer || w.IsMultiplexed != s.IsMultiplexed ||
+				w.MuxValue != s.MuxValue || len(w.Values) != len(s.Values) || len(w.Receivers) != len(s.Receivers) || w.Comment != s.Comment {
+				t.Errorf("%v: got %+v, expected %+v", m.Name, w, s)
+			}
+		}
+	}
+}
+
+func TestWriteFieldAttributeDefs(t *testing.T) {
+	db := &dbc.Database{Messages: []*dbc.Message{
+		{ID: 0x100, Name: "First", Length: 8, CycleTime: 100 * time.Millisecond},
+		{ID: 0x101, Name: "Second", Length: 8, CycleTime: 3600 * time.Second},
+	}}
+	var buf bytes.Buffer
+	if err := dbc.Write(&buf, db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// definitions of attributes stored in fields are written once with integer limits
+	text := buf.String()
+	if n := strings.Count(text, "BA_DEF_ BO_ \""+dbc.AttrCycleTime+"\""); n != 1 {
+		t.Errorf("got %v definitions of %v, expected 1:\n%s", n, dbc.AttrCycleTime, text)
+	}
+	if n := strings.Count(text, "BA_DEF_DEF_ \""+dbc.AttrCycleTime+"\""); n != 1 {
+		t.Errorf("got %v defaults of %v, expected 1:\n%s", n, dbc.AttrCycleTime, text)
+	}
+	if !strings.Contains(text, "INT 0 3600000;") {
+		t.Errorf("expected integer limits:\n%s", text)
+	}
+
+	written, err := dbc.Parse(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m := auxMessage(t, written, "Second"); m.CycleTime != 3600*time.Second {
+		t.Errorf("got cycle time %v, expected 1h", m.CycleTime)
+	}
+}
@@ -0,0 +1,295 @@
+package dbc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/morgadow/gocan/bitfield"
+)
+
+// Node name used by DBC files for messages without sender and signals without receivers
+const NoNode = "Vector__XXX"
+
+// Enum values of the attribute VFrameFormat
+var frameFormats = []string{"StandardCAN", "ExtendedCAN", "StandardCAN_FD", "ExtendedCAN_FD"}
+
+// Formats a number without trailing zeros
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Quotes a string, quotes inside are escaped
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// Returns the DBC id of a message including the extended flag
+func (m *Message) dbcID() uint32 {
+	if m.IsExtended {
+		return uint32(m.ID) | ExtendedFlag
+	}
+	return uint32(m.ID)
+}
+
+// Returns the multiplexer indicator of a signal, e.g. "M", "m2" or "m2M"
+func (s *Signal) muxIndicator() string {
+	indicator := ""
+	if s.IsMultiplexed {
+		indicator = fmt.Sprintf("m%d", s.MuxValue)
+	}
+	if s.IsMultiplexer {
+		indicator += "M"
+	}
+	return indicator
+}
+
+// Returns the attribute definitions to write, the attributes stored in fields are added if they are missing
+func (db *Database) writtenDefs() []*AttributeDef {
+	defs := slices.Clone(db.AttributeDefs)
+	add := func(def *AttributeDef) {
+		if !slices.ContainsFunc(defs, func(d *AttributeDef) bool { return d.Name == def.Name }) {
+			defs = append(defs, def)
+		}
+	}
+	for _, m := range db.Messages {
+		if m.CycleTime > 0 {
+			add(&AttributeDef{Object: ObjectMessage, Name: AttrCycleTime, Type: "INT", Max: 3600000, Default: 0.0})
+		}
+		if m.IsFD {
+			add(&AttributeDef{Object: ObjectMessage, Name: AttrFrameFormat, Type: "ENUM", Enum: frameFormats, Default: frameFormats[0]})
+		}
+		for _, s := range m.Signals {
+			if s.StartValue != 0 {
+				add(&AttributeDef{Object: ObjectSignal, Name: AttrStartValue, Type: "FLOAT", Min: -math.MaxFloat64, Max: math.MaxFloat64, Default: 0.0})
+			}
+		}
+	}
+	return defs
+}
+
+// Formats an attribute value, enum values given as string are written as index
+func formatAttribute(def *AttributeDef, value any) string {
+	switch v := value.(type) {
+	case string:
+		if def != nil && def.Type == "ENUM" {
+			if i := slices.Index(def.Enum, v); i >= 0 {
+				return strconv.Itoa(i)
+			}
+		}
+		return quote(v)
+	case float64:
+		return formatNumber(v)
+	case int:
+		return strconv.Itoa(v)
+	}
+	return quote(fmt.Sprint(value))
+}
+
+// Returns the names of attributes sorted, attributes stored in fields are skipped
+func attributeNames(attrs map[string]any, skip ...string) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		if !slices.Contains(skip, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Writes the database in DBC format
+// CycleTime, IsFD and StartValue are written as the attributes GenMsgCycleTime, VFrameFormat and GenSigStartValue
+func Write(w io.Writer, db *Database) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "VERSION %s\n\n", quote(db.Version))
+	fmt.Fprintf(bw, "NS_ :\n")
+	for _, ns := range []string{"NS_DESC_", "CM_", "BA_DEF_", "BA_", "VAL_", "BA_DEF_DEF_", "VAL_TABLE_", "SIG_VALTYPE_", "BO_TX_BU_", "SG_MUL_VAL_"} {
+		fmt.Fprintf(bw, "\t%s\n", ns)
+	}
+	fmt.Fprintf(bw, "\nBS_:\n\nBU_:")
+	for _, n := range db.Nodes {
+		fmt.Fprintf(bw, " %s", n.Name)
+	}
+	fmt.Fprintf(bw, "\n\n")
+
+	tables := make([]string, 0, len(db.ValueTables))
+	for name := range db.ValueTables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		fmt.Fprintf(bw, "VAL_TABLE_ %s%s ;\n", name, formatDescriptions(db.ValueTables[name]))
+	}
+	if len(tables) > 0 {
+		fmt.Fprintln(bw)
+	}
+
+	for _, m := range db.Messages {
+		sender := m.Sender
+		if sender == "" {
+			sender = NoNode
+		}
+		fmt.Fprintf(bw, "BO_ %d %s: %d %s\n", m.dbcID(), m.Name, m.Length, sender)
+		for _, s := range m.Signals {
+			name := s.Name
+			if indicator := s.muxIndicator(); indicator != "" {
+				name += " " + indicator
+			}
+			order, sign := 1, "+"
+			if s.ByteOrder == bitfield.BigEndian {
+				order = 0
+			}
+			if s.Signed {
+				sign = "-"
+			}
+			receivers := strings.Join(s.Receivers, ",")
+			if receivers == "" {
+				receivers = NoNode
+			}
+			fmt.Fprintf(bw, " SG_ %s : %d|%d@%d%s (%s,%s) [%s|%s] %s %s\n", name, s.StartBit, s.Length, order, sign,
+				formatNumber(s.Factor), formatNumber(s.Offset), formatNumber(s.Min), formatNumber(s.Max), quote(s.Unit), receivers)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	// comments
+	if db.Comment != "" {
+		fmt.Fprintf(bw, "CM_ %s;\n", quote(db.Comment))
+	}
+	for _, n := range db.Nodes {
+		if n.Comment != "" {
+			fmt.Fprintf(bw, "CM_ BU_ %s %s;\n", n.Name, quote(n.Comment))
+		}
+	}
+	for _, m := range db.Messages {
+		if m.Comment != "" {
+			fmt.Fprintf(bw, "CM_ BO_ %d %s;\n", m.dbcID(), quote(m.Comment))
+		}
+		for _, s := range m.Signals {
+			if s.Comment != "" {
+				fmt.Fprintf(bw, "CM_ SG_ %d %s %s;\n", m.dbcID(), s.Name, quote(s.Comment))
+			}
+		}
+	}
+
+	// attribute definitions and defaults
+	defs := db.writtenDefs()
+	defByName := make(map[string]*AttributeDef, len(defs))
+	for _, def := range defs {
+		defByName[def.Name] = def
+		object := ""
+		if def.Object != ObjectNetwork {
+			object = def.Object + " "
+		}
+		switch def.Type {
+		case "INT", "HEX":
+			fmt.Fprintf(bw, "BA_DEF_ %s%s %s %s %s;\n", object, quote(def.Name), def.Type,
+				strconv.FormatInt(int64(def.Min), 10), strconv.FormatInt(int64(def.Max), 10))
+		case "FLOAT":
+			fmt.Fprintf(bw, "BA_DEF_ %s%s %s %s %s;\n", object, quote(def.Name), def.Type, formatNumber(def.Min), formatNumber(def.Max))
+		case "ENUM":
+			values := make([]string, len(def.Enum))
+			for i, v := range def.Enum {
+				values[i] = quote(v)
+			}
+			fmt.Fprintf(bw, "BA_DEF_ %s%s ENUM %s;\n", object, quote(def.Name), strings.Join(values, ","))
+		default:
+			fmt.Fprintf(bw, "BA_DEF_ %s%s %s ;\n", object, quote(def.Name), def.Type)
+		}
+	}
+	for _, def := range defs {
+		if def.Default != nil {
+			// defaults of enums are written as string
+			value := formatAttribute(nil, def.Default)
+			fmt.Fprintf(bw, "BA_DEF_DEF_ %s %s;\n", quote(def.Name), value)
+		}
+	}
+
+	// attribute values
+	for _, name := range attributeNames(db.Attributes) {
+		fmt.Fprintf(bw, "BA_ %s %s;\n", quote(name), formatAttribute(defByName[name], db.Attributes[name]))
+	}
+	for _, n := range db.Nodes {
+		for _, name := range attributeNames(n.Attributes) {
+			fmt.Fprintf(bw, "BA_ %s BU_ %s %s;\n", quote(name), n.Name, formatAttribute(defByName[name], n.Attributes[name]))
+		}
+	}
+	for _, m := range db.Messages {
+		if m.CycleTime > 0 {
+			fmt.Fprintf(bw, "BA_ %s BO_ %d %d;\n", quote(AttrCycleTime), m.dbcID(), m.CycleTime.Milliseconds())
+		}
+		if m.IsFD {
+			format := frameFormats[2]
+			if m.IsExtended {
+				format = frameFormats[3]
+			}
+			fmt.Fprintf(bw, "BA_ %s BO_ %d %s;\n", quote(AttrFrameFormat), m.dbcID(), formatAttribute(defByName[AttrFrameFormat], format))
+		}
+		for _, name := range attributeNames(m.Attributes, AttrCycleTime, AttrFrameFormat) {
+			fmt.Fprintf(bw, "BA_ %s BO_ %d %s;\n", quote(name), m.dbcID(), formatAttribute(defByName[name], m.Attributes[name]))
+		}
+		for _, s := range m.Signals {
+			if s.StartValue != 0 {
+				fmt.Fprintf(bw, "BA_ %s SG_ %d %s %s;\n", quote(AttrStartValue), m.dbcID(), s.Name, formatNumber(s.StartValue))
+			}
+			for _, name := range attributeNames(s.Attributes, AttrStartValue) {
+				fmt.Fprintf(bw, "BA_ %s SG_ %d %s %s;\n", quote(name), m.dbcID(), s.Name, formatAttribute(defByName[name], s.Attributes[name]))
+			}
+		}
+	}
+
+	// value descriptions and value types
+	for _, m := range db.Messages {
+		for _, s := range m.Signals {
+			if len(s.Values) > 0 {
+				fmt.Fprintf(bw, "VAL_ %d %s%s ;\n", m.dbcID(), s.Name, formatDescriptions(s.Values))
+			}
+		}
+	}
+	for _, m := range db.Messages {
+		for _, s := range m.Signals {
+			switch s.Type {
+			case Float32:
+				fmt.Fprintf(bw, "SIG_VALTYPE_ %d %s : 1;\n", m.dbcID(), s.Name)
+			case Float64:
+				fmt.Fprintf(bw, "SIG_VALTYPE_ %d %s : 2;\n", m.dbcID(), s.Name)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Formats value descriptions sorted by value, e.g. ` 0 "Off" 1 "On"`
+func formatDescriptions(values map[int64]string) string {
+	keys := make([]int64, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %d %s", k, quote(values[k]))
+	}
+	return sb.String()
+}
+
+// Writes the database into a DBC file
+func WriteFile(path string, db *Database) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, db); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,25 @@
+package gocan
+
+// List of valid data lengths for a CAN FD message, indexed by DLC
+var FDLengths = [...]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// Converts a DLC value (0-15) into the data length of a CAN or CAN FD frame
+func LengthFromDLC(dlc uint8, isFD bool) int {
+	if dlc > 15 {
+		dlc = 15
+	}
+	if !isFD && dlc > 8 {
+		return 8
+	}
+	return int(FDLengths[dlc])
+}
+
+// Converts a data length into the DLC value of a frame, lengths without an exact DLC are rounded up to the next valid CAN FD length
+func DLCFromLength(length int) uint8 {
+	for dlc, n := range FDLengths {
+		if int(n) >= length {
+			return uint8(dlc)
+		}
+	}
+	return 15
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/morgadow/gocan"
 	"github.com/morgadow/gocan/interfaces/pcan"
+	"github.com/morgadow/gocan/interfaces/virtual"
 )
 
 // Creates and initializes a connection to a CANBus
@@ -17,11 +18,18 @@ func CreateBus(config *gocan.Config) (gocan.Bus, error) {
 	switch config.BusType {
 	case "pcan":
 		newBus, err = pcan.NewPCANBus(config)
+	case "virtual":
+		newBus, err = virtual.NewVirtualBus(config)
 
 	default:
 		return nil, errors.New("invalid interface selected or interface not implemented")
 	}
 
+	// optional software receive buffer
+	if err == nil && config.RxBufferSize > 0 {
+		newBus = gocan.NewBufferedBus(newBus, config.RxBufferSize)
+	}
+
 	return newBus, err
 }
 
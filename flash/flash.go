@@ -0,0 +1,252 @@
+package flash
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/morgadow/gocan/uds"
+)
+
+type Stage uint8
+
+// Stages of a flash sequence reported by the progress function
+const (
+	StageSession  Stage = iota // entering the programming session
+	StageSecurity Stage = iota // unlocking the security level
+	StageErase    Stage = iota // running the erase routine of a segment
+	StageDownload Stage = iota // transferring a segment
+	StageCheck    Stage = iota // running the check routine of a segment
+	StageReset    Stage = iota // resetting the ECU
+	StageDone     Stage = iota // all segments are programmed
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageSession:
+		return "session"
+	case StageSecurity:
+		return "security"
+	case StageErase:
+		return "erase"
+	case StageDownload:
+		return "download"
+	case StageCheck:
+		return "check"
+	case StageReset:
+		return "reset"
+	case StageDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// Timeout of the RequestTransferExit sent after a failed or cancelled transfer
+var AbortTimeout = time.Second
+
+// Shortest usable block length, service id and block sequence counter followed by one data byte
+const MinBlockLength = 3
+
+// errors
+var (
+	ErrRoutine     = errors.New("routine reported an error")
+	ErrNoImage     = errors.New("image without data")
+	ErrBlockLength = errors.New("block length too short")
+)
+
+// Progress of a flash sequence
+type Progress struct {
+	Stage    Stage
+	Segment  int    // index of the current segment
+	Segments int    // amount of segments
+	Address  uint32 // address of the next transferred block
+	Written  int    // transferred bytes of all segments
+	Total    int    // size of all segments
+}
+
+// Position to continue an interrupted flash sequence from
+type Checkpoint struct {
+	Segment int // index of the segment to continue with
+	Offset  int // bytes of the segment which were already transferred
+}
+
+// Config of a flasher
+type Config struct {
+	Session        uds.Session              // session used for programming, default uds.ProgrammingSession
+	SecurityLevel  uint8                    // request seed sub-function unlocked before programming, 0 for none
+	DataFormat     byte                     // dataFormatIdentifier of RequestDownload, 0 for neither compression nor encryption
+	MaxBlockLength int                      // limits maxNumberOfBlockLength reported by the server, 0 for no limit, at least MinBlockLength
+	EraseRoutine   uint16                   // routine started with address and size of a segment before its download, 0 for none
+	CheckRoutine   uint16                   // routine started with the checksum of a segment after its download, 0 for none
+	Checksum       func(seg Segment) []byte // checksum passed to the check routine, default CRC-32 (IEEE) big endian
+	Reset          bool                     // hard reset of the ECU after all segments are programmed
+	Progress       func(p Progress)         // called on every stage change and after every transferred block
+}
+
+// Flasher programs images with a UDS client
+type Flasher struct {
+	client     *uds.Client
+	cfg        Config
+	checkpoint Checkpoint
+	written    int
+}
+
+// Creates a flasher, the client must have a key function if a security level is set
+func NewFlasher(client *uds.Client, cfg *Config) *Flasher {
+	f := &Flasher{client: client}
+	if cfg != nil {
+		f.cfg = *cfg
+	}
+	if f.cfg.Session == 0 {
+		f.cfg.Session = uds.ProgrammingSession
+	}
+	if f.cfg.Checksum == nil {
+		f.cfg.Checksum = func(seg Segment) []byte {
+			return binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(seg.Data))
+		}
+	}
+	return f
+}
+
+// Returns the position an interrupted sequence continues from with Resume
+func (f *Flasher) Checkpoint() Checkpoint {
+	return f.checkpoint
+}
+
+// Programs all segments of the image
+// Cancelling the context aborts the sequence, Resume continues it from the last transferred block.
+func (f *Flasher) Flash(ctx context.Context, img *Image) error {
+	f.checkpoint = Checkpoint{}
+	return f.Resume(ctx, img)
+}
+
+// Continues an interrupted sequence from the checkpoint
+// The session is entered and the security level unlocked again, a partially transferred segment is not erased again.
+func (f *Flasher) Resume(ctx context.Context, img *Image) error {
+	if img.Size() == 0 {
+		return ErrNoImage
+	}
+	if f.cfg.MaxBlockLength > 0 && f.cfg.MaxBlockLength < MinBlockLength {
+		return fmt.Errorf("%w: MaxBlockLength %v", ErrBlockLength, f.cfg.MaxBlockLength)
+	}
+	total := img.Size()
+	f.written = f.checkpoint.Offset
+	for _, s := range img.Segments[:min(f.checkpoint.Segment, len(img.Segments))] {
+		f.written += len(s.Data)
+	}
+
+	f.report(StageSession, img, 0)
+	if _, err := f.client.DiagnosticSessionControl(ctx, f.cfg.Session); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	if f.cfg.SecurityLevel != 0 {
+		f.report(StageSecurity, img, 0)
+		if err := f.client.SecurityAccess(ctx, f.cfg.SecurityLevel); err != nil {
+			return fmt.Errorf("security access: %w", err)
+		}
+	}
+
+	for f.checkpoint.Segment < len(img.Segments) {
+		seg := img.Segments[f.checkpoint.Segment]
+		if err := f.program(ctx, img, seg); err != nil {
+			return fmt.Errorf("segment %v at 0x%08X: %w", f.checkpoint.Segment, seg.Address, err)
+		}
+		f.checkpoint.Segment, f.checkpoint.Offset = f.checkpoint.Segment+1, 0
+	}
+
+	if f.cfg.Reset {
+		f.report(StageReset, img, 0)
+		if _, err := f.client.ECUReset(ctx, uds.HardReset); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+	}
+	f.written = total
+	f.report(StageDone, img, 0)
+	return nil
+}
+
+// Erases, transfers and checks a segment starting at the checkpoint offset
+func (f *Flasher) program(ctx context.Context, img *Image, seg Segment) error {
+	if f.cfg.EraseRoutine != 0 && f.checkpoint.Offset == 0 {
+		f.report(StageErase, img, seg.Address)
+		params := []byte{uds.AddressAndLengthFormat}
+		params = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(params, seg.Address), uint32(len(seg.Data)))
+		if err := f.routine(ctx, f.cfg.EraseRoutine, params); err != nil {
+			return fmt.Errorf("erase: %w", err)
+		}
+	}
+
+	if f.checkpoint.Offset < len(seg.Data) {
+		address := seg.Address + uint32(f.checkpoint.Offset)
+		f.report(StageDownload, img, address)
+		maxLength, err := f.client.RequestDownload(ctx, address, uint32(len(seg.Data)-f.checkpoint.Offset), f.cfg.DataFormat)
+		if err != nil {
+			return fmt.Errorf("request download: %w", err)
+		}
+		if f.cfg.MaxBlockLength > 0 {
+			maxLength = min(maxLength, f.cfg.MaxBlockLength)
+		}
+		if err := f.transfer(ctx, img, seg, maxLength-2); err != nil {
+			// leave the download so a resumed sequence can request it again
+			abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), AbortTimeout)
+			f.client.RequestTransferExit(abortCtx, nil)
+			cancel()
+			return err
+		}
+		if _, err := f.client.RequestTransferExit(ctx, nil); err != nil {
+			return fmt.Errorf("transfer exit: %w", err)
+		}
+	}
+
+	if f.cfg.CheckRoutine != 0 {
+		f.report(StageCheck, img, seg.Address)
+		if err := f.routine(ctx, f.cfg.CheckRoutine, f.cfg.Checksum(seg)); err != nil {
+			return fmt.Errorf("check: %w", err)
+		}
+	}
+	return nil
+}
+
+// Transfers the remaining data of a segment in blocks, the checkpoint follows every confirmed block
+func (f *Flasher) transfer(ctx context.Context, img *Image, seg Segment, blockLength int) error {
+	for counter := uint8(1); f.checkpoint.Offset < len(seg.Data); counter++ {
+		n := min(blockLength, len(seg.Data)-f.checkpoint.Offset)
+		if _, err := f.client.TransferData(ctx, counter, seg.Data[f.checkpoint.Offset:f.checkpoint.Offset+n]); err != nil {
+			return fmt.Errorf("transfer data at 0x%08X: %w", seg.Address+uint32(f.checkpoint.Offset), err)
+		}
+		f.checkpoint.Offset += n
+		f.written += n
+		f.report(StageDownload, img, seg.Address+uint32(f.checkpoint.Offset))
+	}
+	return nil
+}
+
+// Starts a routine, a status record starting with a non zero byte is an error
+func (f *Flasher) routine(ctx context.Context, id uint16, params []byte) error {
+	status, err := f.client.RoutineControl(ctx, uds.StartRoutine, id, params)
+	if err != nil {
+		return err
+	}
+	if len(status) > 0 && status[0] != 0 {
+		return fmt.Errorf("%w: routine 0x%04X status % X", ErrRoutine, id, status)
+	}
+	return nil
+}
+
+func (f *Flasher) report(stage Stage, img *Image, address uint32) {
+	if f.cfg.Progress == nil {
+		return
+	}
+	f.cfg.Progress(Progress{
+		Stage:    stage,
+		Segment:  f.checkpoint.Segment,
+		Segments: len(img.Segments),
+		Address:  address,
+		Written:  f.written,
+		Total:    img.Size(),
+	})
+}
@@ -0,0 +1,77 @@
+package flash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Record types of Intel HEX files
+const (
+	hexData                   = 0x00
+	hexEndOfFile              = 0x01
+	hexExtendedSegmentAddress = 0x02
+	hexStartSegmentAddress    = 0x03
+	hexExtendedLinearAddress  = 0x04
+	hexStartLinearAddress     = 0x05
+)
+
+// Reads an Intel HEX file
+func ParseHex(r io.Reader) (*Image, error) {
+	img := &Image{}
+	b := builder{}
+	base := uint32(0)
+	err := scanLines(r, func(line string, lineNo int) (bool, error) {
+		if line[0] != ':' {
+			return false, fmt.Errorf("line %v: %w: record does not start with ':'", lineNo, ErrSyntax)
+		}
+		rec, err := decodeRecord(line[1:], lineNo, 5)
+		if err != nil {
+			return false, err
+		}
+		if int(rec[0]) != len(rec)-5 {
+			return false, fmt.Errorf("line %v: %w: byte count %v of %v data bytes", lineNo, ErrSyntax, rec[0], len(rec)-5)
+		}
+		sum := byte(0)
+		for _, v := range rec {
+			sum += v
+		}
+		if sum != 0 {
+			return false, fmt.Errorf("line %v: %w", lineNo, ErrChecksum)
+		}
+
+		offset, data := uint32(binary.BigEndian.Uint16(rec[1:3])), rec[4:len(rec)-1]
+		switch rec[3] {
+		case hexData:
+			b.add(base+offset, data)
+		case hexEndOfFile:
+			return true, nil
+		case hexExtendedSegmentAddress, hexExtendedLinearAddress:
+			if len(data) != 2 {
+				return false, fmt.Errorf("line %v: %w: address record with %v bytes", lineNo, ErrSyntax, len(data))
+			}
+			base = uint32(binary.BigEndian.Uint16(data)) << 16
+			if rec[3] == hexExtendedSegmentAddress {
+				base >>= 12
+			}
+		case hexStartSegmentAddress, hexStartLinearAddress:
+			if len(data) != 4 {
+				return false, fmt.Errorf("line %v: %w: start address record with %v bytes", lineNo, ErrSyntax, len(data))
+			}
+			img.Start = binary.BigEndian.Uint32(data)
+			if rec[3] == hexStartSegmentAddress {
+				img.Start = uint32(binary.BigEndian.Uint16(data[:2]))<<4 + uint32(binary.BigEndian.Uint16(data[2:]))
+			}
+		default:
+			return false, fmt.Errorf("line %v: %w: unknown record type 0x%02X", lineNo, ErrSyntax, rec[3])
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if img.Segments, err = b.build(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
@@ -0,0 +1,149 @@
+package flash
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// errors
+var (
+	ErrSyntax   = errors.New("syntax error")
+	ErrChecksum = errors.New("record checksum mismatch")
+	ErrOverlap  = errors.New("overlapping data")
+	ErrFormat   = errors.New("unknown file format")
+)
+
+// Contiguous memory area
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// Returns the address following the last byte of the segment
+func (s Segment) End() uint64 {
+	return uint64(s.Address) + uint64(len(s.Data))
+}
+
+// Memory image read from a file, segments are sorted by address and never touch each other
+type Image struct {
+	Segments []Segment
+	Start    uint32 // execution start address, zero if the file has none
+	Header   []byte // S0 header of S-record files
+}
+
+// Returns the total amount of data bytes
+func (img *Image) Size() int {
+	n := 0
+	for _, s := range img.Segments {
+		n += len(s.Data)
+	}
+	return n
+}
+
+// Collects data records into contiguous segments
+type builder struct {
+	segments []Segment
+}
+
+// Adds data at address, appending it to the previous segment if it directly follows
+func (b *builder) add(address uint32, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if n := len(b.segments); n > 0 && b.segments[n-1].End() == uint64(address) {
+		b.segments[n-1].Data = append(b.segments[n-1].Data, data...)
+		return
+	}
+	b.segments = append(b.segments, Segment{Address: address, Data: slices.Clone(data)})
+}
+
+// Returns the sorted and merged segments, overlapping data is an error
+func (b *builder) build() ([]Segment, error) {
+	slices.SortStableFunc(b.segments, func(x, y Segment) int {
+		return cmp.Compare(x.Address, y.Address)
+	})
+	var segments []Segment
+	for _, s := range b.segments {
+		if n := len(segments); n > 0 {
+			last := &segments[n-1]
+			if last.End() > uint64(s.Address) {
+				return nil, fmt.Errorf("%w: 0x%08X", ErrOverlap, s.Address)
+			}
+			if last.End() == uint64(s.Address) {
+				last.Data = append(last.Data, s.Data...)
+				continue
+			}
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+// Reads an Intel HEX or Motorola S-record file, the format is detected from the first record
+func Parse(r io.Reader) (*Image, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, ErrFormat
+			}
+			return nil, err
+		}
+		switch b[0] {
+		case ':':
+			return ParseHex(br)
+		case 'S', 's':
+			return ParseSRecord(br)
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return nil, ErrFormat
+		}
+	}
+}
+
+// Reads an Intel HEX or Motorola S-record file
+func ParseFile(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Decodes the hex digits of a record and checks its length
+func decodeRecord(line string, lineNo, minLength int) ([]byte, error) {
+	data, err := hex.DecodeString(line)
+	if err != nil {
+		return nil, fmt.Errorf("line %v: %w: %v", lineNo, ErrSyntax, err)
+	}
+	if len(data) < minLength {
+		return nil, fmt.Errorf("line %v: %w: record too short", lineNo, ErrSyntax)
+	}
+	return data, nil
+}
+
+// Iterates the non empty lines of a file
+func scanLines(r io.Reader, fn func(line string, lineNo int) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1024), 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if done, err := fn(line, lineNo); err != nil || done {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,71 @@
+package flash
+
+import (
+	"fmt"
+	"io"
+)
+
+// Reads a Motorola S-record file
+func ParseSRecord(r io.Reader) (*Image, error) {
+	img := &Image{}
+	b := builder{}
+	err := scanLines(r, func(line string, lineNo int) (bool, error) {
+		if len(line) < 2 || (line[0] != 'S' && line[0] != 's') {
+			return false, fmt.Errorf("line %v: %w: record does not start with 'S'", lineNo, ErrSyntax)
+		}
+		rec, err := decodeRecord(line[2:], lineNo, 3)
+		if err != nil {
+			return false, err
+		}
+		if int(rec[0]) != len(rec)-1 {
+			return false, fmt.Errorf("line %v: %w: byte count %v of %v bytes", lineNo, ErrSyntax, rec[0], len(rec)-1)
+		}
+		sum := byte(0)
+		for _, v := range rec {
+			sum += v
+		}
+		if sum != 0xFF {
+			return false, fmt.Errorf("line %v: %w", lineNo, ErrChecksum)
+		}
+
+		// address length of the record type
+		typ := line[1]
+		addrLength := 0
+		switch typ {
+		case '0', '1', '5', '9':
+			addrLength = 2
+		case '2', '6', '8':
+			addrLength = 3
+		case '3', '7':
+			addrLength = 4
+		default:
+			return false, fmt.Errorf("line %v: %w: unknown record type S%c", lineNo, ErrSyntax, typ)
+		}
+		if len(rec) < 2+addrLength {
+			return false, fmt.Errorf("line %v: %w: record too short", lineNo, ErrSyntax)
+		}
+		address := uint32(0)
+		for _, v := range rec[1 : 1+addrLength] {
+			address = address<<8 | uint32(v)
+		}
+		data := rec[1+addrLength : len(rec)-1]
+
+		switch typ {
+		case '0':
+			img.Header = append([]byte(nil), data...)
+		case '1', '2', '3':
+			b.add(address, data)
+		case '7', '8', '9':
+			img.Start = address
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if img.Segments, err = b.build(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
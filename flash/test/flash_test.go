@@ -0,0 +1,328 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/flash"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/uds"
+	"github.com/morgadow/gocan/uds/sim"
+)
+
+const auxHex = `:020000040800F2
+:10000000000102030405060708090A0B0C0D0E0F78
+:10001000101112131415161718191A1B1C1D1E1F68
+:10002000202122232425262728292A2B2C2D2E2F58
+:10003000303132333435363738393A3B3C3D3E3F48
+:14100000A0A1A2A3A4A5A6A7A8A9AAABACADAEAFB0B1B2B39E
+:0400000508000041AE
+:00000001FF
+`
+
+const auxSRecord = `S00700007465737438
+S31508000000000102030405060708090A0B0C0D0E0F6A
+S31508000010101112131415161718191A1B1C1D1E1F5A
+S31508000020202122232425262728292A2B2C2D2E2F4A
+S31508000030303132333435363738393A3B3C3D3E3F3A
+S31908001000A0A1A2A3A4A5A6A7A8A9AAABACADAEAFB0B1B2B390
+S1060100010203F2
+S2060100000405EF
+S5030005F7
+S70508000041B1
+`
+
+func auxCounting(start byte, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = start + byte(i)
+	}
+	return data
+}
+
+func auxCheckImage(t *testing.T, img *flash.Image, expected []flash.Segment) {
+	if len(img.Segments) != len(expected) {
+		t.Fatalf("got %v segments, expected %v", len(img.Segments), len(expected))
+	}
+	for i, s := range img.Segments {
+		if s.Address != expected[i].Address || !bytes.Equal(s.Data, expected[i].Data) {
+			t.Errorf("segment %v: got 0x%08X % X, expected 0x%08X % X", i, s.Address, s.Data, expected[i].Address, expected[i].Data)
+		}
+	}
+	if img.Start != 0x08000041 {
+		t.Errorf("got start 0x%08X, expected 0x08000041", img.Start)
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	img, err := flash.Parse(strings.NewReader(auxHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auxCheckImage(t, img, []flash.Segment{
+		{Address: 0x08000000, Data: auxCounting(0, 64)},
+		{Address: 0x08001000, Data: auxCounting(0xA0, 20)},
+	})
+	if img.Size() != 84 {
+		t.Errorf("got size %v, expected 84", img.Size())
+	}
+
+	invalid := map[string]error{
+		":10000000000102030405060708090A0B0C0D0E0F79\n":                                          flash.ErrChecksum,
+		":0F000000000102030405060708090A0B0C0D0E0F78\n":                                          flash.ErrSyntax,
+		":10000000000102030405060708090A0B0C0D0E0F78\n:0100080011E6\n":                           flash.ErrOverlap,
+		"10000000000102030405060708090A0B0C0D0E0F78\n":                                           flash.ErrFormat,
+		":020000040800F2\n:10000000000102030405060708090A0B0C0D0E0F78\n:0000000AF6\n:00000001FF": flash.ErrSyntax,
+	}
+	for input, expected := range invalid {
+		if _, err := flash.Parse(strings.NewReader(input)); !errors.Is(err, expected) {
+			t.Errorf("%q: got %v, expected %v", input, err, expected)
+		}
+	}
+}
+
+func TestParseSRecord(t *testing.T) {
+	img, err := flash.Parse(strings.NewReader(auxSRecord))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auxCheckImage(t, img, []flash.Segment{
+		{Address: 0x0100, Data: []byte{1, 2, 3}},
+		{Address: 0x010000, Data: []byte{4, 5}},
+		{Address: 0x08000000, Data: auxCounting(0, 64)},
+		{Address: 0x08001000, Data: auxCounting(0xA0, 20)},
+	})
+	if string(img.Header) != "test" {
+		t.Errorf("got header %q", img.Header)
+	}
+	if _, err := flash.ParseSRecord(strings.NewReader("S1060100010203F3\n")); !errors.Is(err, flash.ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+	if _, err := flash.ParseSRecord(strings.NewReader("S4060100010203F2\n")); !errors.Is(err, flash.ErrSyntax) {
+		t.Errorf("expected ErrSyntax, got %v", err)
+	}
+}
+
+// Memory of the simulated ECU written by downloads
+type auxTarget struct {
+	memory   map[uint32]byte
+	address  uint32
+	counter  uint8
+	erased   int
+	segment  flash.Segment // area of the latest erase
+	requests int
+}
+
+// Starts a simulated ECU accepting downloads and returns a client for it
+func auxECU(t *testing.T) (*auxTarget, *sim.Server, *uds.Client) {
+	newBus := func() gocan.Bus {
+		bus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name()})
+		if err != nil {
+			t.Fatalf("error while creating bus: %v", err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		return bus
+	}
+	server, err := sim.NewServer(newBus(), &sim.Config{
+		RxID: 0x7E0, TxID: 0x7E8,
+		Security: []sim.SecurityLevel{{Level: 0x11, KeyXOR: sim.Hex{0xFF}, Access: sim.Access{Sessions: []uds.Session{uds.ProgrammingSession}}}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target := &auxTarget{memory: make(map[uint32]byte)}
+	server.Handle(uds.RequestDownload, func(req []byte) ([]byte, error) {
+		if server.Session() != uds.ProgrammingSession || server.SecurityLevel() != 0x11 {
+			return nil, uds.ConditionsNotCorrect
+		}
+		if len(req) != 11 || req[2] != uds.AddressAndLengthFormat {
+			return nil, uds.IncorrectMessageLengthOrFormat
+		}
+		target.address, target.counter = binary.BigEndian.Uint32(req[3:7]), 1
+		target.requests++
+		// maxNumberOfBlockLength of 18 bytes
+		return []byte{0x74, 0x20, 0x00, 0x12}, nil
+	})
+	server.Handle(uds.TransferData, func(req []byte) ([]byte, error) {
+		if req[1] != target.counter {
+			return nil, uds.WrongBlockSequenceCounter
+		}
+		for _, b := range req[2:] {
+			target.memory[target.address] = b
+			target.address++
+		}
+		target.counter++
+		return []byte{0x76, req[1]}, nil
+	})
+	server.Handle(uds.RequestTransferExit, func(req []byte) ([]byte, error) {
+		return []byte{0x77}, nil
+	})
+	server.SetRoutine(sim.Routine{ID: 0xFF00, Handler: func(control uds.RoutineControlType, data []byte) ([]byte, error) {
+		address, size := binary.BigEndian.Uint32(data[1:5]), binary.BigEndian.Uint32(data[5:9])
+		for a := address; a < address+size; a++ {
+			delete(target.memory, a)
+		}
+		target.erased++
+		target.segment = flash.Segment{Address: address, Data: make([]byte, size)}
+		return []byte{0x00}, nil
+	}})
+	server.SetRoutine(sim.Routine{ID: 0x0202, Handler: func(control uds.RoutineControlType, data []byte) ([]byte, error) {
+		// checksum of the latest erased area
+		mem := target.segment.Data
+		for i := range mem {
+			mem[i] = target.memory[target.segment.Address+uint32(i)]
+		}
+		if binary.BigEndian.Uint32(data) != crc32.ChecksumIEEE(mem) {
+			return []byte{0x01}, nil
+		}
+		return []byte{0x00}, nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		server.Close()
+	})
+
+	conn, err := isotp.NewConn(newBus(), isotp.Address{TxID: 0x7E0, RxID: 0x7E8}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	key := func(level uint8, seed []byte) ([]byte, error) {
+		key := make([]byte, len(seed))
+		for i := range seed {
+			key[i] = seed[i] ^ 0xFF
+		}
+		return key, nil
+	}
+	return target, server, uds.NewClient(conn, &uds.Config{P2: 200 * time.Millisecond, Key: key})
+}
+
+// Checks that the memory of the target holds the image
+func auxCheckMemory(t *testing.T, target *auxTarget, img *flash.Image) {
+	for _, s := range img.Segments {
+		for i, b := range s.Data {
+			if got, ok := target.memory[s.Address+uint32(i)]; !ok || got != b {
+				t.Fatalf("0x%08X: got 0x%02X (%v), expected 0x%02X", s.Address+uint32(i), got, ok, b)
+			}
+		}
+	}
+	if len(target.memory) != img.Size() {
+		t.Errorf("got %v bytes written, expected %v", len(target.memory), img.Size())
+	}
+}
+
+func TestFlash(t *testing.T) {
+	img, err := flash.Parse(strings.NewReader(auxHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target, server, client := auxECU(t)
+
+	var progress []flash.Progress
+	flasher := flash.NewFlasher(client, &flash.Config{
+		SecurityLevel: 0x11,
+		EraseRoutine:  0xFF00,
+		CheckRoutine:  0x0202,
+		Progress:      func(p flash.Progress) { progress = append(progress, p) },
+	})
+	if err := flasher.Flash(auxContext(t), img); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auxCheckMemory(t, target, img)
+	if target.erased != 2 || server.Session() != uds.ProgrammingSession {
+		t.Errorf("got %v erases in session %v", target.erased, server.Session())
+	}
+
+	// 16 data bytes per block
+	stages := []flash.Stage{flash.StageSession, flash.StageSecurity, flash.StageErase, flash.StageDownload}
+	for i, stage := range stages {
+		if progress[i].Stage != stage {
+			t.Errorf("progress %v: got %v, expected %v", i, progress[i].Stage, stage)
+		}
+	}
+	downloads := 0
+	for _, p := range progress {
+		if p.Stage == flash.StageDownload {
+			downloads++
+		}
+	}
+	if last := progress[len(progress)-1]; last.Stage != flash.StageDone || last.Written != 84 || last.Total != 84 || downloads != 2+4+2 {
+		t.Errorf("got last progress %+v and %v download reports", last, downloads)
+	}
+}
+
+func TestResume(t *testing.T) {
+	img, err := flash.Parse(strings.NewReader(auxHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target, server, client := auxECU(t)
+	server.AddResponse(sim.NegativeResponse{Request: sim.Hex{0x36, 0x03}, NRC: uds.GeneralProgrammingFailure, Count: 1})
+	flasher := flash.NewFlasher(client, &flash.Config{SecurityLevel: 0x11, EraseRoutine: 0xFF00, CheckRoutine: 0x0202})
+	ctx := auxContext(t)
+
+	err = flasher.Flash(ctx, img)
+	if !errors.Is(err, uds.GeneralProgrammingFailure) {
+		t.Fatalf("expected GeneralProgrammingFailure, got %v", err)
+	}
+	if cp := flasher.Checkpoint(); cp.Segment != 0 || cp.Offset != 32 {
+		t.Errorf("got checkpoint %+v, expected segment 0 offset 32", cp)
+	}
+	if err := flasher.Resume(ctx, img); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auxCheckMemory(t, target, img)
+	if target.erased != 2 || target.requests != 3 {
+		t.Errorf("got %v erases and %v downloads, expected 2 and 3", target.erased, target.requests)
+	}
+
+	// cancelled context aborts the sequence
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := flasher.Flash(cancelled, img); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := flash.NewFlasher(client, nil).Flash(ctx, &flash.Image{}); !errors.Is(err, flash.ErrNoImage) {
+		t.Errorf("expected ErrNoImage, got %v", err)
+	}
+}
+
+func TestBlockLength(t *testing.T) {
+	img, err := flash.Parse(strings.NewReader(auxHex))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, server, client := auxECU(t)
+
+	// configured limit without room for data is rejected before the sequence starts
+	flasher := flash.NewFlasher(client, &flash.Config{SecurityLevel: 0x11, MaxBlockLength: 2})
+	if err := flasher.Flash(auxContext(t), img); !errors.Is(err, flash.ErrBlockLength) {
+		t.Errorf("expected ErrBlockLength, got %v", err)
+	}
+	if server.Session() != uds.DefaultSession {
+		t.Errorf("got session %v, expected no programming", server.Session())
+	}
+}
+
+func auxContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
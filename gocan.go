@@ -1,9 +1,17 @@
 package gocan
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
 type MessageID uint32
 type MessageType uint8
 type BusState uint8
 type ChannelCondition uint8
+type Direction uint8
 
 // Different types of available frames on a CAN bus connection
 const (
@@ -21,6 +29,12 @@ const (
 	PASSIVE BusState = iota // listen-only
 )
 
+// Direction of a message
+const (
+	Rx Direction = iota // Message was received
+	Tx Direction = iota // Message was sent by this node, e.g. echo frames or messages read from a log file
+)
+
 // Condition for a single handle
 const (
 	Available   ChannelCondition = iota // Channel is available for a connection
@@ -29,33 +43,117 @@ const (
 	Invalid     ChannelCondition = iota // Invalid state or not able to retrieve state for this interface
 )
 
+// Maximum amount of data bytes in a single message (CAN FD)
+const MaxDataLength = 64
+
+// errors
+var (
+	ErrNotSupported = errors.New("function not supported by this interface")
+	ErrRxOverrun    = errors.New("receive queue overrun, messages were lost")
+)
+
+// OverrunError is returned by Recv() and ReadInto() once after messages got lost, receiving can continue afterwards
+// A message read at the same time is returned along with the error, as only earlier messages got lost
+type OverrunError struct {
+	Hardware uint64 // overruns reported by the device, either lost messages or overrun events depending on the device
+	Software uint64 // messages dropped by a software receive buffer
+}
+
+func (e *OverrunError) Error() string {
+	return fmt.Sprintf("%v (hardware: %v, software: %v)", ErrRxOverrun, e.Hardware, e.Software)
+}
+
+// Makes errors.Is(err, ErrRxOverrun) work for any OverrunError
+func (e *OverrunError) Is(target error) bool {
+	return target == ErrRxOverrun
+}
+
 // CAN message for standard CAN and CAN FD
 type Message struct {
 	ID         MessageID
 	Data       []byte
-	TimeStamp  uint64      // only set when receiving message
+	TimeStamp  uint64      // receive time in µs since unix epoch, only set when receiving message
 	Type       MessageType // only set when receiving message
 	DLC        uint8       // only set when receiving message
 	Channel    string      // only set when receiving message
 	IsExtended bool        // only set when receiving message
 	IsFD       bool        // only set when receiving message
+	Direction  Direction   // only set when receiving message
+
+	inline [MaxDataLength]byte // storage used by SetData, avoids a separate allocation for every received frame
 }
 
-// Interface for all main CANBus functionality. Lower device interfaces may support more functionality
-type Bus interface {
-	Send(*Message) error                                          // Send a single message on the CAN bus
-	Recv(timeout int) (*Message, error)                           // Receive single message from CAN bus with timeout in [ms], a timeout below zero is treated as no timeout
-	StatusIsOkay() (bool, error)                                  // Check function if the connection state is okay
-	Status() (uint32, error)                                      // Returns the CAN status code, which can differ between different devices
-	State() BusState                                              // Returns the bus state (ACTIVE or PASSIVE)
-	ReadBuffer(limit uint16) ([]Message, error)                   // Empties the internal CAN hardware message buffer is device supports this feature with a maximum message count
+// Copies data into the inline storage of the message and points Data to it, data exceeding MaxDataLength is cut off
+// Note: a value copy of the message shares this storage, use Clone() for copies outliving the original
+func (m *Message) SetData(data []byte) {
+	n := copy(m.inline[:], data)
+	m.Data = m.inline[:n]
+}
+
+// Returns a copy of the message with its own copy of Data, which stays valid when the original is reused
+func (m *Message) Clone() Message {
+	c := *m
+	c.Data = bytes.Clone(m.Data)
+	return c
+}
+
+// Sender is implemented by anything able to transmit CAN messages
+type Sender interface {
+	Send(*Message) error // Send a single message on the CAN bus
+}
+
+// Receiver is implemented by anything able to deliver received CAN messages
+type Receiver interface {
+	Recv(timeout int) (*Message, error)         // Receive single message from CAN bus with timeout in [ms], a timeout below zero is treated as no timeout
+	ReadBuffer(limit uint16) ([]Message, error) // Empties the internal CAN hardware message buffer is device supports this feature with a maximum message count, the returned messages own their data
+}
+
+// Transceiver is able to send and receive CAN messages, which is all most protocol layers need
+type Transceiver interface {
+	Sender
+	Receiver
+}
+
+// BatchReceiver is implemented by devices able to read multiple messages into caller owned storage without allocations
+type BatchReceiver interface {
+	ReadInto(buf []Message) (int, error) // Reads up to len(buf) buffered messages into buf and returns the amount of messages read, does not wait for messages
+}
+
+// BatchSender is implemented by devices able to transmit multiple messages in one call
+type BatchSender interface {
+	SendBatch(msgs []Message) (int, error) // Sends all messages in order and returns the amount of messages sent until an error occurred
+}
+
+// Filterer is implemented by devices supporting a message id filter
+type Filterer interface {
 	SetFilter(fromID MessageID, toID MessageID, mode uint8) error // Set a message id filter on hardware if supported by device
 	ResetFilter() error                                           // Removes set message filter
-	Reset() error                                                 // Reset rx and tx buffer, does not reset hardware
-	Shutdown() error                                              // Disconnect from device
-	ChannelCondition() (ChannelCondition, error)                  // Returns channel condition
-	TraceStart(filePath string, maxFileSize uint32) error         // Starts recording a trace on given path with a max file size in MB (0 for unlimited file size). Note: For most hardware, to fill the trace file, the Recv() function must be called!
-	TraceStop() error                                             // Stops recording currently running trace
+}
+
+// StatusReporter reports the state and condition of a connection
+type StatusReporter interface {
+	StatusIsOkay() (bool, error)                 // Check function if the connection state is okay
+	Status() (uint32, error)                     // Returns the CAN status code, which can differ between different devices
+	State() BusState                             // Returns the bus state (ACTIVE or PASSIVE)
+	ChannelCondition() (ChannelCondition, error) // Returns channel condition
+}
+
+// Tracer is implemented by devices able to record a trace file on their own
+type Tracer interface {
+	TraceStart(filePath string, maxFileSize uint32) error // Starts recording a trace on given path with a max file size in MB (0 for unlimited file size). Note: For most hardware, to fill the trace file, the Recv() function must be called!
+	TraceStop() error                                     // Stops recording currently running trace
+}
+
+// Interface for all main CANBus functionality. Lower device interfaces may support more functionality
+type Bus interface {
+	Sender
+	Receiver
+	Filterer
+	StatusReporter
+	Tracer
+	io.Closer        // Disconnect from device, same as Shutdown()
+	Reset() error    // Reset rx and tx buffer, does not reset hardware
+	Shutdown() error // Disconnect from device
 }
 
 // CANBus config ready to be read from any json file
@@ -70,4 +168,5 @@ type Config struct {
 	RecvRTRFrames    bool     `json:"RecvRTRFrames"`    // If set to true, remote transmission frames can be received on Recv() call
 	RecvErrorFrames  bool     `json:"RecvErrorFrames"`  // If set to true, error frames can be received on Recv() call
 	RecvEchoFrames   bool     `json:"RecvEchoFrames"`   // If set to true, echo frames can be received on Recv() call
+	RxBufferSize     int      `json:"RxBufferSize"`     // If above zero, a background reader drains the device into a software receive buffer of this size
 }
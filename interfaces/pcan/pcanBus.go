@@ -14,7 +14,7 @@ import (
 // defines and singleton values
 const StandardLanguage = LanguageNeutral // selected language for error messages
 const PositionStateInDataStatusFrame = 3 // position of TPCANStatus inside a StatusFrame message
-var bootTimeEpoch uint64 = 0             // Message epoch time / TODO implement this to be not zero but the correct epoch time (datasheet or maybe python implementation)
+var bootTimeEpoch uint64 = 0             // system boot time in µs since unix epoch, PCAN timestamps count from boot
 var hasEvents = true                     // indicates if WaitForSingleObject can be used to reduce CPU load while waiting for messages /
 
 var (
@@ -228,6 +228,10 @@ func NewPCANBus(config *gocan.Config) (gocan.Bus, error) {
 		}
 	}
 
+	if bootTimeEpoch == 0 {
+		bootTimeEpoch = bootTime()
+	}
+
 	// create bus
 	if config.IsFD {
 		return nil, errors.New("CANFD not implemented error")
@@ -269,6 +273,21 @@ func NewPCANBus(config *gocan.Config) (gocan.Bus, error) {
 	}
 }
 
+// Returns the system boot time in µs since unix epoch, the time base of PCAN timestamps
+// Falls back to zero, which keeps timestamps relative to boot, if the uptime is not available
+func bootTime() uint64 {
+	modkernel32, errLoad := syscall.LoadLibrary("kernel32.dll")
+	if errLoad != nil {
+		return 0
+	}
+	procTickCount, errOpen := syscall.GetProcAddress(modkernel32, "GetTickCount64")
+	if errOpen != nil || procTickCount == 0 {
+		return 0
+	}
+	uptime, _, _ := syscall.SyscallN(procTickCount) // milliseconds since boot
+	return uint64(time.Now().UnixMicro()) - uint64(uptime)*1000
+}
+
 // Initializes PCANStandardBus channel
 func (p *pcanBus) Initialize() error {
 
@@ -330,6 +349,9 @@ func (p *pcanBus) Recv(timeout int) (*gocan.Message, error) {
 	// receive message
 	for msg == nil {
 		ret, msg, err = p.recvSingleMessage()
+		if err != nil {
+			return msg, err
+		}
 		if ret == PCAN_ERROR_QRCVEMPTY {
 			if hasEvents {
 				val, errWait := syscall.WaitForSingleObject(p.recvEvent, timeoutU32)
@@ -358,14 +380,25 @@ func (p *pcanBus) Recv(timeout int) (*gocan.Message, error) {
 
 // Reads single message from PCAN CAN gocan.
 func (p *pcanBus) recvSingleMessage() (TPCANStatus, *gocan.Message, error) {
-
 	var newMsg gocan.Message
+	ret, ok, err := p.recvInto(&newMsg)
+	if !ok {
+		return ret, nil, err
+	}
+	return ret, &newMsg, err
+}
+
+// Reads single message from PCAN channel into given message without allocating, returns false if no message was read
+// A message read along with an overrun is returned together with an OverrunError
+func (p *pcanBus) recvInto(dst *gocan.Message) (TPCANStatus, bool, error) {
+
 	var msgType gocan.MessageType
 	var ret = PCAN_ERROR_UNKNOWN
 	var msg TPCANMsg
 	var msgFD TPCANMsgFD
 	var timestamp TPCANTimestamp
 	var timestampFD TPCANTimestampFD
+	var rxID TPCANMsgID            // buffer for uniform handling FD or std messages
 	var rxData []byte              // buffer for uniform handling FD or std messages
 	var rxMsgType TPCANMessageType // buffer for uniform handling FD or std messages
 	var rxTimeStamp uint64         // buffer for uniform handling FD or std messages
@@ -376,25 +409,32 @@ func (p *pcanBus) recvSingleMessage() (TPCANStatus, *gocan.Message, error) {
 	if p.Config.IsFD {
 		ret, msgFD, timestampFD, err = ReadFD(p.Handle)
 		if err != nil || ret == PCAN_ERROR_QRCVEMPTY {
-			return ret, nil, err
+			return ret, false, err
 		}
+		rxID = msgFD.ID
 		rxDLC = msgFD.DLC
 		rxMsgType = msgFD.MsgType
-		rxTimeStamp = bootTimeEpoch + uint64(timestampFD)/(1000.0*1000.0)
+		rxTimeStamp = bootTimeEpoch + uint64(timestampFD)
 		rxData = msgFD.Data[:getLengthFromDLC(rxDLC)] // only return the suggested message length, even if full message is held in buffer with up to 64 byte
 	} else {
 		ret, msg, timestamp, err = Read(p.Handle)
 
 		if err != nil || ret == PCAN_ERROR_QRCVEMPTY {
-			return ret, nil, err
+			return ret, false, err
 		}
 
+		rxID = msg.ID
 		rxDLC = msg.DLC
 		rxMsgType = msg.MsgType
-		rxTimeStamp = bootTimeEpoch + ((uint64(timestamp.Micros) + 1000*uint64(timestamp.Millis) + uint64(0x100000000)*1000*uint64(timestamp.MillisOverflow)) / (1000.0 * 1000.0))
+		rxTimeStamp = bootTimeEpoch + uint64(timestamp.Micros) + 1000*uint64(timestamp.Millis) + uint64(0x100000000)*1000*uint64(timestamp.MillisOverflow)
 		rxData = msg.Data[:getLengthFromDLC(rxDLC)] // only return the suggested message length, even if full message is held in buffer with 8 byte
 	}
 
+	// the overrun flag reports messages lost before this one, the read message itself is valid
+	if ret&PCAN_ERROR_QOVERRUN != 0 {
+		err = &gocan.OverrunError{Hardware: 1}
+	}
+
 	// determine message frame type
 	switch rxMsgType {
 	case PCAN_MESSAGE_STANDARD, PCAN_MESSAGE_EXTENDED, PCAN_MESSAGE_FD:
@@ -410,18 +450,20 @@ func (p *pcanBus) recvSingleMessage() (TPCANStatus, *gocan.Message, error) {
 	}
 
 	// save message data
-	newMsg = gocan.Message{
-		ID:         gocan.MessageID(msg.ID),
-		TimeStamp:  rxTimeStamp,
-		Type:       msgType,
-		Data:       rxData,
-		DLC:        rxDLC,
-		Channel:    p.Config.Channel,
-		IsFD:       rxMsgType == PCAN_MESSAGE_FD || rxMsgType == PCAN_MESSAGE_ESI || rxMsgType == PCAN_MESSAGE_BRS,
-		IsExtended: rxMsgType == PCAN_MESSAGE_EXTENDED,
+	dst.ID = gocan.MessageID(rxID)
+	dst.TimeStamp = rxTimeStamp
+	dst.Type = msgType
+	dst.DLC = rxDLC
+	dst.Channel = p.Config.Channel
+	dst.IsFD = rxMsgType == PCAN_MESSAGE_FD || rxMsgType == PCAN_MESSAGE_ESI || rxMsgType == PCAN_MESSAGE_BRS
+	dst.IsExtended = rxMsgType == PCAN_MESSAGE_EXTENDED
+	dst.Direction = gocan.Rx
+	if rxMsgType&PCAN_MESSAGE_ECHO != 0 {
+		dst.Direction = gocan.Tx
 	}
+	dst.SetData(rxData)
 
-	return ret, &newMsg, nil
+	return ret, true, err
 }
 
 // Sends message over PCAN channel
@@ -489,16 +531,39 @@ func (p *pcanBus) ReadBuffer(limit uint16) ([]gocan.Message, error) {
 	// read until buffer empty is returned
 	for {
 		ret, msg, err = p.recvSingleMessage()
-		if ret == PCAN_ERROR_QRCVEMPTY {
+		if msg != nil {
+			msgs = append(msgs, msg.Clone())
+		}
+		if ret == PCAN_ERROR_QRCVEMPTY || err != nil || (limit != 0 && len(msgs) >= int(limit)) {
 			return msgs, err
 		}
-		if msg != nil {
-			msgs = append(msgs, *msg)
-			if limit != 0 && len(msgs) >= int(limit) {
-				return msgs, err
-			}
+	}
+}
+
+// Reads from device buffer into caller owned storage until it has no more messages stored or buf is full
+// Returns the amount of messages read, no memory is allocated
+func (p *pcanBus) ReadInto(buf []gocan.Message) (int, error) {
+	n := 0
+	for n < len(buf) {
+		ret, ok, err := p.recvInto(&buf[n])
+		if ok {
+			n++
+		}
+		if ret == PCAN_ERROR_QRCVEMPTY || err != nil {
+			return n, err
 		}
 	}
+	return n, nil
+}
+
+// Sends all messages in order and returns the amount of messages sent until an error occurred
+func (p *pcanBus) SendBatch(msgs []gocan.Message) (int, error) {
+	for i := range msgs {
+		if err := p.Send(&msgs[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
 }
 
 // Retrieves a TPCANParameter value from channel or device (only work for simple parameters)
@@ -553,6 +618,11 @@ func (p *pcanBus) Shutdown() error {
 	return evalRetval(state, err)
 }
 
+// Closes the connection, same as Shutdown()
+func (p *pcanBus) Close() error {
+	return p.Shutdown()
+}
+
 // Turn on or off flashing of the device's LED for physical identification purposes
 func (p *pcanBus) SetLEDState(ledState bool) error {
 	val := PCAN_PARAMETER_OFF
@@ -0,0 +1,301 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+)
+
+func auxInitBusPair(channel string) (gocan.Bus, gocan.Bus, error) {
+	tx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: channel, BaudRate: 500000})
+	if err != nil {
+		return nil, nil, err
+	}
+	rx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: channel, BaudRate: 500000})
+	return tx, rx, err
+}
+
+func TestSendRecv(t *testing.T) {
+	tx, rx, err := auxInitBusPair("TestSendRecv")
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	defer rx.Close()
+
+	err = tx.Send(&gocan.Message{ID: 0x12345, Data: []byte{1, 2, 3}, IsExtended: true})
+	if err != nil {
+		t.Fatalf("error while sending: %v", err)
+	}
+
+	msg, err := rx.Recv(100)
+	if err != nil || msg == nil {
+		t.Fatalf("no message: msg: %v, err: %v", msg, err)
+	}
+	if msg.ID != 0x12345 || !msg.IsExtended || msg.DLC != 3 || len(msg.Data) != 3 || msg.Data[2] != 3 || msg.Channel != "TestSendRecv" {
+		t.Errorf("invalid message: %v", msg)
+	}
+
+	// sender does not receive own messages without echo
+	msg, err = tx.Recv(10)
+	if msg != nil || err != nil {
+		t.Errorf("unexpected echo: msg: %v, err: %v", msg, err)
+	}
+}
+
+func TestRemoteFrame(t *testing.T) {
+	tx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRemoteFrame", BaudRate: 500000})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	rx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRemoteFrame", BaudRate: 500000, RecvRTRFrames: true})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer rx.Close()
+
+	// remote frames keep the requested DLC without data
+	err = tx.Send(&gocan.Message{ID: 0x321, Type: gocan.RemoteFrame, DLC: 6})
+	if err != nil {
+		t.Fatalf("error while sending: %v", err)
+	}
+	msg, err := rx.Recv(100)
+	if err != nil || msg == nil {
+		t.Fatalf("no message: msg: %v, err: %v", msg, err)
+	}
+	if msg.ID != 0x321 || msg.Type != gocan.RemoteFrame || msg.DLC != 6 || len(msg.Data) != 0 {
+		t.Errorf("invalid remote frame: %v", msg)
+	}
+
+	if err := tx.Send(&gocan.Message{ID: 0x321, Type: gocan.RemoteFrame, DLC: 9}); !errors.Is(err, virtual.ErrInvalidLength) {
+		t.Errorf("expected ErrInvalidLength, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tx, rx, err := auxInitBusPair("TestFilter")
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	defer rx.Close()
+
+	rx.SetFilter(0x100, 0x1FF, 0)
+	for _, id := range []gocan.MessageID{0x050, 0x100, 0x150, 0x200} {
+		tx.Send(&gocan.Message{ID: id, Data: []byte{0}})
+	}
+
+	msgs, err := rx.ReadBuffer(0)
+	if err != nil || len(msgs) != 2 || msgs[0].ID != 0x100 || msgs[1].ID != 0x150 {
+		t.Errorf("filter not applied: msgs: %v, err: %v", msgs, err)
+	}
+}
+
+func TestQueueOverrun(t *testing.T) {
+	oldSize := virtual.QueueSize
+	virtual.QueueSize = 4
+	tx, rx, err := auxInitBusPair("TestQueueOverrun")
+	virtual.QueueSize = oldSize
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	defer rx.Close()
+
+	for i := 0; i < 6; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+
+	status, _ := rx.Status()
+	if status != virtual.StatusQueueOverrun {
+		t.Errorf("expected queue overrun, got status %v", status)
+	}
+	buf := make([]gocan.Message, 8)
+	n, err := gocan.ReadInto(rx, buf)
+	if n != 4 || buf[3].ID != 3 {
+		t.Errorf("expected the first 4 messages, got %v: %v", n, buf[:n])
+	}
+	var overrun *gocan.OverrunError
+	if !errors.As(err, &overrun) || overrun.Hardware != 2 {
+		t.Errorf("expected overrun error with 2 lost messages, got %v", err)
+	}
+	if n, err = gocan.ReadInto(rx, buf); n != 0 || err != nil {
+		t.Errorf("overrun reported twice: %v, %v", n, err)
+	}
+}
+
+func TestQueueGrowth(t *testing.T) {
+	oldSize := virtual.QueueSize
+	virtual.QueueSize = 300
+	tx, rx, err := auxInitBusPair("TestQueueGrowth")
+	virtual.QueueSize = oldSize
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	defer rx.Close()
+
+	// the queue grows while wrapped around, the order and data of pending messages are kept
+	for i := 0; i < 40; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+	buf := make([]gocan.Message, 300)
+	if n, _ := gocan.ReadInto(rx, buf[:30]); n != 30 {
+		t.Fatalf("expected 30 messages, got %v", n)
+	}
+	for i := 40; i < 340; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+
+	n, err := gocan.ReadInto(rx, buf)
+	var overrun *gocan.OverrunError
+	if n != 300 || !errors.As(err, &overrun) || overrun.Hardware != 10 {
+		t.Fatalf("expected 300 messages and 10 lost, got %v, %v", n, err)
+	}
+	for i := range buf {
+		if buf[i].ID != gocan.MessageID(30+i) || buf[i].Data[0] != byte(30+i) {
+			t.Fatalf("message %v: got %+v", i, buf[i])
+		}
+	}
+}
+
+func TestReadIntoSendBatch(t *testing.T) {
+	tx, rx, err := auxInitBusPair("TestReadIntoSendBatch")
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer tx.Close()
+	defer rx.Close()
+
+	batch := make([]gocan.Message, 10)
+	for i := range batch {
+		batch[i] = gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i), byte(i)}}
+	}
+	n, err := gocan.SendBatch(tx, batch)
+	if err != nil || n != len(batch) {
+		t.Fatalf("error while sending batch: sent %v, err: %v", n, err)
+	}
+
+	buf := make([]gocan.Message, 4)
+	total := 0
+	for {
+		n, err := gocan.ReadInto(rx, buf)
+		if err != nil {
+			t.Fatalf("error while reading: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		for i := 0; i < n; i++ {
+			if buf[i].ID != gocan.MessageID(total) || buf[i].Data[1] != byte(total) {
+				t.Errorf("unexpected message %v: %v", total, buf[i])
+			}
+			total++
+		}
+	}
+	if total != len(batch) {
+		t.Errorf("expected %v messages, got %v", len(batch), total)
+	}
+}
+
+// benchmarks comparing the allocations of the different receive paths
+func auxFillBus(b *testing.B, tx gocan.Bus, batch []gocan.Message) {
+	if _, err := gocan.SendBatch(tx, batch); err != nil {
+		b.Fatalf("error while sending batch: %v", err)
+	}
+}
+
+func auxBenchBatch(size int) []gocan.Message {
+	batch := make([]gocan.Message, size)
+	for i := range batch {
+		batch[i] = gocan.Message{ID: gocan.MessageID(i), Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	}
+	return batch
+}
+
+func BenchmarkRecv(b *testing.B) {
+	tx, rx, _ := auxInitBusPair(fmt.Sprintf("BenchmarkRecv%v", b.N))
+	defer tx.Close()
+	defer rx.Close()
+	batch := auxBenchBatch(256)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		auxFillBus(b, tx, batch)
+		for range batch {
+			if msg, _ := rx.Recv(0); msg == nil {
+				b.Fatal("missing message")
+			}
+		}
+	}
+}
+
+func BenchmarkReadBuffer(b *testing.B) {
+	tx, rx, _ := auxInitBusPair(fmt.Sprintf("BenchmarkReadBuffer%v", b.N))
+	defer tx.Close()
+	defer rx.Close()
+	batch := auxBenchBatch(256)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		auxFillBus(b, tx, batch)
+		if msgs, _ := rx.ReadBuffer(0); len(msgs) != len(batch) {
+			b.Fatal("missing message")
+		}
+	}
+}
+
+func BenchmarkReadInto(b *testing.B) {
+	tx, rx, _ := auxInitBusPair(fmt.Sprintf("BenchmarkReadInto%v", b.N))
+	defer tx.Close()
+	defer rx.Close()
+	batch := auxBenchBatch(256)
+	buf := make([]gocan.Message, len(batch))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		auxFillBus(b, tx, batch)
+		if n, _ := gocan.ReadInto(rx, buf); n != len(batch) {
+			b.Fatal("missing message")
+		}
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	tx, rx, _ := auxInitBusPair(fmt.Sprintf("BenchmarkSend%v", b.N))
+	defer tx.Close()
+	defer rx.Close()
+	batch := auxBenchBatch(256)
+	buf := make([]gocan.Message, len(batch))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range batch {
+			tx.Send(&batch[j])
+		}
+		gocan.ReadInto(rx, buf)
+	}
+}
+
+func BenchmarkSendBatch(b *testing.B) {
+	tx, rx, _ := auxInitBusPair(fmt.Sprintf("BenchmarkSendBatch%v", b.N))
+	defer tx.Close()
+	defer rx.Close()
+	batch := auxBenchBatch(256)
+	buf := make([]gocan.Message, len(batch))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gocan.SendBatch(tx, batch)
+		gocan.ReadInto(rx, buf)
+	}
+}
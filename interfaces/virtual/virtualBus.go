@@ -0,0 +1,438 @@
+package virtual
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Status codes returned by Status()
+const (
+	StatusOK           uint32 = 0x00 // No error
+	StatusQueueOverrun uint32 = 0x01 // Receive queue was read too late, messages were lost
+)
+
+// Size of the receive queue for every newly created virtual bus, comparable to the hardware queue of a real device
+// The queue starts small and grows up to this size while messages are pending
+var QueueSize = 32768
+
+// Initial size of the receive queue
+const initialQueueSize = 64
+
+// errors
+var (
+	ErrBusClosed     = errors.New("virtual bus is already closed")
+	ErrListenOnly    = errors.New("virtual bus is in listen-only mode")
+	ErrInvalidLength = errors.New("message data exceeds maximum length")
+)
+
+// all buses connected to the same channel name
+type hub struct {
+	mu    sync.RWMutex
+	buses []*virtualBus
+}
+
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[string]*hub)
+)
+
+// filter settings applied when delivering messages
+type idFilter struct {
+	active bool
+	fromID gocan.MessageID
+	toID   gocan.MessageID
+}
+
+// virtualBus in-memory CAN bus, all buses created with the same channel name receive the messages sent by each other
+type virtualBus struct {
+	Config gocan.Config
+
+	hub      *hub
+	mu       sync.Mutex
+	queue    []gocan.Message // ring buffer holding received messages
+	maxQueue int             // size up to which queue grows
+	head     int             // position of the oldest message in queue
+	count    int             // amount of messages in queue
+	notify   chan struct{}   // signals a newly queued message to a waiting Recv() call
+	overrun  bool            // set if messages got lost since the last Reset()
+	lost     uint64          // messages lost since the last reported overrun
+	filter   idFilter
+	isClosed bool
+}
+
+// Creates a virtual bus connected to all other virtual buses with the same channel name
+func NewVirtualBus(config *gocan.Config) (gocan.Bus, error) {
+
+	size := QueueSize
+	if size <= 0 {
+		size = 1
+	}
+
+	hubsMu.Lock()
+	h, ok := hubs[config.Channel]
+	if !ok {
+		h = &hub{}
+		hubs[config.Channel] = h
+	}
+	hubsMu.Unlock()
+
+	newBus := &virtualBus{
+		Config:   *config,
+		hub:      h,
+		queue:    make([]gocan.Message, min(size, initialQueueSize)),
+		maxQueue: size,
+		notify:   make(chan struct{}, 1),
+	}
+
+	h.mu.Lock()
+	h.buses = append(h.buses, newBus)
+	h.mu.Unlock()
+
+	return newBus, nil
+}
+
+// Sends message to all other buses on the same channel, an echo is only received if RecvEchoFrames is set
+// Remote frames are delivered with the DLC of msg, all other messages with the DLC of their data length
+func (v *virtualBus) Send(msg *gocan.Message) error {
+	if err := v.checkSend(msg); err != nil {
+		return err
+	}
+
+	timeStamp := uint64(time.Now().UnixMicro())
+
+	v.hub.mu.RLock()
+	defer v.hub.mu.RUnlock()
+	for _, dst := range v.hub.buses {
+		if dst != v || v.Config.RecvEchoFrames {
+			dst.deliver(msg, timeStamp, dst == v)
+		}
+	}
+	return nil
+}
+
+// Sends all messages in order and returns the amount of messages sent until an error occurred
+func (v *virtualBus) SendBatch(msgs []gocan.Message) (int, error) {
+	for i := range msgs {
+		if err := v.checkSend(&msgs[i]); err != nil {
+			return i, err
+		}
+	}
+
+	timeStamp := uint64(time.Now().UnixMicro())
+
+	v.hub.mu.RLock()
+	defer v.hub.mu.RUnlock()
+	for _, dst := range v.hub.buses {
+		if dst != v || v.Config.RecvEchoFrames {
+			for i := range msgs {
+				dst.deliver(&msgs[i], timeStamp, dst == v)
+			}
+		}
+	}
+	return len(msgs), nil
+}
+
+// Returns an error if the message can not be sent by this bus
+func (v *virtualBus) checkSend(msg *gocan.Message) error {
+	v.mu.Lock()
+	closed := v.isClosed
+	v.mu.Unlock()
+
+	if closed {
+		return ErrBusClosed
+	}
+	if v.Config.BusState == gocan.PASSIVE {
+		return ErrListenOnly
+	}
+	if len(msg.Data) > gocan.MaxDataLength || (!msg.IsFD && len(msg.Data) > 8) {
+		return ErrInvalidLength
+	}
+	if msg.Type == gocan.RemoteFrame && msg.DLC > 8 {
+		return ErrInvalidLength
+	}
+	return nil
+}
+
+// Copies message into the receive queue, messages are dropped if the queue is full or they do not pass the filter
+// isEcho: message was sent by this bus
+func (v *virtualBus) deliver(msg *gocan.Message, timeStamp uint64, isEcho bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.isClosed || !v.passes(msg) {
+		return
+	}
+	if v.count == len(v.queue) && !v.grow() {
+		v.overrun = true
+		v.lost++
+		return
+	}
+
+	slot := &v.queue[(v.head+v.count)%len(v.queue)]
+	slot.ID = msg.ID
+	slot.TimeStamp = timeStamp
+	slot.Type = msg.Type
+	slot.DLC = gocan.DLCFromLength(len(msg.Data))
+	if msg.Type == gocan.RemoteFrame {
+		// remote frames request data of the length given by the DLC without holding data
+		slot.DLC = msg.DLC
+	}
+	slot.Channel = v.Config.Channel
+	slot.IsExtended = msg.IsExtended
+	slot.IsFD = msg.IsFD
+	slot.Direction = gocan.Rx
+	if isEcho {
+		slot.Direction = gocan.Tx
+	}
+	slot.SetData(msg.Data)
+	v.count++
+
+	select {
+	case v.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Doubles the size of the full queue up to maxQueue, returns false if the queue can not grow
+// Note: v.mu must be locked
+func (v *virtualBus) grow() bool {
+	if len(v.queue) >= v.maxQueue {
+		return false
+	}
+	queue := make([]gocan.Message, min(2*len(v.queue), v.maxQueue))
+	for i := 0; i < v.count; i++ {
+		queue[i] = v.queue[(v.head+i)%len(v.queue)]
+		queue[i].SetData(queue[i].Data) // data is stored inline and must point into the new slot
+	}
+	v.queue = queue
+	v.head = 0
+	return true
+}
+
+// Checks if message passes the configured receive settings and id filter
+func (v *virtualBus) passes(msg *gocan.Message) bool {
+	switch msg.Type {
+	case gocan.RemoteFrame:
+		if !v.Config.RecvRTRFrames {
+			return false
+		}
+	case gocan.ErrorFrame:
+		if !v.Config.RecvErrorFrames {
+			return false
+		}
+	}
+	if v.filter.active && (msg.ID < v.filter.fromID || msg.ID > v.filter.toID) {
+		return false
+	}
+	return true
+}
+
+// Returns an OverrunError once after messages got lost, otherwise nil
+// Note: v.mu must be locked
+func (v *virtualBus) takeOverrun() error {
+	if v.lost == 0 {
+		return nil
+	}
+	err := &gocan.OverrunError{Hardware: v.lost}
+	v.lost = 0
+	return err
+}
+
+// Removes the oldest message from the queue and copies it into dst, returns false if the queue is empty
+// Note: v.mu must be locked
+func (v *virtualBus) pop(dst *gocan.Message) bool {
+	if v.count == 0 {
+		return false
+	}
+	src := &v.queue[v.head]
+	*dst = *src
+	dst.SetData(src.Data)
+	v.head = (v.head + 1) % len(v.queue)
+	v.count--
+	return true
+}
+
+// Returns message from virtual bus
+// timeout: Timeout for receiving message in milliseconds (if set below zero, no timeout is set)
+// Every returned message is allocated, ReadInto() is the path without allocations
+func (v *virtualBus) Recv(timeout int) (*gocan.Message, error) {
+
+	var timer *time.Timer
+	for {
+		v.mu.Lock()
+		if v.isClosed {
+			v.mu.Unlock()
+			return nil, ErrBusClosed
+		}
+		if err := v.takeOverrun(); err != nil {
+			v.mu.Unlock()
+			return nil, err
+		}
+		var msg gocan.Message
+		if v.pop(&msg) {
+			v.mu.Unlock()
+			return &msg, nil
+		}
+		v.mu.Unlock()
+
+		// the timer is only created when waiting is needed
+		if timeout == 0 {
+			return nil, nil
+		}
+		var expired <-chan time.Time
+		if timeout > 0 {
+			if timer == nil {
+				timer = time.NewTimer(time.Duration(timeout) * time.Millisecond)
+				defer timer.Stop()
+			}
+			expired = timer.C
+		}
+
+		select {
+		case <-v.notify:
+		case <-expired:
+			return nil, nil
+		}
+	}
+}
+
+// Reads from receive queue until it has no more messages stored with an optional message limit
+// If limit is set to zero, no limit will will be used
+func (v *virtualBus) ReadBuffer(limit uint16) ([]gocan.Message, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.isClosed {
+		return nil, ErrBusClosed
+	}
+
+	n := v.count
+	if limit != 0 && n > int(limit) {
+		n = int(limit)
+	}
+	if n == 0 {
+		return nil, v.takeOverrun()
+	}
+	msgs := make([]gocan.Message, n)
+	var msg gocan.Message
+	for i := range msgs {
+		v.pop(&msg)
+		msgs[i] = msg.Clone()
+	}
+	return msgs, v.takeOverrun()
+}
+
+// Reads from receive queue into caller owned storage until it has no more messages stored or buf is full
+// Returns the amount of messages read, no memory is allocated
+func (v *virtualBus) ReadInto(buf []gocan.Message) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.isClosed {
+		return 0, ErrBusClosed
+	}
+
+	n := 0
+	for n < len(buf) && v.pop(&buf[n]) {
+		n++
+	}
+	return n, v.takeOverrun()
+}
+
+// Returns true if no messages got lost since the last Reset()
+func (v *virtualBus) StatusIsOkay() (bool, error) {
+	status, err := v.Status()
+	return status == StatusOK, err
+}
+
+// Returns StatusQueueOverrun if messages got lost since the last Reset(), otherwise StatusOK
+func (v *virtualBus) Status() (uint32, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.overrun {
+		return StatusQueueOverrun, nil
+	}
+	return StatusOK, nil
+}
+
+// Returns bus state of virtual bus
+func (v *virtualBus) State() gocan.BusState {
+	return v.Config.BusState
+}
+
+// Returns Available, as a virtual channel can always be connected
+func (v *virtualBus) ChannelCondition() (gocan.ChannelCondition, error) {
+	return gocan.Available, nil
+}
+
+// Only messages with an id between fromID and toID are received, mode is ignored
+func (v *virtualBus) SetFilter(fromID gocan.MessageID, toID gocan.MessageID, mode uint8) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.filter = idFilter{active: true, fromID: fromID, toID: toID}
+	return nil
+}
+
+// Removes set message filter
+func (v *virtualBus) ResetFilter() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.filter = idFilter{}
+	return nil
+}
+
+// Empties receive queue and clears the overrun status
+func (v *virtualBus) Reset() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.head = 0
+	v.count = 0
+	v.overrun = false
+	v.lost = 0
+	return nil
+}
+
+// Disconnects bus from its channel
+func (v *virtualBus) Shutdown() error {
+	v.mu.Lock()
+	if v.isClosed {
+		v.mu.Unlock()
+		return nil
+	}
+	v.isClosed = true
+	v.mu.Unlock()
+
+	// wake up waiting Recv() call
+	select {
+	case v.notify <- struct{}{}:
+	default:
+	}
+
+	v.hub.mu.Lock()
+	defer v.hub.mu.Unlock()
+	for i, b := range v.hub.buses {
+		if b == v {
+			v.hub.buses = append(v.hub.buses[:i], v.hub.buses[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Closes the connection, same as Shutdown()
+func (v *virtualBus) Close() error {
+	return v.Shutdown()
+}
+
+// Not supported by virtual bus
+func (v *virtualBus) TraceStart(filePath string, maxFileSize uint32) error {
+	return gocan.ErrNotSupported
+}
+
+// Not supported by virtual bus
+func (v *virtualBus) TraceStop() error {
+	return gocan.ErrNotSupported
+}
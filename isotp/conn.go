@@ -0,0 +1,335 @@
+package isotp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Received flow control frame
+type flowControl struct {
+	status    FlowStatus
+	blockSize uint8
+	stMin     time.Duration
+}
+
+// Received payload or reception error
+type result struct {
+	data []byte
+	err  error
+}
+
+// Conn is an ISO-TP connection between two addresses on a bus
+type Conn struct {
+	mux    *Mux
+	ownMux bool
+	addr   Address
+	cfg    Config
+	sendMu sync.Mutex
+	fc     chan flowControl
+	rx     chan result
+	closed chan struct{}
+	once   sync.Once
+
+	// reception state, only used by the reader of the mux
+	rxActive   bool
+	rxBuf      []byte
+	rxLength   int
+	rxSN       uint8
+	rxBlock    int
+	rxDeadline time.Time
+}
+
+// Opens a connection reading the bus on its own, use a Mux if several connections share the bus
+func NewConn(bus gocan.Transceiver, addr Address, cfg *Config) (*Conn, error) {
+	m := NewMux(bus)
+	c, err := m.Open(addr, cfg)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	c.ownMux = true
+	return c, nil
+}
+
+func newConn(m *Mux, addr Address, cfg *Config) *Conn {
+	c := &Conn{
+		mux:    m,
+		addr:   addr,
+		cfg:    cfg.withDefaults(),
+		fc:     make(chan flowControl, 8),
+		closed: make(chan struct{}),
+	}
+	c.rx = make(chan result, c.cfg.RxQueue)
+	return c
+}
+
+// Returns the address of the connection
+func (c *Conn) Address() Address {
+	return c.addr
+}
+
+// Closes the connection, a connection created by NewConn stops reading the bus
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		if c.ownMux {
+			c.mux.Close()
+		} else {
+			c.mux.remove(c)
+		}
+	})
+	return nil
+}
+
+// Returns an error if the connection or its mux is closed
+func (c *Conn) checkClosed() error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	case <-c.mux.done:
+		return c.mux.closedErr()
+	default:
+		return nil
+	}
+}
+
+// Waits for the next received payload
+func (c *Conn) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case r := <-c.rx:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, ErrClosed
+	case <-c.mux.done:
+		return nil, c.mux.closedErr()
+	}
+}
+
+// Sends a payload, segmented if it does not fit into a single frame
+// Blocks until the last frame is sent or an error occurs.
+func (c *Conn) Send(data []byte) error {
+	if len(data) == 0 {
+		return ErrEmpty
+	}
+	if uint64(len(data)) > MaxLength {
+		return fmt.Errorf("%w: %v bytes", ErrTooLong, len(data))
+	}
+	if err := c.checkClosed(); err != nil {
+		return err
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	prefix := c.addr.prefix()
+	if len(data) <= singleFrameCapacity(c.cfg.FrameLength, prefix) {
+		return c.sendFrame(encodeSingleFrame(data, prefix))
+	}
+	if c.addr.Functional {
+		return fmt.Errorf("%w: %v bytes", ErrFunctional, len(data))
+	}
+
+	// flow control frames of an earlier transfer are outdated
+	for len(c.fc) > 0 {
+		<-c.fc
+	}
+
+	frame, sent := encodeFirstFrame(data, c.cfg.FrameLength, prefix)
+	if err := c.sendFrame(frame); err != nil {
+		return err
+	}
+	sn := uint8(1)
+	for sent < len(data) {
+		fc, err := c.waitFlowControl()
+		if err != nil {
+			return err
+		}
+		for block := 0; sent < len(data) && (fc.blockSize == 0 || block < int(fc.blockSize)); block++ {
+			if block > 0 {
+				time.Sleep(fc.stMin)
+			}
+			frame, n := encodeConsecutiveFrame(data[sent:], sn, c.cfg.FrameLength, prefix)
+			if err := c.sendFrame(frame); err != nil {
+				return err
+			}
+			sent += n
+			sn = (sn + 1) & 0x0F
+		}
+	}
+	return nil
+}
+
+// Waits for a flow control frame allowing to continue, wait frames restart the N_Bs timeout
+func (c *Conn) waitFlowControl() (flowControl, error) {
+	timer := time.NewTimer(c.cfg.TimeoutBs)
+	defer timer.Stop()
+	waits := 0
+	for {
+		select {
+		case fc := <-c.fc:
+			switch fc.status {
+			case ContinueToSend:
+				return fc, nil
+			case Wait:
+				if waits++; waits > c.cfg.MaxWait {
+					return fc, ErrWaitLimit
+				}
+				timer.Reset(c.cfg.TimeoutBs)
+			case Overflow:
+				return fc, ErrOverflow
+			default:
+				return fc, fmt.Errorf("%w: %v", ErrFlowStatus, fc.status)
+			}
+		case <-timer.C:
+			return flowControl{}, ErrTimeoutBs
+		case <-c.closed:
+			return flowControl{}, ErrClosed
+		case <-c.mux.done:
+			return flowControl{}, c.mux.closedErr()
+		}
+	}
+}
+
+// Sends a frame with address byte and padding, a transmission taking longer than N_As is an error
+func (c *Conn) sendFrame(frame []byte) error {
+	data := make([]byte, 0, gocan.MaxDataLength)
+	if c.addr.Mode != Normal {
+		data = append(data, c.addr.TxAddr)
+	}
+	data = append(data, frame...)
+
+	length := len(data)
+	switch {
+	case length > ClassicFrameSize:
+		length = gocan.LengthFromDLC(gocan.DLCFromLength(length), true)
+	case c.cfg.Padding:
+		length = ClassicFrameSize
+	}
+	for len(data) < length {
+		data = append(data, c.cfg.PadByte)
+	}
+
+	msg := &gocan.Message{ID: c.addr.TxID, IsExtended: c.addr.IsExtended, IsFD: c.cfg.FD, DLC: gocan.DLCFromLength(length)}
+	if c.cfg.FD && c.cfg.BRS {
+		msg.Type = gocan.FDBitRateSwitchFrame
+	}
+	msg.SetData(data)
+	start := time.Now()
+	if err := c.mux.bus.Send(msg); err != nil {
+		return err
+	}
+	if time.Since(start) > c.cfg.TimeoutAs {
+		return ErrTimeoutAs
+	}
+	return nil
+}
+
+// Queues a received payload or error, the oldest entry is dropped if Recv is not called fast enough
+func (c *Conn) deliver(r result) {
+	for {
+		select {
+		case c.rx <- r:
+			return
+		default:
+		}
+		select {
+		case <-c.rx:
+		default:
+		}
+	}
+}
+
+// Handles a received frame without address byte, called by the reader of the mux
+func (c *Conn) handle(data []byte, now time.Time) {
+	if len(data) == 0 {
+		return
+	}
+	switch FrameType(data[0] >> 4) {
+	case SingleFrame:
+		length, payload := int(data[0]&0x0F), data[1:]
+		if length == 0 {
+			if len(data) < 2 {
+				return
+			}
+			length, payload = int(data[1]), data[2:]
+		}
+		if length == 0 || length > len(payload) {
+			return
+		}
+		c.rxActive = false
+		c.deliver(result{data: append([]byte(nil), payload[:length]...)})
+
+	case FirstFrame:
+		if len(data) < 2 {
+			return
+		}
+		length, payload := int(data[0]&0x0F)<<8|int(data[1]), data[2:]
+		if length == 0 {
+			if len(data) < 6 {
+				return
+			}
+			length, payload = int(binary.BigEndian.Uint32(data[2:6])), data[6:]
+		}
+		if length > c.cfg.MaxLength {
+			c.rxActive = false
+			c.sendFrame(encodeFlowControl(Overflow, 0, 0))
+			c.deliver(result{err: fmt.Errorf("%w: %v bytes, allowed %v", ErrTooLong, length, c.cfg.MaxLength)})
+			return
+		}
+		if len(payload) >= length {
+			return
+		}
+		c.rxActive, c.rxLength, c.rxSN, c.rxBlock = true, length, 1, 0
+		c.rxBuf = append(make([]byte, 0, length), payload...)
+		c.rxDeadline = now.Add(c.cfg.TimeoutCr)
+		c.sendFrame(encodeFlowControl(ContinueToSend, c.cfg.BlockSize, EncodeSTmin(c.cfg.STmin)))
+
+	case ConsecutiveFrame:
+		if !c.rxActive {
+			return
+		}
+		if sn := data[0] & 0x0F; sn != c.rxSN {
+			c.rxActive = false
+			c.deliver(result{err: fmt.Errorf("%w: got %v, expected %v", ErrSequence, sn, c.rxSN)})
+			return
+		}
+		n := min(len(data)-1, c.rxLength-len(c.rxBuf))
+		c.rxBuf = append(c.rxBuf, data[1:1+n]...)
+		c.rxSN = (c.rxSN + 1) & 0x0F
+		c.rxDeadline = now.Add(c.cfg.TimeoutCr)
+		if len(c.rxBuf) == c.rxLength {
+			c.rxActive = false
+			c.deliver(result{data: c.rxBuf})
+			c.rxBuf = nil
+			return
+		}
+		if c.rxBlock++; c.cfg.BlockSize > 0 && c.rxBlock == int(c.cfg.BlockSize) {
+			c.rxBlock = 0
+			c.sendFrame(encodeFlowControl(ContinueToSend, c.cfg.BlockSize, EncodeSTmin(c.cfg.STmin)))
+		}
+
+	case FlowControl:
+		if len(data) < 3 {
+			return
+		}
+		fc := flowControl{status: FlowStatus(data[0] & 0x0F), blockSize: data[1], stMin: DecodeSTmin(data[2])}
+		select {
+		case c.fc <- fc:
+		default:
+		}
+	}
+}
+
+// Aborts a reception without consecutive frame within N_Cr
+func (c *Conn) checkTimeout(now time.Time) {
+	if c.rxActive && now.After(c.rxDeadline) {
+		c.rxActive, c.rxBuf = false, nil
+		c.deliver(result{err: ErrTimeoutCr})
+	}
+}
@@ -0,0 +1,206 @@
+package isotp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+type Addressing uint8
+type FrameType uint8
+type FlowStatus uint8
+
+// Addressing format, extended and mixed addressing carry an address byte in front of every frame
+const (
+	Normal   Addressing = iota // data starts with the protocol control information
+	Extended Addressing = iota // first byte is the target address, which differs per direction
+	Mixed    Addressing = iota // first byte is the address extension, the same in both directions
+)
+
+// Frame types given by the upper nibble of the protocol control information
+const (
+	SingleFrame      FrameType = iota // complete payload in one frame
+	FirstFrame       FrameType = iota // start of a segmented payload holding the total length
+	ConsecutiveFrame FrameType = iota // further segment with a 4-bit sequence number
+	FlowControl      FrameType = iota // sent by the receiver to pace the sender
+)
+
+// Flow status of a flow control frame
+const (
+	ContinueToSend FlowStatus = iota // send the next block
+	Wait           FlowStatus = iota // wait for the next flow control frame
+	Overflow       FlowStatus = iota // payload too long for the receiver, abort
+)
+
+// Protocol limits
+const (
+	MaxLength        = 1<<32 - 1 // largest payload using the first frame escape sequence
+	MaxClassicLength = 4095      // largest payload without escape sequence
+	ClassicFrameSize = 8         // frame length of classic CAN
+)
+
+// Default values of the configuration
+const (
+	DefaultTimeout   = time.Second
+	DefaultMaxWait   = 10
+	DefaultMaxLength = 1 << 20
+	DefaultRxQueue   = 64
+)
+
+// errors
+var (
+	ErrTimeoutAs  = errors.New("N_As timeout, frame transmission took too long")
+	ErrTimeoutBs  = errors.New("N_Bs timeout, no flow control frame received")
+	ErrTimeoutCr  = errors.New("N_Cr timeout, no consecutive frame received")
+	ErrSequence   = errors.New("wrong sequence number of consecutive frame")
+	ErrOverflow   = errors.New("receiver reported an overflow")
+	ErrWaitLimit  = errors.New("too many flow control wait frames")
+	ErrFlowStatus = errors.New("invalid flow status")
+	ErrTooLong    = errors.New("payload too long")
+	ErrEmpty      = errors.New("empty payload")
+	ErrFunctional = errors.New("functional addressing only supports single frames")
+	ErrClosed     = errors.New("connection closed")
+	ErrDuplicate  = errors.New("receive address already in use")
+)
+
+// Address of a connection
+type Address struct {
+	Mode       Addressing
+	TxID       gocan.MessageID // id of sent frames
+	RxID       gocan.MessageID // id of received frames
+	IsExtended bool            // 29-bit ids
+	TxAddr     byte            // first byte of sent frames with extended or mixed addressing
+	RxAddr     byte            // first byte of received frames with extended or mixed addressing
+	Functional bool            // one-to-many address only able to send single frames, nothing is received
+}
+
+// Returns the length of the address byte in front of every frame
+func (a Address) prefix() int {
+	if a.Mode == Normal {
+		return 0
+	}
+	return 1
+}
+
+// Config of a connection
+type Config struct {
+	FD          bool          // send CAN FD frames
+	BRS         bool          // send CAN FD frames with bit rate switch, ignored without FD
+	FrameLength int           // TX_DL in bytes, 8 for classic CAN and 8 to 64 for CAN FD, default 8 or 64 with FD
+	Padding     bool          // pad classic frames to 8 bytes, CAN FD frames are always padded to the next valid length
+	PadByte     byte          // value of padding bytes
+	BlockSize   uint8         // consecutive frames between flow control frames announced as receiver, 0 for no limit
+	STmin       time.Duration // minimum separation time of consecutive frames announced as receiver
+	MaxLength   int           // largest payload accepted as receiver, default DefaultMaxLength
+	TimeoutAs   time.Duration // N_As: maximum time to transmit a frame, default DefaultTimeout
+	TimeoutBs   time.Duration // N_Bs: maximum time waiting for a flow control frame, default DefaultTimeout
+	TimeoutCr   time.Duration // N_Cr: maximum time waiting for a consecutive frame, default DefaultTimeout
+	MaxWait     int           // flow control wait frames accepted in a row, default DefaultMaxWait
+	RxQueue     int           // received payloads buffered until read by Recv, default DefaultRxQueue
+}
+
+// Returns the configuration with defaults applied
+func (c *Config) withDefaults() Config {
+	cfg := Config{}
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.FrameLength == 0 {
+		cfg.FrameLength = ClassicFrameSize
+		if cfg.FD {
+			cfg.FrameLength = gocan.MaxDataLength
+		}
+	}
+	if !cfg.FD || cfg.FrameLength < ClassicFrameSize {
+		cfg.FrameLength = ClassicFrameSize
+	}
+	cfg.FrameLength = gocan.LengthFromDLC(gocan.DLCFromLength(cfg.FrameLength), true)
+	if cfg.MaxLength <= 0 {
+		cfg.MaxLength = DefaultMaxLength
+	}
+	if cfg.TimeoutAs <= 0 {
+		cfg.TimeoutAs = DefaultTimeout
+	}
+	if cfg.TimeoutBs <= 0 {
+		cfg.TimeoutBs = DefaultTimeout
+	}
+	if cfg.TimeoutCr <= 0 {
+		cfg.TimeoutCr = DefaultTimeout
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = DefaultMaxWait
+	}
+	if cfg.RxQueue <= 0 {
+		cfg.RxQueue = DefaultRxQueue
+	}
+	return cfg
+}
+
+// Encodes a separation time into the STmin byte, 100 µs steps below one millisecond and milliseconds up to 127
+func EncodeSTmin(d time.Duration) byte {
+	switch {
+	case d <= 0:
+		return 0
+	case d > 900*time.Microsecond && d < time.Millisecond:
+		return 0x01 // 0xFA is reserved, rounds up to one millisecond
+	case d < time.Millisecond:
+		steps := (d + 99*time.Microsecond) / (100 * time.Microsecond)
+		return 0xF0 + byte(steps)
+	case d >= 127*time.Millisecond:
+		return 0x7F
+	}
+	return byte((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// Decodes the STmin byte, reserved values are treated as the maximum of 127 ms
+func DecodeSTmin(b byte) time.Duration {
+	switch {
+	case b <= 0x7F:
+		return time.Duration(b) * time.Millisecond
+	case b >= 0xF1 && b <= 0xF9:
+		return time.Duration(b-0xF0) * 100 * time.Microsecond
+	}
+	return 127 * time.Millisecond
+}
+
+// Returns the largest payload of a single frame
+func singleFrameCapacity(frameLength, prefix int) int {
+	if frameLength <= ClassicFrameSize {
+		return ClassicFrameSize - 1 - prefix
+	}
+	return frameLength - 2 - prefix
+}
+
+// Encodes the protocol control information and data of a single frame
+func encodeSingleFrame(payload []byte, prefix int) []byte {
+	if len(payload) <= ClassicFrameSize-1-prefix {
+		return append([]byte{byte(len(payload))}, payload...)
+	}
+	// escape sequence of CAN FD frames
+	return append([]byte{0x00, byte(len(payload))}, payload...)
+}
+
+// Encodes a first frame with as much data as fits into the frame
+func encodeFirstFrame(payload []byte, frameLength, prefix int) ([]byte, int) {
+	var pci []byte
+	if len(payload) <= MaxClassicLength {
+		pci = []byte{0x10 | byte(len(payload)>>8), byte(len(payload))}
+	} else {
+		pci = binary.BigEndian.AppendUint32([]byte{0x10, 0x00}, uint32(len(payload)))
+	}
+	n := frameLength - prefix - len(pci)
+	return append(pci, payload[:n]...), n
+}
+
+// Encodes a consecutive frame with as much data as fits into the frame
+func encodeConsecutiveFrame(payload []byte, sn uint8, frameLength, prefix int) ([]byte, int) {
+	n := min(len(payload), frameLength-prefix-1)
+	return append([]byte{0x20 | sn&0x0F}, payload[:n]...), n
+}
+
+// Encodes a flow control frame
+func encodeFlowControl(status FlowStatus, blockSize uint8, stMin byte) []byte {
+	return []byte{0x30 | byte(status), blockSize, stMin}
+}
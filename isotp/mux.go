@@ -0,0 +1,173 @@
+package isotp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Timeout in [ms] of every Recv call of the reader, defines the resolution of the N_Cr timeout
+var PollTimeout = 5
+
+// Receive id of a connection
+type rxKey struct {
+	id         gocan.MessageID
+	isExtended bool
+}
+
+// Mux reads a bus and dispatches received frames to all connections opened on it
+// Use a Mux if several connections share one bus, every bus must only be read by a single Mux.
+type Mux struct {
+	bus     gocan.Transceiver
+	mu      sync.RWMutex
+	conns   map[rxKey][]*Conn
+	unknown func(msg *gocan.Message)
+	stop    chan struct{}
+	done    chan struct{}
+	err     error // reader error, set before done is closed
+	once    sync.Once
+}
+
+// Creates a mux and starts reading the bus
+func NewMux(bus gocan.Transceiver) *Mux {
+	m := &Mux{
+		bus:   bus,
+		conns: make(map[rxKey][]*Conn),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Sets a function called with received frames no connection is registered for
+// The function may open a connection for the frame, which then receives the frame.
+func (m *Mux) SetUnknownHandler(fn func(msg *gocan.Message)) {
+	m.mu.Lock()
+	m.unknown = fn
+	m.mu.Unlock()
+}
+
+// Opens a connection on the bus of the mux
+func (m *Mux) Open(addr Address, cfg *Config) (*Conn, error) {
+	c := newConn(m, addr, cfg)
+	if addr.Functional {
+		return c, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := rxKey{addr.RxID, addr.IsExtended}
+	for _, other := range m.conns[key] {
+		if other.addr.Mode == Normal || addr.Mode == Normal || other.addr.RxAddr == addr.RxAddr {
+			return nil, fmt.Errorf("%w: 0x%X", ErrDuplicate, uint32(addr.RxID))
+		}
+	}
+	m.conns[key] = append(m.conns[key], c)
+	return c, nil
+}
+
+// Removes a connection
+func (m *Mux) remove(c *Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := rxKey{c.addr.RxID, c.addr.IsExtended}
+	conns := m.conns[key]
+	for i, other := range conns {
+		if other == c {
+			m.conns[key] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(m.conns[key]) == 0 {
+		delete(m.conns, key)
+	}
+}
+
+// Stops reading the bus, all connections are closed
+func (m *Mux) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	<-m.done
+	return nil
+}
+
+// Returns the reader error or ErrClosed once the mux stopped
+func (m *Mux) closedErr() error {
+	if m.err != nil {
+		return m.err
+	}
+	return ErrClosed
+}
+
+func (m *Mux) run() {
+	defer close(m.done)
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+		msg, err := m.bus.Recv(PollTimeout)
+		if err != nil && !errors.Is(err, gocan.ErrRxOverrun) {
+			m.err = err
+			return
+		}
+		if msg != nil && msg.Direction == gocan.Rx && carriesData(msg) {
+			m.dispatch(msg)
+		}
+		m.checkTimeouts(time.Now())
+	}
+}
+
+// Returns true for data frames including CAN FD frames with bit rate switch or error state indicator
+func carriesData(msg *gocan.Message) bool {
+	switch msg.Type {
+	case gocan.RemoteFrame, gocan.ErrorFrame, gocan.OverloadFrame:
+		return false
+	}
+	return true
+}
+
+// Returns the connection a frame is addressed to and the frame data without address byte
+func (m *Mux) lookup(msg *gocan.Message) (*Conn, []byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, c := range m.conns[rxKey{msg.ID, msg.IsExtended}] {
+		if c.addr.Mode == Normal {
+			return c, msg.Data
+		}
+		if len(msg.Data) > 0 && msg.Data[0] == c.addr.RxAddr {
+			return c, msg.Data[1:]
+		}
+	}
+	return nil, nil
+}
+
+func (m *Mux) dispatch(msg *gocan.Message) {
+	c, data := m.lookup(msg)
+	if c == nil {
+		m.mu.RLock()
+		unknown := m.unknown
+		m.mu.RUnlock()
+		if unknown == nil {
+			return
+		}
+		unknown(msg)
+		if c, data = m.lookup(msg); c == nil {
+			return
+		}
+	}
+	c.handle(data, time.Now())
+}
+
+func (m *Mux) checkTimeouts(now time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, conns := range m.conns {
+		for _, c := range conns {
+			c.checkTimeout(now)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/isotp"
+)
+
+var auxTester = isotp.Address{TxID: 0x7E0, RxID: 0x7E8}
+var auxECU = isotp.Address{TxID: 0x7E8, RxID: 0x7E0}
+
+func auxBus(t *testing.T) gocan.Bus {
+	bus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name()})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+func auxConn(t *testing.T, addr isotp.Address, cfg *isotp.Config) *isotp.Conn {
+	c, err := isotp.NewConn(auxBus(t), addr, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func auxPayload(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+// Sends data over tx and returns what rx received
+func auxTransfer(t *testing.T, tx, rx *isotp.Conn, data []byte) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- tx.Send(data) }()
+	received, err := rx.Recv(ctx)
+	if err != nil {
+		t.Fatalf("unexpected receive error: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+	return received
+}
+
+// Reads the next frame of a raw bus
+func auxFrame(t *testing.T, bus gocan.Bus) *gocan.Message {
+	msg, err := bus.Recv(1000)
+	if err != nil || msg == nil {
+		t.Fatalf("no frame: %v, %v", msg, err)
+	}
+	return msg
+}
+
+func TestTransfer(t *testing.T) {
+	sniffer := auxBus(t)
+	tester := auxConn(t, auxTester, &isotp.Config{Padding: true, PadByte: 0xCC})
+	ecu := auxConn(t, auxECU, &isotp.Config{BlockSize: 3, STmin: time.Millisecond})
+
+	for _, n := range []int{1, 7, 8, 62, 300, 4095} {
+		data := auxPayload(n)
+		if received := auxTransfer(t, tester, ecu, data); !bytes.Equal(received, data) {
+			t.Errorf("%v bytes: got % X", n, received)
+		}
+		if received := auxTransfer(t, ecu, tester, data); !bytes.Equal(received, data) {
+			t.Errorf("%v bytes back: got % X", n, received)
+		}
+	}
+
+	// padded single frame, first frame and flow control with block size and STmin
+	frames := []struct {
+		id   gocan.MessageID
+		data []byte
+	}{
+		{0x7E0, []byte{0x01, 0x00, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC}},
+		{0x7E8, []byte{0x01, 0x00}},
+		{0x7E0, []byte{0x07, 0, 1, 2, 3, 4, 5, 6}},
+		{0x7E8, []byte{0x07, 0, 1, 2, 3, 4, 5, 6}},
+		{0x7E0, []byte{0x10, 0x08, 0, 1, 2, 3, 4, 5}},
+		{0x7E8, []byte{0x30, 3, 1}},
+		{0x7E0, []byte{0x21, 6, 7, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC}},
+	}
+	for i, expected := range frames {
+		msg := auxFrame(t, sniffer)
+		if msg.ID != expected.id || !bytes.Equal(msg.Data, expected.data) {
+			t.Errorf("frame %v: got 0x%X % X, expected 0x%X % X", i, msg.ID, msg.Data, expected.id, expected.data)
+		}
+	}
+}
+
+func TestExtendedAddressing(t *testing.T) {
+	sniffer := auxBus(t)
+	tester := auxConn(t, isotp.Address{Mode: isotp.Extended, TxID: 0x600, RxID: 0x601, TxAddr: 0x10, RxAddr: 0xF1}, nil)
+	ecu := auxConn(t, isotp.Address{Mode: isotp.Extended, TxID: 0x601, RxID: 0x600, TxAddr: 0xF1, RxAddr: 0x10}, nil)
+
+	data := auxPayload(50)
+	if received := auxTransfer(t, tester, ecu, data); !bytes.Equal(received, data) {
+		t.Errorf("got % X", received)
+	}
+	if msg := auxFrame(t, sniffer); !bytes.Equal(msg.Data, []byte{0x10, 0x10, 50, 0, 1, 2, 3, 4}) {
+		t.Errorf("got first frame % X", msg.Data)
+	}
+	if msg := auxFrame(t, sniffer); !bytes.Equal(msg.Data, []byte{0xF1, 0x30, 0, 0}) {
+		t.Errorf("got flow control % X", msg.Data)
+	}
+
+	// frames with another address byte are ignored
+	sniffer.Send(&gocan.Message{ID: 0x600, Data: []byte{0x11, 0x01, 0xAA}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if data, err := ecu.Recv(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected no payload, got % X, %v", data, err)
+	}
+}
+
+func TestFD(t *testing.T) {
+	sniffer := auxBus(t)
+	tester := auxConn(t, auxTester, &isotp.Config{FD: true})
+	ecu := auxConn(t, auxECU, &isotp.Config{FD: true})
+
+	// single frame with escape sequence
+	data := auxPayload(40)
+	if received := auxTransfer(t, tester, ecu, data); !bytes.Equal(received, data) {
+		t.Errorf("got % X", received)
+	}
+	if msg := auxFrame(t, sniffer); !msg.IsFD || len(msg.Data) != 48 || msg.Data[0] != 0 || msg.Data[1] != 40 {
+		t.Errorf("got single frame %+v", msg)
+	}
+
+	// first frame with escape sequence above 4095 bytes
+	data = auxPayload(5000)
+	if received := auxTransfer(t, tester, ecu, data); !bytes.Equal(received, data) {
+		t.Errorf("got %v bytes", len(received))
+	}
+	if msg := auxFrame(t, sniffer); len(msg.Data) != 64 || !bytes.Equal(msg.Data[:6], []byte{0x10, 0, 0, 0, 0x13, 0x88}) {
+		t.Errorf("got first frame % X", msg.Data)
+	}
+}
+
+func TestFDBitRateSwitch(t *testing.T) {
+	sniffer := auxBus(t)
+	tester := auxConn(t, auxTester, &isotp.Config{FD: true, BRS: true})
+	ecu := auxConn(t, auxECU, &isotp.Config{FD: true, BRS: true})
+
+	// first frame, flow control and consecutive frames are all sent with bit rate switch
+	data := auxPayload(200)
+	if received := auxTransfer(t, tester, ecu, data); !bytes.Equal(received, data) {
+		t.Errorf("got % X", received)
+	}
+	if received := auxTransfer(t, ecu, tester, data); !bytes.Equal(received, data) {
+		t.Errorf("got % X back", received)
+	}
+	for i := 0; i < 10; i++ {
+		if msg := auxFrame(t, sniffer); !msg.IsFD || msg.Type != gocan.FDBitRateSwitchFrame {
+			t.Errorf("frame %v: got %+v, expected bit rate switch", i, msg)
+		}
+	}
+
+	// remote frames received by the bus are ignored
+	rtrBus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name(), RecvRTRFrames: true})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	defer rtrBus.Close()
+	rtrECU, err := isotp.NewConn(rtrBus, auxECU, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rtrECU.Close()
+	sniffer.Send(&gocan.Message{ID: 0x7E0, Type: gocan.RemoteFrame, DLC: 2, Data: []byte{0x01, 0xAA}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if data, err := rtrECU.Recv(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected no payload, got % X, %v", data, err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	raw := auxBus(t)
+	ecu := auxConn(t, auxECU, &isotp.Config{TimeoutBs: 50 * time.Millisecond, TimeoutCr: 50 * time.Millisecond})
+
+	// no flow control
+	start := time.Now()
+	if err := ecu.Send(auxPayload(20)); !errors.Is(err, isotp.ErrTimeoutBs) {
+		t.Errorf("expected ErrTimeoutBs, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("timeout after %v, expected 50ms", elapsed)
+	}
+
+	// first frame without consecutive frames
+	raw.Send(&gocan.Message{ID: 0x7E0, Data: []byte{0x10, 20, 0, 1, 2, 3, 4, 5}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := ecu.Recv(ctx); !errors.Is(err, isotp.ErrTimeoutCr) {
+		t.Errorf("expected ErrTimeoutCr, got %v", err)
+	}
+
+	// wrong sequence number
+	raw.Send(&gocan.Message{ID: 0x7E0, Data: []byte{0x10, 20, 0, 1, 2, 3, 4, 5}})
+	raw.Send(&gocan.Message{ID: 0x7E0, Data: []byte{0x22, 6, 7, 8, 9, 10, 11, 12}})
+	if _, err := ecu.Recv(ctx); !errors.Is(err, isotp.ErrSequence) {
+		t.Errorf("expected ErrSequence, got %v", err)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	raw := auxBus(t)
+	ecu := auxConn(t, auxECU, &isotp.Config{MaxWait: 2})
+
+	run := func(fcs ...[]byte) (error, int) {
+		errs := make(chan error, 1)
+		go func() { errs <- ecu.Send(auxPayload(20)) }()
+		auxFrame(t, raw)
+		for _, fc := range fcs {
+			raw.Send(&gocan.Message{ID: 0x7E0, Data: fc})
+		}
+		err := <-errs
+		frames := 0
+		for msg, _ := raw.Recv(50); msg != nil; msg, _ = raw.Recv(50) {
+			frames++
+		}
+		return err, frames
+	}
+
+	if err, frames := run([]byte{0x31, 0, 0}, []byte{0x31, 0, 0}, []byte{0x30, 0, 0}); err != nil || frames != 2 {
+		t.Errorf("got %v and %v consecutive frames, expected 2 frames after wait", err, frames)
+	}
+	if err, _ := run([]byte{0x31, 0, 0}, []byte{0x31, 0, 0}, []byte{0x31, 0, 0}); !errors.Is(err, isotp.ErrWaitLimit) {
+		t.Errorf("expected ErrWaitLimit, got %v", err)
+	}
+	if err, _ := run([]byte{0x32, 0, 0}); !errors.Is(err, isotp.ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tester := auxConn(t, auxTester, nil)
+	ecu := auxConn(t, auxECU, &isotp.Config{MaxLength: 10})
+
+	if err := tester.Send(auxPayload(20)); !errors.Is(err, isotp.ErrOverflow) {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := ecu.Recv(ctx); !errors.Is(err, isotp.ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestMux(t *testing.T) {
+	bus := auxBus(t)
+	mux := isotp.NewMux(bus)
+	defer mux.Close()
+
+	functional, err := mux.Open(isotp.Address{TxID: 0x7DF, Functional: true}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := functional.Send(auxPayload(8)); !errors.Is(err, isotp.ErrFunctional) {
+		t.Errorf("expected ErrFunctional, got %v", err)
+	}
+	if _, err := mux.Open(auxTester, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mux.Open(auxTester, nil); !errors.Is(err, isotp.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+
+	// connections opened for unknown ids receive the frame that triggered them
+	opened := make(chan *isotp.Conn, 1)
+	mux.SetUnknownHandler(func(msg *gocan.Message) {
+		c, err := mux.Open(isotp.Address{TxID: msg.ID - 8, RxID: msg.ID}, nil)
+		if err == nil {
+			opened <- c
+		}
+	})
+	ecu := auxConn(t, isotp.Address{TxID: 0x7E9, RxID: 0x7E1}, nil)
+	data := auxPayload(30)
+	go ecu.Send(data)
+
+	var c *isotp.Conn
+	select {
+	case c = <-opened:
+	case <-time.After(time.Second):
+		t.Fatalf("no connection opened")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if received, err := c.Recv(ctx); err != nil || !bytes.Equal(received, data) {
+		t.Errorf("got % X, %v", received, err)
+	}
+}
+
+func TestSTmin(t *testing.T) {
+	tests := []struct {
+		d time.Duration
+		b byte
+	}{
+		{0, 0},
+		{300 * time.Microsecond, 0xF3},
+		{5 * time.Millisecond, 5},
+		{127 * time.Millisecond, 0x7F},
+	}
+	for _, tt := range tests {
+		if b := isotp.EncodeSTmin(tt.d); b != tt.b {
+			t.Errorf("%v: got 0x%X, expected 0x%X", tt.d, b, tt.b)
+		}
+		if d := isotp.DecodeSTmin(tt.b); d != tt.d {
+			t.Errorf("0x%X: got %v, expected %v", tt.b, d, tt.d)
+		}
+	}
+	if b := isotp.EncodeSTmin(900 * time.Microsecond); b != 0xF9 {
+		t.Errorf("900µs: got 0x%X, expected 0xF9", b)
+	}
+	if b := isotp.EncodeSTmin(950 * time.Microsecond); b != 0x01 {
+		t.Errorf("950µs: got 0x%X, expected 0x01", b)
+	}
+	if d := isotp.DecodeSTmin(0xFA); d != 127*time.Millisecond {
+		t.Errorf("got %v for reserved value, expected 127ms", d)
+	}
+}
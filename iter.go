@@ -0,0 +1,156 @@
+package gocan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"time"
+)
+
+// Timeout in [ms] used by the iterators when polling a Receiver, defines how fast a context cancellation is noticed
+var IterPollTimeout = 50
+
+// MessageReader is implemented by message sources without a live bus, e.g. log files. ReadMessage returns io.EOF once the source is exhausted
+type MessageReader interface {
+	ReadMessage() (*Message, error)
+}
+
+// Returns an iterator over all messages received with Recv() until ctx is cancelled or the receiver reports an error
+// The error is yielded once and ends the iteration, a cancelled context ends the iteration without an error
+// Overrun errors (ErrRxOverrun) are yielded as well but do not end the iteration
+func Messages(ctx context.Context, r Receiver) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for ctx.Err() == nil {
+			msg, err := r.Recv(IterPollTimeout)
+			if msg != nil && err != nil && !yield(msg, nil) {
+				return
+			}
+			if err != nil {
+				if !yield(nil, err) || !errors.Is(err, ErrRxOverrun) {
+					return
+				}
+				continue
+			}
+			if msg == nil {
+				continue
+			}
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator over all messages read with ReadBuffer() until ctx is cancelled or the receiver reports an error
+// Overrun errors (ErrRxOverrun) are yielded as well but do not end the iteration
+// limit: maximum message count read in a single ReadBuffer() call, zero for no limit
+func BufferedMessages(ctx context.Context, r Receiver, limit uint16) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		pollInterval := time.Duration(IterPollTimeout) * time.Millisecond
+		for ctx.Err() == nil {
+			msgs, err := r.ReadBuffer(limit)
+			for i := range msgs {
+				if !yield(&msgs[i], nil) {
+					return
+				}
+			}
+			if err != nil {
+				if !yield(nil, err) || !errors.Is(err, ErrRxOverrun) {
+					return
+				}
+				continue
+			}
+			if len(msgs) == 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(pollInterval):
+				}
+			}
+		}
+	}
+}
+
+// Returns an iterator over all messages of a MessageReader until the source returns io.EOF, an error occurs or ctx is cancelled
+func ReadMessages(ctx context.Context, r MessageReader) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for ctx.Err() == nil {
+			msg, err := r.ReadMessage()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator only passing messages for which keep returns true, errors are always passed
+func Filter(seq iter.Seq2[*Message, error], keep func(*Message) bool) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for msg, err := range seq {
+			if err == nil && !keep(msg) {
+				continue
+			}
+			if !yield(msg, err) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator only passing messages with one of the given ids
+func FilterIDs(seq iter.Seq2[*Message, error], ids ...MessageID) iter.Seq2[*Message, error] {
+	return Filter(seq, func(msg *Message) bool {
+		for _, id := range ids {
+			if msg.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Returns an iterator only passing messages of the given frame type
+func FilterType(seq iter.Seq2[*Message, error], msgType MessageType) iter.Seq2[*Message, error] {
+	return Filter(seq, func(msg *Message) bool { return msg.Type == msgType })
+}
+
+// Returns an iterator ending after n messages were yielded, errors do not count as message
+func Take(seq iter.Seq2[*Message, error], n int) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		if n <= 0 {
+			return
+		}
+		count := 0
+		for msg, err := range seq {
+			if !yield(msg, err) {
+				return
+			}
+			if err == nil {
+				count++
+				if count >= n {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Collects all messages of an iterator into a slice, stops at the first error and returns it along the messages collected so far
+// The collected messages own their data, so iterators reusing a message are safe to collect
+func Collect(seq iter.Seq2[*Message, error]) ([]Message, error) {
+	var msgs []Message
+	for msg, err := range seq {
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg.Clone())
+	}
+	return msgs, nil
+}
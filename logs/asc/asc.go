@@ -0,0 +1,390 @@
+package asc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/internal/channels"
+)
+
+// Layout of the date in the header, as written by CANoe/CANalyzer
+const DateLayout = "Mon Jan 2 03:04:05.000 pm 2006"
+
+// Alternative date layouts accepted when reading
+var dateLayouts = []string{
+	DateLayout,
+	"Mon Jan 2 15:04:05.000 2006",
+	"Mon Jan 2 03:04:05 pm 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+// errors
+var (
+	ErrInvalidLine = errors.New("invalid asc line")
+)
+
+// Parses the date of a header line, returns the zero time if the date is unknown
+func parseDate(s string) time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Converts a timestamp in seconds with up to 9 decimals into µs without rounding errors
+func parseSeconds(s string) (uint64, error) {
+	secStr, fracStr, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseUint(secStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fracStr = (fracStr + "000000")[:6]
+	micros, err := strconv.ParseUint(fracStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sec*1000000 + micros, nil
+}
+
+// Reader reads messages from an ASC log file
+// The timestamp of every message is the date of the header in µs since unix epoch plus the offset of the message
+type Reader struct {
+	StartTime time.Time // date of the header, zero if the log has no valid date
+
+	scanner  *bufio.Scanner
+	line     int
+	base     int    // number base of ids and data, 16 or 10
+	relative bool   // timestamps are relative to the previous message
+	start    uint64 // time of the header date in µs
+	last     uint64 // offset of the previous message in µs
+}
+
+// Creates a reader for an ASC log
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r), base: 16}
+}
+
+// Returns the next message of the log or io.EOF at the end of the log
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if r.parseHeader(line) {
+			continue
+		}
+
+		msg, err := r.parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", r.line, err)
+		}
+		if msg != nil {
+			return msg, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Evaluates header lines, returns false if the line is not part of the header
+func (r *Reader) parseHeader(line string) bool {
+	fields := strings.Fields(line)
+	switch strings.ToLower(fields[0]) {
+	case "date":
+		r.setStart(parseDate(strings.TrimSpace(line[len(fields[0]):])))
+	case "base":
+		for i := 0; i+1 < len(fields); i++ {
+			switch {
+			case fields[i] == "base":
+				if fields[i+1] == "dec" {
+					r.base = 10
+				} else {
+					r.base = 16
+				}
+			case fields[i] == "timestamps":
+				r.relative = fields[i+1] == "relative"
+			}
+		}
+	case "begin":
+		if len(fields) > 2 {
+			if t := parseDate(strings.Join(fields[2:], " ")); !t.IsZero() {
+				r.setStart(t)
+			}
+		}
+	case "end", "internal", "no":
+	default:
+		return false
+	}
+	return true
+}
+
+func (r *Reader) setStart(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	r.StartTime = t
+	r.start = uint64(t.UnixMicro())
+}
+
+// Parses a message line, returns nil without error for events which are not messages
+func (r *Reader) parseLine(line string) (*gocan.Message, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, nil
+	}
+	offset, err := parseSeconds(fields[0])
+	if err != nil {
+		return nil, nil // not a timestamped event
+	}
+	if r.relative {
+		offset += r.last
+	}
+	r.last = offset
+
+	var msg *gocan.Message
+	if fields[1] == "CANFD" {
+		msg, err = r.parseFD(fields[2:])
+	} else if _, errCh := strconv.Atoi(fields[1]); errCh == nil {
+		msg, err = r.parseClassic(fields[1:])
+	}
+	if msg == nil || err != nil {
+		return nil, err
+	}
+	msg.TimeStamp = r.start + offset
+	return msg, nil
+}
+
+// Parses "<channel> <id>[x] <Rx|Tx> <d|r> [<dlc> <data>...]" or "<channel> ErrorFrame"
+func (r *Reader) parseClassic(fields []string) (*gocan.Message, error) {
+	msg := &gocan.Message{Channel: fields[0], Data: []byte{}}
+	if fields[1] == "ErrorFrame" {
+		msg.Type = gocan.ErrorFrame
+		return msg, nil
+	}
+	if len(fields) < 4 || (fields[2] != "Rx" && fields[2] != "Tx") {
+		return nil, nil // other event like statistics
+	}
+
+	if err := r.parseID(msg, fields[1]); err != nil {
+		return nil, err
+	}
+	if fields[2] == "Tx" {
+		msg.Direction = gocan.Tx
+	}
+
+	switch fields[3] {
+	case "r":
+		msg.Type = gocan.RemoteFrame
+		if len(fields) > 4 {
+			if dlc, err := strconv.ParseUint(fields[4], r.base, 8); err == nil {
+				msg.DLC = uint8(dlc)
+			}
+		}
+		return msg, nil
+	case "d":
+		if len(fields) < 5 {
+			return nil, ErrInvalidLine
+		}
+		dlc, err := strconv.ParseUint(fields[4], r.base, 8)
+		if err != nil || dlc > 15 {
+			return nil, ErrInvalidLine
+		}
+		msg.DLC = uint8(dlc)
+		msg.Data, err = r.parseData(fields[5:], gocan.LengthFromDLC(msg.DLC, false))
+		return msg, err
+	default:
+		return nil, ErrInvalidLine
+	}
+}
+
+// Parses "<channel> <Rx|Tx> <id>[x] [<name>] <brs> <esi> <dlc> <length> <data>..." or "<channel> <Rx|Tx> ErrorFrame ..."
+func (r *Reader) parseFD(fields []string) (*gocan.Message, error) {
+	if len(fields) < 3 || (fields[1] != "Rx" && fields[1] != "Tx") {
+		return nil, ErrInvalidLine
+	}
+	msg := &gocan.Message{Channel: fields[0], IsFD: true, Data: []byte{}}
+	if fields[1] == "Tx" {
+		msg.Direction = gocan.Tx
+	}
+	if fields[2] == "ErrorFrame" {
+		msg.Type = gocan.ErrorFrame
+		return msg, nil
+	}
+	if err := r.parseID(msg, fields[2]); err != nil {
+		return nil, err
+	}
+
+	fields = fields[3:]
+	if len(fields) > 0 && fields[0] != "0" && fields[0] != "1" {
+		fields = fields[1:] // symbolic name
+	}
+	if len(fields) < 4 {
+		return nil, ErrInvalidLine
+	}
+	brs, esi := fields[0] == "1", fields[1] == "1"
+	dlc, errDLC := strconv.ParseUint(fields[2], 16, 8)
+	length, errLen := strconv.Atoi(fields[3])
+	if errDLC != nil || errLen != nil || dlc > 15 || length > gocan.MaxDataLength {
+		return nil, ErrInvalidLine
+	}
+	switch {
+	case esi:
+		msg.Type = gocan.FDErrorStateIndicator
+	case brs:
+		msg.Type = gocan.FDBitRateSwitchFrame
+	}
+	msg.DLC = uint8(dlc)
+
+	var err error
+	msg.Data, err = r.parseData(fields[4:], length)
+	return msg, err
+}
+
+// Parses an id with optional 'x' suffix for extended ids
+func (r *Reader) parseID(msg *gocan.Message, s string) error {
+	if strings.HasSuffix(s, "x") {
+		msg.IsExtended = true
+		s = s[:len(s)-1]
+	}
+	id, err := strconv.ParseUint(s, r.base, 32)
+	if err != nil {
+		return ErrInvalidLine
+	}
+	msg.ID = gocan.MessageID(id)
+	return nil
+}
+
+// Parses n data bytes
+func (r *Reader) parseData(fields []string, n int) ([]byte, error) {
+	if len(fields) < n {
+		return nil, ErrInvalidLine
+	}
+	data := make([]byte, n)
+	for i := range data {
+		b, err := strconv.ParseUint(fields[i], r.base, 8)
+		if err != nil {
+			return nil, ErrInvalidLine
+		}
+		data[i] = byte(b)
+	}
+	return data, nil
+}
+
+// Writer writes messages into an ASC log file with hex base and absolute timestamps
+// The header date is taken from the first message, whose timestamp is interpreted as µs since unix epoch
+type Writer struct {
+	w        *bufio.Writer
+	start    uint64
+	started  bool
+	channels channels.Numbers
+}
+
+// Creates a writer for an ASC log, Close() must be called after the last message
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Writes the header with the given start time
+func (w *Writer) writeHeader(start time.Time) {
+	date := start.Format(DateLayout)
+	fmt.Fprintf(w.w, "date %s\n", date)
+	fmt.Fprintf(w.w, "base hex  timestamps absolute\n")
+	fmt.Fprintf(w.w, "internal events logged\n")
+	fmt.Fprintf(w.w, "// version 9.0.0\n")
+	fmt.Fprintf(w.w, "Begin Triggerblock %s\n", date)
+	fmt.Fprintf(w.w, "%12.6f Start of measurement\n", 0.0)
+}
+
+// Writes a single message
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	if !w.started {
+		// header date only holds milliseconds
+		w.start = msg.TimeStamp - msg.TimeStamp%1000
+		w.writeHeader(time.UnixMicro(int64(w.start)))
+		w.started = true
+	}
+
+	offset := uint64(0)
+	if msg.TimeStamp > w.start {
+		offset = msg.TimeStamp - w.start
+	}
+	fmt.Fprintf(w.w, "%5d.%06d ", offset/1000000, offset%1000000)
+
+	channel := w.channels.Get(msg.Channel)
+	dir := "Rx"
+	if msg.Direction == gocan.Tx {
+		dir = "Tx"
+	}
+	id := fmt.Sprintf("%X", uint32(msg.ID))
+	if msg.IsExtended {
+		id += "x"
+	}
+
+	var err error
+	switch {
+	case msg.IsFD && msg.Type == gocan.ErrorFrame:
+		_, err = fmt.Fprintf(w.w, "CANFD %3d %s ErrorFrame\n", channel, dir)
+	case msg.Type == gocan.ErrorFrame:
+		_, err = fmt.Fprintf(w.w, "%d  ErrorFrame\n", channel)
+	case msg.IsFD:
+		brs, esi := 0, 0
+		switch msg.Type {
+		case gocan.FDBitRateSwitchFrame:
+			brs = 1
+		case gocan.FDErrorStateIndicator:
+			esi = 1
+		}
+		_, err = fmt.Fprintf(w.w, "CANFD %3d %-4s %8s %32s %d %d %x %2d %s\n", channel, dir, id, "", brs, esi,
+			gocan.DLCFromLength(len(msg.Data)), len(msg.Data), formatData(msg.Data))
+	case msg.Type == gocan.RemoteFrame:
+		_, err = fmt.Fprintf(w.w, "%d  %-15s %-4s r %x\n", channel, id, dir, msg.DLC)
+	default:
+		_, err = fmt.Fprintf(w.w, "%d  %-15s %-4s d %x %s\n", channel, id, dir, len(msg.Data), formatData(msg.Data))
+	}
+	return err
+}
+
+// Writes a comment line, the header is written first if no message was written yet
+func (w *Writer) WriteComment(timeStamp uint64, text string) error {
+	if !w.started {
+		w.start = timeStamp - timeStamp%1000
+		w.writeHeader(time.UnixMicro(int64(w.start)))
+		w.started = true
+	}
+	_, err := fmt.Fprintf(w.w, "// %s\n", strings.ReplaceAll(text, "\n", " "))
+	return err
+}
+
+func formatData(data []byte) string {
+	var sb strings.Builder
+	for i, b := range data {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	return sb.String()
+}
+
+// Writes the end of the log and flushes all buffered data, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if !w.started {
+		w.writeHeader(time.Now())
+		w.started = true
+	}
+	fmt.Fprintf(w.w, "End TriggerBlock\n")
+	return w.w.Flush()
+}
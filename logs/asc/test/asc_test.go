@@ -0,0 +1,150 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/asc"
+)
+
+func auxReadAll(t *testing.T, r *asc.Reader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxEqual(a, b *gocan.Message) bool {
+	return a.ID == b.ID && a.TimeStamp == b.TimeStamp && a.Type == b.Type && a.Channel == b.Channel && a.IsExtended == b.IsExtended &&
+		a.IsFD == b.IsFD && a.Direction == b.Direction && bytes.Equal(a.Data, b.Data)
+}
+
+func TestReadCANoeLog(t *testing.T) {
+	log := `date Wed Jun 16 10:30:21.123 am 2021
+base hex  timestamps absolute
+internal events logged
+// version 13.0.0
+Begin Triggerblock Wed Jun 16 10:30:21.123 am 2021
+   0.000000 Start of measurement
+   0.015991 1  1E6             Rx   d 8 A8 00 00 00 00 00 00 00  Length = 240015 BitCount = 124 ID = 486
+   0.017000 2  18EBFF00x       Tx   d 3 01 02 03
+   0.020000 1  123             Rx   r 4
+   0.030000 1  ErrorFrame
+   0.040000 CANFD   1 Rx        7E8  EngineData                      1 0 9 12 11 22 33 44 55 66 77 88 99 AA BB CC   130000  130 3000 0 0 0 0 0
+   0.050000 1  Statistic: D 0 R 0 XD 0 XR 0 E 0 O 0 B 0.00%
+End TriggerBlock
+`
+	r := asc.NewReader(strings.NewReader(log))
+	msgs := auxReadAll(t, r)
+	if len(msgs) != 5 {
+		t.Fatalf("expected 5 messages, got %v: %v", len(msgs), msgs)
+	}
+
+	start := uint64(time.Date(2021, 6, 16, 10, 30, 21, 123000000, time.Local).UnixMicro())
+	if !r.StartTime.Equal(time.UnixMicro(int64(start))) {
+		t.Errorf("wrong start time: %v", r.StartTime)
+	}
+
+	expected := []gocan.Message{
+		{ID: 0x1E6, TimeStamp: start + 15991, Channel: "1", Data: []byte{0xA8, 0, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x18EBFF00, TimeStamp: start + 17000, Channel: "2", Data: []byte{1, 2, 3}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x123, TimeStamp: start + 20000, Channel: "1", Type: gocan.RemoteFrame, Data: []byte{}},
+		{TimeStamp: start + 30000, Channel: "1", Type: gocan.ErrorFrame, Data: []byte{}},
+		{ID: 0x7E8, TimeStamp: start + 40000, Channel: "1", Type: gocan.FDBitRateSwitchFrame, IsFD: true,
+			Data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC}},
+	}
+	for i := range expected {
+		if !auxEqual(&msgs[i], &expected[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, msgs[i], expected[i])
+		}
+	}
+	if msgs[2].DLC != 4 || msgs[4].DLC != 9 {
+		t.Errorf("wrong DLC: %v, %v", msgs[2].DLC, msgs[4].DLC)
+	}
+}
+
+func TestReadDecimalRelative(t *testing.T) {
+	log := `date Wed Jun 16 10:30:21 2021
+base dec  timestamps relative
+Begin Triggerblock
+   0.010000 1  291             Rx   d 2 1 255
+   0.010000 1  291x            Rx   d 1 16
+End TriggerBlock
+`
+	msgs := auxReadAll(t, asc.NewReader(strings.NewReader(log)))
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %v", len(msgs))
+	}
+	if msgs[0].ID != 0x123 || msgs[0].Data[1] != 0xFF || msgs[1].Data[0] != 0x10 || !msgs[1].IsExtended {
+		t.Errorf("wrong decimal parsing: %+v", msgs)
+	}
+	if msgs[1].TimeStamp-msgs[0].TimeStamp != 10000 {
+		t.Errorf("wrong relative timestamps: %v, %v", msgs[0].TimeStamp, msgs[1].TimeStamp)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.Local).UnixMicro())
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: start + 1234, Channel: "1", Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x1FFFFFFF, TimeStamp: start + 2000, Channel: "2", Data: []byte{}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x200, TimeStamp: start + 3000, Channel: "1", Type: gocan.RemoteFrame, DLC: 8, Data: []byte{}},
+		{TimeStamp: start + 4000, Channel: "2", Type: gocan.ErrorFrame, Data: []byte{}},
+		{ID: 0x300, TimeStamp: start + 5000, Channel: "1", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 64)},
+		{ID: 0x301, TimeStamp: start + 6000000, Channel: "2", IsFD: true, Data: []byte{1, 2, 3}, Direction: gocan.Tx},
+	}
+
+	var buf bytes.Buffer
+	w := asc.NewWriter(&buf)
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	read := auxReadAll(t, asc.NewReader(&buf))
+	if len(read) != len(msgs) {
+		t.Fatalf("expected %v messages, got %v", len(msgs), len(read))
+	}
+	for i := range msgs {
+		if !auxEqual(&read[i], &msgs[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, read[i], msgs[i])
+		}
+	}
+}
+
+func TestWriteChannelNames(t *testing.T) {
+	var buf bytes.Buffer
+	w := asc.NewWriter(&buf)
+	names := []string{"3", "can0", "1", "can1", "can0", "3"}
+	for i, name := range names {
+		w.WriteMessage(&gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(i), Channel: name, Data: []byte{}})
+	}
+	w.Close()
+
+	// numeric names keep their number unless it is taken, named channels never share a number with another name
+	expected := []string{"3", "1", "2", "4", "1", "3"}
+	read := auxReadAll(t, asc.NewReader(&buf))
+	if len(read) != len(expected) {
+		t.Fatalf("expected %v messages, got %v", len(expected), len(read))
+	}
+	for i := range read {
+		if read[i].Channel != expected[i] {
+			t.Errorf("%v: got channel %v, expected %v", names[i], read[i].Channel, expected[i])
+		}
+	}
+}
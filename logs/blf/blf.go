@@ -0,0 +1,609 @@
+package blf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/internal/channels"
+)
+
+// Object types
+const (
+	ObjCANMessage     uint32 = 1
+	ObjLogContainer   uint32 = 10
+	ObjCANErrorExt    uint32 = 73
+	ObjCANMessage2    uint32 = 86
+	ObjCANFDMessage   uint32 = 100
+	ObjCANFDMessage64 uint32 = 101
+)
+
+// Compression methods of a log container
+const (
+	NoCompression uint16 = 0
+	ZlibDeflate   uint16 = 2
+)
+
+// Flags of an object header defining the timestamp resolution
+const (
+	TimeTenMics uint32 = 0x1 // timestamp in 10 µs
+	TimeOneNans uint32 = 0x2 // timestamp in ns
+)
+
+// Flags of CAN messages
+const (
+	canMsgExt      = 0x80000000 // id is an extended id
+	canMsgDir      = 0x01       // CAN_MESSAGE: message was sent
+	canMsgRTR      = 0x80       // CAN_MESSAGE: remote frame
+	canFDMsgEDL    = 0x01       // CAN_FD_MESSAGE: extended data length (FD frame)
+	canFDMsgBRS    = 0x02       // CAN_FD_MESSAGE: bit rate switch
+	canFDMsgESI    = 0x04       // CAN_FD_MESSAGE: error state indicator
+	canFD64RTR     = 0x0010     // CAN_FD_MESSAGE_64: remote frame
+	canFD64EDL     = 0x1000     // CAN_FD_MESSAGE_64: extended data length (FD frame)
+	canFD64BRS     = 0x2000     // CAN_FD_MESSAGE_64: bit rate switch
+	canFD64ESI     = 0x4000     // CAN_FD_MESSAGE_64: error state indicator
+	canErrorExtDir = 0x01       // CAN_ERROR_EXT: frame was sent
+)
+
+// Sizes of the fixed structures
+const (
+	fileHeaderSize      = 144
+	objHeaderBaseSize   = 16
+	objHeaderV1Size     = 16
+	objHeaderV2Size     = 24
+	logContainerSize    = 16
+	canMsgSize          = 16
+	canFDMsgSize        = 84
+	canFD64MsgSize      = 40
+	canErrorExtSize     = 32
+	maxContainerPayload = 128 * 1024
+)
+
+var (
+	fileSignature = [4]byte{'L', 'O', 'G', 'G'}
+	objSignature  = [4]byte{'L', 'O', 'B', 'J'}
+)
+
+// errors
+var (
+	ErrInvalidFile   = errors.New("not a blf file")
+	ErrInvalidObject = errors.New("invalid blf object")
+)
+
+// File header of a BLF file
+type fileHeader struct {
+	Signature        [4]byte
+	HeaderSize       uint32
+	ApplicationID    uint8
+	ApplicationMajor uint8
+	ApplicationMinor uint8
+	ApplicationBuild uint8
+	BinLogMajor      uint8
+	BinLogMinor      uint8
+	BinLogBuild      uint8
+	BinLogPatch      uint8
+	FileSize         uint64
+	UncompressedSize uint64
+	ObjectCount      uint32
+	ObjectsRead      uint32
+	StartTime        systemTime
+	StopTime         systemTime
+	Reserved         [fileHeaderSize - 72]byte
+}
+
+// Windows SYSTEMTIME structure
+type systemTime struct {
+	Year, Month, DayOfWeek, Day, Hour, Minute, Second, Milliseconds uint16
+}
+
+func (s systemTime) time() time.Time {
+	if s.Year == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(s.Year), time.Month(s.Month), int(s.Day), int(s.Hour), int(s.Minute), int(s.Second), int(s.Milliseconds)*1000000, time.Local)
+}
+
+func toSystemTime(t time.Time) systemTime {
+	return systemTime{
+		Year: uint16(t.Year()), Month: uint16(t.Month()), DayOfWeek: uint16(t.Weekday()), Day: uint16(t.Day()),
+		Hour: uint16(t.Hour()), Minute: uint16(t.Minute()), Second: uint16(t.Second()), Milliseconds: uint16(t.Nanosecond() / 1000000),
+	}
+}
+
+// Common header of every object
+type objHeaderBase struct {
+	Signature     [4]byte
+	HeaderSize    uint16
+	HeaderVersion uint16
+	ObjectSize    uint32
+	ObjectType    uint32
+}
+
+// Reader streams messages from a BLF file, log containers are decompressed one at a time
+// The timestamp of every message is the start time of the file in µs since unix epoch plus the offset of the object
+type Reader struct {
+	StartTime time.Time // start time of the file, zero if not set
+
+	r     io.Reader
+	start uint64
+	data  []byte          // decompressed container data not parsed yet
+	msgs  []gocan.Message // parsed messages not returned yet
+}
+
+// Creates a reader and reads the file header
+func NewReader(r io.Reader) (*Reader, error) {
+	var header fileHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	if header.Signature != fileSignature || header.HeaderSize < fileHeaderSize {
+		return nil, ErrInvalidFile
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(header.HeaderSize-fileHeaderSize)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	reader := &Reader{r: r, StartTime: header.StartTime.time()}
+	if !reader.StartTime.IsZero() {
+		reader.start = uint64(reader.StartTime.UnixMicro())
+	}
+	return reader, nil
+}
+
+// Returns the next message of the file or io.EOF at the end of the file
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for len(r.msgs) == 0 {
+		if err := r.readObject(); err != nil {
+			return nil, err
+		}
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return &msg, nil
+}
+
+// Reads the next top level object of the file
+func (r *Reader) readObject() error {
+	var header objHeaderBase
+	if err := binary.Read(r.r, binary.LittleEndian, &header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated object header", ErrInvalidObject)
+		}
+		return err
+	}
+	if header.Signature != objSignature || header.ObjectSize < objHeaderBaseSize {
+		return ErrInvalidObject
+	}
+
+	// object data followed by padding to a size divisible by 4
+	obj := make([]byte, int(header.ObjectSize-objHeaderBaseSize)+int(header.ObjectSize%4))
+	if _, err := io.ReadFull(r.r, obj); err != nil {
+		if errors.Is(err, io.EOF) && header.ObjectSize%4 != 0 {
+			obj = obj[:len(obj)-int(header.ObjectSize%4)] // tolerate missing padding of the last object
+		} else {
+			return fmt.Errorf("%w: %v", ErrInvalidObject, err)
+		}
+	}
+	obj = obj[:header.ObjectSize-objHeaderBaseSize]
+
+	if header.ObjectType != ObjLogContainer {
+		return r.parseObject(header, obj)
+	}
+	if len(obj) < logContainerSize {
+		return ErrInvalidObject
+	}
+
+	method := binary.LittleEndian.Uint16(obj[0:])
+	payload := obj[logContainerSize:]
+	switch method {
+	case NoCompression:
+		r.data = append(r.data, payload...)
+	case ZlibDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidObject, err)
+		}
+		uncompressed, err := io.ReadAll(zr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidObject, err)
+		}
+		r.data = append(r.data, uncompressed...)
+	default:
+		return fmt.Errorf("%w: unknown compression method %v", ErrInvalidObject, method)
+	}
+	return r.parseContainerData()
+}
+
+// Parses all complete objects of the container data, an incomplete object at the end is kept for the next container
+func (r *Reader) parseContainerData() error {
+	pos := 0
+	for {
+		// objects are aligned by padding of a few bytes
+		next := bytes.Index(r.data[pos:min(pos+objHeaderBaseSize+8, len(r.data))], objSignature[:])
+		if next < 0 {
+			break
+		}
+		pos += next
+		if len(r.data)-pos < objHeaderBaseSize {
+			break
+		}
+
+		var header objHeaderBase
+		binary.Read(bytes.NewReader(r.data[pos:]), binary.LittleEndian, &header)
+		if header.ObjectSize < objHeaderBaseSize {
+			return ErrInvalidObject
+		}
+		end := pos + int(header.ObjectSize)
+		if end > len(r.data) {
+			break
+		}
+		if err := r.parseObject(header, r.data[pos+objHeaderBaseSize:end]); err != nil {
+			return err
+		}
+		pos = end
+	}
+	r.data = append(r.data[:0], r.data[pos:]...)
+	return nil
+}
+
+// Parses a single object without its base header, unsupported objects are skipped
+func (r *Reader) parseObject(header objHeaderBase, obj []byte) error {
+	var flags uint32
+	var timeStamp uint64
+	switch header.HeaderVersion {
+	case 1:
+		if len(obj) < objHeaderV1Size {
+			return ErrInvalidObject
+		}
+		flags = binary.LittleEndian.Uint32(obj[0:])
+		timeStamp = binary.LittleEndian.Uint64(obj[8:])
+		obj = obj[objHeaderV1Size:]
+	case 2:
+		if len(obj) < objHeaderV2Size {
+			return ErrInvalidObject
+		}
+		flags = binary.LittleEndian.Uint32(obj[0:])
+		timeStamp = binary.LittleEndian.Uint64(obj[8:])
+		obj = obj[objHeaderV2Size:]
+	default:
+		return nil
+	}
+
+	// timestamp in µs
+	if flags&TimeTenMics != 0 {
+		timeStamp *= 10
+	} else {
+		timeStamp /= 1000
+	}
+
+	msg := gocan.Message{TimeStamp: r.start + timeStamp}
+	var ok bool
+	var err error
+	switch header.ObjectType {
+	case ObjCANMessage, ObjCANMessage2:
+		ok, err = parseCANMessage(&msg, obj)
+	case ObjCANFDMessage:
+		ok, err = parseCANFDMessage(&msg, obj)
+	case ObjCANFDMessage64:
+		ok, err = parseCANFDMessage64(&msg, obj)
+	case ObjCANErrorExt:
+		ok, err = parseCANErrorExt(&msg, obj)
+	}
+	if ok {
+		r.msgs = append(r.msgs, msg)
+	}
+	return err
+}
+
+// Splits the raw id into message id and extended flag
+func setID(msg *gocan.Message, id uint32) {
+	msg.ID = gocan.MessageID(id & 0x1FFFFFFF)
+	msg.IsExtended = id&canMsgExt != 0
+}
+
+func parseCANMessage(msg *gocan.Message, obj []byte) (bool, error) {
+	if len(obj) < canMsgSize {
+		return false, ErrInvalidObject
+	}
+	msg.Channel = strconv.Itoa(int(binary.LittleEndian.Uint16(obj[0:])))
+	flags := obj[2]
+	msg.DLC = obj[3]
+	setID(msg, binary.LittleEndian.Uint32(obj[4:]))
+	if flags&canMsgDir != 0 {
+		msg.Direction = gocan.Tx
+	}
+	if flags&canMsgRTR != 0 {
+		msg.Type = gocan.RemoteFrame
+		msg.Data = []byte{}
+		return true, nil
+	}
+	msg.Data = append([]byte{}, obj[8:8+gocan.LengthFromDLC(msg.DLC, false)]...)
+	return true, nil
+}
+
+func parseCANFDMessage(msg *gocan.Message, obj []byte) (bool, error) {
+	if len(obj) < canFDMsgSize {
+		return false, ErrInvalidObject
+	}
+	msg.Channel = strconv.Itoa(int(binary.LittleEndian.Uint16(obj[0:])))
+	flags := obj[2]
+	msg.DLC = obj[3]
+	setID(msg, binary.LittleEndian.Uint32(obj[4:]))
+	fdFlags := obj[13]
+	validBytes := int(min(obj[14], gocan.MaxDataLength))
+
+	if flags&canMsgDir != 0 {
+		msg.Direction = gocan.Tx
+	}
+	msg.IsFD = fdFlags&canFDMsgEDL != 0
+	switch {
+	case flags&canMsgRTR != 0:
+		msg.Type = gocan.RemoteFrame
+		validBytes = 0
+	case fdFlags&canFDMsgESI != 0:
+		msg.Type = gocan.FDErrorStateIndicator
+	case fdFlags&canFDMsgBRS != 0:
+		msg.Type = gocan.FDBitRateSwitchFrame
+	}
+	msg.Data = append([]byte{}, obj[20:20+validBytes]...)
+	return true, nil
+}
+
+func parseCANFDMessage64(msg *gocan.Message, obj []byte) (bool, error) {
+	if len(obj) < canFD64MsgSize {
+		return false, ErrInvalidObject
+	}
+	msg.Channel = strconv.Itoa(int(obj[0]))
+	msg.DLC = obj[1]
+	validBytes := int(min(obj[2], gocan.MaxDataLength))
+	setID(msg, binary.LittleEndian.Uint32(obj[4:]))
+	flags := binary.LittleEndian.Uint32(obj[12:])
+	if obj[34] != 0 {
+		msg.Direction = gocan.Tx
+	}
+
+	msg.IsFD = flags&canFD64EDL != 0
+	switch {
+	case flags&canFD64RTR != 0:
+		msg.Type = gocan.RemoteFrame
+		validBytes = 0
+	case flags&canFD64ESI != 0:
+		msg.Type = gocan.FDErrorStateIndicator
+	case flags&canFD64BRS != 0:
+		msg.Type = gocan.FDBitRateSwitchFrame
+	}
+	if len(obj) < canFD64MsgSize+validBytes {
+		return false, ErrInvalidObject
+	}
+	msg.Data = append([]byte{}, obj[canFD64MsgSize:canFD64MsgSize+validBytes]...)
+	return true, nil
+}
+
+func parseCANErrorExt(msg *gocan.Message, obj []byte) (bool, error) {
+	if len(obj) < canErrorExtSize {
+		return false, ErrInvalidObject
+	}
+	msg.Type = gocan.ErrorFrame
+	msg.Channel = strconv.Itoa(int(binary.LittleEndian.Uint16(obj[0:])))
+	if binary.LittleEndian.Uint32(obj[4:])&canErrorExtDir != 0 {
+		msg.Direction = gocan.Tx
+	}
+	msg.DLC = obj[10]
+	setID(msg, binary.LittleEndian.Uint32(obj[16:]))
+	msg.IsExtended = false
+	msg.Data = append([]byte{}, obj[24:24+gocan.LengthFromDLC(msg.DLC, false)]...)
+	return true, nil
+}
+
+// Writer writes messages into a zlib compressed BLF file
+// The start time of the file is taken from the first message, whose timestamp is interpreted as µs since unix epoch
+// If the underlying writer implements io.WriteSeeker, the file header is updated with sizes and object count on Close()
+type Writer struct {
+	CompressionLevel int // zlib compression level used for log containers
+
+	w                io.Writer
+	buf              bytes.Buffer // uncompressed objects of the current container
+	started          bool
+	start            uint64 // start time in µs
+	last             uint64 // timestamp of the latest message in µs
+	fileSize         uint64
+	uncompressedSize uint64
+	objectCount      uint32
+	channels         channels.Numbers
+}
+
+// Creates a writer for a BLF file, Close() must be called after the last message
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, CompressionLevel: zlib.DefaultCompression}
+}
+
+// Builds the file header with the current statistics
+func (w *Writer) header() fileHeader {
+	start := time.UnixMicro(int64(w.start))
+	stop := time.UnixMicro(int64(w.last))
+	return fileHeader{
+		Signature:        fileSignature,
+		HeaderSize:       fileHeaderSize,
+		ApplicationID:    5,
+		BinLogMajor:      2,
+		BinLogMinor:      6,
+		BinLogBuild:      8,
+		BinLogPatch:      1,
+		FileSize:         w.fileSize,
+		UncompressedSize: w.uncompressedSize,
+		ObjectCount:      w.objectCount,
+		StartTime:        toSystemTime(start),
+		StopTime:         toSystemTime(stop),
+	}
+}
+
+// Writes a single message
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	if !w.started {
+		// start time of the header only holds milliseconds
+		w.start = msg.TimeStamp - msg.TimeStamp%1000
+		w.started = true
+		header := w.header()
+		if err := binary.Write(w.w, binary.LittleEndian, &header); err != nil {
+			return err
+		}
+		w.fileSize = fileHeaderSize
+	}
+	if msg.TimeStamp > w.last {
+		w.last = msg.TimeStamp
+	}
+
+	channel := w.channels.Get(msg.Channel)
+	id := uint32(msg.ID)
+	if msg.IsExtended {
+		id |= canMsgExt
+	}
+	dir := uint8(0)
+	if msg.Direction == gocan.Tx {
+		dir = canMsgDir
+	}
+
+	var obj []byte
+	var objType uint32
+	switch {
+	case msg.Type == gocan.ErrorFrame:
+		objType = ObjCANErrorExt
+		obj = make([]byte, canErrorExtSize)
+		binary.LittleEndian.PutUint16(obj[0:], uint16(channel))
+		binary.LittleEndian.PutUint32(obj[4:], uint32(dir))
+		obj[10] = uint8(min(len(msg.Data), 8))
+		binary.LittleEndian.PutUint32(obj[16:], uint32(msg.ID))
+		copy(obj[24:32], msg.Data)
+
+	case msg.IsFD:
+		objType = ObjCANFDMessage
+		obj = make([]byte, canFDMsgSize)
+		binary.LittleEndian.PutUint16(obj[0:], uint16(channel))
+		obj[2] = dir
+		obj[3] = gocan.DLCFromLength(len(msg.Data))
+		binary.LittleEndian.PutUint32(obj[4:], id)
+		fdFlags := uint8(canFDMsgEDL)
+		switch msg.Type {
+		case gocan.FDBitRateSwitchFrame:
+			fdFlags |= canFDMsgBRS
+		case gocan.FDErrorStateIndicator:
+			fdFlags |= canFDMsgESI
+		}
+		obj[13] = fdFlags
+		obj[14] = uint8(copy(obj[20:], msg.Data))
+
+	default:
+		objType = ObjCANMessage
+		obj = make([]byte, canMsgSize)
+		binary.LittleEndian.PutUint16(obj[0:], uint16(channel))
+		obj[2] = dir
+		obj[3] = uint8(min(len(msg.Data), 8))
+		if msg.Type == gocan.RemoteFrame {
+			obj[2] |= canMsgRTR
+			obj[3] = msg.DLC
+		}
+		binary.LittleEndian.PutUint32(obj[4:], id)
+		copy(obj[8:16], msg.Data)
+	}
+
+	offset := uint64(0)
+	if msg.TimeStamp > w.start {
+		offset = msg.TimeStamp - w.start
+	}
+	w.addObject(objType, offset*1000, obj)
+
+	if w.buf.Len() >= maxContainerPayload {
+		return w.flushContainer()
+	}
+	return nil
+}
+
+// Appends an object with a version 1 header and nanosecond timestamp to the current container
+func (w *Writer) addObject(objType uint32, timeStamp uint64, obj []byte) {
+	var header [objHeaderBaseSize + objHeaderV1Size]byte
+	copy(header[0:], objSignature[:])
+	binary.LittleEndian.PutUint16(header[4:], objHeaderBaseSize+objHeaderV1Size)
+	binary.LittleEndian.PutUint16(header[6:], 1)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(header)+len(obj)))
+	binary.LittleEndian.PutUint32(header[12:], objType)
+	binary.LittleEndian.PutUint32(header[16:], TimeOneNans)
+	binary.LittleEndian.PutUint64(header[24:], timeStamp)
+
+	w.buf.Write(header[:])
+	w.buf.Write(obj)
+	w.buf.Write(make([]byte, len(obj)%4))
+	w.objectCount++
+}
+
+// Compresses the buffered objects into a log container and writes it
+func (w *Writer) flushContainer() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, w.CompressionLevel)
+	if err != nil {
+		return err
+	}
+	zw.Write(w.buf.Bytes())
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	size := objHeaderBaseSize + logContainerSize + compressed.Len()
+	var header [objHeaderBaseSize + logContainerSize]byte
+	copy(header[0:], objSignature[:])
+	binary.LittleEndian.PutUint16(header[4:], objHeaderBaseSize)
+	binary.LittleEndian.PutUint16(header[6:], 1)
+	binary.LittleEndian.PutUint32(header[8:], uint32(size))
+	binary.LittleEndian.PutUint32(header[12:], ObjLogContainer)
+	binary.LittleEndian.PutUint16(header[16:], ZlibDeflate)
+	binary.LittleEndian.PutUint32(header[24:], uint32(w.buf.Len()))
+
+	for _, b := range [][]byte{header[:], compressed.Bytes(), make([]byte, size%4)} {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	w.fileSize += uint64(size + size%4)
+	w.uncompressedSize += uint64(objHeaderBaseSize + logContainerSize + w.buf.Len())
+	w.buf.Reset()
+	return nil
+}
+
+// Writes the remaining messages and updates the file header if possible, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if !w.started {
+		w.start = uint64(time.Now().UnixMicro())
+		w.started = true
+		header := w.header()
+		if err := binary.Write(w.w, binary.LittleEndian, &header); err != nil {
+			return err
+		}
+		w.fileSize = fileHeaderSize
+	}
+	if err := w.flushContainer(); err != nil {
+		return err
+	}
+
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := w.header()
+	if err := binary.Write(ws, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,230 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/blf"
+)
+
+func auxReadAll(t *testing.T, r *blf.Reader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxEqual(a, b *gocan.Message) bool {
+	return a.ID == b.ID && a.TimeStamp == b.TimeStamp && a.Type == b.Type && a.Channel == b.Channel && a.IsExtended == b.IsExtended &&
+		a.IsFD == b.IsFD && a.Direction == b.Direction && bytes.Equal(a.Data, b.Data)
+}
+
+// Builds an object with a version 2 header and a timestamp in 10 µs
+func auxObject(objType uint32, timeStamp uint64, data []byte) []byte {
+	obj := make([]byte, 40, 40+len(data)+4)
+	copy(obj, "LOBJ")
+	binary.LittleEndian.PutUint16(obj[4:], 40)
+	binary.LittleEndian.PutUint16(obj[6:], 2)
+	binary.LittleEndian.PutUint32(obj[8:], uint32(40+len(data)))
+	binary.LittleEndian.PutUint32(obj[12:], objType)
+	binary.LittleEndian.PutUint32(obj[16:], blf.TimeTenMics)
+	binary.LittleEndian.PutUint64(obj[24:], timeStamp)
+	obj = append(obj, data...)
+	return append(obj, make([]byte, len(data)%4)...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.Local).UnixMicro())
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: start + 1234, Channel: "1", Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x1FFFFFFF, TimeStamp: start + 2000, Channel: "2", Data: []byte{}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x200, TimeStamp: start + 3000, Channel: "1", Type: gocan.RemoteFrame, DLC: 8, Data: []byte{}},
+		{ID: 0x12, TimeStamp: start + 4000, Channel: "2", Type: gocan.ErrorFrame, Data: []byte{}},
+		{ID: 0x300, TimeStamp: start + 5000, Channel: "1", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 64)},
+		{ID: 0x301, TimeStamp: start + 6000000, Channel: "2", IsFD: true, Type: gocan.FDErrorStateIndicator, Data: []byte{1, 2, 3}, Direction: gocan.Tx},
+	}
+
+	var buf bytes.Buffer
+	w := blf.NewWriter(&buf)
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := blf.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// start time is the first timestamp truncated to milliseconds
+	if !r.StartTime.Equal(time.UnixMicro(int64(start + 1000))) {
+		t.Errorf("wrong start time: got %v, expected %v", r.StartTime, time.UnixMicro(int64(start+1000)))
+	}
+	read := auxReadAll(t, r)
+	if len(read) != len(msgs) {
+		t.Fatalf("expected %v messages, got %v", len(msgs), len(read))
+	}
+	for i := range msgs {
+		if !auxEqual(&read[i], &msgs[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, read[i], msgs[i])
+		}
+	}
+	if read[2].DLC != 8 || read[4].DLC != 15 {
+		t.Errorf("wrong DLC: %v, %v", read[2].DLC, read[4].DLC)
+	}
+}
+
+func TestMultipleContainers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.blf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// random-like payload to spread objects over several containers
+	const count = 20000
+	w := blf.NewWriter(f)
+	w.CompressionLevel = 1
+	for i := 0; i < count; i++ {
+		msg := gocan.Message{ID: gocan.MessageID(i % 0x800), TimeStamp: uint64(1700000000000000 + i*100), Channel: "vcan0",
+			IsFD: i%3 == 0, Data: []byte{byte(i), byte(i >> 8), byte(i * 7), byte(i * 13)}}
+		if err := w.WriteMessage(&msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Close()
+
+	data, _ := os.ReadFile(path)
+	if objects := binary.LittleEndian.Uint32(data[32:]); objects != count {
+		t.Errorf("wrong object count in header: got %v, expected %v", objects, count)
+	}
+	if size := binary.LittleEndian.Uint64(data[16:]); size != uint64(len(data)) {
+		t.Errorf("wrong file size in header: got %v, expected %v", size, len(data))
+	}
+	if containers := bytes.Count(data, []byte("LOBJ")); containers < 2 {
+		t.Errorf("expected several containers, got %v", containers)
+	}
+
+	r, err := blf.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	if len(read) != count {
+		t.Fatalf("expected %v messages, got %v", count, len(read))
+	}
+	for i, msg := range read {
+		if msg.ID != gocan.MessageID(i%0x800) || msg.TimeStamp != uint64(1700000000000000+i*100) || msg.Channel != "1" || msg.Data[1] != byte(i>>8) {
+			t.Fatalf("message %v: wrong content %+v", i, msg)
+		}
+	}
+}
+
+func TestReadUncompressedObjects(t *testing.T) {
+	canMsg2 := make([]byte, 24)
+	binary.LittleEndian.PutUint16(canMsg2[0:], 3)
+	canMsg2[3] = 2
+	binary.LittleEndian.PutUint32(canMsg2[4:], 0x80000123)
+	canMsg2[8], canMsg2[9] = 0xAA, 0xBB
+
+	fd64 := make([]byte, 40, 52)
+	fd64[0] = 1
+	fd64[1] = 9
+	fd64[2] = 12
+	binary.LittleEndian.PutUint32(fd64[4:], 0x7E8)
+	binary.LittleEndian.PutUint32(fd64[12:], 0x1000|0x2000)
+	fd64[34] = 1
+	fd64 = append(fd64, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+
+	var objects []byte
+	objects = append(objects, auxObject(blf.ObjCANMessage2, 100, canMsg2)...)
+	objects = append(objects, auxObject(96, 150, make([]byte, 12))...) // unsupported global marker
+	objects = append(objects, auxObject(blf.ObjCANFDMessage64, 200, fd64)...)
+
+	container := make([]byte, 32)
+	copy(container, "LOBJ")
+	binary.LittleEndian.PutUint16(container[4:], 16)
+	binary.LittleEndian.PutUint16(container[6:], 1)
+	binary.LittleEndian.PutUint32(container[8:], uint32(32+len(objects)))
+	binary.LittleEndian.PutUint32(container[12:], blf.ObjLogContainer)
+	binary.LittleEndian.PutUint16(container[16:], blf.NoCompression)
+	binary.LittleEndian.PutUint32(container[24:], uint32(len(objects)))
+	container = append(container, objects...)
+
+	header := make([]byte, 144)
+	copy(header, "LOGG")
+	binary.LittleEndian.PutUint32(header[4:], 144)
+
+	r, err := blf.NewReader(bytes.NewReader(append(header, container...)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !r.StartTime.IsZero() {
+		t.Errorf("expected no start time, got %v", r.StartTime)
+	}
+	read := auxReadAll(t, r)
+	expected := []gocan.Message{
+		{ID: 0x123, TimeStamp: 1000, Channel: "3", IsExtended: true, Data: []byte{0xAA, 0xBB}},
+		{ID: 0x7E8, TimeStamp: 2000, Channel: "1", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Direction: gocan.Tx,
+			Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+	}
+	if len(read) != len(expected) {
+		t.Fatalf("expected %v messages, got %v", len(expected), len(read))
+	}
+	for i := range expected {
+		if !auxEqual(&read[i], &expected[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, read[i], expected[i])
+		}
+	}
+}
+
+func TestInvalidFile(t *testing.T) {
+	if _, err := blf.NewReader(bytes.NewReader([]byte("date Wed Jun 16 10:30:21 2021"))); err == nil {
+		t.Errorf("expected error for non blf file")
+	}
+}
+
+func TestWriteChannelNames(t *testing.T) {
+	var buf bytes.Buffer
+	w := blf.NewWriter(&buf)
+	names := []string{"3", "can0", "1", "can1", "can0", "3"}
+	for i, name := range names {
+		w.WriteMessage(&gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(i), Channel: name, Data: []byte{}})
+	}
+	w.Close()
+
+	// numeric names keep their number unless it is taken, named channels never share a number with another name
+	expected := []string{"3", "1", "2", "4", "1", "3"}
+	r, err := blf.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	if len(read) != len(expected) {
+		t.Fatalf("expected %v messages, got %v", len(expected), len(read))
+	}
+	for i := range read {
+		if read[i].Channel != expected[i] {
+			t.Errorf("%v: got channel %v, expected %v", names[i], read[i].Channel, expected[i])
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package candump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/morgadow/gocan"
+)
+
+// Flags of the SocketCAN id and CAN FD frames
+const (
+	FlagEFF = 0x80000000 // extended frame format
+	FlagRTR = 0x40000000 // remote transmission request
+	FlagERR = 0x20000000 // error frame
+	MaskEFF = 0x1FFFFFFF // extended id
+	MaskSFF = 0x000007FF // standard id
+
+	FlagBRS = 0x01 // CAN FD bit rate switch
+	FlagESI = 0x02 // CAN FD error state indicator
+)
+
+// Channel written for messages without a channel
+var DefaultChannel = "can0"
+
+// errors
+var (
+	ErrInvalidFrame = errors.New("invalid candump frame")
+	ErrInvalidLine  = errors.New("invalid candump log line")
+)
+
+// Parses a frame in compact notation, e.g. "123#DEADBEEF", "12345678#R", "123##1AABB" or "20000080#0000000000000000"
+func ParseFrame(s string) (*gocan.Message, error) {
+	sep := strings.IndexByte(s, '#')
+	if sep != 3 && sep != 8 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+
+	id, err := strconv.ParseUint(s[:sep], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+
+	msg := &gocan.Message{Type: gocan.DataFrame}
+	if sep == 8 {
+		if id&FlagERR != 0 {
+			msg.Type = gocan.ErrorFrame
+		} else {
+			msg.IsExtended = true
+		}
+		msg.ID = gocan.MessageID(id & MaskEFF)
+	} else {
+		if id > MaskSFF {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+		}
+		msg.ID = gocan.MessageID(id)
+	}
+
+	payload := s[sep+1:]
+	switch {
+	case strings.HasPrefix(payload, "#"):
+		// CAN FD frame with flags
+		if len(payload) < 2 || msg.Type == gocan.ErrorFrame {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+		}
+		flags, err := strconv.ParseUint(payload[1:2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+		}
+		msg.IsFD = true
+		if flags&FlagESI != 0 {
+			msg.Type = gocan.FDErrorStateIndicator
+		} else if flags&FlagBRS != 0 {
+			msg.Type = gocan.FDBitRateSwitchFrame
+		}
+		payload = payload[2:]
+
+	case strings.HasPrefix(payload, "R"):
+		// remote frame with optional length
+		if msg.Type == gocan.ErrorFrame {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+		}
+		msg.Type = gocan.RemoteFrame
+		msg.Data = []byte{}
+		if len(payload) > 1 {
+			dlc, err := strconv.ParseUint(payload[1:2], 16, 8)
+			if err != nil || dlc > 8 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+			}
+			msg.DLC = uint8(dlc)
+		}
+		return msg, nil
+	}
+
+	data, err := parseData(payload)
+	if err != nil || len(data) > gocan.MaxDataLength || (!msg.IsFD && len(data) > 8) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFrame, s)
+	}
+	msg.Data = data
+	msg.DLC = gocan.DLCFromLength(len(data))
+	return msg, nil
+}
+
+// Parses hex data bytes with optional '.' separators and an optional "_<dlc>" suffix for classic frames with a DLC above 8
+func parseData(s string) ([]byte, error) {
+	if i := strings.IndexByte(s, '_'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.ReplaceAll(s, ".", "")
+	if len(s)%2 != 0 {
+		return nil, ErrInvalidFrame
+	}
+
+	data := make([]byte, len(s)/2)
+	for i := range data {
+		b, err := strconv.ParseUint(s[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil, ErrInvalidFrame
+		}
+		data[i] = byte(b)
+	}
+	return data, nil
+}
+
+// Formats a message in compact notation, e.g. "123#DEADBEEF"
+func FormatFrame(msg *gocan.Message) string {
+	var sb strings.Builder
+
+	switch {
+	case msg.Type == gocan.ErrorFrame:
+		fmt.Fprintf(&sb, "%08X#", uint32(msg.ID&MaskEFF)|FlagERR)
+	case msg.IsExtended:
+		fmt.Fprintf(&sb, "%08X#", uint32(msg.ID&MaskEFF))
+	default:
+		fmt.Fprintf(&sb, "%03X#", uint32(msg.ID&MaskSFF))
+	}
+
+	if msg.Type == gocan.RemoteFrame && !msg.IsFD {
+		sb.WriteByte('R')
+		if msg.DLC > 0 {
+			fmt.Fprintf(&sb, "%X", msg.DLC)
+		}
+		return sb.String()
+	}
+
+	if msg.IsFD {
+		flags := 0
+		switch msg.Type {
+		case gocan.FDBitRateSwitchFrame:
+			flags = FlagBRS
+		case gocan.FDErrorStateIndicator:
+			flags = FlagESI
+		}
+		fmt.Fprintf(&sb, "#%X", flags)
+	}
+	for _, b := range msg.Data {
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	return sb.String()
+}
+
+// Reader reads messages from a candump log file
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// Creates a reader for a candump log
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Returns the next message of the log or io.EOF at the end of the log
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		msg, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", r.line, err)
+		}
+		return msg, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Parses a single log line "(<seconds>.<micros>) <channel> <frame>"
+func ParseLine(line string) (*gocan.Message, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLine, line)
+	}
+
+	timeStamp, err := parseTimeStamp(fields[0][1 : len(fields[0])-1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidLine, line)
+	}
+	msg, err := ParseFrame(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	msg.TimeStamp = timeStamp
+	msg.Channel = fields[1]
+	return msg, nil
+}
+
+// Converts "<seconds>.<fraction>" into µs
+func parseTimeStamp(s string) (uint64, error) {
+	secStr, fracStr, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseUint(secStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fracStr = (fracStr + "000000")[:6]
+	micros, err := strconv.ParseUint(fracStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return sec*1000000 + micros, nil
+}
+
+// Writer writes messages into a candump log file
+type Writer struct {
+	w *bufio.Writer
+}
+
+// Creates a writer for a candump log, Flush() or Close() must be called after the last message
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Writes a single message as log line
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	_, err := fmt.Fprintln(w.w, FormatLine(msg))
+	return err
+}
+
+// Writes a comment line, candump logs have no time for comments so timeStamp is written as part of the comment
+func (w *Writer) WriteComment(timeStamp uint64, text string) error {
+	_, err := fmt.Fprintf(w.w, "# (%d.%06d) %s\n", timeStamp/1000000, timeStamp%1000000, strings.ReplaceAll(text, "\n", " "))
+	return err
+}
+
+// Writes all buffered lines to the underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Flushes all buffered lines, the underlying writer is not closed
+func (w *Writer) Close() error {
+	return w.w.Flush()
+}
+
+// Formats a message as log line "(<seconds>.<micros>) <channel> <frame>"
+func FormatLine(msg *gocan.Message) string {
+	channel := msg.Channel
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	return fmt.Sprintf("(%d.%06d) %s %s", msg.TimeStamp/1000000, msg.TimeStamp%1000000, channel, FormatFrame(msg))
+}
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/candump"
+)
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		frame string
+		msg   gocan.Message
+	}{
+		{"123#DEADBEEF", gocan.Message{ID: 0x123, Data: []byte{0xDE, 0xAD, 0xBE, 0xEF}, DLC: 4}},
+		{"00000123#11.22", gocan.Message{ID: 0x123, Data: []byte{0x11, 0x22}, DLC: 2, IsExtended: true}},
+		{"123#", gocan.Message{ID: 0x123, Data: []byte{}}},
+		{"7FF#R", gocan.Message{ID: 0x7FF, Type: gocan.RemoteFrame, Data: []byte{}}},
+		{"1ABCDEF0#R4", gocan.Message{ID: 0x1ABCDEF0, Type: gocan.RemoteFrame, Data: []byte{}, DLC: 4, IsExtended: true}},
+		{"20000080#0000000000000000", gocan.Message{ID: 0x80, Type: gocan.ErrorFrame, Data: make([]byte, 8), DLC: 8}},
+		{"123##0AA", gocan.Message{ID: 0x123, Data: []byte{0xAA}, DLC: 1, IsFD: true}},
+		{"123##1" + strings.Repeat("01", 12), gocan.Message{ID: 0x123, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{1}, 12), DLC: 9, IsFD: true}},
+		{"12345678##3", gocan.Message{ID: 0x12345678, Type: gocan.FDErrorStateIndicator, Data: []byte{}, IsFD: true, IsExtended: true}},
+	}
+
+	for _, test := range tests {
+		msg, err := candump.ParseFrame(test.frame)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.frame, err)
+			continue
+		}
+		if msg.ID != test.msg.ID || msg.Type != test.msg.Type || msg.DLC != test.msg.DLC || msg.IsExtended != test.msg.IsExtended ||
+			msg.IsFD != test.msg.IsFD || !bytes.Equal(msg.Data, test.msg.Data) {
+			t.Errorf("%v: got %+v, expected %+v", test.frame, *msg, test.msg)
+		}
+	}
+}
+
+func TestParseFrameInvalid(t *testing.T) {
+	for _, frame := range []string{"", "12#00", "123#0", "123#XX", "123#001122334455667788", "1234#00", "20000080#R", "123##", "FFF#00", "800#R"} {
+		if _, err := candump.ParseFrame(frame); !errors.Is(err, candump.ErrInvalidFrame) {
+			t.Errorf("%q: expected invalid frame error, got %v", frame, err)
+		}
+	}
+}
+
+func TestFormatFrame(t *testing.T) {
+	for _, frame := range []string{"123#DEADBEEF", "00000123#1122", "7FF#R", "1ABCDEF0#R4", "20000080#0000000000000000", "123##0AA", "123##1", "12345678##2FF"} {
+		msg, err := candump.ParseFrame(frame)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", frame, err)
+		}
+		if formatted := candump.FormatFrame(msg); formatted != frame {
+			t.Errorf("got %q, expected %q", formatted, frame)
+		}
+	}
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	log := `(1436509052.249713) vcan0 044#2A366C2BBA
+(1436509052.449847) vcan0 0F6#7ADFE07BD2
+
+(1436509052.650004) can1 1F334455#R
+(1436509052.850000) can1 123##1112233
+(1436509053.000001) can0 20000004#0000000000000000
+`
+	r := candump.NewReader(strings.NewReader(log))
+	var out bytes.Buffer
+	w := candump.NewWriter(&out)
+
+	count := 0
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count == 0 && (msg.TimeStamp != 1436509052249713 || msg.Channel != "vcan0" || msg.ID != 0x44) {
+			t.Errorf("wrong first message: %+v", msg)
+		}
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count++
+	}
+	w.Flush()
+
+	if count != 5 {
+		t.Errorf("expected 5 messages, got %v", count)
+	}
+	if expected := strings.ReplaceAll(log, "\n\n", "\n"); out.String() != expected {
+		t.Errorf("round trip mismatch:\n%v\nexpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestReaderInvalidLine(t *testing.T) {
+	r := candump.NewReader(strings.NewReader("(1.000000) can0 123#00\ngarbage\n"))
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.ReadMessage(); !errors.Is(err, candump.ErrInvalidLine) {
+		t.Errorf("expected invalid line error, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/asc"
+	"github.com/morgadow/gocan/logs/blf"
+	"github.com/morgadow/gocan/logs/candump"
+	"github.com/morgadow/gocan/logs/mf4"
+	"github.com/morgadow/gocan/logs/pcapng"
+	"github.com/morgadow/gocan/logs/trc"
+)
+
+// Returns the first line of a text log which is not empty
+func firstLine(head []byte) string {
+	for _, line := range strings.Split(string(head), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func sniffCandump(head []byte) bool {
+	line := firstLine(head)
+	return strings.HasPrefix(line, "(") && strings.Contains(line, "#")
+}
+
+func sniffASC(head []byte) bool {
+	line := strings.ToLower(firstLine(head))
+	return strings.HasPrefix(line, "date ") || strings.HasPrefix(line, "base ")
+}
+
+func sniffTRC(head []byte) bool {
+	return strings.HasPrefix(firstLine(head), ";")
+}
+
+func sniffPcapng(head []byte) bool {
+	return len(head) >= 4 && binary.LittleEndian.Uint32(head) == 0x0A0D0D0A
+}
+
+func sniffPcap(head []byte) bool {
+	if len(head) < 4 {
+		return false
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(head) {
+		case 0xA1B2C3D4, 0xA1B23C4D:
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	Register(Format{
+		Name:       "candump",
+		Extensions: []string{".log", ".candump"},
+		Sniff:      sniffCandump,
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return candump.NewReader(r), nil },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return candump.NewWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "asc",
+		Extensions: []string{".asc"},
+		Sniff:      sniffASC,
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return asc.NewReader(r), nil },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return asc.NewWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "blf",
+		Extensions: []string{".blf"},
+		Sniff:      func(head []byte) bool { return bytes.HasPrefix(head, []byte("LOGG")) },
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return blf.NewReader(r) },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return blf.NewWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "trc",
+		Extensions: []string{".trc"},
+		Sniff:      sniffTRC,
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return trc.NewReader(r), nil },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return trc.NewWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "pcapng",
+		Extensions: []string{".pcapng"},
+		Sniff:      sniffPcapng,
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return pcapng.NewReader(r) },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return pcapng.NewWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "pcap",
+		Extensions: []string{".pcap"},
+		Sniff:      sniffPcap,
+		NewReader:  func(r io.Reader) (gocan.MessageReader, error) { return pcapng.NewReader(r) },
+		NewWriter:  func(w io.Writer) (LogWriter, error) { return pcapng.NewPcapWriter(w), nil },
+	})
+	Register(Format{
+		Name:       "mf4",
+		Extensions: []string{".mf4", ".mdf"},
+		Sniff:      func(head []byte) bool { return bytes.HasPrefix(head, []byte("MDF ")) },
+		Seekable:   true,
+		NewReader: func(r io.Reader) (gocan.MessageReader, error) {
+			ra, ok := r.(io.ReaderAt)
+			if !ok {
+				// compressed or streamed files are read into memory for random access
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return nil, err
+				}
+				ra = bytes.NewReader(data)
+			}
+			return mf4.NewReader(ra)
+		},
+		NewWriter: func(w io.Writer) (LogWriter, error) {
+			ws, ok := w.(io.WriteSeeker)
+			if !ok {
+				return nil, ErrNotSeekable
+			}
+			return mf4.NewWriter(ws), nil
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package channels
+
+import "strconv"
+
+// Numbers assigns channel numbers to the channel names of messages for formats storing the channel as number
+// Numeric names are used directly, all other names and numbers already taken get the lowest unused number in order of appearance,
+// so two names never share a number; the zero value is ready to use
+type Numbers struct {
+	names map[string]int
+	used  map[int]bool // numbers assigned to any name
+}
+
+// Returns the channel number of a name, the same name always gets the same number
+func (c *Numbers) Get(name string) int {
+	if n, ok := c.names[name]; ok {
+		return n
+	}
+	if c.names == nil {
+		c.names = make(map[string]int)
+		c.used = make(map[int]bool)
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n <= 0 || c.used[n] {
+		for n = 1; c.used[n]; n++ {
+		}
+	}
+	c.names[name] = n
+	c.used[n] = true
+	return n
+}
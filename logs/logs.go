@@ -0,0 +1,383 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/morgadow/gocan"
+)
+
+// Amount of bytes passed to the sniff functions of formats and compressions
+const SniffSize = 512
+
+// LogReader yields the messages of a log, ReadMessage returns io.EOF at the end of the log
+type LogReader interface {
+	gocan.MessageReader
+	io.Closer
+}
+
+// LogWriter accepts messages, Close finishes the log
+type LogWriter interface {
+	WriteMessage(msg *gocan.Message) error
+	io.Closer
+}
+
+// Commenter is implemented by log writers able to store text comments, e.g. markers of a recording
+type Commenter interface {
+	WriteComment(timeStamp uint64, text string) error // timeStamp in µs since unix epoch
+}
+
+// Format describes a log format of the registry
+type Format struct {
+	Name       string
+	Extensions []string          // file extensions including the dot, e.g. ".asc"
+	Sniff      func([]byte) bool // reports if the start of a log is in this format, nil if the format cannot be detected by its content
+	Seekable   bool              // the writer requires an io.WriteSeeker, e.g. to finalize a file header
+
+	NewReader func(r io.Reader) (gocan.MessageReader, error)
+	NewWriter func(w io.Writer) (LogWriter, error) // Close() of the returned writer must not close w
+}
+
+// Compression describes a compression applied on top of any log format
+// A compression without NewReader and NewWriter is known but not available, e.g. zstd which is not part of the standard library,
+// it can be enabled by registering it again with an implementation, e.g. by importing github.com/morgadow/gocan/logs/zstd
+type Compression struct {
+	Name      string
+	Extension string // file extension including the dot, e.g. ".gz"
+	Magic     []byte // bytes at the start of a compressed stream
+
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// errors
+var (
+	ErrUnknownFormat          = errors.New("unknown log format")
+	ErrCompressionUnavailable = errors.New("compression not available, register an implementation with RegisterCompression")
+	ErrNotSeekable            = errors.New("log format requires a seekable writer")
+)
+
+var (
+	mu           sync.RWMutex
+	formats      []Format
+	compressions []Compression
+)
+
+// Adds a format to the registry, a format with the same name is replaced
+func Register(format Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range formats {
+		if formats[i].Name == format.Name {
+			formats[i] = format
+			return
+		}
+	}
+	formats = append(formats, format)
+}
+
+// Adds a compression to the registry, a compression with the same name is replaced
+func RegisterCompression(compression Compression) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range compressions {
+		if compressions[i].Name == compression.Name {
+			compressions[i] = compression
+			return
+		}
+	}
+	compressions = append(compressions, compression)
+}
+
+// Removes the compression with the given name from the registry, e.g. a compression registered by a test
+func UnregisterCompression(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	compressions = slices.DeleteFunc(compressions, func(c Compression) bool { return c.Name == name })
+}
+
+// Returns all registered formats
+func Formats() []Format {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Format{}, formats...)
+}
+
+// Returns all registered compressions
+func Compressions() []Compression {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Compression{}, compressions...)
+}
+
+// Returns the format with the given name or file extension, e.g. "asc" or ".asc"
+func FormatByName(name string) (Format, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	name = strings.ToLower(name)
+	for _, format := range formats {
+		if format.Name == name {
+			return format, true
+		}
+		for _, ext := range format.Extensions {
+			if ext == name {
+				return format, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// Returns the compression of a file name and the file name without the compression extension
+func compressionByName(name string) (*Compression, string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	ext := strings.ToLower(filepath.Ext(name))
+	for i := range compressions {
+		if compressions[i].Extension == ext {
+			return &compressions[i], strings.TrimSuffix(name, filepath.Ext(name))
+		}
+	}
+	return nil, name
+}
+
+// Returns the compression whose magic matches the start of the data
+func compressionByMagic(head []byte) *Compression {
+	mu.RLock()
+	defer mu.RUnlock()
+	for i := range compressions {
+		if len(compressions[i].Magic) > 0 && bytes.HasPrefix(head, compressions[i].Magic) {
+			return &compressions[i]
+		}
+	}
+	return nil
+}
+
+// Returns the format of a log by the extension of its name or, if the extension is unknown, by its content
+func detectFormat(name string, head []byte) (Format, error) {
+	if ext := filepath.Ext(name); ext != "" {
+		if format, ok := FormatByName(ext); ok {
+			return format, nil
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, format := range formats {
+		if format.Sniff != nil && format.Sniff(head) {
+			return format, nil
+		}
+	}
+	return Format{}, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// Returns the first bytes of r without consuming them and a reader which still starts at the beginning
+func peek(r io.Reader) ([]byte, io.Reader, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		head := make([]byte, SniffSize)
+		n, err := io.ReadFull(rs, head)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil, err
+		}
+		_, err = rs.Seek(int64(-n), io.SeekCurrent)
+		return head[:n], rs, err
+	}
+	br := bufio.NewReaderSize(r, SniffSize)
+	head, err := br.Peek(SniffSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	return head, br, nil
+}
+
+// Reader returned by the registry, closes all layers below the format reader
+type reader struct {
+	gocan.MessageReader
+	closers []io.Closer
+}
+
+func (r *reader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if errClose := c.Close(); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+// Creates a reader for a log of any registered format, compressed logs are decompressed transparently
+// The format and compression are detected by the extensions of name and the content of the log, name may be empty
+// Closing the returned reader does not close r
+func NewReader(r io.Reader, name string) (LogReader, error) {
+	return newReader(r, name, nil)
+}
+
+func newReader(r io.Reader, name string, closers []io.Closer) (LogReader, error) {
+	head, r, err := peek(r)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, name := compressionByName(name)
+	if magic := compressionByMagic(head); magic != nil {
+		compression = magic
+	}
+	if compression != nil {
+		if compression.NewReader == nil {
+			return nil, fmt.Errorf("%w: %v", ErrCompressionUnavailable, compression.Name)
+		}
+		cr, err := compression.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		closers = append([]io.Closer{cr}, closers...)
+		if head, r, err = peek(cr); err != nil {
+			return nil, err
+		}
+	}
+
+	format, err := detectFormat(name, head)
+	if err != nil {
+		return nil, err
+	}
+	fr, err := format.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", format.Name, err)
+	}
+	return &reader{MessageReader: fr, closers: closers}, nil
+}
+
+// Opens a log file of any registered format, e.g. "drive.asc.gz" or "trace.trc"
+func Open(path string) (LogReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReader(f, path, []io.Closer{f})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Writer returned by the registry, finishes the format and closes all layers below
+type writer struct {
+	LogWriter
+	closers []io.Closer
+}
+
+func (w *writer) Close() error {
+	err := w.LogWriter.Close()
+	for _, c := range w.closers {
+		if errClose := c.Close(); err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+// Writes a comment if the format supports comments, otherwise gocan.ErrNotSupported is returned
+func (w *writer) WriteComment(timeStamp uint64, text string) error {
+	if c, ok := w.LogWriter.(Commenter); ok {
+		return c.WriteComment(timeStamp, text)
+	}
+	return gocan.ErrNotSupported
+}
+
+// Writer which flushes a bufio.Writer on Close
+type flushCloser struct {
+	*bufio.Writer
+}
+
+func (f flushCloser) Close() error {
+	return f.Flush()
+}
+
+// Creates a writer for a log in the format and compression given by the extensions of name, e.g. "drive.asc.gz"
+// Closing the returned writer does not close w
+func NewWriter(w io.Writer, name string) (LogWriter, error) {
+	return newWriter(w, name, nil)
+}
+
+func newWriter(w io.Writer, name string, closers []io.Closer) (LogWriter, error) {
+	compression, name := compressionByName(name)
+	format, ok := FormatByName(filepath.Ext(name))
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+	}
+	if compression != nil {
+		if compression.NewWriter == nil {
+			return nil, fmt.Errorf("%w: %v", ErrCompressionUnavailable, compression.Name)
+		}
+		if format.Seekable {
+			return nil, fmt.Errorf("%w: %v cannot be compressed", ErrNotSeekable, format.Name)
+		}
+		cw, err := compression.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		closers = append([]io.Closer{cw}, closers...)
+		w = cw
+	}
+
+	fw, err := format.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", format.Name, err)
+	}
+	return &writer{LogWriter: fw, closers: closers}, nil
+}
+
+// Creates a log file in the format and compression given by the extensions of path, e.g. "drive.asc.gz"
+func Create(path string) (LogWriter, error) {
+	_, name := compressionByName(path)
+	format, ok := FormatByName(filepath.Ext(name))
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// file writes are buffered, e.g. pcapng writes every block unbuffered for streaming
+	var w io.Writer = f
+	closers := []io.Closer{f}
+	if !format.Seekable {
+		bw := flushCloser{bufio.NewWriter(f)}
+		w = bw
+		closers = []io.Closer{bw, f}
+	}
+	lw, err := newWriter(w, path, closers)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return lw, nil
+}
+
+func init() {
+	RegisterCompression(Compression{
+		Name:      "gzip",
+		Extension: ".gz",
+		Magic:     []byte{0x1F, 0x8B},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	})
+	RegisterCompression(Compression{
+		Name:      "zstd",
+		Extension: ".zst",
+		Magic:     []byte{0x28, 0xB5, 0x2F, 0xFD},
+	})
+}
@@ -0,0 +1,805 @@
+package mf4
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/internal/channels"
+)
+
+// Names of the channel groups of the bus logging layout
+const (
+	GroupDataFrame   = "CAN_DataFrame"
+	GroupRemoteFrame = "CAN_RemoteFrame"
+	GroupErrorFrame  = "CAN_ErrorFrame"
+)
+
+// Size of the uncompressed records collected in a single data block
+var DataBlockSize = 4 * 1024 * 1024
+
+// Channel data types
+const (
+	dataTypeUint      = 0
+	dataTypeFloat     = 4
+	dataTypeByteArray = 10
+)
+
+// Channel and channel group types and flags
+const (
+	channelTypeValue  = 0
+	channelTypeMaster = 2
+	syncTypeTime      = 1
+	cgFlagVLSD        = 0x01
+	cgFlagBusEvent    = 0x02
+	cgFlagPlainBus    = 0x04
+	siTypeBus         = 2
+	siBusTypeCAN      = 2
+)
+
+// Record layout of all channel groups after the record id
+const (
+	offTimestamp  = 0  // float64 seconds since the start time
+	offBusChannel = 8  // uint8
+	offID         = 9  // 29 bit id, IDE in bit 31
+	offDLC        = 13 // uint8
+	offDataLength = 14 // uint8
+	offFlags      = 15 // Dir, EDL, BRS and ESI in bits 0 to 3
+	offDataBytes  = 16 // 64 data bytes
+	recordSize    = offDataBytes + gocan.MaxDataLength
+)
+
+const (
+	blockHeaderSize = 24
+	idBlockSize     = 64
+	hdDataSize      = 32
+)
+
+// errors
+var (
+	ErrInvalidFile   = errors.New("not an mf4 file")
+	ErrInvalidBlock  = errors.New("invalid mf4 block")
+	ErrInvalidLayout = errors.New("unsupported mf4 channel layout")
+)
+
+// Channel definition written into a CN block
+type channelDef struct {
+	name       string
+	typ        uint8
+	syncType   uint8
+	dataType   uint8
+	bitOffset  uint8
+	byteOffset uint32
+	bitCount   uint32
+	unit       string
+	children   []channelDef
+}
+
+// Returns the channels of a bus logging channel group
+func groupChannels(group string) []channelDef {
+	field := func(name string, byteOffset uint32, bitOffset uint8, bitCount uint32) channelDef {
+		return channelDef{name: group + "." + name, dataType: dataTypeUint, byteOffset: byteOffset, bitOffset: bitOffset, bitCount: bitCount}
+	}
+	children := []channelDef{
+		field("BusChannel", offBusChannel, 0, 8),
+		field("ID", offID, 0, 29),
+		field("IDE", offID+3, 7, 1),
+		field("DLC", offDLC, 0, 4),
+		field("DataLength", offDataLength, 0, 7),
+		field("Dir", offFlags, 0, 1),
+		field("EDL", offFlags, 1, 1),
+		field("BRS", offFlags, 2, 1),
+		field("ESI", offFlags, 3, 1),
+		{name: group + ".DataBytes", dataType: dataTypeByteArray, byteOffset: offDataBytes, bitCount: 8 * gocan.MaxDataLength},
+	}
+	return []channelDef{
+		{name: "Timestamp", typ: channelTypeMaster, syncType: syncTypeTime, dataType: dataTypeFloat, byteOffset: offTimestamp, bitCount: 64, unit: "s"},
+		{name: group, dataType: dataTypeByteArray, byteOffset: offBusChannel, bitCount: 8 * (recordSize - offBusChannel), children: children},
+	}
+}
+
+// Writer writes messages into an MDF 4.10 file with the ASAM bus logging layout for CAN
+// All messages are stored in a single data group with the channel groups CAN_DataFrame, CAN_RemoteFrame and CAN_ErrorFrame,
+// the bus channel of a message is stored in the BusChannel channel
+// The start time of the file is taken from the first message, whose timestamp is interpreted as µs since unix epoch
+type Writer struct {
+	Compression bool // deflate compressed data blocks
+
+	w        io.WriteSeeker
+	pos      uint64
+	started  bool
+	start    uint64 // start time in µs
+	buf      bytes.Buffer
+	blocks   []uint64 // addresses of the data blocks
+	offsets  []uint64 // offsets of the data blocks in the uncompressed data
+	size     uint64   // uncompressed size of all written data blocks
+	counts   [3]uint64
+	channels channels.Numbers
+}
+
+// Creates a writer for an MF4 file, Close() must be called after the last message to finalize the file
+func NewWriter(w io.WriteSeeker) *Writer {
+	return &Writer{w: w}
+}
+
+// Writes a block at the current position aligned to 8 bytes and returns its address
+func (w *Writer) writeBlock(id string, links []uint64, data []byte) (uint64, error) {
+	length := blockHeaderSize + 8*len(links) + len(data)
+	block := make([]byte, 0, length+7)
+	block = append(block, id...)
+	block = append(block, 0, 0, 0, 0)
+	block = binary.LittleEndian.AppendUint64(block, uint64(length))
+	block = binary.LittleEndian.AppendUint64(block, uint64(len(links)))
+	for _, link := range links {
+		block = binary.LittleEndian.AppendUint64(block, link)
+	}
+	block = append(block, data...)
+	block = append(block, make([]byte, (8-length%8)%8)...)
+
+	addr := w.pos
+	if _, err := w.w.Write(block); err != nil {
+		return 0, err
+	}
+	w.pos += uint64(len(block))
+	return addr, nil
+}
+
+// Writes a text block, returns 0 for an empty text
+func (w *Writer) writeText(id, text string) (uint64, error) {
+	if text == "" {
+		return 0, nil
+	}
+	return w.writeBlock(id, nil, append([]byte(text), 0))
+}
+
+// Returns the identification block and the header block with the given links
+func (w *Writer) header(links []uint64) []byte {
+	id := make([]byte, idBlockSize)
+	copy(id[0:], "MDF     ")
+	copy(id[8:], "4.10    ")
+	copy(id[16:], "gocan   ")
+	binary.LittleEndian.PutUint16(id[28:], 410)
+
+	data := binary.LittleEndian.AppendUint64(nil, w.start*1000)
+	data = append(data, make([]byte, hdDataSize-8)...)
+	hd := append([]byte("##HD"), 0, 0, 0, 0)
+	hd = binary.LittleEndian.AppendUint64(hd, uint64(blockHeaderSize+8*6+hdDataSize))
+	hd = binary.LittleEndian.AppendUint64(hd, 6)
+	for i := 0; i < 6; i++ {
+		var link uint64
+		if i < len(links) {
+			link = links[i]
+		}
+		hd = binary.LittleEndian.AppendUint64(hd, link)
+	}
+	return append(append(id, hd...), data...)
+}
+
+// Writes the identification and header block with empty links
+func (w *Writer) writeHeader(start uint64) error {
+	w.started = true
+	w.start = start
+	header := w.header(nil)
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	w.pos = uint64(len(header))
+	return nil
+}
+
+// Writes a single message, messages should be written in order of their timestamps
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	if !w.started {
+		if err := w.writeHeader(msg.TimeStamp); err != nil {
+			return err
+		}
+	}
+
+	var record [1 + recordSize]byte
+	switch msg.Type {
+	case gocan.RemoteFrame:
+		record[0] = 2
+	case gocan.ErrorFrame:
+		record[0] = 3
+	default:
+		record[0] = 1
+	}
+	w.counts[record[0]-1]++
+
+	rec := record[1:]
+	offset := int64(msg.TimeStamp) - int64(w.start)
+	binary.LittleEndian.PutUint64(rec[offTimestamp:], math.Float64bits(float64(offset)/1e6))
+	rec[offBusChannel] = uint8(w.channels.Get(msg.Channel))
+	id := uint32(msg.ID) & 0x1FFFFFFF
+	if msg.IsExtended {
+		id |= 0x80000000
+	}
+	binary.LittleEndian.PutUint32(rec[offID:], id)
+
+	length := copy(rec[offDataBytes:], msg.Data)
+	dlc := gocan.DLCFromLength(length)
+	if msg.Type == gocan.RemoteFrame {
+		length = 0
+		dlc = msg.DLC
+	}
+	rec[offDLC] = dlc
+	rec[offDataLength] = uint8(length)
+
+	var flags uint8
+	if msg.Direction == gocan.Tx {
+		flags |= 0x01
+	}
+	if msg.IsFD {
+		flags |= 0x02
+		switch msg.Type {
+		case gocan.FDBitRateSwitchFrame:
+			flags |= 0x04
+		case gocan.FDErrorStateIndicator:
+			flags |= 0x08
+		}
+	}
+	rec[offFlags] = flags
+
+	w.buf.Write(record[:])
+	if w.buf.Len() >= DataBlockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// Writes the buffered records as data block
+func (w *Writer) flushBlock() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	var addr uint64
+	var err error
+	if w.Compression {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(w.buf.Bytes())
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data := append([]byte("DT"), 0, 0, 0, 0, 0, 0) // original block type, deflate, parameter
+		data = binary.LittleEndian.AppendUint64(data, uint64(w.buf.Len()))
+		data = binary.LittleEndian.AppendUint64(data, uint64(compressed.Len()))
+		addr, err = w.writeBlock("##DZ", nil, append(data, compressed.Bytes()...))
+	} else {
+		addr, err = w.writeBlock("##DT", nil, w.buf.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+
+	w.blocks = append(w.blocks, addr)
+	w.offsets = append(w.offsets, w.size)
+	w.size += uint64(w.buf.Len())
+	w.buf.Reset()
+	return nil
+}
+
+// Writes the channel blocks of a list and returns the address of the first one
+func (w *Writer) writeChannels(defs []channelDef) (uint64, error) {
+	var next uint64
+	for i := len(defs) - 1; i >= 0; i-- {
+		def := defs[i]
+		composition, err := w.writeChannels(def.children)
+		if err != nil {
+			return 0, err
+		}
+		name, err := w.writeText("##TX", def.name)
+		if err != nil {
+			return 0, err
+		}
+		unit, err := w.writeText("##TX", def.unit)
+		if err != nil {
+			return 0, err
+		}
+
+		data := []byte{def.typ, def.syncType, def.dataType, def.bitOffset}
+		data = binary.LittleEndian.AppendUint32(data, def.byteOffset)
+		data = binary.LittleEndian.AppendUint32(data, def.bitCount)
+		data = append(data, make([]byte, 12+6*8)...) // flags, invalidation bit, precision, attachments and ranges
+		next, err = w.writeBlock("##CN", []uint64{next, composition, name, 0, 0, 0, unit, 0}, data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// Writes all metadata blocks and links them into the header block, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if !w.started {
+		if err := w.writeHeader(0); err != nil {
+			return err
+		}
+	}
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	// data block list
+	var data uint64
+	var err error
+	switch {
+	case len(w.blocks) == 1:
+		data = w.blocks[0]
+	case len(w.blocks) > 1:
+		list := []byte{0, 0, 0, 0}
+		list = binary.LittleEndian.AppendUint32(list, uint32(len(w.blocks)))
+		for _, offset := range w.offsets {
+			list = binary.LittleEndian.AppendUint64(list, offset)
+		}
+		data, err = w.writeBlock("##DL", append([]uint64{0}, w.blocks...), list)
+		if err != nil {
+			return err
+		}
+		if w.Compression {
+			data, err = w.writeBlock("##HL", []uint64{data}, make([]byte, 8)) // deflate
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// channel groups with their source
+	siName, err := w.writeText("##TX", "CAN")
+	if err != nil {
+		return err
+	}
+	si, err := w.writeBlock("##SI", []uint64{siName, 0, 0}, []byte{siTypeBus, siBusTypeCAN, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return err
+	}
+	var cg uint64
+	groups := []string{GroupDataFrame, GroupRemoteFrame, GroupErrorFrame}
+	for i := len(groups) - 1; i >= 0; i-- {
+		first, err := w.writeChannels(groupChannels(groups[i]))
+		if err != nil {
+			return err
+		}
+		name, err := w.writeText("##TX", groups[i])
+		if err != nil {
+			return err
+		}
+		cgData := binary.LittleEndian.AppendUint64(nil, uint64(i+1))
+		cgData = binary.LittleEndian.AppendUint64(cgData, w.counts[i])
+		cgData = binary.LittleEndian.AppendUint16(cgData, cgFlagBusEvent|cgFlagPlainBus)
+		cgData = binary.LittleEndian.AppendUint16(cgData, '.')
+		cgData = append(cgData, 0, 0, 0, 0)
+		cgData = binary.LittleEndian.AppendUint32(cgData, recordSize)
+		cgData = binary.LittleEndian.AppendUint32(cgData, 0)
+		cg, err = w.writeBlock("##CG", []uint64{cg, first, name, si, 0, 0}, cgData)
+		if err != nil {
+			return err
+		}
+	}
+	dg, err := w.writeBlock("##DG", []uint64{0, cg, data, 0}, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return err
+	}
+
+	// file history
+	comment, err := w.writeText("##MD", `<FHcomment xmlns="http://www.asam.net/mdf/v4"><TX>CAN bus logging</TX>`+
+		`<tool_id>gocan</tool_id><tool_vendor>gocan</tool_vendor><tool_version>1.3.0</tool_version></FHcomment>`)
+	if err != nil {
+		return err
+	}
+	fhData := binary.LittleEndian.AppendUint64(nil, w.start*1000)
+	fh, err := w.writeBlock("##FH", []uint64{0, comment}, append(fhData, make([]byte, 8)...))
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.header([]uint64{dg, fh})); err != nil {
+		return err
+	}
+	_, err = w.w.Seek(int64(w.pos), io.SeekStart)
+	return err
+}
+
+// Block read from a file
+type block struct {
+	id    string
+	links []uint64
+	data  []byte
+}
+
+// Channel of a channel group read from a file
+type field struct {
+	byteOffset uint32
+	bitOffset  uint8
+	bitCount   uint32
+	dataType   uint8
+}
+
+// Returns the unsigned value of a field, values above 64 bit are not supported
+func (f *field) uint(record []byte) uint64 {
+	var raw [8]byte
+	n := min((int(f.bitOffset)+int(f.bitCount)+7)/8, 8)
+	if int(f.byteOffset)+n > len(record) {
+		return 0
+	}
+	copy(raw[:], record[f.byteOffset:int(f.byteOffset)+n])
+	value := binary.LittleEndian.Uint64(raw[:]) >> f.bitOffset
+	if f.bitCount < 64 {
+		value &= 1<<f.bitCount - 1
+	}
+	return value
+}
+
+// Returns the value of a float or unsigned field
+func (f *field) float(record []byte) float64 {
+	switch {
+	case f.dataType == dataTypeFloat && f.bitCount == 64:
+		return math.Float64frombits(f.uint(record))
+	case f.dataType == dataTypeFloat && f.bitCount == 32:
+		return float64(math.Float32frombits(uint32(f.uint(record))))
+	default:
+		return float64(f.uint(record))
+	}
+}
+
+// Returns the bytes of a byte array field
+func (f *field) bytes(record []byte) []byte {
+	end := min(int(f.byteOffset+f.bitCount/8), len(record))
+	return record[min(int(f.byteOffset), end):end]
+}
+
+// Channel group read from a file
+type group struct {
+	msgType gocan.MessageType
+	vlsd    bool
+	size    int // record size including the record id
+	fields  map[string]*field
+}
+
+// Reader reads messages from an MF4 file with the bus logging layout written by the Writer
+// Data blocks are read and decompressed one at a time
+// The timestamp of every message is the start time of the file in µs since unix epoch plus the offset of the message
+type Reader struct {
+	r         io.ReaderAt
+	start     uint64
+	dataGroup []uint64 // addresses of the remaining data groups
+	idSize    int
+	groups    map[uint64]*group
+	blocks    []uint64 // addresses of the remaining data blocks of the current data group
+	data      []byte   // records not parsed yet
+}
+
+// Creates a reader and reads the header of the file
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	id := make([]byte, idBlockSize)
+	if _, err := r.ReadAt(id, 0); err != nil || string(id[:4]) != "MDF " {
+		return nil, ErrInvalidFile
+	}
+	if version := binary.LittleEndian.Uint16(id[28:]); version < 400 {
+		return nil, fmt.Errorf("%w: version %v", ErrInvalidFile, version)
+	}
+
+	reader := &Reader{r: r}
+	hd, err := reader.readBlock(idBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	if hd.id != "##HD" || len(hd.links) < 1 || len(hd.data) < 8 {
+		return nil, ErrInvalidFile
+	}
+	reader.start = binary.LittleEndian.Uint64(hd.data) / 1000
+
+	for dg := hd.links[0]; dg != 0; {
+		reader.dataGroup = append(reader.dataGroup, dg)
+		b, err := reader.readBlock(dg)
+		if err != nil {
+			return nil, err
+		}
+		if b.id != "##DG" || len(b.links) < 3 {
+			return nil, ErrInvalidBlock
+		}
+		dg = b.links[0]
+	}
+	return reader, nil
+}
+
+// Reads a block at the given address
+func (r *Reader) readBlock(addr uint64) (*block, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := r.r.ReadAt(header, int64(addr)); err != nil {
+		return nil, fmt.Errorf("%w at 0x%X: %v", ErrInvalidBlock, addr, err)
+	}
+	length := binary.LittleEndian.Uint64(header[8:])
+	count := binary.LittleEndian.Uint64(header[16:])
+	if length < blockHeaderSize+8*count || length > 1<<32 {
+		return nil, fmt.Errorf("%w at 0x%X", ErrInvalidBlock, addr)
+	}
+	body := make([]byte, length-blockHeaderSize)
+	if _, err := r.r.ReadAt(body, int64(addr)+blockHeaderSize); err != nil {
+		return nil, fmt.Errorf("%w at 0x%X: %v", ErrInvalidBlock, addr, err)
+	}
+
+	b := &block{id: string(header[:4]), links: make([]uint64, count), data: body[8*count:]}
+	for i := range b.links {
+		b.links[i] = binary.LittleEndian.Uint64(body[8*i:])
+	}
+	return b, nil
+}
+
+// Reads the string of a text block
+func (r *Reader) readText(addr uint64) (string, error) {
+	if addr == 0 {
+		return "", nil
+	}
+	b, err := r.readBlock(addr)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(b.data, "\x00")), nil
+}
+
+// Reads all channels of a list including composed channels into fields
+func (r *Reader) readChannels(addr uint64, fields map[string]*field) error {
+	for addr != 0 {
+		b, err := r.readBlock(addr)
+		if err != nil {
+			return err
+		}
+		if b.id != "##CN" || len(b.links) < 8 || len(b.data) < 12 {
+			return ErrInvalidBlock
+		}
+		name, err := r.readText(b.links[2])
+		if err != nil {
+			return err
+		}
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		if b.data[0] == channelTypeMaster {
+			name = "Timestamp"
+		}
+		fields[name] = &field{
+			dataType:   b.data[2],
+			bitOffset:  b.data[3],
+			byteOffset: binary.LittleEndian.Uint32(b.data[4:]),
+			bitCount:   binary.LittleEndian.Uint32(b.data[8:]),
+		}
+		if err := r.readChannels(b.links[1], fields); err != nil {
+			return err
+		}
+		addr = b.links[0]
+	}
+	return nil
+}
+
+// Reads the channel groups of the next data group and collects its data blocks
+func (r *Reader) nextDataGroup() error {
+	dg, err := r.readBlock(r.dataGroup[0])
+	if err != nil {
+		return err
+	}
+	r.dataGroup = r.dataGroup[1:]
+	if len(dg.data) < 1 {
+		return ErrInvalidBlock
+	}
+	r.idSize = int(dg.data[0])
+	r.groups = make(map[uint64]*group)
+
+	for addr := dg.links[1]; addr != 0; {
+		cg, err := r.readBlock(addr)
+		if err != nil {
+			return err
+		}
+		if cg.id != "##CG" || len(cg.links) < 6 || len(cg.data) < 32 {
+			return ErrInvalidBlock
+		}
+		recordID := binary.LittleEndian.Uint64(cg.data[0:])
+		flags := binary.LittleEndian.Uint16(cg.data[16:])
+		g := &group{
+			vlsd:   flags&cgFlagVLSD != 0,
+			size:   r.idSize + int(binary.LittleEndian.Uint32(cg.data[24:])) + int(binary.LittleEndian.Uint32(cg.data[28:])),
+			fields: make(map[string]*field),
+		}
+		name, err := r.readText(cg.links[2])
+		if err != nil {
+			return err
+		}
+		switch name {
+		case GroupRemoteFrame:
+			g.msgType = gocan.RemoteFrame
+		case GroupErrorFrame:
+			g.msgType = gocan.ErrorFrame
+		}
+		if err := r.readChannels(cg.links[1], g.fields); err != nil {
+			return err
+		}
+		r.groups[recordID] = g
+		addr = cg.links[0]
+	}
+
+	r.blocks = nil
+	return r.collectBlocks(dg.links[2])
+}
+
+// Collects the addresses of all data blocks below a data block, list or header list
+func (r *Reader) collectBlocks(addr uint64) error {
+	for addr != 0 {
+		b, err := r.readBlock(addr)
+		if err != nil {
+			return err
+		}
+		switch b.id {
+		case "##DT", "##DZ":
+			r.blocks = append(r.blocks, addr)
+			return nil
+		case "##HL":
+			if len(b.links) < 1 {
+				return ErrInvalidBlock
+			}
+			addr = b.links[0]
+		case "##DL":
+			if len(b.links) < 1 {
+				return ErrInvalidBlock
+			}
+			r.blocks = append(r.blocks, b.links[1:]...)
+			addr = b.links[0]
+		default:
+			return fmt.Errorf("%w: unexpected %v data block", ErrInvalidBlock, b.id)
+		}
+	}
+	return nil
+}
+
+// Reads and decompresses the next data block and appends its records
+func (r *Reader) nextDataBlock() error {
+	b, err := r.readBlock(r.blocks[0])
+	if err != nil {
+		return err
+	}
+	r.blocks = r.blocks[1:]
+
+	if b.id == "##DT" {
+		r.data = append(r.data, b.data...)
+		return nil
+	}
+	if len(b.data) < 24 || string(b.data[:2]) != "DT" || b.data[2] != 0 {
+		return fmt.Errorf("%w: unsupported compression", ErrInvalidBlock)
+	}
+	size := binary.LittleEndian.Uint64(b.data[8:])
+	zr, err := zlib.NewReader(bytes.NewReader(b.data[24:]))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, int64(size)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	r.data = append(r.data, data...)
+	return nil
+}
+
+// Returns the next message of the file or io.EOF at the end of the file
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for {
+		if len(r.data) >= r.idSize && r.idSize > 0 {
+			msg, ok, err := r.parseRecord()
+			if err != nil {
+				return nil, err
+			}
+			if msg != nil {
+				return msg, nil
+			}
+			if ok {
+				continue
+			}
+		}
+
+		// more data needed
+		switch {
+		case len(r.blocks) > 0:
+			if err := r.nextDataBlock(); err != nil {
+				return nil, err
+			}
+		case len(r.data) > 0:
+			return nil, fmt.Errorf("%w: truncated record", ErrInvalidBlock)
+		case len(r.dataGroup) > 0:
+			if err := r.nextDataGroup(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+// Parses the next record, returns false if the record is incomplete and nil for records which are not messages
+func (r *Reader) parseRecord() (*gocan.Message, bool, error) {
+	var raw [8]byte
+	copy(raw[:], r.data[:r.idSize])
+	g, ok := r.groups[binary.LittleEndian.Uint64(raw[:])]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: unknown record id %v", ErrInvalidBlock, binary.LittleEndian.Uint64(raw[:]))
+	}
+
+	size := g.size
+	if g.vlsd {
+		if len(r.data) < r.idSize+4 {
+			return nil, false, nil
+		}
+		size = r.idSize + 4 + int(binary.LittleEndian.Uint32(r.data[r.idSize:]))
+	}
+	if len(r.data) < size {
+		return nil, false, nil
+	}
+	record := r.data[:size]
+	r.data = r.data[size:]
+	if g.vlsd {
+		return nil, true, nil
+	}
+
+	msg, err := g.parse(record[r.idSize:], r.start)
+	return msg, true, err
+}
+
+// Converts a record without record id into a message
+func (g *group) parse(record []byte, start uint64) (*gocan.Message, error) {
+	get := func(name string) uint64 {
+		if f, ok := g.fields[name]; ok {
+			return f.uint(record)
+		}
+		return 0
+	}
+	ts, okTS := g.fields["Timestamp"]
+	id, okID := g.fields["ID"]
+	if !okTS || !okID {
+		return nil, ErrInvalidLayout
+	}
+
+	msg := &gocan.Message{
+		Type:       g.msgType,
+		TimeStamp:  uint64(int64(start) + int64(math.Round(ts.float(record)*1e6))),
+		ID:         gocan.MessageID(id.uint(record)),
+		IsExtended: get("IDE") != 0,
+		DLC:        uint8(get("DLC")),
+		Channel:    strconv.FormatUint(get("BusChannel"), 10),
+		IsFD:       get("EDL") != 0,
+		Data:       []byte{},
+	}
+	if get("Dir") != 0 {
+		msg.Direction = gocan.Tx
+	}
+	switch {
+	case g.msgType != gocan.DataFrame:
+	case get("ESI") != 0:
+		msg.Type = gocan.FDErrorStateIndicator
+	case get("BRS") != 0:
+		msg.Type = gocan.FDBitRateSwitchFrame
+	}
+	if msg.Type == gocan.RemoteFrame {
+		return msg, nil
+	}
+
+	length := int(get("DataLength"))
+	if _, ok := g.fields["DataLength"]; !ok {
+		length = gocan.LengthFromDLC(msg.DLC, msg.IsFD)
+	}
+	if f, ok := g.fields["DataBytes"]; ok {
+		data := f.bytes(record)
+		if length > len(data) {
+			return nil, fmt.Errorf("%w: data length %v exceeds DataBytes", ErrInvalidLayout, length)
+		}
+		msg.Data = append(msg.Data, data[:length]...)
+	}
+	return msg, nil
+}
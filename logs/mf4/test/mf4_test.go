@@ -0,0 +1,172 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/mf4"
+)
+
+func auxReadAll(t *testing.T, r *mf4.Reader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxEqual(a, b *gocan.Message) bool {
+	return a.ID == b.ID && a.TimeStamp == b.TimeStamp && a.Type == b.Type && a.Channel == b.Channel && a.IsExtended == b.IsExtended &&
+		a.IsFD == b.IsFD && a.Direction == b.Direction && bytes.Equal(a.Data, b.Data)
+}
+
+// Writes all messages into a new file and returns its content
+func auxWrite(t *testing.T, msgs []gocan.Message, compression bool) []byte {
+	path := filepath.Join(t.TempDir(), "test.mf4")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	w := mf4.NewWriter(f)
+	w.Compression = compression
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC).UnixMicro())
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: start + 1234, Channel: "1", Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x1FFFFFFF, TimeStamp: start + 2000, Channel: "2", Data: []byte{}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x200, TimeStamp: start + 3000, Channel: "1", Type: gocan.RemoteFrame, DLC: 8, Data: []byte{}},
+		{TimeStamp: start + 4000, Channel: "2", Type: gocan.ErrorFrame, Data: []byte{}},
+		{ID: 0x300, TimeStamp: start + 5000, Channel: "1", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 64)},
+		{ID: 0x301, TimeStamp: start + 86400000001, Channel: "2", IsFD: true, Type: gocan.FDErrorStateIndicator, Data: []byte{1, 2, 3}, Direction: gocan.Tx},
+	}
+
+	for _, compression := range []bool{false, true} {
+		data := auxWrite(t, msgs, compression)
+		if string(data[:8]) != "MDF     " || string(data[8:12]) != "4.10" || string(data[64:68]) != "##HD" {
+			t.Fatalf("wrong identification block: %q", data[:16])
+		}
+		if bytes.Contains(data, []byte("##DZ")) != compression {
+			t.Errorf("compression %v: wrong data block type", compression)
+		}
+		for _, name := range []string{"CAN_DataFrame.DataBytes", "CAN_RemoteFrame.DLC", "CAN_ErrorFrame.BusChannel"} {
+			if !bytes.Contains(data, []byte(name)) {
+				t.Errorf("missing channel %v", name)
+			}
+		}
+
+		r, err := mf4.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		read := auxReadAll(t, r)
+		if len(read) != len(msgs) {
+			t.Fatalf("compression %v: expected %v messages, got %v", compression, len(msgs), len(read))
+		}
+		for i := range msgs {
+			if !auxEqual(&read[i], &msgs[i]) {
+				t.Errorf("compression %v, message %v: got %+v, expected %+v", compression, i, read[i], msgs[i])
+			}
+		}
+		if read[2].DLC != 8 || read[4].DLC != 15 {
+			t.Errorf("wrong DLC: %v, %v", read[2].DLC, read[4].DLC)
+		}
+	}
+}
+
+func TestMultipleDataBlocks(t *testing.T) {
+	defer func(size int) { mf4.DataBlockSize = size }(mf4.DataBlockSize)
+	mf4.DataBlockSize = 1000
+
+	const count = 500
+	msgs := make([]gocan.Message, count)
+	for i := range msgs {
+		msgs[i] = gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(1700000000000000 + i*1000), Channel: "vcan0", Data: []byte{byte(i), byte(i >> 8)}}
+	}
+
+	for _, compression := range []bool{false, true} {
+		data := auxWrite(t, msgs, compression)
+		if !bytes.Contains(data, []byte("##DL")) || bytes.Contains(data, []byte("##HL")) != compression {
+			t.Errorf("compression %v: expected data list", compression)
+		}
+
+		r, err := mf4.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		read := auxReadAll(t, r)
+		if len(read) != count {
+			t.Fatalf("compression %v: expected %v messages, got %v", compression, count, len(read))
+		}
+		for i, msg := range read {
+			if msg.ID != gocan.MessageID(i) || msg.TimeStamp != msgs[i].TimeStamp || msg.Channel != "1" || !bytes.Equal(msg.Data, msgs[i].Data) {
+				t.Fatalf("message %v: wrong content %+v", i, msg)
+			}
+		}
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	r, err := mf4.NewReader(bytes.NewReader(auxWrite(t, nil, false)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	if _, err := mf4.NewReader(bytes.NewReader([]byte("not an mdf file"))); err == nil {
+		t.Errorf("expected error for invalid file")
+	}
+}
+
+func TestWriteChannelNames(t *testing.T) {
+	names := []string{"3", "can0", "1", "can1", "can0", "3"}
+	msgs := make([]gocan.Message, len(names))
+	for i, name := range names {
+		msgs[i] = gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(1700000000000000 + i*1000), Channel: name, Data: []byte{}}
+	}
+
+	// numeric names keep their number unless it is taken, named channels never share a number with another name
+	expected := []string{"3", "1", "2", "4", "1", "3"}
+	r, err := mf4.NewReader(bytes.NewReader(auxWrite(t, msgs, false)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	if len(read) != len(expected) {
+		t.Fatalf("expected %v messages, got %v", len(expected), len(read))
+	}
+	for i := range read {
+		if read[i].Channel != expected[i] {
+			t.Errorf("%v: got channel %v, expected %v", names[i], read[i].Channel, expected[i])
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package pcapng
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/morgadow/gocan"
+)
+
+// Link type of SocketCAN frames
+const LinkTypeCANSocketCAN = 227
+
+// Flags of the SocketCAN id and CAN FD frames
+const (
+	FlagEFF = 0x80000000 // extended frame format
+	FlagRTR = 0x40000000 // remote transmission request
+	FlagERR = 0x20000000 // error frame
+	MaskEFF = 0x1FFFFFFF // extended id
+	MaskSFF = 0x000007FF // standard id
+
+	FlagBRS = 0x01 // CAN FD bit rate switch
+	FlagESI = 0x02 // CAN FD error state indicator
+	FlagFDF = 0x04 // CAN FD frame
+)
+
+// Block types
+const (
+	blockSHB = 0x0A0D0D0A // section header block
+	blockIDB = 0x00000001 // interface description block
+	blockSPB = 0x00000003 // simple packet block
+	blockEPB = 0x00000006 // enhanced packet block
+)
+
+// Option codes
+const (
+	optEndOfOpt = 0
+	optIfName   = 2 // interface description block: interface name
+	optTsResol  = 9 // interface description block: timestamp resolution
+	optEPBFlags = 2 // enhanced packet block: flags with direction in bits 0-1
+)
+
+const (
+	byteOrderMagic  = 0x1A2B3C4D
+	pcapMagicMicros = 0xA1B2C3D4 // classic pcap file with µs timestamps
+	pcapMagicNanos  = 0xA1B23C4D // classic pcap file with ns timestamps
+	canFrameSize    = 16         // SocketCAN frame with 8 data bytes
+	canFDFrameSize  = 72         // SocketCAN frame with 64 data bytes
+	maxBlockSize    = 16 * 1024 * 1024
+)
+
+// Channel written for messages without a channel
+var DefaultChannel = "can0"
+
+// errors
+var (
+	ErrInvalidFile  = errors.New("not a pcap or pcapng file")
+	ErrInvalidBlock = errors.New("invalid pcapng block")
+	ErrInvalidFrame = errors.New("invalid SocketCAN frame")
+)
+
+// Parses a SocketCAN frame as captured with LINKTYPE_CAN_SOCKETCAN, the id is in network byte order
+func ParseFrame(data []byte) (*gocan.Message, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidFrame
+	}
+	id := binary.BigEndian.Uint32(data[0:])
+	length := int(data[4])
+	flags := data[5]
+	payload := data[8:]
+
+	msg := &gocan.Message{Type: gocan.DataFrame}
+	switch {
+	case id&FlagERR != 0:
+		msg.Type = gocan.ErrorFrame
+		msg.ID = gocan.MessageID(id & MaskEFF)
+	case id&FlagEFF != 0:
+		msg.IsExtended = true
+		msg.ID = gocan.MessageID(id & MaskEFF)
+	default:
+		msg.ID = gocan.MessageID(id & MaskSFF)
+	}
+
+	msg.IsFD = flags&FlagFDF != 0 || len(data) == canFDFrameSize
+	if msg.IsFD && msg.Type != gocan.ErrorFrame {
+		if flags&FlagESI != 0 {
+			msg.Type = gocan.FDErrorStateIndicator
+		} else if flags&FlagBRS != 0 {
+			msg.Type = gocan.FDBitRateSwitchFrame
+		}
+	}
+
+	if id&FlagRTR != 0 && !msg.IsFD && msg.Type != gocan.ErrorFrame {
+		msg.Type = gocan.RemoteFrame
+		msg.DLC = uint8(min(length, 8))
+		msg.Data = []byte{}
+		return msg, nil
+	}
+
+	if length > len(payload) || length > gocan.MaxDataLength || (!msg.IsFD && length > 8) {
+		return nil, ErrInvalidFrame
+	}
+	msg.Data = append([]byte{}, payload[:length]...)
+	msg.DLC = gocan.DLCFromLength(length)
+	return msg, nil
+}
+
+// Formats a message as SocketCAN frame, classic frames have 16 bytes and FD frames 72 bytes
+func FormatFrame(msg *gocan.Message) []byte {
+	size := canFrameSize
+	if msg.IsFD {
+		size = canFDFrameSize
+	}
+	data := make([]byte, size)
+
+	var id uint32
+	switch {
+	case msg.Type == gocan.ErrorFrame:
+		id = uint32(msg.ID&MaskEFF) | FlagERR
+	case msg.IsExtended:
+		id = uint32(msg.ID&MaskEFF) | FlagEFF
+	default:
+		id = uint32(msg.ID & MaskSFF)
+	}
+
+	length := copy(data[8:], msg.Data)
+	switch {
+	case msg.Type == gocan.ErrorFrame && !msg.IsFD:
+		length = 8 // error frames always have 8 data bytes
+	case msg.Type == gocan.RemoteFrame && !msg.IsFD:
+		id |= FlagRTR
+		length = int(msg.DLC)
+	}
+	if msg.IsFD {
+		data[5] = FlagFDF
+		switch msg.Type {
+		case gocan.FDBitRateSwitchFrame:
+			data[5] |= FlagBRS
+		case gocan.FDErrorStateIndicator:
+			data[5] |= FlagESI
+		}
+	}
+	binary.BigEndian.PutUint32(data[0:], id)
+	data[4] = uint8(length)
+	return data
+}
+
+// Interface of a pcapng section
+type iface struct {
+	name     string
+	linkType uint16
+	tsResol  uint8
+}
+
+// Converts a timestamp in units of the interface resolution into µs
+func (i *iface) micros(ts uint64) uint64 {
+	if i.tsResol&0x80 != 0 {
+		// negative power of 2
+		shift := i.tsResol & 0x7F
+		if shift == 0 {
+			return ts * 1000000
+		}
+		return (ts>>shift)*1000000 + ((ts&(1<<shift-1))*1000000)>>shift
+	}
+	res := int(i.tsResol)
+	for ; res > 6; res-- {
+		ts /= 10
+	}
+	for ; res < 6; res++ {
+		ts *= 10
+	}
+	return ts
+}
+
+// Reader reads SocketCAN frames from a pcapng or classic pcap file
+// Packets of other link types and other blocks are skipped
+// The channel of a message is the name of its interface, or the interface index if the interface has no name
+type Reader struct {
+	r      io.Reader
+	order  binary.ByteOrder
+	ifaces []iface
+	pcap   bool // classic pcap file with a single interface
+}
+
+// Creates a reader and reads the file header
+func NewReader(r io.Reader) (*Reader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	reader := &Reader{r: r}
+	if binary.LittleEndian.Uint32(magic[:]) == blockSHB {
+		if err := reader.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	// classic pcap file header
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(magic[:]) {
+		case pcapMagicMicros:
+			reader.ifaces = []iface{{tsResol: 6}}
+		case pcapMagicNanos:
+			reader.ifaces = []iface{{tsResol: 9}}
+		default:
+			continue
+		}
+		reader.order = order
+		break
+	}
+	if reader.order == nil {
+		return nil, ErrInvalidFile
+	}
+	var header [20]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	reader.ifaces[0].linkType = uint16(reader.order.Uint32(header[16:]))
+	reader.pcap = true
+	return reader, nil
+}
+
+// Reads a section header block after its block type, a new section resets all interfaces
+func (r *Reader) readSectionHeader() error {
+	var header [8]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	switch uint32(byteOrderMagic) {
+	case binary.LittleEndian.Uint32(header[4:]):
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[4:]):
+		r.order = binary.BigEndian
+	default:
+		return ErrInvalidFile
+	}
+	length := r.order.Uint32(header[0:])
+	if length < 28 || length > maxBlockSize {
+		return ErrInvalidBlock
+	}
+	if _, err := io.CopyN(io.Discard, r.r, int64(length-12)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	r.ifaces = nil
+	return nil
+}
+
+// Returns the next message of the file or io.EOF at the end of the file
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for {
+		var msg *gocan.Message
+		var err error
+		if r.pcap {
+			msg, err = r.readRecord()
+		} else {
+			msg, err = r.readBlock()
+		}
+		if msg != nil || err != nil {
+			return msg, err
+		}
+	}
+}
+
+// Reads the next record of a classic pcap file, returns nil without error for skipped packets
+func (r *Reader) readRecord() (*gocan.Message, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: truncated record", ErrInvalidBlock)
+		}
+		return nil, err
+	}
+	length := r.order.Uint32(header[8:])
+	if length > maxBlockSize {
+		return nil, ErrInvalidBlock
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+
+	i := &r.ifaces[0]
+	if i.linkType != LinkTypeCANSocketCAN {
+		return nil, nil
+	}
+	msg, err := ParseFrame(data)
+	if err != nil {
+		return nil, err
+	}
+	sec, frac := uint64(r.order.Uint32(header[0:])), uint64(r.order.Uint32(header[4:]))
+	msg.TimeStamp = sec*1000000 + i.micros(frac)
+	msg.Channel = "0"
+	return msg, nil
+}
+
+// Reads the next block of a pcapng file, returns nil without error for blocks which are not a SocketCAN packet
+func (r *Reader) readBlock() (*gocan.Message, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r.r, header[:4]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: truncated block", ErrInvalidBlock)
+		}
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header[:4]) == blockSHB {
+		return nil, r.readSectionHeader()
+	}
+	if _, err := io.ReadFull(r.r, header[4:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+
+	blockType := r.order.Uint32(header[0:])
+	length := r.order.Uint32(header[4:])
+	if length < 12 || length%4 != 0 || length > maxBlockSize {
+		return nil, ErrInvalidBlock
+	}
+	body := make([]byte, length-8)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	body = body[:len(body)-4] // trailing block length
+
+	switch blockType {
+	case blockIDB:
+		return nil, r.parseInterface(body)
+	case blockEPB:
+		return r.parseEnhancedPacket(body)
+	case blockSPB:
+		if len(body) < 4 || len(r.ifaces) == 0 || r.ifaces[0].linkType != LinkTypeCANSocketCAN {
+			return nil, nil
+		}
+		length := min(int(r.order.Uint32(body[0:])), len(body)-4)
+		msg, err := ParseFrame(body[4 : 4+length])
+		if err != nil {
+			return nil, err
+		}
+		msg.Channel = r.channel(0)
+		return msg, nil
+	}
+	return nil, nil
+}
+
+// Returns the channel name of an interface
+func (r *Reader) channel(index uint32) string {
+	if name := r.ifaces[index].name; name != "" {
+		return name
+	}
+	return strconv.Itoa(int(index))
+}
+
+// Parses the options of a block and calls fn for every option
+func (r *Reader) parseOptions(data []byte, fn func(code uint16, value []byte)) {
+	for len(data) >= 4 {
+		code := r.order.Uint16(data[0:])
+		length := int(r.order.Uint16(data[2:]))
+		if code == optEndOfOpt || 4+length > len(data) {
+			return
+		}
+		fn(code, data[4:4+length])
+		data = data[min(len(data), 4+(length+3)&^3):]
+	}
+}
+
+func (r *Reader) parseInterface(body []byte) error {
+	if len(body) < 8 {
+		return ErrInvalidBlock
+	}
+	i := iface{linkType: r.order.Uint16(body[0:]), tsResol: 6}
+	r.parseOptions(body[8:], func(code uint16, value []byte) {
+		switch {
+		case code == optIfName:
+			i.name = string(value)
+		case code == optTsResol && len(value) > 0:
+			i.tsResol = value[0]
+		}
+	})
+	r.ifaces = append(r.ifaces, i)
+	return nil
+}
+
+func (r *Reader) parseEnhancedPacket(body []byte) (*gocan.Message, error) {
+	if len(body) < 20 {
+		return nil, ErrInvalidBlock
+	}
+	index := r.order.Uint32(body[0:])
+	if int(index) >= len(r.ifaces) {
+		return nil, fmt.Errorf("%w: unknown interface %v", ErrInvalidBlock, index)
+	}
+	i := &r.ifaces[index]
+	if i.linkType != LinkTypeCANSocketCAN {
+		return nil, nil
+	}
+
+	captured := int(r.order.Uint32(body[12:]))
+	if 20+captured > len(body) {
+		return nil, ErrInvalidBlock
+	}
+	msg, err := ParseFrame(body[20 : 20+captured])
+	if err != nil {
+		return nil, err
+	}
+	ts := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+	msg.TimeStamp = i.micros(ts)
+	msg.Channel = r.channel(index)
+
+	options := body[min(len(body), 20+(captured+3)&^3):]
+	r.parseOptions(options, func(code uint16, value []byte) {
+		if code == optEPBFlags && len(value) >= 4 && r.order.Uint32(value)&0x3 == 2 {
+			msg.Direction = gocan.Tx
+		}
+	})
+	return msg, nil
+}
+
+// Writer writes messages as SocketCAN frames into a pcapng file with nanosecond timestamps
+// Every channel gets its own interface named after the channel
+// Every block is written with a single Write() call, so the output can be streamed into a named pipe read by Wireshark,
+// wrap the writer into a bufio.Writer when writing into a file
+type Writer struct {
+	w       io.Writer
+	started bool
+	ifaces  map[string]uint32
+}
+
+// Creates a writer for a pcapng file, the section header is written with the first message
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, ifaces: make(map[string]uint32)}
+}
+
+// Appends an option padded to 32 bit
+func appendOption(block []byte, code uint16, value []byte) []byte {
+	block = binary.LittleEndian.AppendUint16(block, code)
+	block = binary.LittleEndian.AppendUint16(block, uint16(len(value)))
+	block = append(block, value...)
+	return append(block, make([]byte, (4-len(value)%4)%4)...)
+}
+
+// Writes a block with the given body, type and length fields are added
+func (w *Writer) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	_, err := w.w.Write(block)
+	return err
+}
+
+// Writes the section header if not written yet
+func (w *Writer) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	body := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor version
+	body = binary.LittleEndian.AppendUint64(body, 0xFFFFFFFFFFFFFFFF)
+	return w.writeBlock(blockSHB, body)
+}
+
+// Returns the interface index of a channel, writes an interface description block for new channels
+func (w *Writer) iface(channel string) (uint32, error) {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	if index, ok := w.ifaces[channel]; ok {
+		return index, nil
+	}
+	index := uint32(len(w.ifaces))
+	w.ifaces[channel] = index
+
+	body := binary.LittleEndian.AppendUint16(nil, LinkTypeCANSocketCAN)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, canFDFrameSize) // snap length
+	body = appendOption(body, optIfName, []byte(channel))
+	body = appendOption(body, optTsResol, []byte{9})
+	body = appendOption(body, optEndOfOpt, nil)
+	return index, w.writeBlock(blockIDB, body)
+}
+
+// Writes a single message as enhanced packet block, the timestamp is interpreted as µs since unix epoch
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	index, err := w.iface(msg.Channel)
+	if err != nil {
+		return err
+	}
+
+	frame := FormatFrame(msg)
+	ts := msg.TimeStamp * 1000
+	flags := uint32(1) // inbound
+	if msg.Direction == gocan.Tx {
+		flags = 2 // outbound
+	}
+
+	body := make([]byte, 0, 20+len(frame)+12)
+	body = binary.LittleEndian.AppendUint32(body, index)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(frame)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(frame)))
+	body = append(body, frame...)
+	body = appendOption(body, optEPBFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	body = appendOption(body, optEndOfOpt, nil)
+	return w.writeBlock(blockEPB, body)
+}
+
+// Writes the section header if no message was written, the underlying writer is not closed
+func (w *Writer) Close() error {
+	return w.writeHeader()
+}
+
+// PcapWriter writes messages as SocketCAN frames into a classic pcap file with µs timestamps
+// Classic pcap has a single interface, the channel of a message is not stored
+type PcapWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// Creates a writer for a classic pcap file, the file header is written with the first message
+func NewPcapWriter(w io.Writer) *PcapWriter {
+	return &PcapWriter{w: w}
+}
+
+// Writes the file header if not written yet
+func (w *PcapWriter) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	header := binary.LittleEndian.AppendUint32(nil, pcapMagicMicros)
+	header = binary.LittleEndian.AppendUint16(header, 2) // major version
+	header = binary.LittleEndian.AppendUint16(header, 4) // minor version
+	header = binary.LittleEndian.AppendUint64(header, 0) // time zone and accuracy
+	header = binary.LittleEndian.AppendUint32(header, canFDFrameSize)
+	header = binary.LittleEndian.AppendUint32(header, LinkTypeCANSocketCAN)
+	_, err := w.w.Write(header)
+	return err
+}
+
+// Writes a single message as record, the timestamp is interpreted as µs since unix epoch
+func (w *PcapWriter) WriteMessage(msg *gocan.Message) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	frame := FormatFrame(msg)
+	record := make([]byte, 0, 16+len(frame))
+	record = binary.LittleEndian.AppendUint32(record, uint32(msg.TimeStamp/1000000))
+	record = binary.LittleEndian.AppendUint32(record, uint32(msg.TimeStamp%1000000))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(frame)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(frame)))
+	record = append(record, frame...)
+	_, err := w.w.Write(record)
+	return err
+}
+
+// Writes the file header if no message was written, the underlying writer is not closed
+func (w *PcapWriter) Close() error {
+	return w.writeHeader()
+}
+
+// Writes all messages received from r until ctx is cancelled or r reports an error, overrun errors are skipped
+// Used for live captures, e.g. into a named pipe opened by "wireshark -k -i <pipe>"
+func Capture(ctx context.Context, r gocan.Receiver, w *Writer) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	for msg, err := range gocan.Messages(ctx, r) {
+		if errors.Is(err, gocan.ErrRxOverrun) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
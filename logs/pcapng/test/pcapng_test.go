@@ -0,0 +1,189 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/logs/pcapng"
+)
+
+func auxReadAll(t *testing.T, r *pcapng.Reader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxEqual(a, b *gocan.Message) bool {
+	return a.ID == b.ID && a.TimeStamp == b.TimeStamp && a.Type == b.Type && a.Channel == b.Channel && a.IsExtended == b.IsExtended &&
+		a.IsFD == b.IsFD && a.Direction == b.Direction && bytes.Equal(a.Data, b.Data)
+}
+
+func TestFrame(t *testing.T) {
+	frame := pcapng.FormatFrame(&gocan.Message{ID: 0x18DAF110, IsExtended: true, Data: []byte{0x02, 0x10, 0x03}})
+	expected := []byte{0x98, 0xDA, 0xF1, 0x10, 3, 0, 0, 0, 0x02, 0x10, 0x03, 0, 0, 0, 0, 0}
+	if !bytes.Equal(frame, expected) {
+		t.Errorf("wrong frame: got % X, expected % X", frame, expected)
+	}
+
+	frame = pcapng.FormatFrame(&gocan.Message{ID: 0x123, IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: make([]byte, 12)})
+	if len(frame) != 72 || frame[4] != 12 || frame[5] != pcapng.FlagFDF|pcapng.FlagBRS {
+		t.Errorf("wrong FD frame: % X", frame)
+	}
+	msg, err := pcapng.ParseFrame(frame)
+	if err != nil || msg.ID != 0x123 || !msg.IsFD || msg.Type != gocan.FDBitRateSwitchFrame || len(msg.Data) != 12 || msg.DLC != 9 {
+		t.Errorf("wrong parsed FD frame: %+v, err: %v", msg, err)
+	}
+
+	if _, err := pcapng.ParseFrame([]byte{0, 0, 1, 0x23, 9, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}); err == nil {
+		t.Errorf("expected error for classic frame with 9 data bytes")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC).UnixMicro())
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: start + 1234, Channel: "can0", Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x1FFFFFFF, TimeStamp: start + 2000, Channel: "can1", Data: []byte{}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x200, TimeStamp: start + 3000, Channel: "can0", Type: gocan.RemoteFrame, DLC: 8, Data: []byte{}},
+		{ID: 0x4, TimeStamp: start + 4000, Channel: "can1", Type: gocan.ErrorFrame, Data: []byte{0, 0x10, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x300, TimeStamp: start + 5000, Channel: "can0", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 64)},
+		{ID: 0x301, TimeStamp: start + 6000001, Channel: "can1", IsFD: true, Type: gocan.FDErrorStateIndicator, Data: []byte{1, 2, 3}, Direction: gocan.Tx},
+	}
+
+	var buf bytes.Buffer
+	w := pcapng.NewWriter(&buf)
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte{1, 0, 0, 0, 40, 0, 0, 0}); n != 2 {
+		t.Errorf("expected 2 interface description blocks, got %v", n)
+	}
+
+	r, err := pcapng.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	if len(read) != len(msgs) {
+		t.Fatalf("expected %v messages, got %v", len(msgs), len(read))
+	}
+	for i := range msgs {
+		if !auxEqual(&read[i], &msgs[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, read[i], msgs[i])
+		}
+	}
+	if read[2].DLC != 8 {
+		t.Errorf("wrong DLC of remote frame: %v", read[2].DLC)
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	var buf bytes.Buffer
+	header := []uint32{0xA1B23C4D, 0x00040002, 0, 0, 72, pcapng.LinkTypeCANSocketCAN}
+	binary.Write(&buf, binary.BigEndian, header)
+
+	frame := pcapng.FormatFrame(&gocan.Message{ID: 0x7E8, Data: []byte{0x03, 0x41, 0x0D, 0x32}})
+	binary.Write(&buf, binary.BigEndian, []uint32{1700000000, 123456789, uint32(len(frame)), uint32(len(frame))})
+	buf.Write(frame)
+
+	r, err := pcapng.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	expected := gocan.Message{ID: 0x7E8, TimeStamp: 1700000000123456, Channel: "0", Data: []byte{0x03, 0x41, 0x0D, 0x32}}
+	if len(read) != 1 || !auxEqual(&read[0], &expected) {
+		t.Errorf("got %+v, expected %+v", read, expected)
+	}
+}
+
+func TestWritePcap(t *testing.T) {
+	msgs := []gocan.Message{
+		{ID: 0x123, TimeStamp: 1700000000123456, Channel: "0", Data: []byte{1, 2, 3}},
+		{ID: 0x18DAF110, TimeStamp: 1700000001000001, Channel: "0", IsExtended: true, Data: []byte{0x02, 0x10, 0x03}},
+	}
+	var buf bytes.Buffer
+	w := pcapng.NewPcapWriter(&buf)
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	w.Close()
+	if !bytes.HasPrefix(buf.Bytes(), []byte{0xD4, 0xC3, 0xB2, 0xA1}) {
+		t.Fatalf("expected classic pcap header, got % X", buf.Bytes()[:4])
+	}
+
+	r, err := pcapng.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	read := auxReadAll(t, r)
+	if len(read) != len(msgs) {
+		t.Fatalf("expected %v messages, got %v", len(msgs), len(read))
+	}
+	for i := range msgs {
+		if !auxEqual(&read[i], &msgs[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, read[i], msgs[i])
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	txBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestCapture"})
+	rxBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestCapture"})
+	defer txBus.Close()
+	defer rxBus.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- pcapng.Capture(ctx, rxBus, pcapng.NewWriter(pw))
+		pw.Close()
+	}()
+
+	// the reader receives blocks while the capture is running like Wireshark on a named pipe
+	r, err := pcapng.NewReader(pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		txBus.Send(&gocan.Message{ID: gocan.MessageID(0x100 + i), Data: []byte{byte(i)}})
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.ID != gocan.MessageID(0x100+i) || msg.Data[0] != byte(i) || msg.Channel != "TestCapture" {
+			t.Errorf("wrong captured message: %+v", msg)
+		}
+	}
+
+	cancel()
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs"
+)
+
+func auxMessages() []gocan.Message {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC).UnixMicro())
+	return []gocan.Message{
+		{ID: 0x100, TimeStamp: start, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x18DAF110, TimeStamp: start + 1000, Data: []byte{0x02, 0x10, 0x03}, IsExtended: true},
+		{ID: 0x300, TimeStamp: start + 2000, IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 12)},
+	}
+}
+
+func auxReadAll(t *testing.T, r logs.LogReader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxCheck(t *testing.T, name string, got []gocan.Message) {
+	expected := auxMessages()
+	if len(got) != len(expected) {
+		t.Fatalf("%v: expected %v messages, got %v", name, len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i].ID != expected[i].ID || got[i].TimeStamp != expected[i].TimeStamp || got[i].IsExtended != expected[i].IsExtended ||
+			got[i].IsFD != expected[i].IsFD || got[i].Type != expected[i].Type || !bytes.Equal(got[i].Data, expected[i].Data) {
+			t.Errorf("%v, message %v: got %+v, expected %+v", name, i, got[i], expected[i])
+		}
+	}
+}
+
+func auxCreate(t *testing.T, path string) {
+	w, err := logs.Create(path)
+	if err != nil {
+		t.Fatalf("%v: unexpected error: %v", path, err)
+	}
+	msgs := auxMessages()
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("%v: unexpected error: %v", path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%v: unexpected error: %v", path, err)
+	}
+}
+
+func TestRoundTripAllFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"drive.log", "drive.asc", "drive.blf", "drive.trc", "drive.pcapng", "drive.mf4",
+		"drive.pcap", "drive.log.gz", "drive.asc.gz", "drive.blf.gz", "drive.trc.gz", "drive.pcapng.gz"} {
+		path := filepath.Join(dir, name)
+		auxCreate(t, path)
+
+		r, err := logs.Open(path)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		auxCheck(t, name, auxReadAll(t, r))
+		if err := r.Close(); err != nil {
+			t.Errorf("%v: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestSniffContent(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"drive.log", "drive.asc", "drive.blf", "drive.trc", "drive.pcapng", "drive.pcap", "drive.mf4", "drive.asc.gz"} {
+		path := filepath.Join(dir, name)
+		auxCreate(t, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// neither name nor seekable input
+		r, err := logs.NewReader(io.MultiReader(bytes.NewReader(data)), "")
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		auxCheck(t, name, auxReadAll(t, r))
+	}
+
+	if _, err := logs.NewReader(bytes.NewReader([]byte("no log content")), ""); !errors.Is(err, logs.ErrUnknownFormat) {
+		t.Errorf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestCompressionRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	// zstd is known but needs an implementation
+	if _, err := logs.Create(filepath.Join(dir, "drive.asc.zst")); !errors.Is(err, logs.ErrCompressionUnavailable) {
+		t.Errorf("expected ErrCompressionUnavailable, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "drive.asc.zst")); !os.IsNotExist(err) {
+		t.Errorf("expected no file after failed create, got %v", err)
+	}
+	if _, err := logs.NewReader(bytes.NewReader([]byte{0x28, 0xB5, 0x2F, 0xFD, 0}), "drive.asc"); !errors.Is(err, logs.ErrCompressionUnavailable) {
+		t.Errorf("expected ErrCompressionUnavailable, got %v", err)
+	}
+	if _, err := logs.Create(filepath.Join(dir, "drive.mf4.gz")); !errors.Is(err, logs.ErrNotSeekable) {
+		t.Errorf("expected ErrNotSeekable, got %v", err)
+	}
+
+	// custom compression works with every format
+	logs.RegisterCompression(logs.Compression{
+		Name:      "deflate",
+		Extension: ".deflate",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.BestSpeed) },
+	})
+	t.Cleanup(func() { logs.UnregisterCompression("deflate") })
+	path := filepath.Join(dir, "drive.trc.deflate")
+	auxCreate(t, path)
+	r, err := logs.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	auxCheck(t, path, auxReadAll(t, r))
+}
+
+func TestComments(t *testing.T) {
+	for _, name := range []string{"drive.log", "drive.asc", "drive.trc"} {
+		var buf bytes.Buffer
+		w, err := logs.NewWriter(&buf, name)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		msgs := auxMessages()
+		if err := w.(logs.Commenter).WriteComment(msgs[0].TimeStamp, "first comment"); err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		for i := range msgs {
+			w.WriteMessage(&msgs[i])
+		}
+		w.(logs.Commenter).WriteComment(msgs[2].TimeStamp, "last comment")
+		w.Close()
+
+		if !bytes.Contains(buf.Bytes(), []byte("first comment")) || !bytes.Contains(buf.Bytes(), []byte("last comment")) {
+			t.Errorf("%v: missing comments:\n%s", name, buf.String())
+		}
+		r, err := logs.NewReader(&buf, name)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		auxCheck(t, name, auxReadAll(t, r))
+	}
+
+	// formats without comments
+	w, _ := logs.NewWriter(io.Discard, "drive.blf")
+	if err := w.(logs.Commenter).WriteComment(0, "comment"); !errors.Is(err, gocan.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/trc"
+)
+
+func auxReadAll(t *testing.T, r *trc.Reader) []gocan.Message {
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxEqual(a, b *gocan.Message) bool {
+	return a.ID == b.ID && a.TimeStamp == b.TimeStamp && a.Type == b.Type && a.Channel == b.Channel && a.IsExtended == b.IsExtended &&
+		a.IsFD == b.IsFD && a.Direction == b.Direction && bytes.Equal(a.Data, b.Data)
+}
+
+func auxCheck(t *testing.T, got, expected []gocan.Message) {
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v messages, got %v: %+v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if !auxEqual(&got[i], &expected[i]) {
+			t.Errorf("message %v: got %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestReadVersion11(t *testing.T) {
+	log := `;$FILEVERSION=1.1
+;$STARTTIME=41225.5765515046
+;
+;   Start time: 12.11.2012 13:50:14.050.0
+;   Generated by PCAN-View v3.2.2.0
+;-------------------------------------------------------------------------------
+;   Message Number
+;   |         Time Offset (ms)
+;   |         |        Type
+;   |         |        |        ID (hex)
+;   |         |        |        |     Data Length
+;   |         |        |        |     |   Data Bytes (hex) ...
+;   |         |        |        |     |   |
+;---+--   ----+----  --+--  ----+---  +  -+ -- -- -- -- -- -- --
+     1)      1841.0  Rx         0001  8  00 00 00 00 00 00 00 00
+     2)      1842.3  Tx     18EFFF00  4  01 02 03 04
+     3)      1843.5  Rx         0100  4  RTR
+     4)      1844.0  Warng  FFFFFFFF  4  00 00 00 08  BUSHEAVY
+     5)      1845.7  Error      0008  5  02 00 00 00 08
+`
+	r := trc.NewReader(strings.NewReader(log))
+	msgs := auxReadAll(t, r)
+
+	start := time.Date(2012, 11, 12, 13, 50, 14, 50000000, time.Local)
+	if d := r.StartTime.Sub(start); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("wrong start time: got %v, expected %v", r.StartTime, start)
+	}
+	base := uint64(r.StartTime.UnixMicro())
+	auxCheck(t, msgs, []gocan.Message{
+		{ID: 0x1, TimeStamp: base + 1841000, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x18EFFF00, TimeStamp: base + 1842300, Data: []byte{1, 2, 3, 4}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x100, TimeStamp: base + 1843500, Type: gocan.RemoteFrame, Data: []byte{}},
+		{ID: 0x8, TimeStamp: base + 1845700, Type: gocan.ErrorFrame, Data: []byte{2, 0, 0, 0, 8}},
+	})
+	if msgs[2].DLC != 4 {
+		t.Errorf("wrong DLC of remote frame: %v", msgs[2].DLC)
+	}
+}
+
+func TestReadVersion13(t *testing.T) {
+	log := `;$FILEVERSION=1.3
+;$STARTTIME=43474.4279629282
+     1)      1059.9 1  Rx        0300 -  7    00 00 00 00 04 00 00
+     2)      1283.2 2  Tx    00000301 -  2    AB CD
+`
+	msgs := auxReadAll(t, trc.NewReader(strings.NewReader(log)))
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %v", len(msgs))
+	}
+	if msgs[0].Channel != "1" || msgs[0].ID != 0x300 || len(msgs[0].Data) != 7 || msgs[0].Data[4] != 4 {
+		t.Errorf("wrong first message: %+v", msgs[0])
+	}
+	if msgs[1].Channel != "2" || !msgs[1].IsExtended || msgs[1].Direction != gocan.Tx || msgs[1].TimeStamp-msgs[0].TimeStamp != 223300 {
+		t.Errorf("wrong second message: %+v", msgs[1])
+	}
+}
+
+func TestReadVersion20(t *testing.T) {
+	log := `;$FILEVERSION=2.0
+;$STARTTIME=43474.4279629282
+;$COLUMNS=N,O,T,I,d,l,D
+      1      1059.900 DT     0300 Rx 7  00 00 00 00 04 00 00
+      2      1283.231 FD     0400 Rx 12 01 02 03 04 05 06 07 08 09 0A 0B 0C
+      3      1290.000 ST          Rx    00 00 00 08
+      4      1298.945 RR     0500 Tx 3
+`
+	msgs := auxReadAll(t, trc.NewReader(strings.NewReader(log)))
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %v: %+v", len(msgs), msgs)
+	}
+	if !msgs[1].IsFD || len(msgs[1].Data) != 12 || msgs[1].DLC != 9 {
+		t.Errorf("wrong FD message: %+v", msgs[1])
+	}
+	if msgs[2].Type != gocan.RemoteFrame || msgs[2].DLC != 3 || msgs[2].Direction != gocan.Tx {
+		t.Errorf("wrong remote frame: %+v", msgs[2])
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.Local).UnixMicro())
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: start + 1234, Channel: "1", Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{ID: 0x1FFFFFFF, TimeStamp: start + 2000, Channel: "2", Data: []byte{}, IsExtended: true, Direction: gocan.Tx},
+		{ID: 0x200, TimeStamp: start + 3000, Channel: "1", Type: gocan.RemoteFrame, DLC: 8, Data: []byte{}},
+		{TimeStamp: start + 4000, Channel: "2", Type: gocan.ErrorFrame, Data: []byte{2, 0, 0, 0, 8}},
+		{ID: 0x300, TimeStamp: start + 5000, Channel: "1", IsFD: true, Type: gocan.FDBitRateSwitchFrame, Data: bytes.Repeat([]byte{0xAB}, 64)},
+		{ID: 0x301, TimeStamp: start + 6000001, Channel: "2", IsFD: true, Type: gocan.FDErrorStateIndicator, Data: []byte{1, 2, 3}, Direction: gocan.Tx},
+		{ID: 0x302, TimeStamp: start + 7000000, Channel: "1", IsFD: true, Data: make([]byte, 20)},
+	}
+
+	var buf bytes.Buffer
+	w := trc.NewWriter(&buf)
+	for i := range msgs {
+		if err := w.WriteMessage(&msgs[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := trc.NewReader(&buf)
+	read := auxReadAll(t, r)
+	if r.Version != trc.WriterVersion {
+		t.Errorf("wrong version: got %v, expected %v", r.Version, trc.WriterVersion)
+	}
+	auxCheck(t, read, msgs)
+	if read[2].DLC != 8 || read[4].DLC != 15 {
+		t.Errorf("wrong DLC: %v, %v", read[2].DLC, read[4].DLC)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	_, err := trc.NewReader(strings.NewReader(";$FILEVERSION=3.0\n")).ReadMessage()
+	if err == nil || err == io.EOF {
+		t.Errorf("expected error for unsupported version, got %v", err)
+	}
+}
+
+func TestWriteChannelNames(t *testing.T) {
+	var buf bytes.Buffer
+	w := trc.NewWriter(&buf)
+	names := []string{"3", "can0", "1", "can1", "can0", "3"}
+	for i, name := range names {
+		w.WriteMessage(&gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(i) * 1000, Channel: name, Data: []byte{}})
+	}
+	w.Close()
+
+	// numeric names keep their number unless it is taken, named channels never share a number with another name
+	expected := []string{"3", "1", "2", "4", "1", "3"}
+	read := auxReadAll(t, trc.NewReader(&buf))
+	if len(read) != len(expected) {
+		t.Fatalf("expected %v messages, got %v", len(expected), len(read))
+	}
+	for i := range read {
+		if read[i].Channel != expected[i] {
+			t.Errorf("%v: got channel %v, expected %v", names[i], read[i].Channel, expected[i])
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package trc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs/internal/channels"
+)
+
+// Version written by the Writer
+const WriterVersion = "2.1"
+
+// Default columns of version 2.0 and 2.1 files without a $COLUMNS line
+var (
+	columnsV20 = []string{"N", "O", "T", "I", "d", "l", "D"}
+	columnsV21 = []string{"N", "O", "T", "B", "I", "d", "R", "L", "D"}
+)
+
+// errors
+var (
+	ErrInvalidLine        = errors.New("invalid trc line")
+	ErrUnsupportedVersion = errors.New("unsupported trc file version")
+)
+
+// Converts the $STARTTIME value, days since 30.12.1899 in local time, into a time
+func parseStartTime(s string) (time.Time, error) {
+	days, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	whole := math.Floor(days)
+	micros := math.Round((days - whole) * 86400e6)
+	return time.Date(1899, 12, 30+int(whole), 0, 0, 0, int(micros)*1000, time.Local), nil
+}
+
+// Converts a time into days since 30.12.1899 as written in the $STARTTIME line
+func formatStartTime(t time.Time) string {
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	days := date.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	micros := (t.Hour()*3600+t.Minute()*60+t.Second())*1000000 + t.Nanosecond()/1000
+	return strconv.FormatFloat(math.Round(days)+float64(micros)/86400e6, 'f', -1, 64)
+}
+
+// Converts a time offset in ms with up to 3 decimals into µs without rounding errors
+func parseOffset(s string) (uint64, error) {
+	msStr, fracStr, _ := strings.Cut(s, ".")
+	ms, err := strconv.ParseUint(msStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	fracStr = (fracStr + "000")[:3]
+	micros, err := strconv.ParseUint(fracStr, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return ms*1000 + micros, nil
+}
+
+// Reader reads messages from a PCAN trace file of version 1.0 to 2.1
+// The timestamp of every message is the $STARTTIME of the file in µs since unix epoch plus the offset of the message
+// Status, error counter and event lines are skipped
+type Reader struct {
+	StartTime time.Time // start time of the file, zero if the file has no $STARTTIME
+	Version   string    // file version, "1.0" for files without $FILEVERSION
+
+	scanner *bufio.Scanner
+	line    int
+	start   uint64   // start time in µs
+	columns []string // columns of version 2.x
+}
+
+// Creates a reader for a PCAN trace file
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r), Version: "1.0"}
+}
+
+// Returns the next message of the trace or io.EOF at the end of the trace
+func (r *Reader) ReadMessage() (*gocan.Message, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ";") {
+			if err := r.parseHeader(line); err != nil {
+				return nil, fmt.Errorf("line %v: %w", r.line, err)
+			}
+			continue
+		}
+
+		var msg *gocan.Message
+		var err error
+		if strings.HasPrefix(r.Version, "2.") {
+			msg, err = r.parseLineV2(strings.Fields(line))
+		} else {
+			msg, err = r.parseLineV1(strings.Fields(line))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", r.line, err)
+		}
+		if msg != nil {
+			return msg, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Evaluates the $FILEVERSION, $STARTTIME and $COLUMNS lines, all other comments are ignored
+func (r *Reader) parseHeader(line string) error {
+	key, value, ok := strings.Cut(strings.TrimPrefix(line, ";"), "=")
+	if !ok {
+		return nil
+	}
+	switch strings.TrimSpace(key) {
+	case "$FILEVERSION":
+		r.Version = strings.TrimSpace(value)
+		switch r.Version {
+		case "1.0", "1.1", "1.2", "1.3":
+		case "2.0":
+			r.columns = columnsV20
+		case "2.1":
+			r.columns = columnsV21
+		default:
+			return fmt.Errorf("%w: %v", ErrUnsupportedVersion, r.Version)
+		}
+	case "$STARTTIME":
+		t, err := parseStartTime(value)
+		if err != nil {
+			return fmt.Errorf("%w: invalid start time %q", ErrInvalidLine, value)
+		}
+		r.StartTime = t
+		r.start = uint64(t.UnixMicro())
+	case "$COLUMNS":
+		r.columns = strings.Split(strings.TrimSpace(value), ",")
+	}
+	return nil
+}
+
+// Parses "<n>) <offset> [<bus>] [<type>] <id> [-] <dlc> <data>..." of version 1.x
+// The bus column exists since 1.2, the type column since 1.1 and the reserved column since 1.3
+func (r *Reader) parseLineV1(fields []string) (*gocan.Message, error) {
+	if len(fields) < 2 || !strings.HasSuffix(fields[0], ")") {
+		return nil, ErrInvalidLine
+	}
+	offset, err := parseOffset(fields[1])
+	if err != nil {
+		return nil, ErrInvalidLine
+	}
+	msg := &gocan.Message{TimeStamp: r.start + offset, Data: []byte{}}
+	fields = fields[2:]
+
+	if r.Version >= "1.2" && len(fields) > 0 {
+		msg.Channel = fields[0]
+		fields = fields[1:]
+	}
+	if r.Version >= "1.1" && len(fields) > 0 {
+		switch fields[0] {
+		case "Rx":
+		case "Tx":
+			msg.Direction = gocan.Tx
+		case "Error":
+			msg.Type = gocan.ErrorFrame
+		default:
+			return nil, nil // status line like "Warng"
+		}
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return nil, ErrInvalidLine
+	}
+
+	if err := parseID(msg, fields[0]); err != nil && msg.Type != gocan.ErrorFrame {
+		return nil, err
+	}
+	fields = fields[1:]
+	if r.Version >= "1.3" && fields[0] == "-" {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return nil, ErrInvalidLine
+	}
+	dlc, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil || dlc > 15 {
+		return nil, ErrInvalidLine
+	}
+	msg.DLC = uint8(dlc)
+	fields = fields[1:]
+
+	if len(fields) > 0 && fields[0] == "RTR" {
+		msg.Type = gocan.RemoteFrame
+		return msg, nil
+	}
+	msg.Data, err = parseData(fields, gocan.LengthFromDLC(msg.DLC, false), msg.Type == gocan.ErrorFrame)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Parses a line of version 2.x according to the columns of the file
+func (r *Reader) parseLineV2(fields []string) (*gocan.Message, error) {
+	msg := &gocan.Message{Data: []byte{}}
+	length := -1
+	for _, column := range r.columns {
+		if column == "D" {
+			break // data are the remaining fields
+		}
+		if len(fields) == 0 {
+			return nil, ErrInvalidLine
+		}
+		field := fields[0]
+		fields = fields[1:]
+
+		switch column {
+		case "O":
+			offset, err := parseOffset(field)
+			if err != nil {
+				return nil, ErrInvalidLine
+			}
+			msg.TimeStamp = r.start + offset
+		case "T":
+			switch field {
+			case "DT":
+			case "RR":
+				msg.Type = gocan.RemoteFrame
+			case "FD":
+				msg.IsFD = true
+			case "FB":
+				msg.IsFD = true
+				msg.Type = gocan.FDBitRateSwitchFrame
+			case "FE", "BI":
+				msg.IsFD = true
+				msg.Type = gocan.FDErrorStateIndicator
+			case "ER":
+				msg.Type = gocan.ErrorFrame
+			default:
+				return nil, nil // status, error counter or event line
+			}
+		case "B":
+			msg.Channel = field
+		case "I":
+			if err := parseID(msg, field); err != nil && msg.Type != gocan.ErrorFrame {
+				return nil, err
+			}
+		case "d":
+			if field == "Tx" {
+				msg.Direction = gocan.Tx
+			}
+		case "L":
+			dlc, err := strconv.ParseUint(field, 10, 8)
+			if err != nil || dlc > 15 {
+				return nil, ErrInvalidLine
+			}
+			msg.DLC = uint8(dlc)
+			length = gocan.LengthFromDLC(msg.DLC, msg.IsFD)
+		case "l":
+			n, err := strconv.ParseUint(field, 10, 8)
+			if err != nil || n > gocan.MaxDataLength {
+				return nil, ErrInvalidLine
+			}
+			length = int(n)
+			msg.DLC = gocan.DLCFromLength(length)
+		}
+	}
+
+	if msg.Type == gocan.RemoteFrame {
+		return msg, nil
+	}
+	if length < 0 {
+		length = len(fields)
+	}
+	var err error
+	msg.Data, err = parseData(fields, length, msg.Type == gocan.ErrorFrame)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == gocan.ErrorFrame {
+		msg.DLC = uint8(len(msg.Data))
+	}
+	return msg, nil
+}
+
+// Parses a hex id, ids with 8 digits are extended ids
+func parseID(msg *gocan.Message, s string) error {
+	id, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return ErrInvalidLine
+	}
+	msg.ID = gocan.MessageID(id)
+	msg.IsExtended = len(s) == 8
+	return nil
+}
+
+// Parses n hex data bytes, if lenient is set the data ends at the first field which is no byte
+func parseData(fields []string, n int, lenient bool) ([]byte, error) {
+	if len(fields) < n && !lenient {
+		return nil, ErrInvalidLine
+	}
+	data := make([]byte, 0, n)
+	for i := 0; i < n && i < len(fields); i++ {
+		b, err := strconv.ParseUint(fields[i], 16, 8)
+		if err != nil {
+			if lenient {
+				break
+			}
+			return nil, ErrInvalidLine
+		}
+		data = append(data, byte(b))
+	}
+	return data, nil
+}
+
+// Writer writes messages into a PCAN trace file of version 2.1
+// The start time is taken from the first message, whose timestamp is interpreted as µs since unix epoch
+type Writer struct {
+	w        *bufio.Writer
+	start    uint64
+	started  bool
+	count    int
+	channels channels.Numbers
+}
+
+// Creates a writer for a PCAN trace file, Close() must be called after the last message
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Writes the header with the given start time
+func (w *Writer) writeHeader(start time.Time) {
+	fmt.Fprintf(w.w, ";$FILEVERSION=%s\n", WriterVersion)
+	fmt.Fprintf(w.w, ";$STARTTIME=%s\n", formatStartTime(start))
+	fmt.Fprintf(w.w, ";$COLUMNS=%s\n", strings.Join(columnsV21, ","))
+	fmt.Fprintf(w.w, ";\n")
+	fmt.Fprintf(w.w, ";   Start time: %s.0\n", start.Format("02.01.2006 15:04:05.000"))
+	fmt.Fprintf(w.w, ";   Generated by gocan\n")
+	fmt.Fprintf(w.w, ";-------------------------------------------------------------------------------\n")
+	fmt.Fprintf(w.w, ";   Message   Time    Type ID     Rx/Tx\n")
+	fmt.Fprintf(w.w, ";   Number    Offset  |  Bus    [hex]  |  Reserved\n")
+	fmt.Fprintf(w.w, ";   |         [ms]    |  |      |      |  |  Data Length Code\n")
+	fmt.Fprintf(w.w, ";   |         |       |  |      |      |  |  |    Data [hex] ...\n")
+	fmt.Fprintf(w.w, ";   |         |       |  |      |      |  |  |    |\n")
+	fmt.Fprintf(w.w, ";---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --\n")
+}
+
+// Writes a single message
+func (w *Writer) WriteMessage(msg *gocan.Message) error {
+	if !w.started {
+		// the start time is written with milliseconds
+		w.start = msg.TimeStamp - msg.TimeStamp%1000
+		w.writeHeader(time.UnixMicro(int64(w.start)))
+		w.started = true
+	}
+	w.count++
+
+	offset := uint64(0)
+	if msg.TimeStamp > w.start {
+		offset = msg.TimeStamp - w.start
+	}
+
+	msgType := "DT"
+	switch {
+	case msg.Type == gocan.ErrorFrame:
+		msgType = "ER"
+	case msg.IsFD && msg.Type == gocan.FDErrorStateIndicator:
+		msgType = "FE"
+	case msg.IsFD && msg.Type == gocan.FDBitRateSwitchFrame:
+		msgType = "FB"
+	case msg.IsFD:
+		msgType = "FD"
+	case msg.Type == gocan.RemoteFrame:
+		msgType = "RR"
+	}
+
+	id := fmt.Sprintf("%04X", uint32(msg.ID))
+	if msg.IsExtended {
+		id = fmt.Sprintf("%08X", uint32(msg.ID))
+	}
+	dir := "Rx"
+	if msg.Direction == gocan.Tx {
+		dir = "Tx"
+	}
+	dlc := gocan.DLCFromLength(len(msg.Data))
+	if msg.Type == gocan.RemoteFrame || msg.Type == gocan.ErrorFrame {
+		dlc = uint8(len(msg.Data))
+		if msg.Type == gocan.RemoteFrame {
+			dlc = msg.DLC
+		}
+	}
+
+	_, err := fmt.Fprintf(w.w, "%7d %9d.%03d %s %-2d %8s %s - %-4d", w.count, offset/1000, offset%1000, msgType, w.channels.Get(msg.Channel), id, dir, dlc)
+	if err != nil {
+		return err
+	}
+	if msg.Type != gocan.RemoteFrame {
+		for _, b := range msg.Data {
+			fmt.Fprintf(w.w, " %02X", b)
+		}
+	}
+	_, err = fmt.Fprintln(w.w)
+	return err
+}
+
+// Writes a comment line, the header is written first if no message was written yet
+func (w *Writer) WriteComment(timeStamp uint64, text string) error {
+	if !w.started {
+		w.start = timeStamp - timeStamp%1000
+		w.writeHeader(time.UnixMicro(int64(w.start)))
+		w.started = true
+	}
+	_, err := fmt.Fprintf(w.w, ";   %s\n", strings.ReplaceAll(text, "\n", " "))
+	return err
+}
+
+// Flushes all buffered data, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if !w.started {
+		w.writeHeader(time.Now())
+		w.started = true
+	}
+	return w.w.Flush()
+}
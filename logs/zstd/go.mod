@@ -0,0 +1,8 @@
+module github.com/morgadow/gocan/logs/zstd
+
+go 1.23.4
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/morgadow/gocan v1.3.0
+)
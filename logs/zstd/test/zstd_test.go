@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs"
+	_ "github.com/morgadow/gocan/logs/zstd"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	msgs := []gocan.Message{
+		{ID: 0x123, TimeStamp: 1700000000000000, Channel: "1", Data: []byte{1, 2, 3}},
+		{ID: 0x456, TimeStamp: 1700000000010000, Channel: "1", Data: []byte{4, 5, 6, 7}},
+	}
+	for _, name := range []string{"drive.log.zst", "drive.asc.zst", "drive.blf.zst"} {
+		path := filepath.Join(dir, name)
+		w, err := logs.Create(path)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		for i := range msgs {
+			if err := w.WriteMessage(&msgs[i]); err != nil {
+				t.Fatalf("%v: unexpected error: %v", name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+
+		r, err := logs.Open(path)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", name, err)
+		}
+		var read []gocan.Message
+		for {
+			msg, err := r.ReadMessage()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", name, err)
+			}
+			read = append(read, *msg)
+		}
+		r.Close()
+		if len(read) != len(msgs) {
+			t.Fatalf("%v: expected %v messages, got %v", name, len(msgs), len(read))
+		}
+		for i := range msgs {
+			if read[i].ID != msgs[i].ID || read[i].TimeStamp != msgs[i].TimeStamp || !bytes.Equal(read[i].Data, msgs[i].Data) {
+				t.Errorf("%v: message %v: got %+v, expected %+v", name, i, read[i], msgs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package zstd
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/morgadow/gocan/logs"
+)
+
+// Registers zstd of github.com/klauspost/compress in the logs registry, imported for its side effect with
+// import _ "github.com/morgadow/gocan/logs/zstd"
+// The package is a separate module, so gocan itself stays without third-party dependencies
+func init() {
+	logs.RegisterCompression(logs.Compression{
+		Name:      "zstd",
+		Extension: ".zst",
+		Magic:     []byte{0x28, 0xB5, 0x2F, 0xFD},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	})
+}
@@ -0,0 +1,61 @@
+package obd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/morgadow/gocan"
+)
+
+// Services (modes) of SAE J1979, positive responses add PositiveResponse
+const (
+	ShowCurrentData    = 0x01
+	ShowFreezeFrame    = 0x02
+	ShowStoredDTCs     = 0x03
+	ClearDTCs          = 0x04
+	ShowPendingDTCs    = 0x07
+	VehicleInformation = 0x09
+	ShowPermanentDTCs  = 0x0A
+
+	PositiveResponse = 0x40 // added to the service in positive responses
+	NegativeResponse = 0x7F // first byte of a negative response
+)
+
+// Info types of service 09
+const (
+	InfoTypeVIN            = 0x02
+	InfoTypeCalibrationIDs = 0x04
+)
+
+// CAN identifiers of ISO 15765-4
+const (
+	FunctionalID       gocan.MessageID = 0x7DF      // functional request with 11-bit ids
+	PhysicalRequestID  gocan.MessageID = 0x7E0      // physical request to the first ECU with 11-bit ids, up to 0x7E7
+	PhysicalResponseID gocan.MessageID = 0x7E8      // response of the first ECU with 11-bit ids, up to 0x7EF
+	FunctionalID29     gocan.MessageID = 0x18DB33F1 // functional request with 29-bit ids
+	PhysicalRequest29  gocan.MessageID = 0x18DA00F1 // physical request with 29-bit ids, ECU address in bits 8 to 15
+	PhysicalResponse29 gocan.MessageID = 0x18DAF100 // response with 29-bit ids, ECU address in bits 0 to 7
+	TesterAddress                      = 0xF1
+)
+
+// Amount of ECUs with 11-bit ids
+const MaxECUs11 = 8
+
+// errors
+var (
+	ErrNoResponse = errors.New("no ECU responded")
+	ErrUnknownPID = errors.New("unknown PID")
+	ErrLength     = errors.New("invalid response length")
+)
+
+// Diagnostic trouble code of two bytes, printed like "P0133"
+type DTC uint16
+
+func (d DTC) String() string {
+	return fmt.Sprintf("%c%04X", "PCBU"[d>>14], uint16(d)&0x3FFF)
+}
+
+// Returns the system letter P (powertrain), C (chassis), B (body) or U (network)
+func (d DTC) System() byte {
+	return "PCBU"[d>>14]
+}
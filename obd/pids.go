@@ -0,0 +1,132 @@
+package obd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Decoded physical value of a PID
+type Value struct {
+	Name  string
+	Value float64
+	Unit  string
+}
+
+func (v Value) String() string {
+	if v.Unit == "" {
+		return fmt.Sprintf("%v: %v", v.Name, v.Value)
+	}
+	return fmt.Sprintf("%v: %v %v", v.Name, v.Value, v.Unit)
+}
+
+// Definition of a service 01 PID
+type pid struct {
+	name   string
+	length int // data bytes following the PID
+	decode func(name string, d []byte) []Value
+}
+
+// Returns a decoder of a single value
+func single(unit string, fn func(d []byte) float64) func(name string, d []byte) []Value {
+	return func(name string, d []byte) []Value {
+		return []Value{{Name: name, Value: fn(d), Unit: unit}}
+	}
+}
+
+func percent(d []byte) float64     { return float64(d[0]) * 100 / 255 }
+func temperature(d []byte) float64 { return float64(d[0]) - 40 }
+func fuelTrim(d []byte) float64    { return (float64(d[0]) - 128) * 100 / 128 }
+func unsigned(d []byte) float64    { return float64(d[0]) }
+func word(d []byte) float64        { return float64(uint16(d[0])<<8 | uint16(d[1])) }
+
+// Decoder of the oxygen sensor PIDs 14 to 1B
+func oxygenSensor(name string, d []byte) []Value {
+	return []Value{
+		{Name: name + " voltage", Value: float64(d[0]) / 200, Unit: "V"},
+		{Name: name + " short term fuel trim", Value: fuelTrim(d[1:]), Unit: "%"},
+	}
+}
+
+// Standard PIDs of service 01
+var pids = map[uint8]pid{
+	0x01: {"Monitor status since DTCs cleared", 4, func(_ string, d []byte) []Value {
+		mil := 0.0
+		if d[0]&0x80 != 0 {
+			mil = 1
+		}
+		return []Value{{Name: "MIL", Value: mil}, {Name: "DTC count", Value: float64(d[0] & 0x7F)}}
+	}},
+	0x04: {"Calculated engine load", 1, single("%", percent)},
+	0x05: {"Engine coolant temperature", 1, single("°C", temperature)},
+	0x06: {"Short term fuel trim bank 1", 1, single("%", fuelTrim)},
+	0x07: {"Long term fuel trim bank 1", 1, single("%", fuelTrim)},
+	0x08: {"Short term fuel trim bank 2", 1, single("%", fuelTrim)},
+	0x09: {"Long term fuel trim bank 2", 1, single("%", fuelTrim)},
+	0x0A: {"Fuel pressure", 1, single("kPa", func(d []byte) float64 { return 3 * float64(d[0]) })},
+	0x0B: {"Intake manifold absolute pressure", 1, single("kPa", unsigned)},
+	0x0C: {"Engine speed", 2, single("rpm", func(d []byte) float64 { return word(d) / 4 })},
+	0x0D: {"Vehicle speed", 1, single("km/h", unsigned)},
+	0x0E: {"Timing advance", 1, single("° before TDC", func(d []byte) float64 { return float64(d[0])/2 - 64 })},
+	0x0F: {"Intake air temperature", 1, single("°C", temperature)},
+	0x10: {"Mass air flow rate", 2, single("g/s", func(d []byte) float64 { return word(d) / 100 })},
+	0x11: {"Throttle position", 1, single("%", percent)},
+	0x14: {"Oxygen sensor 1", 2, oxygenSensor},
+	0x15: {"Oxygen sensor 2", 2, oxygenSensor},
+	0x16: {"Oxygen sensor 3", 2, oxygenSensor},
+	0x17: {"Oxygen sensor 4", 2, oxygenSensor},
+	0x18: {"Oxygen sensor 5", 2, oxygenSensor},
+	0x19: {"Oxygen sensor 6", 2, oxygenSensor},
+	0x1A: {"Oxygen sensor 7", 2, oxygenSensor},
+	0x1B: {"Oxygen sensor 8", 2, oxygenSensor},
+	0x1C: {"OBD standard", 1, single("", unsigned)},
+	0x1F: {"Run time since engine start", 2, single("s", word)},
+	0x21: {"Distance traveled with MIL on", 2, single("km", word)},
+	0x2F: {"Fuel tank level input", 1, single("%", percent)},
+	0x30: {"Warm-ups since DTCs cleared", 1, single("", unsigned)},
+	0x31: {"Distance traveled since DTCs cleared", 2, single("km", word)},
+	0x33: {"Absolute barometric pressure", 1, single("kPa", unsigned)},
+	0x42: {"Control module voltage", 2, single("V", func(d []byte) float64 { return word(d) / 1000 })},
+	0x43: {"Absolute load value", 2, single("%", func(d []byte) float64 { return word(d) * 100 / 255 })},
+	0x45: {"Relative throttle position", 1, single("%", percent)},
+	0x46: {"Ambient air temperature", 1, single("°C", temperature)},
+	0x4D: {"Time run with MIL on", 2, single("min", word)},
+	0x4E: {"Time since DTCs cleared", 2, single("min", word)},
+	0x51: {"Fuel type", 1, single("", unsigned)},
+	0x5C: {"Engine oil temperature", 1, single("°C", temperature)},
+	0x5E: {"Engine fuel rate", 2, single("L/h", func(d []byte) float64 { return word(d) / 20 })},
+}
+
+// Returns the name of a service 01 PID
+func PIDName(id uint8) (string, bool) {
+	p, ok := pids[id]
+	return p.name, ok
+}
+
+// Returns all service 01 PIDs DecodePID knows, sorted
+func KnownPIDs() []uint8 {
+	return slices.Sorted(maps.Keys(pids))
+}
+
+// Decodes the data following the PID of a service 01 response into physical values
+func DecodePID(id uint8, data []byte) ([]Value, error) {
+	p, ok := pids[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: 0x%02X", ErrUnknownPID, id)
+	}
+	if len(data) < p.length {
+		return nil, fmt.Errorf("%w: PID 0x%02X with %v bytes, expected %v", ErrLength, id, len(data), p.length)
+	}
+	return p.decode(p.name, data[:p.length]), nil
+}
+
+// Returns the PIDs flagged in the 4 byte bitmap answering the supported PIDs request base
+func DecodeSupported(base uint8, bitmap []byte) []uint8 {
+	var supported []uint8
+	for i := 0; i < 32 && i/8 < len(bitmap); i++ {
+		if bitmap[i/8]&(0x80>>(i%8)) != 0 {
+			supported = append(supported, base+uint8(i)+1)
+		}
+	}
+	return supported
+}
@@ -0,0 +1,327 @@
+package obd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/isotp"
+)
+
+// Default values of the configuration
+const (
+	DefaultTimeout        = 100 * time.Millisecond
+	DefaultPendingTimeout = 5 * time.Second
+)
+
+// Config of a scanner
+type Config struct {
+	Extended       bool          // use 29-bit ids instead of 11-bit ids
+	Timeout        time.Duration // time collecting responses after a request, default DefaultTimeout
+	PendingTimeout time.Duration // time waiting for an ECU which answered response pending, default DefaultPendingTimeout
+	ISOTP          *isotp.Config // transport configuration
+}
+
+// Response of a single ECU
+type Response struct {
+	ID   gocan.MessageID // response id of the ECU
+	Data []byte          // complete response including the service byte
+}
+
+// Scanner sends OBD-II requests to all ECUs of a vehicle and collects their responses
+type Scanner struct {
+	cfg        Config
+	mux        *isotp.Mux
+	functional *isotp.Conn
+	mu         sync.Mutex // serializes requests
+	rx         chan Response
+	done       chan struct{}
+}
+
+// Creates a scanner on the bus, the bus must not be read by anything else
+func NewScanner(bus gocan.Transceiver, cfg *Config) (*Scanner, error) {
+	s := &Scanner{
+		rx:   make(chan Response, 64),
+		done: make(chan struct{}),
+	}
+	if cfg != nil {
+		s.cfg = *cfg
+	}
+	if s.cfg.Timeout <= 0 {
+		s.cfg.Timeout = DefaultTimeout
+	}
+	if s.cfg.PendingTimeout <= 0 {
+		s.cfg.PendingTimeout = DefaultPendingTimeout
+	}
+	s.mux = isotp.NewMux(bus)
+
+	var err error
+	if s.cfg.Extended {
+		// connections are opened for every ECU responding with its address
+		s.mux.SetUnknownHandler(s.openExtended)
+		s.functional, err = s.mux.Open(isotp.Address{TxID: FunctionalID29, IsExtended: true, Functional: true}, s.cfg.ISOTP)
+	} else {
+		for i := range gocan.MessageID(MaxECUs11) {
+			if err = s.open(isotp.Address{TxID: PhysicalRequestID + i, RxID: PhysicalResponseID + i}); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			s.functional, err = s.mux.Open(isotp.Address{TxID: FunctionalID, Functional: true}, s.cfg.ISOTP)
+		}
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stops reading the bus
+func (s *Scanner) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return s.mux.Close()
+}
+
+// Opens the connection to an ECU and forwards its responses
+func (s *Scanner) open(addr isotp.Address) error {
+	c, err := s.mux.Open(addr, s.cfg.ISOTP)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			data, err := c.Recv(context.Background())
+			if errors.Is(err, isotp.ErrTimeoutCr) || errors.Is(err, isotp.ErrSequence) || errors.Is(err, isotp.ErrTooLong) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case s.rx <- Response{ID: addr.RxID, Data: data}:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Opens the connection to an ECU answering with a 29-bit response id
+func (s *Scanner) openExtended(msg *gocan.Message) {
+	if !msg.IsExtended || msg.ID&^0xFF != PhysicalResponse29 {
+		return
+	}
+	ecu := msg.ID & 0xFF
+	s.open(isotp.Address{TxID: PhysicalRequest29 | ecu<<8, RxID: msg.ID, IsExtended: true})
+}
+
+// Sends a functional request and returns the responses of all ECUs received within the timeout
+// Negative responses are included, response pending answers extend the timeout of the ECU.
+func (s *Scanner) Request(ctx context.Context, req []byte) ([]Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// responses of earlier requests are outdated
+	for len(s.rx) > 0 {
+		<-s.rx
+	}
+	if err := s.functional.Send(req); err != nil {
+		return nil, err
+	}
+
+	var responses []Response
+	pending := map[gocan.MessageID]bool{}
+	timer := time.NewTimer(s.cfg.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return responses, nil
+		case r := <-s.rx:
+			if len(r.Data) >= 3 && r.Data[0] == NegativeResponse && r.Data[1] == req[0] && r.Data[2] == 0x78 {
+				pending[r.ID] = true
+				timer.Reset(s.cfg.PendingTimeout)
+				continue
+			}
+			responses = append(responses, r)
+			if delete(pending, r.ID); len(pending) == 0 {
+				// other ECUs had the normal timeout since the request
+				timer.Reset(s.cfg.Timeout)
+			}
+		}
+	}
+}
+
+// Returns the data following the echoed request of positive responses per ECU, ErrNoResponse if there are none
+func (s *Scanner) query(ctx context.Context, req ...byte) (map[gocan.MessageID][]byte, error) {
+	responses, err := s.Request(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	echo := append([]byte{req[0] + PositiveResponse}, req[1:]...)
+	results := make(map[gocan.MessageID][]byte)
+	for _, r := range responses {
+		if bytes.HasPrefix(r.Data, echo) {
+			results[r.ID] = r.Data[len(echo):]
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: request % X", ErrNoResponse, req)
+	}
+	return results, nil
+}
+
+// Returns the response ids of all ECUs supporting OBD-II services, sorted
+func (s *Scanner) Discover(ctx context.Context) ([]gocan.MessageID, error) {
+	results, err := s.query(ctx, ShowCurrentData, 0x00)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(results)), nil
+}
+
+// Returns the supported PIDs of service 01 or info types of service 09 per ECU
+// The bitmaps of PID 00, 20, 40 and so on are requested as long as an ECU flags the next bitmap as supported.
+func (s *Scanner) SupportedPIDs(ctx context.Context, service uint8) (map[gocan.MessageID][]uint8, error) {
+	supported := make(map[gocan.MessageID][]uint8)
+	next := map[gocan.MessageID]bool{}
+	for base := 0; base <= 0xE0; base += 0x20 {
+		results, err := s.query(ctx, service, uint8(base))
+		if err != nil {
+			if base > 0 && errors.Is(err, ErrNoResponse) {
+				break
+			}
+			return nil, err
+		}
+		more := false
+		for id, bitmap := range results {
+			if base > 0 && !next[id] {
+				continue
+			}
+			pids := DecodeSupported(uint8(base), bitmap)
+			supported[id] = append(supported[id], pids...)
+			next[id] = slices.Contains(pids, uint8(base+0x20))
+			more = more || next[id]
+		}
+		if !more {
+			break
+		}
+	}
+	return supported, nil
+}
+
+// Reads a service 01 PID and decodes it per ECU
+func (s *Scanner) ReadPID(ctx context.Context, pid uint8) (map[gocan.MessageID][]Value, error) {
+	if _, ok := pids[pid]; !ok {
+		return nil, fmt.Errorf("%w: 0x%02X", ErrUnknownPID, pid)
+	}
+	results, err := s.query(ctx, ShowCurrentData, pid)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[gocan.MessageID][]Value)
+	for id, data := range results {
+		v, err := DecodePID(pid, data)
+		if err != nil {
+			return nil, fmt.Errorf("ECU 0x%X: %w", uint32(id), err)
+		}
+		values[id] = v
+	}
+	return values, nil
+}
+
+// Reads the DTCs of service 03 (stored), 07 (pending) or 0A (permanent) per ECU
+func (s *Scanner) ReadDTCs(ctx context.Context, service uint8) (map[gocan.MessageID][]DTC, error) {
+	results, err := s.query(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	dtcs := make(map[gocan.MessageID][]DTC)
+	for id, data := range results {
+		// number of DTCs followed by two bytes per DTC
+		if len(data) < 1 || len(data) < 1+2*int(data[0]) {
+			return nil, fmt.Errorf("%w: ECU 0x%X: % X", ErrLength, uint32(id), data)
+		}
+		list := make([]DTC, 0, data[0])
+		for i := 0; i < int(data[0]); i++ {
+			list = append(list, DTC(uint16(data[1+2*i])<<8|uint16(data[2+2*i])))
+		}
+		dtcs[id] = list
+	}
+	return dtcs, nil
+}
+
+// Reads the stored DTCs of service 03 per ECU
+func (s *Scanner) StoredDTCs(ctx context.Context) (map[gocan.MessageID][]DTC, error) {
+	return s.ReadDTCs(ctx, ShowStoredDTCs)
+}
+
+// Reads the pending DTCs of service 07 per ECU
+func (s *Scanner) PendingDTCs(ctx context.Context) (map[gocan.MessageID][]DTC, error) {
+	return s.ReadDTCs(ctx, ShowPendingDTCs)
+}
+
+// Reads the permanent DTCs of service 0A per ECU
+func (s *Scanner) PermanentDTCs(ctx context.Context) (map[gocan.MessageID][]DTC, error) {
+	return s.ReadDTCs(ctx, ShowPermanentDTCs)
+}
+
+// Reads a service 09 info type per ECU, returns the data items following the number of data items
+func (s *Scanner) VehicleInfo(ctx context.Context, infoType uint8) (map[gocan.MessageID][]byte, error) {
+	results, err := s.query(ctx, VehicleInformation, infoType)
+	if err != nil {
+		return nil, err
+	}
+	for id, data := range results {
+		if len(data) < 1 {
+			return nil, fmt.Errorf("%w: ECU 0x%X: no data items", ErrLength, uint32(id))
+		}
+		results[id] = data[1:]
+	}
+	return results, nil
+}
+
+// Reads the vehicle identification number per ECU
+func (s *Scanner) VIN(ctx context.Context) (map[gocan.MessageID]string, error) {
+	results, err := s.VehicleInfo(ctx, InfoTypeVIN)
+	if err != nil {
+		return nil, err
+	}
+	vins := make(map[gocan.MessageID]string)
+	for id, data := range results {
+		vins[id] = string(bytes.Trim(data, "\x00 "))
+	}
+	return vins, nil
+}
+
+// Reads the calibration ids per ECU, every id has 16 bytes padded with zeros
+func (s *Scanner) CalibrationIDs(ctx context.Context) (map[gocan.MessageID][]string, error) {
+	results, err := s.VehicleInfo(ctx, InfoTypeCalibrationIDs)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[gocan.MessageID][]string)
+	for id, data := range results {
+		if len(data)%16 != 0 {
+			return nil, fmt.Errorf("%w: ECU 0x%X: calibration ids of %v bytes", ErrLength, uint32(id), len(data))
+		}
+		for ; len(data) > 0; data = data[16:] {
+			ids[id] = append(ids[id], string(bytes.TrimRight(data[:16], "\x00")))
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,215 @@
+package test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/obd"
+)
+
+const auxVIN = "1G1JC5444R7252367"
+
+// Responses of a simulated ECU by request in hex, a leading "+" delays the response after a response pending answer
+type auxResponses map[string]string
+
+var auxEngine = auxResponses{
+	"0100": "4100BE1FA813",
+	"0120": "412000000001",
+	"0140": "414040000000",
+	"010C": "410C1AF8",
+	"0105": "41057B",
+	"010D": "+410D32",
+	"03":   "430201 33C123",
+	"07":   "4700",
+	"0A":   "4A010001",
+	"0900": "490054000000",
+	"0902": "490201" + hex.EncodeToString([]byte(auxVIN)),
+	"0904": "490402" + hex.EncodeToString([]byte("CAL-ENGINE-0001\x00")) + hex.EncodeToString([]byte("CAL-OBD-02\x00\x00\x00\x00\x00\x00")),
+}
+
+var auxTransmission = auxResponses{
+	"0100": "410080000000",
+	"0105": "41058C",
+	"03":   "4300",
+}
+
+func auxBus(t *testing.T) gocan.Bus {
+	bus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name()})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+// Starts an ECU answering physical requests on rx and functional requests on functional with tx
+func auxECU(t *testing.T, tx, rx, functional gocan.MessageID, extended bool, responses auxResponses) {
+	mux := isotp.NewMux(auxBus(t))
+	t.Cleanup(func() { mux.Close() })
+	physical, err := mux.Open(isotp.Address{TxID: tx, RxID: rx, IsExtended: extended}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fn, err := mux.Open(isotp.Address{TxID: tx, RxID: functional, IsExtended: extended}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range []*isotp.Conn{physical, fn} {
+		go func() {
+			for {
+				req, err := c.Recv(context.Background())
+				if err != nil {
+					return
+				}
+				resp, ok := responses[strings.ToUpper(hex.EncodeToString(req))]
+				if !ok {
+					continue
+				}
+				if strings.HasPrefix(resp, "+") {
+					physical.Send([]byte{0x7F, req[0], 0x78})
+					time.Sleep(150 * time.Millisecond)
+					resp = resp[1:]
+				}
+				data, _ := hex.DecodeString(strings.ReplaceAll(resp, " ", ""))
+				physical.Send(data)
+			}
+		}()
+	}
+}
+
+func auxScanner(t *testing.T, cfg *obd.Config) *obd.Scanner {
+	s, err := obd.NewScanner(auxBus(t), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func auxContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestScanner(t *testing.T) {
+	auxECU(t, 0x7E8, 0x7E0, obd.FunctionalID, false, auxEngine)
+	auxECU(t, 0x7E9, 0x7E1, obd.FunctionalID, false, auxTransmission)
+	s := auxScanner(t, nil)
+	ctx := auxContext(t)
+
+	if ecus, err := s.Discover(ctx); err != nil || !slices.Equal(ecus, []gocan.MessageID{0x7E8, 0x7E9}) {
+		t.Errorf("got %v, %v", ecus, err)
+	}
+
+	supported, err := s.SupportedPIDs(ctx, obd.ShowCurrentData)
+	expected := []uint8{0x01, 0x03, 0x04, 0x05, 0x06, 0x07, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11, 0x13, 0x15, 0x1C, 0x1F, 0x20, 0x40, 0x42}
+	if err != nil || !slices.Equal(supported[0x7E8], expected) || !slices.Equal(supported[0x7E9], []uint8{0x01}) {
+		t.Errorf("got %X, %v, expected engine %X", supported, err, expected)
+	}
+	if info, err := s.SupportedPIDs(ctx, obd.VehicleInformation); err != nil || !slices.Equal(info[0x7E8], []uint8{0x02, 0x04, 0x06}) {
+		t.Errorf("got %X, %v", info, err)
+	}
+
+	if values, err := s.ReadPID(ctx, 0x0C); err != nil || len(values) != 1 || values[0x7E8][0] != (obd.Value{Name: "Engine speed", Value: 1726, Unit: "rpm"}) {
+		t.Errorf("got %v, %v", values, err)
+	}
+	if values, err := s.ReadPID(ctx, 0x05); err != nil || values[0x7E8][0].Value != 83 || values[0x7E9][0].Value != 100 {
+		t.Errorf("got %v, %v", values, err)
+	}
+	// response pending beyond the timeout
+	if values, err := s.ReadPID(ctx, 0x0D); err != nil || values[0x7E8][0].Value != 50 {
+		t.Errorf("got %v, %v", values, err)
+	}
+	if _, err := s.ReadPID(ctx, 0x46); !errors.Is(err, obd.ErrNoResponse) {
+		t.Errorf("expected ErrNoResponse, got %v", err)
+	}
+	if _, err := s.ReadPID(ctx, 0xFE); !errors.Is(err, obd.ErrUnknownPID) {
+		t.Errorf("expected ErrUnknownPID, got %v", err)
+	}
+
+	dtcs, err := s.StoredDTCs(ctx)
+	if err != nil || len(dtcs[0x7E9]) != 0 || !slices.Equal(dtcs[0x7E8], []obd.DTC{0x0133, 0xC123}) {
+		t.Errorf("got %v, %v", dtcs, err)
+	}
+	if dtcs[0x7E8][0].String() != "P0133" || dtcs[0x7E8][1].String() != "U0123" {
+		t.Errorf("got %v", dtcs[0x7E8])
+	}
+	if dtcs, err := s.PendingDTCs(ctx); err != nil || len(dtcs[0x7E8]) != 0 {
+		t.Errorf("got %v, %v", dtcs, err)
+	}
+	if dtcs, err := s.PermanentDTCs(ctx); err != nil || !slices.Equal(dtcs[0x7E8], []obd.DTC{0x0001}) {
+		t.Errorf("got %v, %v", dtcs, err)
+	}
+
+	if vins, err := s.VIN(ctx); err != nil || len(vins) != 1 || vins[0x7E8] != auxVIN {
+		t.Errorf("got %v, %v", vins, err)
+	}
+	if cals, err := s.CalibrationIDs(ctx); err != nil || !slices.Equal(cals[0x7E8], []string{"CAL-ENGINE-0001", "CAL-OBD-02"}) {
+		t.Errorf("got %q, %v", cals, err)
+	}
+}
+
+func TestScannerExtended(t *testing.T) {
+	auxECU(t, obd.PhysicalResponse29|0x10, obd.PhysicalRequest29|0x10<<8, obd.FunctionalID29, true, auxEngine)
+	s := auxScanner(t, &obd.Config{Extended: true})
+	ctx := auxContext(t)
+
+	if ecus, err := s.Discover(ctx); err != nil || !slices.Equal(ecus, []gocan.MessageID{0x18DAF110}) {
+		t.Errorf("got %X, %v", ecus, err)
+	}
+	if vins, err := s.VIN(ctx); err != nil || vins[0x18DAF110] != auxVIN {
+		t.Errorf("got %v, %v", vins, err)
+	}
+}
+
+func TestDecodePID(t *testing.T) {
+	tests := []struct {
+		pid      uint8
+		data     []byte
+		expected []obd.Value
+	}{
+		{0x01, []byte{0x83, 0x07, 0x65, 0x04}, []obd.Value{{Name: "MIL", Value: 1}, {Name: "DTC count", Value: 3}}},
+		{0x04, []byte{0xFF}, []obd.Value{{Name: "Calculated engine load", Value: 100, Unit: "%"}}},
+		{0x06, []byte{0x00}, []obd.Value{{Name: "Short term fuel trim bank 1", Value: -100, Unit: "%"}}},
+		{0x0E, []byte{0x80}, []obd.Value{{Name: "Timing advance", Value: 0, Unit: "° before TDC"}}},
+		{0x10, []byte{0x01, 0x2C}, []obd.Value{{Name: "Mass air flow rate", Value: 3, Unit: "g/s"}}},
+		{0x14, []byte{0x64, 0x80}, []obd.Value{{Name: "Oxygen sensor 1 voltage", Value: 0.5, Unit: "V"}, {Name: "Oxygen sensor 1 short term fuel trim", Value: 0, Unit: "%"}}},
+		{0x42, []byte{0x36, 0xB0}, []obd.Value{{Name: "Control module voltage", Value: 14, Unit: "V"}}},
+	}
+	for _, tt := range tests {
+		values, err := obd.DecodePID(tt.pid, tt.data)
+		if err != nil || len(values) != len(tt.expected) {
+			t.Errorf("PID 0x%02X: got %v, %v, expected %v", tt.pid, values, err, tt.expected)
+			continue
+		}
+		for i, v := range values {
+			if v.Name != tt.expected[i].Name || v.Unit != tt.expected[i].Unit || math.Abs(v.Value-tt.expected[i].Value) > 1e-9 {
+				t.Errorf("PID 0x%02X: got %v, expected %v", tt.pid, v, tt.expected[i])
+			}
+		}
+	}
+	if _, err := obd.DecodePID(0x0C, []byte{0x1A}); !errors.Is(err, obd.ErrLength) {
+		t.Errorf("expected ErrLength, got %v", err)
+	}
+	if name, ok := obd.PIDName(0x0D); !ok || name != "Vehicle speed" {
+		t.Errorf("got %q, %v", name, ok)
+	}
+	for _, tt := range []struct {
+		dtc      obd.DTC
+		expected string
+	}{{0x0133, "P0133"}, {0x4123, "C0123"}, {0x9234, "B1234"}, {0xFFFF, "U3FFF"}} {
+		if s := tt.dtc.String(); s != tt.expected {
+			t.Errorf("got %v, expected %v", s, tt.expected)
+		}
+	}
+}
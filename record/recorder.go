@@ -0,0 +1,501 @@
+package record
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs"
+)
+
+// Time messages are held back to write messages of multiple buses ordered by timestamp
+var DefaultReorderWindow = 100 * time.Millisecond
+
+// Suffix of files which are still being written, files are renamed to their final name once they are complete
+const PartSuffix = ".part"
+
+// Texts of the markers written into formats supporting comments
+const (
+	MarkerStart     = "gocan recording started"
+	MarkerStop      = "gocan recording stopped"
+	MarkerContinued = "gocan recording continued from previous file"
+	MarkerRotated   = "gocan recording continued in next file"
+)
+
+// errors
+var ErrNoBus = errors.New("no bus attached to the recorder")
+
+// Counters of a recording
+type Stats struct {
+	Messages uint64   // written messages
+	Overruns uint64   // overrun errors reported by the recorded buses
+	Files    []string // finalized files in order of creation
+}
+
+// Recorder writes the traffic of one or multiple buses into log files of any format registered in package logs
+// Files are written with PartSuffix and renamed when they are finalized, so a finished file is never incomplete
+type Recorder struct {
+	MaxSize       int64             // rotates after a file reached this size in bytes, zero for no limit; only bytes flushed to the file count, so it is exceeded by up to the data buffered by the writer and compression
+	MaxDuration   time.Duration     // rotates after a file has been open for this time, also without traffic, zero for no limit
+	MaxMessages   uint64            // rotates after a file holds this amount of messages, zero for no limit
+	ReorderWindow time.Duration     // messages are held back for this time to order messages of multiple buses by timestamp, zero writes in order of arrival
+	Markers       bool              // writes start, stop and rotation markers as comments if the format supports them
+	OnFile        func(path string) // called with the final path of every finalized file, nil to disable
+
+	path    string
+	sources []source
+
+	mu    sync.Mutex
+	stats Stats
+
+	// state of the running recording, only used by the writing goroutine
+	file     *file
+	index    int
+	lastTime uint64 // timestamp of the last written message in the time base of the buses
+	pending  queue
+	seq      uint64
+}
+
+// Receiver recorded under a channel name
+type source struct {
+	rx      gocan.Receiver
+	channel string
+}
+
+// Creates a recorder writing into path, whose extensions select the log format and compression, e.g. "drive.blf" or "drive.asc.gz"
+// If any rotation limit is set, files are numbered by inserting the index before the extensions, e.g. "drive_0001.asc.gz"
+func NewRecorder(path string) *Recorder {
+	return &Recorder{path: path, ReorderWindow: DefaultReorderWindow, Markers: true}
+}
+
+// Adds a bus to the recording, must be called before Run()
+// Messages of all buses are ordered by their timestamps, so all buses must use the same time base, usually µs since unix epoch
+// channel: written as channel of all messages of rx, empty to keep the channel set by the bus
+func (r *Recorder) Attach(rx gocan.Receiver, channel string) {
+	r.sources = append(r.sources, source{rx: rx, channel: channel})
+}
+
+// Returns the current counters, may be called while recording
+func (r *Recorder) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats
+	stats.Files = append([]string(nil), r.stats.Files...)
+	return stats
+}
+
+// Returns true if files are rotated
+func (r *Recorder) rotates() bool {
+	return r.MaxSize > 0 || r.MaxDuration > 0 || r.MaxMessages > 0
+}
+
+// Returns the path of the file with the given index, indexes start at 1
+func (r *Recorder) fileName(index int) string {
+	if !r.rotates() {
+		return r.path
+	}
+	return indexedName(r.path, index)
+}
+
+// Inserts the index before the extensions of path, e.g. "drive_0001.asc.gz"
+func indexedName(path string, index int) string {
+	dir, base := filepath.Split(path)
+	ext := ""
+	for _, c := range logs.Compressions() {
+		if c.Extension != "" && strings.HasSuffix(strings.ToLower(base), c.Extension) {
+			ext = base[len(base)-len(c.Extension):]
+			base = base[:len(base)-len(c.Extension)]
+			break
+		}
+	}
+	ext = filepath.Ext(base) + ext
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Join(dir, fmt.Sprintf("%s_%04d%s", base, index, ext))
+}
+
+// Records all attached buses until ctx is cancelled or all buses reported an error, the last file is finalized before returning
+// Overrun errors of a bus are counted and recording continues, any other error ends the recording of this bus
+// A cancelled context ends the recording without an error
+func (r *Recorder) Run(ctx context.Context) error {
+	if len(r.sources) == 0 {
+		return ErrNoBus
+	}
+	if err := r.open(MarkerStart); err != nil {
+		return err
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in, errs := startSources(readCtx, r.sources, r.countOverrun)
+
+	var tick <-chan time.Time
+	if r.ReorderWindow > 0 {
+		ticker := time.NewTicker(max(r.ReorderWindow/4, time.Millisecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// files reaching MaxDuration are rotated without waiting for the next message
+	var rotate <-chan time.Time
+	var rotateTimer *time.Timer
+	if r.MaxDuration > 0 {
+		rotateTimer = time.NewTimer(r.MaxDuration)
+		defer rotateTimer.Stop()
+		rotate = rotateTimer.C
+	}
+
+	var errResult error
+loop:
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				// all buses are done
+				in = nil
+				break loop
+			}
+			r.push(msg, time.Now())
+			errResult = r.flush(r.ReorderWindow)
+		case <-tick:
+			errResult = r.flush(r.ReorderWindow)
+		case <-rotate:
+			if errResult = r.flush(r.ReorderWindow); errResult == nil {
+				errResult = r.rotate()
+			}
+			if errResult == nil {
+				rotateTimer.Reset(max(time.Until(r.file.opened.Add(r.MaxDuration)), time.Millisecond))
+			}
+		case <-ctx.Done():
+			break loop
+		}
+		if errResult != nil {
+			break
+		}
+	}
+	cancel()
+
+	// drain messages received before the end of the recording
+	if in != nil {
+		for msg := range in {
+			r.push(msg, time.Now())
+		}
+	}
+	if errResult == nil {
+		errResult = r.flush(0)
+	}
+	if r.file != nil {
+		if err := r.close(MarkerStop); errResult == nil {
+			errResult = err
+		}
+	}
+
+	var busErrs []error
+	for range r.sources {
+		if err := <-errs; err != nil {
+			busErrs = append(busErrs, err)
+		}
+	}
+	if errResult == nil {
+		errResult = errors.Join(busErrs...)
+	}
+	return errResult
+}
+
+// Counts an overrun error of a bus
+func (r *Recorder) countOverrun() {
+	r.mu.Lock()
+	r.stats.Overruns++
+	r.mu.Unlock()
+}
+
+// Starts reading all sources, in is closed once all sources are done and errs receives the result of every source
+func startSources(ctx context.Context, sources []source, overrun func()) (<-chan *gocan.Message, <-chan error) {
+	in := make(chan *gocan.Message, 256)
+	errs := make(chan error, len(sources))
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- read(ctx, src, in, overrun)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+	return in, errs
+}
+
+// Forwards copies of all messages of a bus until ctx is cancelled or the bus reports an error
+func read(ctx context.Context, src source, in chan<- *gocan.Message, overrun func()) error {
+	for msg, err := range gocan.Messages(ctx, src.rx) {
+		if errors.Is(err, gocan.ErrRxOverrun) {
+			overrun()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		out := new(gocan.Message)
+		*out = *msg
+		out.SetData(msg.Data)
+		if src.channel != "" {
+			out.Channel = src.channel
+		}
+		select {
+		case in <- out:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// Adds a message to the pending messages
+func (r *Recorder) push(msg *gocan.Message, arrival time.Time) {
+	r.seq++
+	heap.Push(&r.pending, &entry{msg: msg, arrival: arrival, seq: r.seq})
+}
+
+// Writes all pending messages which arrived at least window ago, ordered by timestamp
+func (r *Recorder) flush(window time.Duration) error {
+	for r.pending.Len() > 0 {
+		next := r.pending[0]
+		if window > 0 && time.Since(next.arrival) < window {
+			return nil
+		}
+		heap.Pop(&r.pending)
+		if err := r.write(next.msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalizes the current file and opens the next one if a rotation limit is reached
+func (r *Recorder) rotate() error {
+	if !r.file.full(r) {
+		return nil
+	}
+	if err := r.close(MarkerRotated); err != nil {
+		return err
+	}
+	return r.open(MarkerContinued)
+}
+
+// Writes a message and rotates the file beforehand if a limit is reached
+func (r *Recorder) write(msg *gocan.Message) error {
+	if err := r.rotate(); err != nil {
+		return err
+	}
+
+	if r.file.marker != "" {
+		// markers use the time base of the bus, which may differ from the system time
+		if err := r.file.comment(msg.TimeStamp, r.file.marker); err != nil {
+			return err
+		}
+		r.file.marker = ""
+	}
+	if err := r.file.lw.WriteMessage(msg); err != nil {
+		return err
+	}
+	r.file.messages++
+	r.lastTime = msg.TimeStamp
+
+	r.mu.Lock()
+	r.stats.Messages++
+	r.mu.Unlock()
+	return nil
+}
+
+// Opens the next file, the marker is written in front of the first message
+func (r *Recorder) open(marker string) error {
+	r.index++
+	f, err := createFile(r.fileName(r.index))
+	if err != nil {
+		return err
+	}
+	r.file = f
+	if r.Markers {
+		f.marker = marker
+	}
+	return nil
+}
+
+// Writes the marker, finalizes the current file and renames it to its final name
+func (r *Recorder) close(marker string) error {
+	f := r.file
+	r.file = nil
+
+	// markers keep the time base of the buses, which may not be the system time, e.g. for relative timestamps
+	// only markers written before any message was received use the system time
+	timeStamp := r.lastTime
+	if timeStamp == 0 && r.pending.Len() > 0 {
+		timeStamp = r.pending[0].msg.TimeStamp
+	} else if timeStamp == 0 {
+		timeStamp = uint64(time.Now().UnixMicro())
+	}
+	var err error
+	if f.marker != "" {
+		err = f.comment(timeStamp, f.marker)
+	}
+	if r.Markers && err == nil {
+		err = f.comment(timeStamp, marker)
+	}
+	if err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.close(); err != nil {
+		os.Remove(f.path + PartSuffix)
+		return err
+	}
+
+	r.mu.Lock()
+	r.stats.Files = append(r.stats.Files, f.path)
+	r.mu.Unlock()
+	if r.OnFile != nil {
+		r.OnFile(f.path)
+	}
+	return nil
+}
+
+// Log file which is written under a temporary name
+type file struct {
+	path     string
+	f        *os.File
+	counter  *counter
+	buf      *bufio.Writer // nil for formats requiring a seekable writer
+	lw       logs.LogWriter
+	opened   time.Time
+	marker   string // marker written in front of the first message
+	messages uint64
+}
+
+// Creates path with PartSuffix and a log writer for the format given by the extensions of path
+func createFile(path string) (*file, error) {
+	f, err := os.Create(path + PartSuffix)
+	if err != nil {
+		return nil, err
+	}
+	c := &counter{f: f}
+
+	fl := &file{path: path, f: f, counter: c, opened: time.Now()}
+	fl.buf = bufio.NewWriter(c)
+	fl.lw, err = logs.NewWriter(fl.buf, path)
+	if errors.Is(err, logs.ErrNotSeekable) {
+		// formats requiring a seekable writer are written unbuffered
+		fl.buf = nil
+		fl.lw, err = logs.NewWriter(c, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path + PartSuffix)
+		return nil, err
+	}
+	return fl, nil
+}
+
+// Returns true if a rotation limit of r is reached
+func (f *file) full(r *Recorder) bool {
+	return (r.MaxSize > 0 && f.counter.size >= r.MaxSize) ||
+		(r.MaxDuration > 0 && time.Since(f.opened) >= r.MaxDuration) ||
+		(r.MaxMessages > 0 && f.messages >= r.MaxMessages)
+}
+
+// Writes a comment if the format supports comments
+func (f *file) comment(timeStamp uint64, text string) error {
+	c, ok := f.lw.(logs.Commenter)
+	if !ok {
+		return nil
+	}
+	if err := c.WriteComment(timeStamp, text); err != nil && !errors.Is(err, gocan.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// Finishes the log, syncs and closes the file and renames it to its final name
+func (f *file) close() error {
+	err := f.lw.Close()
+	if f.buf != nil {
+		if errFlush := f.buf.Flush(); err == nil {
+			err = errFlush
+		}
+	}
+	if errSync := f.f.Sync(); err == nil {
+		err = errSync
+	}
+	if errClose := f.f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.path+PartSuffix, f.path)
+}
+
+// Closes and removes the unfinished file after an error
+func (f *file) abort() {
+	f.lw.Close()
+	f.f.Close()
+	os.Remove(f.path + PartSuffix)
+}
+
+// Writer counting the size of a file, seeking is passed through for formats patching their header
+type counter struct {
+	f    *os.File
+	pos  int64
+	size int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.f.Write(p)
+	c.pos += int64(n)
+	c.size = max(c.size, c.pos)
+	return n, err
+}
+
+func (c *counter) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.f.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+// Pending message waiting for the reorder window
+type entry struct {
+	msg     *gocan.Message
+	arrival time.Time
+	seq     uint64 // keeps the order of arrival for equal timestamps
+}
+
+// Min-heap of pending messages ordered by timestamp
+type queue []*entry
+
+func (q queue) Len() int { return len(q) }
+func (q queue) Less(i, j int) bool {
+	if q[i].msg.TimeStamp != q[j].msg.TimeStamp {
+		return q[i].msg.TimeStamp < q[j].msg.TimeStamp
+	}
+	return q[i].seq < q[j].seq
+}
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x any)   { *q = append(*q, x.(*entry)) }
+func (q *queue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,397 @@
+package record
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Name of the trigger fired by RingRecorder.Fire() without a name
+const ManualTrigger = "manual"
+
+// SocketCAN error class of a bus-off error frame, set in the id of error frames
+const ErrClassBusOff = 0x40
+
+// Interval in which the status of attached buses is polled for status triggers if StatusInterval is not set
+var DefaultStatusInterval = 100 * time.Millisecond
+
+// Trigger starts a capture when Match returns true for a received message or Status returns true for the status of an attached bus
+type Trigger struct {
+	Name   string // written into the capture marker
+	Match  func(msg *gocan.Message) bool
+	Status func(status uint32) bool // evaluated on the status of buses implementing gocan.StatusReporter, fires once per change to true, nil to disable
+}
+
+// Returns a trigger matching data and remote frames of the given ids
+// isExtended: ids are extended 29 bit ids, otherwise standard 11 bit ids
+func TriggerID(name string, isExtended bool, ids ...gocan.MessageID) Trigger {
+	return Trigger{Name: name, Match: func(msg *gocan.Message) bool {
+		return msg.Type != gocan.ErrorFrame && msg.IsExtended == isExtended && slices.Contains(ids, msg.ID)
+	}}
+}
+
+// Returns a trigger matching messages of the given id whose payload fulfills match, e.g. a signal exceeding a limit
+// isExtended: id is an extended 29 bit id, otherwise a standard 11 bit id
+func TriggerPayload(name string, id gocan.MessageID, isExtended bool, match func(data []byte) bool) Trigger {
+	return Trigger{Name: name, Match: func(msg *gocan.Message) bool {
+		return msg.Type != gocan.ErrorFrame && msg.ID == id && msg.IsExtended == isExtended && match(msg.Data)
+	}}
+}
+
+// Returns a trigger matching any error frame, requires buses configured with RecvErrorFrames
+func TriggerErrorFrame(name string) Trigger {
+	return Trigger{Name: name, Match: func(msg *gocan.Message) bool {
+		return msg.Type == gocan.ErrorFrame
+	}}
+}
+
+// Returns a trigger matching a status of an attached bus, e.g. a device specific error state
+func TriggerStatus(name string, match func(status uint32) bool) Trigger {
+	return Trigger{Name: name, Status: match}
+}
+
+// Returns a trigger matching error frames reporting bus-off with the SocketCAN error class ErrClassBusOff, as read from logs
+// busOffStatus: status bits of the device reporting bus-off, e.g. uint32(pcan.PCAN_ERROR_BUSOFF), matched on the polled bus status
+// Note: the virtual interface never reports bus-off
+func TriggerBusOff(name string, busOffStatus ...uint32) Trigger {
+	trigger := Trigger{Name: name, Match: func(msg *gocan.Message) bool {
+		return msg.Type == gocan.ErrorFrame && msg.ID&ErrClassBusOff != 0
+	}}
+	if len(busOffStatus) > 0 {
+		trigger.Status = func(status uint32) bool {
+			for _, bits := range busOffStatus {
+				if status&bits != 0 {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return trigger
+}
+
+// Finished capture of a RingRecorder
+type Capture struct {
+	Path      string
+	Trigger   string // name of the trigger
+	TimeStamp uint64 // timestamp of the trigger in µs, the time of the triggering message or of the latest message for manual triggers
+	Messages  int    // messages written into the capture
+}
+
+// Capture which is still collecting its post-trigger window
+type activeCapture struct {
+	trigger   string
+	timeStamp uint64
+	deadline  time.Time
+	msgs      []*gocan.Message
+}
+
+// Message held in the pre-trigger window
+type ringEntry struct {
+	msg     *gocan.Message
+	arrival time.Time
+}
+
+// RingRecorder keeps the latest traffic of one or multiple buses in memory and writes it into a separate log file
+// whenever a trigger fires, each file holds the pre-trigger window and the post-trigger window ordered by timestamp
+// A trigger does not fire again while its previous capture is still collecting the post-trigger window
+type RingRecorder struct {
+	Pre            time.Duration // time of traffic before a trigger written into a capture
+	Post           time.Duration // time of traffic after a trigger written into a capture
+	Triggers       []Trigger
+	Markers        bool                  // writes a marker comment at the trigger position if the format supports comments
+	OnCapture      func(capture Capture) // called for every finished capture, nil to disable
+	StatusInterval time.Duration         // interval polling the bus status for status triggers, default DefaultStatusInterval
+
+	path    string
+	sources []source
+
+	mu       sync.Mutex
+	ring     []ringEntry // pre-trigger window starting at head
+	head     int
+	active   []*activeCapture
+	captures []Capture
+	index    int
+	lastTime uint64
+	overruns uint64
+}
+
+// Creates a ring recorder writing captures into numbered files of path, whose extensions select the log format and
+// compression, e.g. "fault.blf" creates "fault_0001.blf", "fault_0002.blf", ...
+func NewRingRecorder(path string, pre time.Duration, post time.Duration, triggers ...Trigger) *RingRecorder {
+	return &RingRecorder{Pre: pre, Post: post, Triggers: triggers, Markers: true, path: path}
+}
+
+// Adds a bus to the recording, must be called before Run()
+// channel: written as channel of all messages of rx, empty to keep the channel set by the bus
+func (r *RingRecorder) Attach(rx gocan.Receiver, channel string) {
+	r.sources = append(r.sources, source{rx: rx, channel: channel})
+}
+
+// Returns all finished captures
+func (r *RingRecorder) Captures() []Capture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Capture(nil), r.captures...)
+}
+
+// Returns the overrun errors reported by the recorded buses
+func (r *RingRecorder) Overruns() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.overruns
+}
+
+// Fires a manual trigger, the capture is written once the post-trigger window is collected
+// name: written into the capture marker, ManualTrigger if empty
+func (r *RingRecorder) Fire(name string) {
+	if name == "" {
+		name = ManualTrigger
+	}
+	r.fire(name, time.Now())
+}
+
+// Starts a capture at the time of the latest message, or at the system time if no message was received yet
+func (r *RingRecorder) fire(name string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	timeStamp := r.lastTime
+	if timeStamp == 0 {
+		timeStamp = uint64(now.UnixMicro())
+	}
+	r.start(name, timeStamp, now)
+}
+
+// Starts a capture with a copy of the pre-trigger window
+// Note: r.mu must be locked
+func (r *RingRecorder) start(trigger string, timeStamp uint64, now time.Time) {
+	for _, c := range r.active {
+		if c.trigger == trigger {
+			return
+		}
+	}
+	c := &activeCapture{trigger: trigger, timeStamp: timeStamp, deadline: now.Add(r.Post)}
+	c.msgs = make([]*gocan.Message, 0, len(r.ring)-r.head)
+	for _, e := range r.ring[r.head:] {
+		if now.Sub(e.arrival) <= r.Pre {
+			c.msgs = append(c.msgs, e.msg)
+		}
+	}
+	r.active = append(r.active, c)
+}
+
+// Records all attached buses until ctx is cancelled or all buses reported an error
+// Captures still collecting their post-trigger window are written with the traffic received until then
+// A cancelled context ends the recording without an error
+func (r *RingRecorder) Run(ctx context.Context) error {
+	if len(r.sources) == 0 {
+		return ErrNoBus
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in, errs := startSources(readCtx, r.sources, func() {
+		r.mu.Lock()
+		r.overruns++
+		r.mu.Unlock()
+	})
+
+	ticker := time.NewTicker(max(min(r.Post/10, 100*time.Millisecond), time.Millisecond))
+	defer ticker.Stop()
+
+	var statusTick <-chan time.Time
+	statusMatched := make(map[[2]int]bool) // result of the status trigger per source and trigger index
+	if r.pollsStatus() {
+		interval := r.StatusInterval
+		if interval <= 0 {
+			interval = DefaultStatusInterval
+		}
+		statusTicker := time.NewTicker(interval)
+		defer statusTicker.Stop()
+		statusTick = statusTicker.C
+	}
+
+	var errResult error
+loop:
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				in = nil
+				break loop
+			}
+			r.add(msg, time.Now())
+		case <-ticker.C:
+			errResult = r.finish(time.Now(), false)
+		case <-statusTick:
+			r.checkStatus(statusMatched, time.Now())
+		case <-ctx.Done():
+			break loop
+		}
+		if errResult != nil {
+			break
+		}
+	}
+	cancel()
+
+	if in != nil {
+		for msg := range in {
+			r.add(msg, time.Now())
+		}
+	}
+	if err := r.finish(time.Now(), true); errResult == nil {
+		errResult = err
+	}
+
+	var busErrs []error
+	for range r.sources {
+		if err := <-errs; err != nil {
+			busErrs = append(busErrs, err)
+		}
+	}
+	if errResult == nil {
+		errResult = errors.Join(busErrs...)
+	}
+	return errResult
+}
+
+// Returns true if a status trigger is set and an attached bus reports its status
+func (r *RingRecorder) pollsStatus() bool {
+	hasTrigger := slices.ContainsFunc(r.Triggers, func(t Trigger) bool { return t.Status != nil })
+	hasReporter := slices.ContainsFunc(r.sources, func(s source) bool {
+		_, ok := s.rx.(gocan.StatusReporter)
+		return ok
+	})
+	return hasTrigger && hasReporter
+}
+
+// Evaluates the status triggers on the status of all attached buses, a trigger fires when its result changes to true
+// The status is evaluated even if Status() returns an error, as devices like PCAN report bus errors this way
+func (r *RingRecorder) checkStatus(matched map[[2]int]bool, now time.Time) {
+	for i, src := range r.sources {
+		reporter, ok := src.rx.(gocan.StatusReporter)
+		if !ok {
+			continue
+		}
+		status, _ := reporter.Status()
+		for j, trigger := range r.Triggers {
+			if trigger.Status == nil {
+				continue
+			}
+			match := trigger.Status(status)
+			if match && !matched[[2]int{i, j}] {
+				r.fire(trigger.Name, now)
+			}
+			matched[[2]int{i, j}] = match
+		}
+	}
+}
+
+// Adds a received message to the pre-trigger window and all active captures and evaluates the triggers
+func (r *RingRecorder) add(msg *gocan.Message, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastTime = msg.TimeStamp
+	r.ring = append(r.ring, ringEntry{msg: msg, arrival: now})
+	for r.head < len(r.ring) && now.Sub(r.ring[r.head].arrival) > r.Pre {
+		r.ring[r.head] = ringEntry{}
+		r.head++
+	}
+	if r.head > len(r.ring)/2 {
+		r.ring = append(r.ring[:0], r.ring[r.head:]...)
+		r.head = 0
+	}
+
+	for _, c := range r.active {
+		c.msgs = append(c.msgs, msg)
+	}
+	for _, trigger := range r.Triggers {
+		if trigger.Match != nil && trigger.Match(msg) {
+			// the pre-trigger window already holds the triggering message
+			r.start(trigger.Name, msg.TimeStamp, now)
+		}
+	}
+}
+
+// Writes all captures whose post-trigger window is complete, or all captures if force is set
+func (r *RingRecorder) finish(now time.Time, force bool) error {
+	r.mu.Lock()
+	var done []*activeCapture
+	active := r.active[:0]
+	for _, c := range r.active {
+		if force || !now.Before(c.deadline) {
+			done = append(done, c)
+		} else {
+			active = append(active, c)
+		}
+	}
+	r.active = active
+	r.mu.Unlock()
+
+	var errResult error
+	for _, c := range done {
+		if err := r.write(c); errResult == nil {
+			errResult = err
+		}
+	}
+	return errResult
+}
+
+// Writes a capture into the next file
+func (r *RingRecorder) write(c *activeCapture) error {
+	r.mu.Lock()
+	r.index++
+	path := indexedName(r.path, r.index)
+	r.mu.Unlock()
+
+	// messages of multiple buses are ordered by timestamp
+	sort.SliceStable(c.msgs, func(i, j int) bool { return c.msgs[i].TimeStamp < c.msgs[j].TimeStamp })
+
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	marker := ""
+	if r.Markers {
+		marker = fmt.Sprintf("gocan capture triggered by %v", c.trigger)
+	}
+	for _, msg := range c.msgs {
+		if marker != "" && msg.TimeStamp >= c.timeStamp {
+			if err = f.comment(c.timeStamp, marker); err != nil {
+				break
+			}
+			marker = ""
+		}
+		if err = f.lw.WriteMessage(msg); err != nil {
+			break
+		}
+	}
+	if marker != "" && err == nil {
+		err = f.comment(c.timeStamp, marker)
+	}
+	if err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.close(); err != nil {
+		os.Remove(path + PartSuffix)
+		return err
+	}
+
+	capture := Capture{Path: path, Trigger: c.trigger, TimeStamp: c.timeStamp, Messages: len(c.msgs)}
+	r.mu.Lock()
+	r.captures = append(r.captures, capture)
+	r.mu.Unlock()
+	if r.OnCapture != nil {
+		r.OnCapture(capture)
+	}
+	return nil
+}
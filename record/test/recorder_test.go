@@ -0,0 +1,329 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/logs"
+	"github.com/morgadow/gocan/logs/asc"
+	"github.com/morgadow/gocan/record"
+)
+
+var errAuxBus = errors.New("bus failure")
+
+// Receiver returning the given messages, afterwards err or no message
+type auxBus struct {
+	mu   sync.Mutex
+	msgs []gocan.Message
+	err  error
+}
+
+func (b *auxBus) Recv(timeout int) (*gocan.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.msgs) > 0 {
+		msg := b.msgs[0]
+		b.msgs = b.msgs[1:]
+		return &msg, nil
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	time.Sleep(time.Duration(timeout) * time.Millisecond)
+	return nil, nil
+}
+
+func (b *auxBus) ReadBuffer(limit uint16) ([]gocan.Message, error) {
+	return nil, gocan.ErrNotSupported
+}
+
+func auxMessages(first, step uint64, n int) []gocan.Message {
+	start := uint64(time.Date(2024, 3, 1, 14, 0, 0, 0, time.UTC).UnixMicro())
+	msgs := make([]gocan.Message, n)
+	for i := range msgs {
+		msgs[i] = gocan.Message{ID: gocan.MessageID(0x100 + i), TimeStamp: start + first + uint64(i)*step, Data: []byte{byte(i), 0xAA}}
+	}
+	return msgs
+}
+
+// Runs the recorder until count messages are written
+func auxRecord(t *testing.T, rec *record.Recorder, count uint64) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rec.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.Stats().Messages < count && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	return <-done
+}
+
+func auxReadFile(t *testing.T, path string) []gocan.Message {
+	r, err := logs.Open(path)
+	if err != nil {
+		t.Fatalf("%v: unexpected error: %v", path, err)
+	}
+	defer r.Close()
+	var msgs []gocan.Message
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", path, err)
+		}
+		msgs = append(msgs, *msg)
+	}
+}
+
+func auxCheckNoParts(t *testing.T, dir string) {
+	parts, _ := filepath.Glob(filepath.Join(dir, "*"+record.PartSuffix))
+	if len(parts) > 0 {
+		t.Errorf("unfinished files left: %v", parts)
+	}
+}
+
+func TestMultipleBusesOrdered(t *testing.T) {
+	dir := t.TempDir()
+	busA := &auxBus{msgs: auxMessages(0, 20000, 5)}
+	busB := &auxBus{msgs: auxMessages(10000, 20000, 5)}
+
+	rec := record.NewRecorder(filepath.Join(dir, "drive.asc"))
+	rec.Attach(busA, "1")
+	rec.Attach(busB, "2")
+	if err := auxRecord(t, rec, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := rec.Stats().Files
+	if len(files) != 1 || files[0] != filepath.Join(dir, "drive.asc") {
+		t.Fatalf("got files %v, expected only drive.asc", files)
+	}
+	msgs := auxReadFile(t, files[0])
+	if len(msgs) != 10 {
+		t.Fatalf("got %v messages, expected 10", len(msgs))
+	}
+	for i := range msgs {
+		expected := "1"
+		if i%2 == 1 {
+			expected = "2"
+		}
+		if msgs[i].Channel != expected {
+			t.Errorf("message %v: got channel %v, expected %v", i, msgs[i].Channel, expected)
+		}
+		if i > 0 && msgs[i].TimeStamp <= msgs[i-1].TimeStamp {
+			t.Errorf("message %v not ordered by timestamp: %v after %v", i, msgs[i].TimeStamp, msgs[i-1].TimeStamp)
+		}
+	}
+
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), record.MarkerStart) || !strings.Contains(string(data), record.MarkerStop) {
+		t.Errorf("missing start or stop marker:\n%s", data)
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestRotationByCount(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.log.gz"))
+	rec.MaxMessages = 4
+	rec.ReorderWindow = 0
+	var finalized []string
+	rec.OnFile = func(path string) { finalized = append(finalized, path) }
+	rec.Attach(&auxBus{msgs: auxMessages(0, 1000, 10)}, "")
+	if err := auxRecord(t, rec, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"drive_0001.log.gz", "drive_0002.log.gz", "drive_0003.log.gz"}
+	files := rec.Stats().Files
+	if len(files) != len(expected) || len(finalized) != len(expected) {
+		t.Fatalf("got files %v, expected %v", files, expected)
+	}
+	id := gocan.MessageID(0x100)
+	for i, name := range expected {
+		if files[i] != filepath.Join(dir, name) || finalized[i] != files[i] {
+			t.Errorf("file %v: got %v, expected %v", i, files[i], name)
+		}
+		for _, msg := range auxReadFile(t, files[i]) {
+			if msg.ID != id {
+				t.Errorf("%v: got id 0x%X, expected 0x%X", name, msg.ID, id)
+			}
+			id++
+		}
+	}
+	if id != 0x10A {
+		t.Errorf("got %v messages, expected 10", id-0x100)
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestRotationBySize(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.trc"))
+	rec.MaxSize = 16 * 1024
+	rec.ReorderWindow = 0
+	rec.Attach(&auxBus{msgs: auxMessages(0, 1000, 2000)}, "")
+	if err := auxRecord(t, rec, 2000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := rec.Stats().Files
+	if len(files) < 3 {
+		t.Fatalf("expected several files, got %v", files)
+	}
+	total := 0
+	for i, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// size is exceeded by the buffered data only
+		if info.Size() > rec.MaxSize+16*1024 {
+			t.Errorf("%v: size %v exceeds limit", path, info.Size())
+		}
+		data, _ := os.ReadFile(path)
+		if i > 0 && !strings.Contains(string(data), record.MarkerContinued) {
+			t.Errorf("%v: missing continued marker", path)
+		}
+		if i < len(files)-1 && !strings.Contains(string(data), record.MarkerRotated) {
+			t.Errorf("%v: missing rotated marker", path)
+		}
+		total += len(auxReadFile(t, path))
+	}
+	if total != 2000 {
+		t.Errorf("got %v messages, expected 2000", total)
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestRotationByDurationWithoutTraffic(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.asc"))
+	rec.MaxDuration = 100 * time.Millisecond
+	rec.Attach(&auxBus{msgs: auxMessages(0, 1000, 2)}, "")
+
+	// the bus is quiet after two messages, files are rotated nevertheless
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	if err := rec.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := rec.Stats().Files
+	if len(files) < 3 {
+		t.Fatalf("expected rotated files without traffic, got %v", files)
+	}
+	total := 0
+	for _, path := range files {
+		total += len(auxReadFile(t, path))
+	}
+	if total != 2 {
+		t.Errorf("got %v messages, expected 2", total)
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestSeekableFormat(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.mf4"))
+	rec.MaxDuration = time.Hour
+	rec.Attach(&auxBus{msgs: auxMessages(0, 1000, 20)}, "")
+	if err := auxRecord(t, rec, 20); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := rec.Stats().Files
+	if len(files) != 1 || files[0] != filepath.Join(dir, "drive_0001.mf4") {
+		t.Fatalf("got files %v, expected drive_0001.mf4", files)
+	}
+	if msgs := auxReadFile(t, files[0]); len(msgs) != 20 {
+		t.Errorf("got %v messages, expected 20", len(msgs))
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestBusError(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.blf"))
+	rec.Attach(&auxBus{msgs: auxMessages(0, 1000, 3), err: errAuxBus}, "")
+
+	// the recording ends on its own once all buses failed
+	if err := rec.Run(context.Background()); !errors.Is(err, errAuxBus) {
+		t.Errorf("expected bus error, got %v", err)
+	}
+	if msgs := auxReadFile(t, filepath.Join(dir, "drive.blf")); len(msgs) != 3 {
+		t.Errorf("got %v messages, expected 3", len(msgs))
+	}
+
+	if err := record.NewRecorder(filepath.Join(dir, "empty.blf")).Run(context.Background()); !errors.Is(err, record.ErrNoBus) {
+		t.Errorf("expected ErrNoBus, got %v", err)
+	}
+}
+
+func TestRelativeTimeStamps(t *testing.T) {
+	dir := t.TempDir()
+	rec := record.NewRecorder(filepath.Join(dir, "drive.asc"))
+	rec.MaxDuration = 100 * time.Millisecond
+
+	// timestamps relative to the start of the bus instead of µs since unix epoch
+	msgs := make([]gocan.Message, 3)
+	for i := range msgs {
+		msgs[i] = gocan.Message{ID: 0x100, TimeStamp: 5000 + uint64(i)*1000, Data: []byte{byte(i)}}
+	}
+	rec.Attach(&auxBus{msgs: msgs}, "")
+
+	// the bus is quiet after three messages, the markers of the following empty files keep the time base of the bus
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	if err := rec.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := rec.Stats().Files
+	if len(files) < 3 {
+		t.Fatalf("expected rotated files without traffic, got %v", files)
+	}
+	var read []gocan.Message
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", path, err)
+		}
+		r := asc.NewReader(f)
+		for {
+			msg, err := r.ReadMessage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: unexpected error: %v", path, err)
+			}
+			read = append(read, *msg)
+		}
+		f.Close()
+		if r.StartTime.Year() != 1970 {
+			t.Errorf("%v: got start time %v, expected the time base of the bus", path, r.StartTime)
+		}
+	}
+	if len(read) != len(msgs) {
+		t.Fatalf("got %v messages, expected %v", len(read), len(msgs))
+	}
+	for i := range read {
+		if read[i].TimeStamp != msgs[i].TimeStamp {
+			t.Errorf("message %v: got timestamp %v, expected %v", i, read[i].TimeStamp, msgs[i].TimeStamp)
+		}
+	}
+	auxCheckNoParts(t, dir)
+}
@@ -0,0 +1,194 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/record"
+)
+
+// Waits until the ring recorder finished count captures
+func auxWaitCaptures(rec *record.RingRecorder, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Captures()) < count && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRingPrePostWindow(t *testing.T) {
+	dir := t.TempDir()
+	txBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRingPrePostWindow"})
+	rxBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRingPrePostWindow"})
+	defer txBus.Close()
+	defer rxBus.Close()
+
+	rec := record.NewRingRecorder(filepath.Join(dir, "fault.asc"), 50*time.Millisecond, 50*time.Millisecond,
+		record.TriggerID("fault", false, 0x7FF))
+	rec.Attach(rxBus, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rec.Run(ctx) }()
+
+	// 100 ms of traffic before and after the trigger, the extended id equal to the trigger id does not fire
+	for i := 0; i < 20; i++ {
+		txBus.Send(&gocan.Message{ID: 0x10, Data: []byte{byte(i)}})
+		if i == 5 {
+			txBus.Send(&gocan.Message{ID: 0x7FF, IsExtended: true, Data: []byte{0xFF}})
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	txBus.Send(&gocan.Message{ID: 0x7FF, Data: []byte{0xFF}})
+	for i := 0; i < 20; i++ {
+		time.Sleep(5 * time.Millisecond)
+		txBus.Send(&gocan.Message{ID: 0x20, Data: []byte{byte(i)}})
+	}
+	auxWaitCaptures(rec, 1)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	captures := rec.Captures()
+	if len(captures) != 1 || captures[0].Trigger != "fault" || captures[0].Path != filepath.Join(dir, "fault_0001.asc") {
+		t.Fatalf("unexpected captures: %+v", captures)
+	}
+	msgs := auxReadFile(t, captures[0].Path)
+	if len(msgs) != captures[0].Messages {
+		t.Errorf("got %v messages, expected %v", len(msgs), captures[0].Messages)
+	}
+	before, after, trigger := 0, 0, 0
+	for _, msg := range msgs {
+		switch msg.ID {
+		case 0x10:
+			before++
+			if msg.Data[0] < 5 {
+				t.Errorf("message %v older than the pre-trigger window", msg.Data[0])
+			}
+		case 0x20:
+			after++
+			if msg.Data[0] > 15 {
+				t.Errorf("message %v later than the post-trigger window", msg.Data[0])
+			}
+		case 0x7FF:
+			if !msg.IsExtended {
+				trigger++
+			}
+		}
+	}
+	if trigger != 1 || before < 3 || after < 3 {
+		t.Errorf("got %v messages before, %v after and %v trigger messages", before, after, trigger)
+	}
+
+	data, _ := os.ReadFile(captures[0].Path)
+	if !strings.Contains(string(data), "triggered by fault") {
+		t.Errorf("missing trigger marker:\n%s", data)
+	}
+	auxCheckNoParts(t, dir)
+}
+
+func TestRingMultipleTriggers(t *testing.T) {
+	dir := t.TempDir()
+	bus := &auxBus{msgs: []gocan.Message{
+		{ID: 0x100, TimeStamp: 1000, Data: []byte{1}},
+		{ID: record.ErrClassBusOff, TimeStamp: 2000, Type: gocan.ErrorFrame},
+		{ID: 0x101, TimeStamp: 3000, Data: []byte{2}},
+		{ID: 0x102, TimeStamp: 4000, Data: []byte{0x80}},
+	}}
+
+	rec := record.NewRingRecorder(filepath.Join(dir, "fault.log"), time.Second, 20*time.Millisecond,
+		record.TriggerErrorFrame("error"), record.TriggerBusOff("busoff"),
+		record.TriggerPayload("limit", 0x102, false, func(data []byte) bool { return data[0] >= 0x80 }))
+	var notified []record.Capture
+	rec.OnCapture = func(c record.Capture) { notified = append(notified, c) }
+	rec.Attach(bus, "can1")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rec.Run(ctx) }()
+
+	auxWaitCaptures(rec, 3)
+	rec.Fire("")
+	auxWaitCaptures(rec, 4)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	captures := rec.Captures()
+	if len(captures) != 4 || len(notified) != 4 {
+		t.Fatalf("got %v captures, expected 4", len(captures))
+	}
+	triggers := map[string]uint64{}
+	paths := map[string]bool{}
+	for _, c := range captures {
+		triggers[c.Trigger] = c.TimeStamp
+		paths[c.Path] = true
+		msgs := auxReadFile(t, c.Path)
+		if len(msgs) != 4 || msgs[0].Channel != "can1" {
+			t.Errorf("%v: got %v messages, expected 4 on can1", c.Trigger, len(msgs))
+		}
+	}
+	if triggers["error"] != 2000 || triggers["busoff"] != 2000 || triggers["limit"] != 4000 || triggers[record.ManualTrigger] != 4000 {
+		t.Errorf("unexpected triggers: %v", triggers)
+	}
+	if len(paths) != 4 {
+		t.Errorf("expected separate files, got %v", paths)
+	}
+}
+
+// Receiver reporting a settable status like PCAN reports bus-off
+type auxStatusBus struct {
+	auxBus
+	status atomic.Uint32
+}
+
+func (b *auxStatusBus) Status() (uint32, error)     { return b.status.Load(), nil }
+func (b *auxStatusBus) StatusIsOkay() (bool, error) { return b.status.Load() == 0, nil }
+func (b *auxStatusBus) State() gocan.BusState       { return gocan.ACTIVE }
+func (b *auxStatusBus) ChannelCondition() (gocan.ChannelCondition, error) {
+	return gocan.Available, nil
+}
+
+func TestRingBusOffStatus(t *testing.T) {
+	const busOff = 0x10
+	dir := t.TempDir()
+	bus := &auxStatusBus{auxBus: auxBus{msgs: []gocan.Message{{ID: 0x100, TimeStamp: 1000, Data: []byte{1}}}}}
+
+	rec := record.NewRingRecorder(filepath.Join(dir, "fault.log"), time.Second, 10*time.Millisecond, record.TriggerBusOff("busoff", busOff))
+	rec.StatusInterval = 2 * time.Millisecond
+	rec.Attach(bus, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rec.Run(ctx) }()
+
+	// a lasting bus-off fires once, a new bus-off fires again
+	time.Sleep(20 * time.Millisecond)
+	bus.status.Store(busOff | 0x04)
+	auxWaitCaptures(rec, 1)
+	time.Sleep(30 * time.Millisecond)
+	if n := len(rec.Captures()); n != 1 {
+		t.Errorf("expected 1 capture while bus-off lasts, got %v", n)
+	}
+	bus.status.Store(0)
+	time.Sleep(10 * time.Millisecond)
+	bus.status.Store(busOff)
+	auxWaitCaptures(rec, 2)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	captures := rec.Captures()
+	if len(captures) != 2 || captures[0].Trigger != "busoff" || captures[0].TimeStamp != 1000 {
+		t.Fatalf("unexpected captures: %+v", captures)
+	}
+	if msgs := auxReadFile(t, captures[0].Path); len(msgs) != 1 {
+		t.Errorf("got %v messages, expected 1", len(msgs))
+	}
+}
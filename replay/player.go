@@ -0,0 +1,202 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Last part of every wait which is busy-waited instead of using a timer for a more accurate send time, zero disables busy-waiting
+var SpinDuration = 500 * time.Microsecond
+
+// Timing report of a replay
+type Report struct {
+	Sent      uint64        // sent messages
+	Skipped   uint64        // messages skipped by filters, unmapped channels or error frames
+	Errors    uint64        // failed sends
+	LastError error         // error of the latest failed send
+	Loops     int           // completed passes through the log
+	MeanDrift time.Duration // mean delay of the actual send time compared to the scaled log time
+	MaxDrift  time.Duration // largest delay of the actual send time compared to the scaled log time
+	LastDrift time.Duration // delay of the latest sent message
+}
+
+// Player transmits the messages of a log onto a bus keeping the original inter-frame timing
+// Error frames of the log are never sent
+type Player struct {
+	Speed    float64                             // time scaling, 2 replays twice as fast, zero or below sends without delay
+	Repeat   int                                 // additional passes through the log, negative for endless looping until the context is cancelled
+	Start    time.Duration                       // offset from the first message of the log where the replay starts
+	Stop     time.Duration                       // offset from the first message of the log where the replay stops, zero for the end of the log
+	Include  []gocan.MessageID                   // only these ids are sent, empty for all ids
+	Exclude  []gocan.MessageID                   // ids which are never sent
+	Remap    map[gocan.MessageID]gocan.MessageID // ids replaced before sending, filters apply to the original id
+	Channels map[string]gocan.Sender             // sender per log channel, messages of other channels are sent on the default bus
+
+	bus    gocan.Sender
+	mu     sync.Mutex
+	report Report
+	timed  uint64 // sent messages with a drift measurement
+}
+
+// Creates a player sending in real time onto bus, bus may be nil if all channels are mapped by Channels
+func NewPlayer(bus gocan.Sender) *Player {
+	return &Player{bus: bus, Speed: 1}
+}
+
+// Returns the current report, may be called while playing
+func (p *Player) Report() Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.report
+}
+
+// Replays the log opened by open until all passes are done, the context is cancelled or the log reports an error
+// open is called once per pass, a returned reader implementing io.Closer is closed after its pass
+// A cancelled context ends the replay without an error, an endless replay also ends after a pass without any sent message
+func (p *Player) Play(ctx context.Context, open func() (gocan.MessageReader, error)) error {
+	for pass := 0; p.Repeat < 0 || pass <= p.Repeat; pass++ {
+		r, err := open()
+		if err != nil {
+			return err
+		}
+		sent, err := p.playOnce(ctx, r)
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		p.mu.Lock()
+		p.report.Loops++
+		p.mu.Unlock()
+		if p.Repeat < 0 && sent == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Returns the sender of a message or nil if the message is skipped
+func (p *Player) route(msg *gocan.Message) gocan.Sender {
+	if msg.Type == gocan.ErrorFrame {
+		return nil
+	}
+	if len(p.Include) > 0 && !slices.Contains(p.Include, msg.ID) {
+		return nil
+	}
+	if slices.Contains(p.Exclude, msg.ID) {
+		return nil
+	}
+	if sender, ok := p.Channels[msg.Channel]; ok {
+		return sender
+	}
+	return p.bus
+}
+
+// Plays a single pass through the log, returns the amount of messages handed to a sender
+func (p *Player) playOnce(ctx context.Context, r gocan.MessageReader) (int, error) {
+	var first uint64
+	var wallStart time.Time
+	started := false
+	sent := 0
+	for n := 0; ctx.Err() == nil; n++ {
+		msg, err := r.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+		if n == 0 {
+			first = msg.TimeStamp
+		}
+
+		offset := time.Duration(int64(msg.TimeStamp)-int64(first)) * time.Microsecond
+		if offset < p.Start {
+			continue
+		}
+		if p.Stop > 0 && offset > p.Stop {
+			return sent, nil
+		}
+		if !started {
+			wallStart = time.Now()
+			started = true
+		}
+
+		sender := p.route(msg)
+		if sender == nil {
+			p.mu.Lock()
+			p.report.Skipped++
+			p.mu.Unlock()
+			continue
+		}
+
+		var due time.Time
+		if p.Speed > 0 {
+			due = wallStart.Add(time.Duration(float64(offset-p.Start) / p.Speed))
+			if !wait(ctx, due) {
+				return sent, nil
+			}
+		}
+
+		out := *msg
+		out.SetData(msg.Data)
+		if id, ok := p.Remap[msg.ID]; ok {
+			out.ID = id
+		}
+		err = sender.Send(&out)
+		sent++
+		p.record(due, err)
+	}
+	return sent, nil
+}
+
+// Updates the report after a send
+func (p *Player) record(due time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.report.Errors++
+		p.report.LastError = err
+		return
+	}
+
+	p.report.Sent++
+	if due.IsZero() {
+		return
+	}
+	drift := time.Since(due)
+	p.timed++
+	p.report.LastDrift = drift
+	if drift > p.report.MaxDrift {
+		p.report.MaxDrift = drift
+	}
+	p.report.MeanDrift += (drift - p.report.MeanDrift) / time.Duration(p.timed)
+}
+
+// Waits until due, returns false if ctx was cancelled
+func wait(ctx context.Context, due time.Time) bool {
+	if d := time.Until(due) - SpinDuration; d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+	for time.Now().Before(due) {
+		runtime.Gosched()
+	}
+	return ctx.Err() == nil
+}
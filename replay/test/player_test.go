@@ -0,0 +1,198 @@
+package test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/replay"
+)
+
+// MessageReader over a slice of messages
+type auxLog struct {
+	msgs []gocan.Message
+}
+
+func (l *auxLog) ReadMessage() (*gocan.Message, error) {
+	if len(l.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := l.msgs[0]
+	l.msgs = l.msgs[1:]
+	return &msg, nil
+}
+
+func auxOpen(msgs []gocan.Message) func() (gocan.MessageReader, error) {
+	return func() (gocan.MessageReader, error) { return &auxLog{msgs: msgs}, nil }
+}
+
+// Sender recording all sent messages with their send time
+type auxSender struct {
+	msgs  []gocan.Message
+	times []time.Time
+}
+
+func (s *auxSender) Send(msg *gocan.Message) error {
+	s.msgs = append(s.msgs, *msg)
+	s.times = append(s.times, time.Now())
+	return nil
+}
+
+func auxIDs(msgs []gocan.Message) []gocan.MessageID {
+	ids := make([]gocan.MessageID, len(msgs))
+	for i := range msgs {
+		ids[i] = msgs[i].ID
+	}
+	return ids
+}
+
+func auxEqualIDs(a, b []gocan.MessageID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTiming(t *testing.T) {
+	// 20 ms between messages replayed at double speed
+	var msgs []gocan.Message
+	for i := 0; i < 6; i++ {
+		msgs = append(msgs, gocan.Message{ID: gocan.MessageID(i), TimeStamp: uint64(1000000 + i*20000), Data: []byte{byte(i)}})
+	}
+	sender := &auxSender{}
+	p := replay.NewPlayer(sender)
+	p.Speed = 2
+
+	// every message is due at its scaled offset from the start of the replay, independent of the latency of earlier sends
+	start := time.Now()
+	if err := p.Play(context.Background(), auxOpen(msgs)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sender.msgs) != len(msgs) {
+		t.Fatalf("expected %v messages, got %v", len(msgs), len(sender.msgs))
+	}
+	for i := range sender.times {
+		gap := sender.times[i].Sub(start)
+		expected := time.Duration(i) * 10 * time.Millisecond
+		if gap < expected || gap > expected+5*time.Millisecond {
+			t.Errorf("message %v sent after %v, expected %v", i, gap, expected)
+		}
+	}
+
+	report := p.Report()
+	if report.Sent != 6 || report.Loops != 1 || report.MaxDrift > 5*time.Millisecond || report.MeanDrift > report.MaxDrift {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestFilterAndRemap(t *testing.T) {
+	msgs := []gocan.Message{
+		{ID: 0x100, TimeStamp: 0, Channel: "1"},
+		{ID: 0x200, TimeStamp: 10000, Channel: "2"},
+		{ID: 0x300, TimeStamp: 20000, Channel: "1"},
+		{ID: 0x400, TimeStamp: 30000, Channel: "1", Type: gocan.ErrorFrame},
+		{ID: 0x500, TimeStamp: 40000, Channel: "2"},
+		{ID: 0x600, TimeStamp: 50000, Channel: "1"},
+		{ID: 0x700, TimeStamp: 60000, Channel: "1"},
+	}
+	bus1, bus2 := &auxSender{}, &auxSender{}
+	p := replay.NewPlayer(bus1)
+	p.Speed = 0
+	p.Repeat = 1
+	p.Start = 10 * time.Millisecond
+	p.Stop = 50 * time.Millisecond
+	p.Exclude = []gocan.MessageID{0x300}
+	p.Remap = map[gocan.MessageID]gocan.MessageID{0x500: 0x501}
+	p.Channels = map[string]gocan.Sender{"2": bus2}
+
+	if err := p.Play(context.Background(), auxOpen(msgs)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := auxIDs(bus1.msgs); !auxEqualIDs(ids, []gocan.MessageID{0x600, 0x600}) {
+		t.Errorf("wrong messages on bus 1: %X", ids)
+	}
+	if ids := auxIDs(bus2.msgs); !auxEqualIDs(ids, []gocan.MessageID{0x200, 0x501, 0x200, 0x501}) {
+		t.Errorf("wrong messages on bus 2: %X", ids)
+	}
+	if report := p.Report(); report.Sent != 6 || report.Skipped != 4 || report.Loops != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// include list
+	bus1.msgs = nil
+	p = replay.NewPlayer(bus1)
+	p.Speed = 0
+	p.Include = []gocan.MessageID{0x100, 0x700}
+	p.Play(context.Background(), auxOpen(msgs))
+	if ids := auxIDs(bus1.msgs); !auxEqualIDs(ids, []gocan.MessageID{0x100, 0x700}) {
+		t.Errorf("wrong included messages: %X", ids)
+	}
+}
+
+func TestEndlessLoopOnVirtualBus(t *testing.T) {
+	txBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestEndlessLoopOnVirtualBus"})
+	rxBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestEndlessLoopOnVirtualBus"})
+	defer txBus.Close()
+	defer rxBus.Close()
+
+	msgs := []gocan.Message{{ID: 0x10, TimeStamp: 0, Data: []byte{1}}, {ID: 0x11, TimeStamp: 1000, Data: []byte{2}}}
+	p := replay.NewPlayer(txBus)
+	p.Repeat = -1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Play(ctx, auxOpen(msgs)) }()
+
+	received := 0
+	for received < 10 {
+		msg, err := rxBus.Recv(1000)
+		if err != nil || msg == nil {
+			t.Fatalf("expected message, got %v, err: %v", msg, err)
+		}
+		if msg.ID != gocan.MessageID(0x10+received%2) {
+			t.Errorf("message %v: wrong id 0x%X", received, msg.ID)
+		}
+		received++
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("player did not stop after cancel")
+	}
+	if report := p.Report(); report.Loops < 4 {
+		t.Errorf("expected at least 4 loops, got %+v", report)
+	}
+}
+
+func TestEndlessLoopWithoutMessages(t *testing.T) {
+	p := replay.NewPlayer(&auxSender{})
+	p.Repeat = -1
+
+	done := make(chan error, 1)
+	go func() { done <- p.Play(context.Background(), auxOpen(nil)) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("endless replay of an empty log did not end")
+	}
+	if report := p.Report(); report.Loops != 1 || report.Sent != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
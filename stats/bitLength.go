@@ -0,0 +1,157 @@
+package stats
+
+import "github.com/morgadow/gocan"
+
+// Bits following the stuffed part of a classic frame: CRC delimiter, ACK slot, ACK delimiter, end of frame and interframe space
+const classicTrailerBits = 1 + 1 + 1 + 7 + 3
+
+// Bits following the CRC delimiter of a CAN FD frame: ACK slot, ACK delimiter, end of frame and interframe space
+const fdTrailerBits = 1 + 1 + 7 + 3
+
+// Bits of an error frame: error flag, error delimiter and interframe space
+const errorFrameBits = 6 + 8 + 3
+
+// Returns the length of a frame on the wire including stuff bits and interframe space
+// nominal: bits transmitted with the nominal bit rate
+// data: bits transmitted with the data bit rate, only non zero for CAN FD frames with bit rate switch
+func FrameBits(msg *gocan.Message) (nominal int, data int) {
+	if msg.Type == gocan.ErrorFrame {
+		return errorFrameBits, 0
+	}
+	if msg.IsFD {
+		return fdFrameBits(msg)
+	}
+	return classicFrameBits(msg)
+}
+
+// stuffed bit stream of a frame, counts stuff bits and calculates the CAN CRC-15 while bits are appended
+type bitStream struct {
+	bits      int
+	last      byte
+	run       int
+	stuffBits int
+	crc       uint64
+}
+
+// Appends the lowest n bits of value, most significant bit first
+func (s *bitStream) add(value uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		s.addBit(byte(value>>uint(i)) & 1)
+	}
+}
+
+func (s *bitStream) addBit(bit byte) {
+	s.bits++
+	next := uint64(bit) ^ ((s.crc >> 14) & 1)
+	s.crc = (s.crc << 1) & 0x7FFF
+	if next == 1 {
+		s.crc ^= 0x4599
+	}
+
+	if bit == s.last && s.run > 0 {
+		s.run++
+	} else {
+		s.last = bit
+		s.run = 1
+	}
+	if s.run == 5 {
+		// stuff bit with inverted level starts a new run
+		s.stuffBits++
+		s.last = bit ^ 1
+		s.run = 1
+	}
+}
+
+// Frame header from start of frame until the end of the arbitration field, for CAN FD frames the RTR bit is the RRS bit
+func addArbitration(s *bitStream, msg *gocan.Message) {
+	rtr := uint64(0)
+	if msg.Type == gocan.RemoteFrame && !msg.IsFD {
+		rtr = 1
+	}
+
+	s.addBit(0) // start of frame
+	if msg.IsExtended {
+		s.add(uint64(msg.ID)>>18, 11)
+		s.addBit(1) // SRR
+		s.addBit(1) // IDE
+		s.add(uint64(msg.ID), 18)
+	} else {
+		s.add(uint64(msg.ID), 11)
+	}
+	s.addBit(byte(rtr)) // RTR or RRS
+}
+
+// Exact length of a classic frame, the CRC is calculated to determine the stuff bits
+func classicFrameBits(msg *gocan.Message) (int, int) {
+	var s bitStream
+
+	addArbitration(&s, msg)
+	if msg.IsExtended {
+		s.add(0, 2) // r1, r0
+	} else {
+		s.add(0, 2) // IDE, r0
+	}
+
+	dlc := gocan.DLCFromLength(len(msg.Data))
+	if msg.Type == gocan.RemoteFrame {
+		dlc = msg.DLC
+	}
+	s.add(uint64(dlc), 4)
+	if msg.Type != gocan.RemoteFrame {
+		for _, b := range msg.Data {
+			s.add(uint64(b), 8)
+		}
+	}
+	s.add(s.crc, 15)
+
+	return s.bits + s.stuffBits + classicTrailerBits, 0
+}
+
+// Length of a CAN FD frame, dynamic stuff bits are counted exactly until the end of the data field, the CRC field uses fixed stuff bits
+func fdFrameBits(msg *gocan.Message) (int, int) {
+	var s bitStream
+
+	addArbitration(&s, msg)
+	if !msg.IsExtended {
+		s.addBit(0) // IDE
+	}
+	s.addBit(1) // FDF
+	s.addBit(0) // res
+	brs := msg.Type == gocan.FDBitRateSwitchFrame
+	if brs {
+		s.addBit(1)
+	} else {
+		s.addBit(0)
+	}
+	arbitration := s.bits + s.stuffBits
+
+	esi := byte(0)
+	if msg.Type == gocan.FDErrorStateIndicator {
+		esi = 1
+	}
+	s.addBit(esi)
+
+	length := len(msg.Data)
+	dlc := gocan.DLCFromLength(length)
+	s.add(uint64(dlc), 4)
+	for _, b := range msg.Data {
+		s.add(uint64(b), 8)
+	}
+	for i := length; i < gocan.LengthFromDLC(dlc, true); i++ {
+		s.add(0xCC, 8) // padding bytes
+	}
+
+	// stuff count with parity, CRC and their fixed stuff bits before every 4 bits, CRC delimiter
+	crcBits := 17
+	if gocan.LengthFromDLC(dlc, true) > 16 {
+		crcBits = 21
+	}
+	crcField := 4 + crcBits
+	crcField += crcField/4 + 1
+	dataPhase := s.bits + s.stuffBits - arbitration + crcField + 1
+
+	if !brs {
+		return arbitration + dataPhase + fdTrailerBits, 0
+	}
+	return arbitration + fdTrailerBits, dataPhase
+}
@@ -0,0 +1,282 @@
+package stats
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Time resolution of the bus load measurement
+const BucketDuration = 100 * time.Millisecond
+
+// Windows used for the bus load measurement if none are given
+var DefaultWindows = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// Identifies the traffic of a single message id
+type IDKey struct {
+	ID         gocan.MessageID
+	Type       gocan.MessageType
+	IsExtended bool
+}
+
+// Frame and byte count
+type Counter struct {
+	Frames uint64
+	Bytes  uint64
+}
+
+// Traffic statistics of a single message id
+type IDStats struct {
+	RX       Counter
+	TX       Counter
+	LastSeen time.Time
+}
+
+// Statistics of a bus at a point in time
+type Snapshot struct {
+	Start       time.Time                 // time of creation or last Clear()
+	Time        time.Time                 // time of the snapshot
+	RX          Counter                   // all received frames
+	TX          Counter                   // all sent frames
+	ErrorFrames uint64                    // received error frames
+	Overruns    uint64                    // lost messages or overrun events reported by the bus
+	PerID       map[IDKey]IDStats         // traffic per id and frame type
+	BusLoad     map[time.Duration]float64 // bus load in percent for every measurement window
+}
+
+// Returns all ids of the snapshot sorted by id and frame type
+func (s *Snapshot) IDs() []IDKey {
+	keys := make([]IDKey, 0, len(s.PerID))
+	for key := range s.PerID {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Type < keys[j].Type
+	})
+	return keys
+}
+
+// bus time used by frames within one bucket
+type bucket struct {
+	index int64   // bucket number since unix epoch
+	busy  float64 // seconds
+}
+
+// Collector counts the traffic of a bus and measures its bus load
+// It wraps a gocan.Bus and can be used in its place, messages can also be fed manually with Observe()
+type Collector struct {
+	gocan.Bus
+
+	mu          sync.Mutex
+	baudRate    uint32
+	dataBitRate uint32
+	windows     []time.Duration
+	buckets     []bucket
+	snap        Snapshot
+}
+
+// Creates a collector for bus, which may be nil if messages are only fed with Observe()
+// baudRate: nominal bit rate of the bus used to calculate the bus load
+// windows: sliding windows for the bus load measurement, DefaultWindows if none are given
+func NewCollector(bus gocan.Bus, baudRate uint32, windows ...time.Duration) *Collector {
+	if len(windows) == 0 {
+		windows = DefaultWindows
+	}
+
+	var longest time.Duration
+	for _, w := range windows {
+		if w > longest {
+			longest = w
+		}
+	}
+
+	c := &Collector{
+		Bus:         bus,
+		baudRate:    baudRate,
+		dataBitRate: baudRate,
+		windows:     append([]time.Duration(nil), windows...),
+		buckets:     make([]bucket, int(longest/BucketDuration)+1),
+	}
+	c.Clear()
+	return c
+}
+
+// Sets the bit rate of the data phase of CAN FD frames with bit rate switch, defaults to the nominal baud rate
+func (c *Collector) SetDataBitRate(rate uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dataBitRate = rate
+}
+
+// Resets all counters
+func (c *Collector) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snap = Snapshot{Start: time.Now(), PerID: make(map[IDKey]IDStats)}
+	for i := range c.buckets {
+		c.buckets[i] = bucket{}
+	}
+}
+
+// Counts a message observed now
+// isTx: true if the message was sent by this node
+func (c *Collector) Observe(msg *gocan.Message, isTx bool) {
+	c.ObserveAt(msg, isTx, time.Now())
+}
+
+// Counts a message observed at the given time, e.g. when feeding messages from a log file
+// Messages older than the longest window before the latest observed message are counted, but not added to the bus load
+func (c *Collector) ObserveAt(msg *gocan.Message, isTx bool, at time.Time) {
+	nominal, data := FrameBits(msg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.Type == gocan.ErrorFrame {
+		c.snap.ErrorFrames++
+	} else {
+		key := IDKey{ID: msg.ID, Type: msg.Type, IsExtended: msg.IsExtended}
+		idStats := c.snap.PerID[key]
+		count := &c.snap.RX
+		idCount := &idStats.RX
+		if isTx {
+			count = &c.snap.TX
+			idCount = &idStats.TX
+		}
+		count.Frames++
+		count.Bytes += uint64(len(msg.Data))
+		idCount.Frames++
+		idCount.Bytes += uint64(len(msg.Data))
+		idStats.LastSeen = at
+		c.snap.PerID[key] = idStats
+	}
+
+	if c.baudRate == 0 {
+		return
+	}
+	busy := float64(nominal) / float64(c.baudRate)
+	if data > 0 && c.dataBitRate > 0 {
+		busy += float64(data) / float64(c.dataBitRate)
+	}
+	if b := c.bucketAt(at); b != nil {
+		b.busy += busy
+	}
+}
+
+// Counts lost messages reported by an OverrunError
+func (c *Collector) observeErr(err error) {
+	var overrun *gocan.OverrunError
+	if errors.As(err, &overrun) {
+		c.mu.Lock()
+		c.snap.Overruns += overrun.Hardware + overrun.Software
+		c.mu.Unlock()
+	}
+}
+
+// Returns the bucket for the given time, a bucket holding older data is reused
+// Returns nil if the time is older than the longest window before the latest observed time, its bucket was reused already
+// Note: c.mu must be locked
+func (c *Collector) bucketAt(at time.Time) *bucket {
+	index := at.UnixNano() / int64(BucketDuration)
+	b := &c.buckets[index%int64(len(c.buckets))]
+	if b.index > index {
+		return nil
+	}
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	return b
+}
+
+// Returns a copy of the current statistics
+func (c *Collector) Snapshot() Snapshot {
+	return c.SnapshotAt(time.Now())
+}
+
+// Returns a copy of the statistics with the bus load calculated for windows ending at the given time
+func (c *Collector) SnapshotAt(at time.Time) Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snap := c.snap
+	snap.Time = at
+	snap.PerID = make(map[IDKey]IDStats, len(c.snap.PerID))
+	for key, val := range c.snap.PerID {
+		snap.PerID[key] = val
+	}
+
+	snap.BusLoad = make(map[time.Duration]float64, len(c.windows))
+	last := at.UnixNano() / int64(BucketDuration)
+	for _, w := range c.windows {
+		first := last - int64(w/BucketDuration) + 1
+		var busy float64
+		for _, b := range c.buckets {
+			if b.index >= first && b.index <= last {
+				busy += b.busy
+			}
+		}
+		snap.BusLoad[w] = 100 * busy / w.Seconds()
+	}
+	return snap
+}
+
+// Sends message over the wrapped bus and counts it as sent
+func (c *Collector) Send(msg *gocan.Message) error {
+	err := c.Bus.Send(msg)
+	if err == nil {
+		c.Observe(msg, true)
+	}
+	return err
+}
+
+// Sends all messages over the wrapped bus and counts the sent ones
+func (c *Collector) SendBatch(msgs []gocan.Message) (int, error) {
+	n, err := gocan.SendBatch(c.Bus, msgs)
+	for i := 0; i < n; i++ {
+		c.Observe(&msgs[i], true)
+	}
+	return n, err
+}
+
+// Counts a received message, echo frames were counted by Send() already
+func (c *Collector) observeRx(msg *gocan.Message) {
+	if msg.Direction != gocan.Tx {
+		c.Observe(msg, false)
+	}
+}
+
+// Receives message from the wrapped bus and counts it as received, echo frames of sent messages are not counted again
+func (c *Collector) Recv(timeout int) (*gocan.Message, error) {
+	msg, err := c.Bus.Recv(timeout)
+	if msg != nil {
+		c.observeRx(msg)
+	}
+	c.observeErr(err)
+	return msg, err
+}
+
+// Reads the buffer of the wrapped bus and counts all messages except echo frames as received
+func (c *Collector) ReadBuffer(limit uint16) ([]gocan.Message, error) {
+	msgs, err := c.Bus.ReadBuffer(limit)
+	for i := range msgs {
+		c.observeRx(&msgs[i])
+	}
+	c.observeErr(err)
+	return msgs, err
+}
+
+// Reads the buffer of the wrapped bus into buf and counts all messages except echo frames as received
+func (c *Collector) ReadInto(buf []gocan.Message) (int, error) {
+	n, err := gocan.ReadInto(c.Bus, buf)
+	for i := 0; i < n; i++ {
+		c.observeRx(&buf[i])
+	}
+	c.observeErr(err)
+	return n, err
+}
@@ -0,0 +1,152 @@
+package test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/stats"
+)
+
+func TestFrameBitsClassic(t *testing.T) {
+	// 34 dominant bits until the end of the CRC need 6 stuff bits
+	nominal, data := stats.FrameBits(&gocan.Message{ID: 0, Data: []byte{}})
+	if nominal != 53 || data != 0 {
+		t.Errorf("wrong length for zero frame: %v, %v", nominal, data)
+	}
+
+	// every frame must be between the unstuffed and the worst case length
+	for _, msg := range []gocan.Message{
+		{ID: 0x7FF, Data: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{ID: 0x123, Data: []byte{0x55, 0xAA, 0x00, 0xFF}},
+		{ID: 0x1FFFFFFF, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}, IsExtended: true},
+	} {
+		n := len(msg.Data)
+		header := 47
+		if msg.IsExtended {
+			header = 67
+		}
+		minBits := 8*n + header
+		maxBits := minBits + (header-13+8*n-1)/4
+		bits, _ := stats.FrameBits(&msg)
+		if bits < minBits || bits > maxBits {
+			t.Errorf("length %v of frame 0x%X out of range [%v, %v]", bits, msg.ID, minBits, maxBits)
+		}
+	}
+}
+
+func TestFrameBitsFD(t *testing.T) {
+	msg := gocan.Message{ID: 0x100, Data: make([]byte, 64), IsFD: true, Type: gocan.FDBitRateSwitchFrame}
+	nominal, data := stats.FrameBits(&msg)
+	if nominal < 29 || nominal > 35 || data < 64*8+33 {
+		t.Errorf("unexpected length of FD frame with bit rate switch: %v, %v", nominal, data)
+	}
+
+	msg.Type = gocan.DataFrame
+	nominal, data = stats.FrameBits(&msg)
+	if data != 0 || nominal < 64*8+60 {
+		t.Errorf("unexpected length of FD frame without bit rate switch: %v, %v", nominal, data)
+	}
+}
+
+func TestBusLoad(t *testing.T) {
+	c := stats.NewCollector(nil, 500000, time.Second, 10*time.Second)
+
+	// 100 frames of 53 bits within the last second at 500 kbit/s
+	now := time.Now()
+	msg := gocan.Message{ID: 0, Data: []byte{}}
+	for i := 0; i < 100; i++ {
+		c.ObserveAt(&msg, false, now.Add(-time.Duration(i)*5*time.Millisecond))
+	}
+	snap := c.SnapshotAt(now)
+
+	expLoad := 100 * 100 * 53 / 500000.0
+	if math.Abs(snap.BusLoad[time.Second]-expLoad) > 1e-9 {
+		t.Errorf("wrong load over 1s: got %v, expected %v", snap.BusLoad[time.Second], expLoad)
+	}
+	if math.Abs(snap.BusLoad[10*time.Second]-expLoad/10) > 1e-9 {
+		t.Errorf("wrong load over 10s: got %v, expected %v", snap.BusLoad[10*time.Second], expLoad/10)
+	}
+
+	// frames leave the window
+	snap = c.SnapshotAt(now.Add(2 * time.Second))
+	if snap.BusLoad[time.Second] != 0 {
+		t.Errorf("expected no load, got %v", snap.BusLoad[time.Second])
+	}
+}
+
+func TestCollectorWrapsBus(t *testing.T) {
+	txBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestCollectorWrapsBus"})
+	rxBus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestCollectorWrapsBus", RecvErrorFrames: true})
+	tx := stats.NewCollector(txBus, 500000)
+	rx := stats.NewCollector(rxBus, 500000)
+	defer tx.Close()
+	defer rx.Close()
+
+	var bus gocan.Bus = tx // usable in place of the wrapped bus
+	bus.Send(&gocan.Message{ID: 0x100, Data: []byte{1, 2, 3}})
+	bus.Send(&gocan.Message{ID: 0x100, Data: []byte{1, 2}})
+	bus.Send(&gocan.Message{ID: 0x200, Data: []byte{1}})
+	bus.Send(&gocan.Message{ID: 0x0, Type: gocan.ErrorFrame, Data: []byte{}})
+
+	buf := make([]gocan.Message, 8)
+	if n, err := rx.ReadInto(buf); n != 4 || err != nil {
+		t.Fatalf("expected 4 messages, got %v, err: %v", n, err)
+	}
+
+	snap := tx.Snapshot()
+	if snap.TX.Frames != 3 || snap.TX.Bytes != 6 || snap.RX.Frames != 0 {
+		t.Errorf("wrong tx counters: %+v", snap)
+	}
+
+	snap = rx.Snapshot()
+	key := stats.IDKey{ID: 0x100, Type: gocan.DataFrame}
+	if snap.RX.Frames != 3 || snap.ErrorFrames != 1 || snap.PerID[key].RX.Frames != 2 || snap.PerID[key].RX.Bytes != 5 {
+		t.Errorf("wrong rx counters: %+v", snap)
+	}
+	if ids := snap.IDs(); len(ids) != 2 || ids[0].ID != 0x100 || ids[1].ID != 0x200 {
+		t.Errorf("wrong ids: %v", ids)
+	}
+	if snap.BusLoad[time.Second] <= 0 {
+		t.Errorf("expected bus load, got %v", snap.BusLoad)
+	}
+}
+
+func TestCollectorEchoFrames(t *testing.T) {
+	bus, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestCollectorEchoFrames", RecvEchoFrames: true})
+	c := stats.NewCollector(bus, 500000)
+	defer c.Close()
+
+	c.Send(&gocan.Message{ID: 0x100, Data: []byte{1, 2}})
+	msg, err := c.Recv(100)
+	if err != nil || msg == nil || msg.Direction != gocan.Tx {
+		t.Fatalf("expected echo frame, got %v, err: %v", msg, err)
+	}
+
+	snap := c.Snapshot()
+	key := stats.IDKey{ID: 0x100, Type: gocan.DataFrame}
+	if snap.TX.Frames != 1 || snap.RX.Frames != 0 || snap.PerID[key].RX.Frames != 0 {
+		t.Errorf("echo frame counted twice: %+v", snap)
+	}
+}
+
+func TestBusLoadOldSamples(t *testing.T) {
+	c := stats.NewCollector(nil, 500000, time.Second)
+
+	now := time.Now()
+	msg := gocan.Message{ID: 0, Data: []byte{}}
+	c.ObserveAt(&msg, false, now)
+	expLoad := 100 * 53 / 500000.0
+
+	// a sample older than the window maps to the bucket of the newer sample, which must not be replaced
+	c.ObserveAt(&msg, false, now.Add(-11*stats.BucketDuration))
+	snap := c.SnapshotAt(now)
+	if math.Abs(snap.BusLoad[time.Second]-expLoad) > 1e-9 {
+		t.Errorf("wrong load over 1s: got %v, expected %v", snap.BusLoad[time.Second], expLoad)
+	}
+	if snap.RX.Frames != 2 {
+		t.Errorf("expected both frames to be counted, got %v", snap.RX.Frames)
+	}
+}
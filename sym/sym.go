@@ -0,0 +1,570 @@
+package sym
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+	"github.com/morgadow/gocan/dbc"
+)
+
+// Sections of a symbol file holding messages
+const (
+	SectionSend        = "SEND"
+	SectionReceive     = "RECEIVE"
+	SectionSendReceive = "SENDRECEIVE"
+)
+
+// Message attribute holding the section of a message, an enum of the sections
+const AttrSection = "SymSection"
+
+// Sections in the order of the AttrSection enum
+var sections = []string{SectionSend, SectionReceive, SectionSendReceive}
+
+// errors
+var (
+	ErrSyntax      = errors.New("invalid SYM syntax")
+	ErrUnknownEnum = errors.New("unknown enum")
+)
+
+// Signal whose value descriptions are taken from an enum after parsing
+type enumRef struct {
+	signal *dbc.Signal
+	enum   string
+	line   int
+}
+
+// Message block started by [Name], multiplexed messages have one block per multiplexer value
+type block struct {
+	name      string
+	line      int
+	id        int64
+	hasID     bool
+	extended  bool
+	fd        bool
+	length    int
+	cycleTime time.Duration
+	comment   string
+	mux       *dbc.Signal
+	muxValue  uint64
+	signals   []*dbc.Signal
+}
+
+type parser struct {
+	db       *dbc.Database
+	section  string
+	block    *block
+	enum     strings.Builder // enum definition spanning several lines
+	enumLine int
+	signals  map[string]*dbc.Signal // definitions of the {SIGNALS} section
+	refs     []enumRef
+	line     int
+}
+
+// Parses a PEAK symbol file into a database, the same model used for DBC files
+// Enums become value tables, the section of every message is stored in the attribute SymSection and the title as
+// database comment
+func Parse(r io.Reader) (*dbc.Database, error) {
+	p := &parser{
+		db: &dbc.Database{
+			ValueTables: make(map[string]map[int64]string),
+			Attributes:  make(map[string]any),
+		},
+		section: SectionSendReceive,
+		signals: make(map[string]*dbc.Signal),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		p.line++
+		line := scanner.Text()
+		if p.line == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+		if err := p.parseLine(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.enum.Len() > 0 {
+		return nil, fmt.Errorf("line %v: %w: unterminated enum", p.enumLine, ErrSyntax)
+	}
+	if err := p.finishBlock(); err != nil {
+		return nil, err
+	}
+	for _, ref := range p.refs {
+		values, ok := p.db.ValueTables[ref.enum]
+		if !ok {
+			return nil, fmt.Errorf("line %v: %w: %v", ref.line, ErrUnknownEnum, ref.enum)
+		}
+		ref.signal.Values = values
+	}
+	if len(p.db.Messages) > 0 {
+		p.db.AttributeDefs = append(p.db.AttributeDefs, &dbc.AttributeDef{
+			Object: dbc.ObjectMessage, Name: AttrSection, Type: "ENUM", Enum: sections, Default: SectionSendReceive,
+		})
+	}
+	return p.db, nil
+}
+
+// Parses a PEAK symbol file
+func ParseFile(path string) (*dbc.Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %v: %w: %v", p.line, ErrSyntax, fmt.Sprintf(format, args...))
+}
+
+// Splits a line into its content and a trailing // comment, quoted text is kept
+func splitComment(line string) (string, string) {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && strings.HasPrefix(line[i:], "//"):
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:])
+		}
+	}
+	return strings.TrimSpace(line), ""
+}
+
+// Splits by whitespace, quoted text is kept together including its quotes
+func fields(s string) []string {
+	var result []string
+	var current strings.Builder
+	inQuote := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			current.WriteRune(r)
+		case !inQuote && (r == ' ' || r == '\t'):
+			if current.Len() > 0 {
+				result = append(result, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+	return result
+}
+
+// Removes surrounding quotes
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Parses an integer, decimal or hex with suffix h or prefix 0x, e.g. "100h"
+func parseInt(s string) (int64, error) {
+	switch {
+	case strings.HasSuffix(s, "h") || strings.HasSuffix(s, "H"):
+		return strconv.ParseInt(s[:len(s)-1], 16, 64)
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		return strconv.ParseInt(s[2:], 16, 64)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func (p *parser) parseLine(line string) error {
+	content, comment := splitComment(line)
+
+	if p.enum.Len() > 0 {
+		p.enum.WriteString(" " + content)
+		return p.parseEnum()
+	}
+	if content == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(content, "{") && strings.HasSuffix(content, "}") {
+		if err := p.finishBlock(); err != nil {
+			return err
+		}
+		p.section = strings.ToUpper(content[1 : len(content)-1])
+		return nil
+	}
+	if strings.HasPrefix(content, "[") && strings.HasSuffix(content, "]") {
+		if err := p.finishBlock(); err != nil {
+			return err
+		}
+		p.block = &block{name: strings.TrimSpace(content[1 : len(content)-1]), line: p.line}
+		return nil
+	}
+
+	if p.section == "ENUMS" && p.block == nil {
+		if !strings.HasPrefix(strings.ToLower(content), "enum ") {
+			return p.errorf("expected enum, got %q", content)
+		}
+		p.enumLine = p.line
+		p.enum.WriteString(content)
+		return p.parseEnum()
+	}
+
+	key, value, ok := strings.Cut(content, "=")
+	if !ok {
+		return p.errorf("expected key=value, got %q", content)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+	if p.block == nil {
+		switch {
+		case strings.EqualFold(key, "Title"):
+			p.db.Comment = unquote(value)
+		case p.section == "SIGNALS" && key == "Sig":
+			return p.parseSignalDef(value, comment)
+		}
+		// FormatVersion and unknown global keys are ignored
+		return nil
+	}
+	return p.parseBlockLine(key, value, comment)
+}
+
+// Parses a complete enum definition "enum Name(0="A", 1="B")" once its parenthesis is closed
+func (p *parser) parseEnum() error {
+	text := p.enum.String()
+	inQuote, closed := false, false
+	for _, r := range text {
+		if r == '"' {
+			inQuote = !inQuote
+		} else if r == ')' && !inQuote {
+			closed = true
+		}
+	}
+	if !closed {
+		return nil
+	}
+	p.enum.Reset()
+
+	open := strings.Index(text, "(")
+	end := strings.LastIndex(text, ")")
+	if open < 0 || end < open {
+		return fmt.Errorf("line %v: %w: invalid enum", p.enumLine, ErrSyntax)
+	}
+	name := strings.TrimSpace(text[len("enum"):open])
+	values := make(map[int64]string)
+	for _, item := range splitItems(text[open+1 : end]) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("line %v: %w: invalid enum value %q", p.enumLine, ErrSyntax, item)
+		}
+		n, err := parseInt(strings.TrimSpace(k))
+		if err != nil {
+			return fmt.Errorf("line %v: %w: invalid enum value %q", p.enumLine, ErrSyntax, item)
+		}
+		values[n] = unquote(strings.TrimSpace(v))
+	}
+	p.db.ValueTables[name] = values
+	return nil
+}
+
+// Splits enum items by commas outside of quotes
+func splitItems(s string) []string {
+	var items []string
+	inQuote, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == ',' && !inQuote:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+func (p *parser) parseBlockLine(key, value, comment string) error {
+	b := p.block
+	switch strings.ToLower(key) {
+	case "id":
+		id, err := parseInt(value)
+		if err != nil || id < 0 || id > 0x1FFFFFFF {
+			return p.errorf("invalid id %q", value)
+		}
+		b.id, b.hasID, b.comment = id, true, comment
+		if id > 0x7FF {
+			b.extended = true
+		}
+	case "type":
+		upper := strings.ToUpper(value)
+		b.extended = b.extended || strings.Contains(upper, "EXTENDED")
+		b.fd = strings.Contains(upper, "FD")
+	case "dlc", "len", "length":
+		n, err := parseInt(value)
+		if err != nil || n < 0 || n > gocan.MaxDataLength {
+			return p.errorf("invalid length %q", value)
+		}
+		b.length = int(n)
+	case "cycletime":
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return p.errorf("invalid cycle time %q", value)
+		}
+		b.cycleTime = time.Duration(ms * float64(time.Millisecond))
+	case "mux":
+		return p.parseMux(value, comment)
+	case "var":
+		s, err := p.parseVar(fields(value), true)
+		if err != nil {
+			return err
+		}
+		s.Comment = comment
+		b.signals = append(b.signals, s)
+	case "sig":
+		f := fields(value)
+		if len(f) < 2 {
+			return p.errorf("invalid signal reference %q", value)
+		}
+		def, ok := p.signals[f[0]]
+		if !ok {
+			return p.errorf("unknown signal %q", f[0])
+		}
+		start, err := parseInt(f[1])
+		if err != nil {
+			return p.errorf("invalid start bit %q", f[1])
+		}
+		s := *def
+		s.Attributes = make(map[string]any)
+		if err := p.setStart(&s, int(start)); err != nil {
+			return err
+		}
+		// enums are resolved after parsing, the copy needs its own reference
+		for _, ref := range p.refs {
+			if ref.signal == def {
+				p.refs = append(p.refs, enumRef{signal: &s, enum: ref.enum, line: ref.line})
+			}
+		}
+		if comment != "" {
+			s.Comment = comment
+		}
+		b.signals = append(b.signals, &s)
+	}
+	// further keys like Timeout are ignored
+	return nil
+}
+
+// Parses a signal definition of the {SIGNALS} section: Name type length [flags] [options]
+func (p *parser) parseSignalDef(value, comment string) error {
+	f := fields(value)
+	if len(f) < 3 {
+		return p.errorf("invalid signal definition %q", value)
+	}
+	// reuse the variable parser with a start bit of zero, the start bit is given by the reference
+	f[2] = "0," + f[2]
+	s, err := p.parseVar(f, false)
+	if err != nil {
+		return err
+	}
+	s.Comment = comment
+	p.signals[s.Name] = s
+	return nil
+}
+
+// Sets the start bit from the SYM numbering, Motorola signals count from the msb of the first byte
+func (p *parser) setStart(s *dbc.Signal, start int) error {
+	if s.ByteOrder == bitfield.BigEndian {
+		start = 8*(start/8) + 7 - start%8
+	}
+	s.StartBit = start
+	if err := bitfield.Check(s.StartBit, s.Length, s.ByteOrder, gocan.MaxDataLength); err != nil {
+		return p.errorf("signal %v: %v", s.Name, err)
+	}
+	return nil
+}
+
+// Parses a variable: Name type start,length [-m] [-h] [-b] [/u:unit] [/f:factor] [/o:offset] [/min:x] [/max:x] [/e:enum] [/d:default]
+func (p *parser) parseVar(f []string, checkStart bool) (*dbc.Signal, error) {
+	if len(f) < 3 {
+		return nil, p.errorf("invalid variable %q", strings.Join(f, " "))
+	}
+	s := &dbc.Signal{Name: f[0], Factor: 1, ByteOrder: bitfield.LittleEndian, Attributes: make(map[string]any)}
+
+	length := -1
+	switch strings.ToLower(f[1]) {
+	case "unsigned", "char", "string", "raw":
+	case "bit":
+		length = 1
+	case "signed":
+		s.Signed = true
+	case "float":
+		s.Type, length = dbc.Float32, 32
+	case "double":
+		s.Type, length = dbc.Float64, 64
+	default:
+		return nil, p.errorf("unknown type %q", f[1])
+	}
+
+	startText, lengthText, hasLength := strings.Cut(f[2], ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return nil, p.errorf("invalid start bit %q", startText)
+	}
+	if hasLength {
+		n, err := strconv.Atoi(lengthText)
+		if err != nil || n <= 0 || n > bitfield.MaxLength {
+			return nil, p.errorf("invalid length %q", lengthText)
+		}
+		length = n
+	}
+	if length < 0 {
+		return nil, p.errorf("missing length of %v", s.Name)
+	}
+	s.Length = length
+
+	var startValue *float64
+	for _, option := range f[3:] {
+		if strings.HasPrefix(option, "-") {
+			if option == "-m" {
+				s.ByteOrder = bitfield.BigEndian
+			}
+			// display flags like -h and -b are ignored
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(option, "/"), ":")
+		if !ok || !strings.HasPrefix(option, "/") {
+			return nil, p.errorf("invalid option %q", option)
+		}
+		value = unquote(value)
+		var number float64
+		switch name {
+		case "f", "o", "min", "max", "d":
+			if number, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, p.errorf("invalid option %q", option)
+			}
+		}
+		switch name {
+		case "u":
+			s.Unit = value
+		case "f":
+			s.Factor = number
+		case "o":
+			s.Offset = number
+		case "min":
+			s.Min = number
+		case "max":
+			s.Max = number
+		case "d":
+			startValue = &number
+		case "e":
+			p.refs = append(p.refs, enumRef{signal: s, enum: value, line: p.line})
+		}
+		// further options like /ln and /p only affect the display
+	}
+	if startValue != nil && s.Factor != 0 {
+		s.StartValue = (*startValue - s.Offset) / s.Factor
+	}
+	if checkStart {
+		if err := p.setStart(s, start); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Parses a multiplexer: Name start,length value [-m]
+func (p *parser) parseMux(value, comment string) error {
+	f := fields(value)
+	if len(f) < 3 {
+		return p.errorf("invalid multiplexer %q", value)
+	}
+	muxValue, err := parseInt(f[2])
+	if err != nil || muxValue < 0 {
+		return p.errorf("invalid multiplexer value %q", f[2])
+	}
+	// the multiplexer is an unsigned variable without scaling
+	s, err := p.parseVar(append([]string{f[0], "unsigned", f[1]}, f[3:]...), true)
+	if err != nil {
+		return err
+	}
+	s.IsMultiplexer = true
+	s.Comment = comment
+	p.block.mux, p.block.muxValue = s, uint64(muxValue)
+	return nil
+}
+
+// Merges the current block into its message, blocks with the same id are variants of a multiplexed message
+func (p *parser) finishBlock() error {
+	b := p.block
+	if b == nil {
+		return nil
+	}
+	p.block = nil
+
+	var m *dbc.Message
+	if b.hasID {
+		m, _ = p.db.MessageByID(gocan.MessageID(b.id), b.extended)
+	} else {
+		m, _ = p.db.MessageByName(b.name)
+	}
+	if m == nil {
+		if !b.hasID {
+			return fmt.Errorf("line %v: %w: message %v without id", b.line, ErrSyntax, b.name)
+		}
+		m = &dbc.Message{
+			ID:         gocan.MessageID(b.id),
+			IsExtended: b.extended,
+			IsFD:       b.fd || b.length > 8,
+			Name:       b.name,
+			Length:     b.length,
+			CycleTime:  b.cycleTime,
+			Comment:    b.comment,
+			Attributes: map[string]any{AttrSection: p.section},
+		}
+		p.db.Messages = append(p.db.Messages, m)
+	}
+
+	if b.mux != nil {
+		mux := m.Multiplexer()
+		if mux == nil {
+			mux = b.mux
+			m.Signals = append(m.Signals, mux)
+		} else if mux.Name != b.mux.Name || mux.StartBit != b.mux.StartBit || mux.Length != b.mux.Length {
+			return fmt.Errorf("line %v: %w: message %v has different multiplexers", b.line, ErrSyntax, m.Name)
+		}
+	}
+
+	for _, s := range b.signals {
+		if b.mux != nil {
+			s.IsMultiplexed, s.MuxValue = true, b.muxValue
+		}
+		existing, ok := m.Signal(s.Name)
+		if !ok {
+			m.Signals = append(m.Signals, s)
+			continue
+		}
+		if existing.StartBit != s.StartBit || existing.Length != s.Length || existing.ByteOrder != s.ByteOrder {
+			return fmt.Errorf("line %v: %w: signal %v.%v defined twice", b.line, ErrSyntax, m.Name, s.Name)
+		}
+		// a variable repeated in the blocks of several multiplexer values is present independent of the multiplexer
+		if existing.IsMultiplexed && existing.MuxValue != s.MuxValue {
+			existing.IsMultiplexed, existing.MuxValue = false, 0
+		}
+	}
+	return nil
+}
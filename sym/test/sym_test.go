@@ -0,0 +1,250 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/bitfield"
+	"github.com/morgadow/gocan/dbc"
+	"github.com/morgadow/gocan/sym"
+)
+
+const auxSYM = `FormatVersion=6.0 // Do not edit this line!
+Title="Test network"
+
+{ENUMS}
+enum State(0="Off", 1="On",
+  2="Error", 3="SNA")
+
+{SIGNALS}
+Sig=Counter unsigned 4	// alive counter
+Sig=GearSig unsigned 2 /e:State
+
+{SEND}
+
+[Engine]
+ID=100h	// Engine status
+DLC=8
+CycleTime=100
+Var=Speed unsigned 0,16 /u:km/h /f:0.01 /max:655.35
+Var=Temp signed 16,8 /u:degC /o:-40 /min:-80 /max:87 /d:0
+Var=Rpm unsigned 24,16 -m /u:rpm /f:0.25 /max:16383.75
+Var=State unsigned 40,2 /e:State	// engine state
+Sig=Counter 56
+Sig=GearSig 60
+
+{RECEIVE}
+
+[Diag]
+ID=400h
+Type=Extended
+DLC=8
+Mux=Mode 0,8 1h
+Var=Flag bit 63,1
+Var=ValueA unsigned 8,16 /u:V /f:0.5 /max:1000
+
+[Diag]
+DLC=8
+Mux=Mode 0,8 2
+Var=Flag bit 63,1
+Var=ValueB signed 8,16 -m /u:A /min:-100 /max:100
+
+{SENDRECEIVE}
+
+[Floats]
+ID=200h
+Type=FDStandard
+DLC=12
+Var=F32 float 0,32
+Var=F64 double 32,64 /f:2 /o:1
+`
+
+func auxParse(t *testing.T) *dbc.Database {
+	db, err := sym.Parse(strings.NewReader(auxSYM))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return db
+}
+
+func auxMessage(t *testing.T, db *dbc.Database, name string) *dbc.Message {
+	m, ok := db.MessageByName(name)
+	if !ok {
+		t.Fatalf("missing message %v", name)
+	}
+	return m
+}
+
+func TestParse(t *testing.T) {
+	db := auxParse(t)
+	if db.Comment != "Test network" || len(db.Messages) != 3 || db.ValueTables["State"][3] != "SNA" {
+		t.Fatalf("unexpected database: comment %q, %v messages, enums %v", db.Comment, len(db.Messages), db.ValueTables)
+	}
+
+	engine := auxMessage(t, db, "Engine")
+	if engine.ID != 0x100 || engine.IsExtended || engine.Length != 8 || engine.CycleTime != 100*time.Millisecond ||
+		engine.Comment != "Engine status" || engine.Attributes[sym.AttrSection] != sym.SectionSend || len(engine.Signals) != 6 {
+		t.Errorf("unexpected message: %+v", engine)
+	}
+	temp, _ := engine.Signal("Temp")
+	if !temp.Signed || temp.Offset != -40 || temp.Min != -80 || temp.StartValue != 40 {
+		t.Errorf("unexpected signal: %+v", temp)
+	}
+	// Motorola start bits count from the msb of the first byte
+	rpm, _ := engine.Signal("Rpm")
+	if rpm.ByteOrder != bitfield.BigEndian || rpm.StartBit != 31 || rpm.Factor != 0.25 {
+		t.Errorf("unexpected signal: %+v", rpm)
+	}
+	state, _ := engine.Signal("State")
+	if state.Values[2] != "Error" || state.Comment != "engine state" {
+		t.Errorf("unexpected signal: %+v", state)
+	}
+	// enum of a {SIGNALS} definition referenced by the message
+	if gear, _ := engine.Signal("GearSig"); gear.StartBit != 60 || gear.Values[3] != "SNA" {
+		t.Errorf("unexpected signal: %+v", gear)
+	}
+	counter, _ := engine.Signal("Counter")
+	if counter.StartBit != 56 || counter.Length != 4 || counter.Comment != "alive counter" {
+		t.Errorf("unexpected signal: %+v", counter)
+	}
+
+	diag := auxMessage(t, db, "Diag")
+	if !diag.IsExtended || diag.Attributes[sym.AttrSection] != sym.SectionReceive || diag.Multiplexer().Name != "Mode" {
+		t.Errorf("unexpected message: %+v", diag)
+	}
+	if flag, _ := diag.Signal("Flag"); flag.IsMultiplexed || flag.Length != 1 {
+		t.Errorf("expected Flag independent of the multiplexer, got %+v", flag)
+	}
+	if valueB, _ := diag.Signal("ValueB"); !valueB.IsMultiplexed || valueB.MuxValue != 2 || valueB.StartBit != 15 {
+		t.Errorf("unexpected signal: %+v", valueB)
+	}
+
+	floats := auxMessage(t, db, "Floats")
+	if !floats.IsFD || floats.Length != 12 {
+		t.Errorf("unexpected message: %+v", floats)
+	}
+	if f64, _ := floats.Signal("F64"); f64.Type != dbc.Float64 || f64.Length != 64 {
+		t.Errorf("unexpected signal: %+v", f64)
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	db := auxParse(t)
+	data := []byte{0x34, 0x12, 0xD8, 0x0F, 0xA0, 0x02, 0, 0x05}
+
+	m, values, err := db.Decode(&gocan.Message{ID: 0x100, Data: data})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]float64{"Speed": 46.6, "Temp": -80, "Rpm": 1000, "State": 2, "Counter": 5, "GearSig": 0}
+	if m.Name != "Engine" || len(values) != len(expected) {
+		t.Fatalf("got %v with %v values, expected Engine with %v values", m.Name, len(values), len(expected))
+	}
+	for _, v := range values {
+		if diff := v.Physical - expected[v.Signal.Name]; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%v: got %v, expected %v", v.Signal.Name, v.Physical, expected[v.Signal.Name])
+		}
+	}
+
+	msg, err := db.Encode("Engine", expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(msg.Data, data) {
+		t.Errorf("got % X, expected % X", msg.Data, data)
+	}
+
+	msg, err = db.Encode("Diag", map[string]float64{"Mode": 2, "ValueB": -5, "Flag": 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !msg.IsExtended || !bytes.Equal(msg.Data, []byte{2, 0xFF, 0xFB, 0, 0, 0, 0, 0x80}) {
+		t.Errorf("got %+v", msg)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var dbcOut bytes.Buffer
+	if err := sym.ToDBC(strings.NewReader(auxSYM), &dbcOut); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var symOut bytes.Buffer
+	if err := sym.FromDBC(bytes.NewReader(dbcOut.Bytes()), &symOut); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, dbcOut.String())
+	}
+	db, err := sym.Parse(&symOut)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	original := auxParse(t)
+	for _, m := range original.Messages {
+		converted := auxMessage(t, db, m.Name)
+		if converted.ID != m.ID || converted.IsExtended != m.IsExtended || converted.IsFD != m.IsFD ||
+			converted.Length != m.Length || converted.CycleTime != m.CycleTime || converted.Comment != m.Comment ||
+			converted.Attributes[sym.AttrSection] != m.Attributes[sym.AttrSection] || len(converted.Signals) != len(m.Signals) {
+			t.Errorf("got %+v, expected %+v", converted, m)
+			continue
+		}
+		for _, s := range m.Signals {
+			c, ok := converted.Signal(s.Name)
+			if !ok || c.StartBit != s.StartBit || c.Length != s.Length || c.ByteOrder != s.ByteOrder || c.Signed != s.Signed ||
+				c.Type != s.Type || c.Factor != s.Factor || c.Offset != s.Offset || c.Min != s.Min || c.Max != s.Max ||
+				c.Unit != s.Unit || c.StartValue != s.StartValue || c.IsMultiplexer != s.IsMultiplexer ||
+				c.IsMultiplexed != s.IsMultiplexed || c.MuxValue != s.MuxValue || len(c.Values) != len(s.Values) || c.Comment != s.Comment {
+				t.Errorf("%v: got %+v, expected %+v", m.Name, c, s)
+			}
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := sym.Parse(strings.NewReader("FormatVersion=6.0\n{SEND}\n[A]\nID=1h\nVar=S int 0,8\n"))
+	if !errors.Is(err, sym.ErrSyntax) || !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("expected ErrSyntax in line 5, got %v", err)
+	}
+	_, err = sym.Parse(strings.NewReader("{SEND}\n[A]\nID=1h\nVar=S unsigned 0,8 /e:Missing\n"))
+	if !errors.Is(err, sym.ErrUnknownEnum) {
+		t.Errorf("expected ErrUnknownEnum, got %v", err)
+	}
+	_, err = sym.Parse(strings.NewReader("{SEND}\n[A]\nID=1h\nVar=S unsigned 510,8\n"))
+	if !errors.Is(err, sym.ErrSyntax) {
+		t.Errorf("expected ErrSyntax, got %v", err)
+	}
+}
+
+func TestWriteSingleMuxValue(t *testing.T) {
+	const dbcText = `VERSION ""
+
+BU_: ECU
+
+BO_ 512 Diag: 8 ECU
+ SG_ Page M : 0|8@1+ (1,0) [0|0] "" Vector__XXX
+ SG_ Counter : 8|4@1+ (1,0) [0|15] "" Vector__XXX
+ SG_ Speed m0 : 16|16@1+ (0.1,0) [0|0] "km/h" Vector__XXX
+`
+	var symOut bytes.Buffer
+	if err := sym.FromDBC(strings.NewReader(dbcText), &symOut); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db, err := sym.Parse(bytes.NewReader(symOut.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the signal present for every multiplexer value stays unmultiplexed with only one multiplexed value
+	m := auxMessage(t, db, "Diag")
+	if s, ok := m.Signal("Counter"); !ok || s.IsMultiplexed {
+		t.Errorf("got %+v, expected an unmultiplexed signal\n%s", s, symOut.String())
+	}
+	if s, ok := m.Signal("Speed"); !ok || !s.IsMultiplexed || s.MuxValue != 0 {
+		t.Errorf("got %+v, expected a signal multiplexed with 0\n%s", s, symOut.String())
+	}
+	if mux := m.Multiplexer(); mux == nil || mux.Name != "Page" {
+		t.Errorf("got multiplexer %+v, expected Page", mux)
+	}
+}
@@ -0,0 +1,269 @@
+package sym
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/morgadow/gocan/bitfield"
+	"github.com/morgadow/gocan/dbc"
+)
+
+// Format version written into symbol files
+const FormatVersion = "6.0"
+
+// Formats a number without trailing zeros
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Quotes a value if it contains whitespace
+func quoteIfNeeded(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// Returns the start bit in SYM numbering, Motorola signals count from the msb of the first byte
+func symStart(s *dbc.Signal) int {
+	if s.ByteOrder == bitfield.BigEndian {
+		return 8*(s.StartBit/8) + 7 - s.StartBit%8
+	}
+	return s.StartBit
+}
+
+// Returns the section of a message, the attribute may hold the name or the enum index
+func section(db *dbc.Database, m *dbc.Message) string {
+	switch v := m.Attributes[AttrSection].(type) {
+	case string:
+		if slices.Contains(sections, v) {
+			return v
+		}
+	case float64:
+		if def, ok := db.AttributeDef(AttrSection); ok && int(v) >= 0 && int(v) < len(def.Enum) {
+			return def.Enum[int(v)]
+		}
+	}
+	return SectionSendReceive
+}
+
+// Assigns enum names to the value descriptions of all signals
+// Value tables are written with their name, signals with descriptions not matching a table get an enum named after
+// the signal
+func enums(db *dbc.Database) (map[string]map[int64]string, map[*dbc.Signal]string) {
+	result := maps.Clone(db.ValueTables)
+	if result == nil {
+		result = make(map[string]map[int64]string)
+	}
+	names := make(map[*dbc.Signal]string)
+	for _, m := range db.Messages {
+		for _, s := range m.Signals {
+			if len(s.Values) == 0 {
+				continue
+			}
+			for _, name := range slices.Sorted(maps.Keys(result)) {
+				if maps.Equal(result[name], s.Values) {
+					names[s] = name
+					break
+				}
+			}
+			if _, ok := names[s]; ok {
+				continue
+			}
+			name := s.Name
+			for i := 2; result[name] != nil; i++ {
+				name = fmt.Sprintf("%v_%v", s.Name, i)
+			}
+			result[name] = s.Values
+			names[s] = name
+		}
+	}
+	return result, names
+}
+
+// Formats a variable: Name type start,length [-m] [options]
+func formatVar(s *dbc.Signal, enum string) string {
+	var sb strings.Builder
+	typ := "unsigned"
+	switch {
+	case s.Type == dbc.Float32:
+		typ = "float"
+	case s.Type == dbc.Float64:
+		typ = "double"
+	case s.Signed:
+		typ = "signed"
+	case s.Length == 1:
+		typ = "bit"
+	}
+	fmt.Fprintf(&sb, "%v %v %v,%v", s.Name, typ, symStart(s), s.Length)
+	if s.ByteOrder == bitfield.BigEndian {
+		sb.WriteString(" -m")
+	}
+	if s.Unit != "" {
+		fmt.Fprintf(&sb, " /u:%v", quoteIfNeeded(s.Unit))
+	}
+	if s.Factor != 1 {
+		fmt.Fprintf(&sb, " /f:%v", formatNumber(s.Factor))
+	}
+	if s.Offset != 0 {
+		fmt.Fprintf(&sb, " /o:%v", formatNumber(s.Offset))
+	}
+	if s.Min != 0 || s.Max != 0 {
+		fmt.Fprintf(&sb, " /min:%v /max:%v", formatNumber(s.Min), formatNumber(s.Max))
+	}
+	if enum != "" {
+		fmt.Fprintf(&sb, " /e:%v", enum)
+	}
+	if s.StartValue != 0 {
+		fmt.Fprintf(&sb, " /d:%v", formatNumber(s.StartValue*s.Factor+s.Offset))
+	}
+	if s.Comment != "" {
+		fmt.Fprintf(&sb, "\t// %v", strings.Join(strings.Fields(s.Comment), " "))
+	}
+	return sb.String()
+}
+
+// Writes the header lines of a message block
+func writeHeader(w io.Writer, m *dbc.Message) {
+	fmt.Fprintf(w, "[%v]\n", m.Name)
+	if m.Comment != "" {
+		fmt.Fprintf(w, "ID=%Xh\t// %v\n", uint32(m.ID), strings.Join(strings.Fields(m.Comment), " "))
+	} else {
+		fmt.Fprintf(w, "ID=%Xh\n", uint32(m.ID))
+	}
+	switch {
+	case m.IsFD && m.IsExtended:
+		fmt.Fprintln(w, "Type=FDExtended")
+	case m.IsFD:
+		fmt.Fprintln(w, "Type=FDStandard")
+	case m.IsExtended:
+		fmt.Fprintln(w, "Type=Extended")
+	}
+	fmt.Fprintf(w, "DLC=%v\n", m.Length)
+	if m.CycleTime > 0 {
+		fmt.Fprintf(w, "CycleTime=%v\n", m.CycleTime.Milliseconds())
+	}
+}
+
+// Writes a message, multiplexed messages are written as one block per multiplexer value
+func writeMessage(w io.Writer, m *dbc.Message, enumNames map[*dbc.Signal]string) {
+	var muxValues []uint64
+	for _, s := range m.Signals {
+		if s.IsMultiplexed && !slices.Contains(muxValues, s.MuxValue) {
+			muxValues = append(muxValues, s.MuxValue)
+		}
+	}
+
+	mux := m.Multiplexer()
+	if mux != nil && len(muxValues) == 1 && slices.ContainsFunc(m.Signals, func(s *dbc.Signal) bool { return s != mux && !s.IsMultiplexed }) {
+		// signals are only read as present for every multiplexer value if they are repeated in several blocks,
+		// so they are written into a second block with an unused multiplexer value as well
+		unused := uint64(0)
+		if unused == muxValues[0] {
+			unused++
+		}
+		muxValues = append(muxValues, unused)
+	}
+	slices.Sort(muxValues)
+
+	if mux == nil || len(muxValues) == 0 {
+		writeHeader(w, m)
+		for _, s := range m.Signals {
+			fmt.Fprintf(w, "Var=%v\n", formatVar(s, enumNames[s]))
+		}
+		fmt.Fprintln(w)
+		return
+	}
+	for _, value := range muxValues {
+		writeHeader(w, m)
+		fmt.Fprintf(w, "Mux=%v %v,%v %Xh", mux.Name, symStart(mux), mux.Length, value)
+		if mux.ByteOrder == bitfield.BigEndian {
+			fmt.Fprint(w, " -m")
+		}
+		fmt.Fprintln(w)
+		for _, s := range m.Signals {
+			if s != mux && (!s.IsMultiplexed || s.MuxValue == value) {
+				fmt.Fprintf(w, "Var=%v\n", formatVar(s, enumNames[s]))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// Writes the database as PEAK symbol file
+// Messages are sorted into the sections given by the attribute SymSection, messages without it are written into
+// {SENDRECEIVE}. Signals present for every multiplexer value are repeated in each block of a multiplexed message,
+// a message with a single multiplexer value gets a second block with an unused value holding only these signals.
+func Write(w io.Writer, db *dbc.Database) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "FormatVersion=%v // Do not edit this line!\n", FormatVersion)
+	title := strings.Join(strings.Fields(db.Comment), " ")
+	fmt.Fprintf(bw, "Title=\"%v\"\n", strings.ReplaceAll(title, `"`, "'"))
+
+	tables, enumNames := enums(db)
+	if len(tables) > 0 {
+		fmt.Fprint(bw, "\n{ENUMS}\n")
+		for _, name := range slices.Sorted(maps.Keys(tables)) {
+			keys := slices.Sorted(maps.Keys(tables[name]))
+			items := make([]string, len(keys))
+			for i, k := range keys {
+				items[i] = fmt.Sprintf("%v=\"%v\"", k, strings.ReplaceAll(tables[name][k], `"`, "'"))
+			}
+			fmt.Fprintf(bw, "enum %v(%v)\n", name, strings.Join(items, ", "))
+		}
+	}
+
+	for _, sec := range sections {
+		var messages []*dbc.Message
+		for _, m := range db.Messages {
+			if section(db, m) == sec {
+				messages = append(messages, m)
+			}
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "\n{%v}\n\n", sec)
+		for _, m := range messages {
+			writeMessage(bw, m, enumNames)
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes the database into a PEAK symbol file
+func WriteFile(path string, db *dbc.Database) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, db); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Converts a PEAK symbol file into a DBC file
+func ToDBC(r io.Reader, w io.Writer) error {
+	db, err := Parse(r)
+	if err != nil {
+		return err
+	}
+	return dbc.Write(w, db)
+}
+
+// Converts a DBC file into a PEAK symbol file
+func FromDBC(r io.Reader, w io.Writer) error {
+	db, err := dbc.Parse(r)
+	if err != nil {
+		return err
+	}
+	return Write(w, db)
+}
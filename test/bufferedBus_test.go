@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+)
+
+func auxVirtualPair(t *testing.T, channel string, queueSize int) (gocan.Bus, gocan.Bus) {
+	oldSize := virtual.QueueSize
+	defer func() { virtual.QueueSize = oldSize }()
+
+	tx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: channel, BaudRate: 500000})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	virtual.QueueSize = queueSize
+	rx, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: channel, BaudRate: 500000})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	return tx, rx
+}
+
+// waits until the buffered bus processed the expected amount of messages
+func auxWaitStats(t *testing.T, b *gocan.BufferedBus, processed uint64) gocan.RxStats {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		stats := b.Stats()
+		if uint64(stats.Buffered)+stats.SoftwareDrops >= processed {
+			return stats
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("buffered bus did not process %v messages: %+v", processed, b.Stats())
+	return gocan.RxStats{}
+}
+
+func TestBufferedBusSoftwareDrops(t *testing.T) {
+	tx, rx := auxVirtualPair(t, "TestBufferedBusSoftwareDrops", 1024)
+	defer tx.Close()
+	buffered := gocan.NewBufferedBus(rx, 4)
+	defer buffered.Close()
+
+	for i := 0; i < 10; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+
+	stats := auxWaitStats(t, buffered, 10)
+	if stats.SoftwareDrops != 6 || stats.HighWaterMark != 4 || stats.Capacity != 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// drops are reported once before the remaining messages
+	_, err := buffered.Recv(100)
+	var overrun *gocan.OverrunError
+	if !errors.As(err, &overrun) || overrun.Software != 6 || overrun.Hardware != 0 {
+		t.Fatalf("expected overrun error, got %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		msg, err := buffered.Recv(100)
+		if err != nil || msg == nil || msg.ID != gocan.MessageID(i) {
+			t.Errorf("unexpected message %v: msg: %v, err: %v", i, msg, err)
+		}
+	}
+	if msg, err := buffered.Recv(10); msg != nil || err != nil {
+		t.Errorf("unexpected message: msg: %v, err: %v", msg, err)
+	}
+}
+
+func TestBufferedBusHardwareOverruns(t *testing.T) {
+	tx, rx := auxVirtualPair(t, "TestBufferedBusHardwareOverruns", 4)
+	defer tx.Close()
+
+	// overrun the device queue before the background reader starts
+	for i := 0; i < 6; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+	buffered := gocan.NewBufferedBus(rx, 16)
+	defer buffered.Close()
+
+	stats := auxWaitStats(t, buffered, 4)
+	if stats.HardwareOverruns != 2 || stats.SoftwareDrops != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	buf := make([]gocan.Message, 16)
+	n, err := buffered.ReadInto(buf)
+	if n != 4 || !errors.Is(err, gocan.ErrRxOverrun) {
+		t.Errorf("expected 4 messages and overrun error, got %v, %v", n, err)
+	}
+}
+
+func TestBufferedBusIterator(t *testing.T) {
+	tx, rx := auxVirtualPair(t, "TestBufferedBusIterator", 1024)
+	defer tx.Close()
+	buffered := gocan.NewBufferedBus(rx, 2)
+	defer buffered.Close()
+
+	for i := 0; i < 5; i++ {
+		tx.Send(&gocan.Message{ID: gocan.MessageID(i), Data: []byte{byte(i)}})
+	}
+	auxWaitStats(t, buffered, 5)
+
+	// overrun errors do not end the iteration
+	errCount, msgCount := 0, 0
+	for _, err := range gocan.Take(gocan.Messages(context.Background(), buffered), 2) {
+		if err != nil {
+			errCount++
+			continue
+		}
+		msgCount++
+	}
+	if errCount != 1 || msgCount != 2 {
+		t.Errorf("expected 1 error and 2 messages, got %v and %v", errCount, msgCount)
+	}
+}
+
+// bus returning the first received message together with an overrun, like PCAN does, and counting batch sends
+type auxOverrunBus struct {
+	gocan.Bus
+	overrun bool
+	batches int
+}
+
+func (b *auxOverrunBus) Recv(timeout int) (*gocan.Message, error) {
+	msg, err := b.Bus.Recv(timeout)
+	if msg != nil && !b.overrun {
+		b.overrun = true
+		return msg, &gocan.OverrunError{Hardware: 1}
+	}
+	return msg, err
+}
+
+func (b *auxOverrunBus) SendBatch(msgs []gocan.Message) (int, error) {
+	b.batches++
+	return gocan.SendBatch(b.Bus, msgs)
+}
+
+func TestBufferedBusOverrunWithMessage(t *testing.T) {
+	tx, rx := auxVirtualPair(t, "TestBufferedBusOverrunWithMessage", 16)
+	defer tx.Close()
+
+	tx.Send(&gocan.Message{ID: 0x1, Data: []byte{0x1}})
+	buffered := gocan.NewBufferedBus(&auxOverrunBus{Bus: rx}, 16)
+	defer buffered.Close()
+
+	stats := auxWaitStats(t, buffered, 1)
+	if stats.HardwareOverruns != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	msgs, err := buffered.ReadBuffer(0)
+	if len(msgs) != 1 || msgs[0].ID != 0x1 || !errors.Is(err, gocan.ErrRxOverrun) {
+		t.Errorf("expected the message read with the overrun and overrun error, got %v, %v", msgs, err)
+	}
+}
+
+func TestBufferedBusSendBatch(t *testing.T) {
+	tx, rx := auxVirtualPair(t, "TestBufferedBusSendBatch", 16)
+	defer rx.Close()
+
+	wrapped := &auxOverrunBus{Bus: tx}
+	buffered := gocan.NewBufferedBus(wrapped, 16)
+	defer buffered.Close()
+
+	n, err := gocan.SendBatch(buffered, auxMessages(0x1, 0x2, 0x3))
+	if n != 3 || err != nil {
+		t.Fatalf("expected 3 sent messages, got %v, %v", n, err)
+	}
+	if wrapped.batches != 1 {
+		t.Errorf("expected SendBatch of the wrapped bus to be used once, got %v", wrapped.batches)
+	}
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// receiver returning the given messages once and then only timeouts
+type auxReceiver struct {
+	msgs []gocan.Message
+	err  error
+}
+
+func (r *auxReceiver) Recv(timeout int) (*gocan.Message, error) {
+	if len(r.msgs) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		time.Sleep(time.Duration(timeout) * time.Millisecond)
+		return nil, nil
+	}
+	msg := r.msgs[0]
+	r.msgs = r.msgs[1:]
+	return &msg, nil
+}
+
+func (r *auxReceiver) ReadBuffer(limit uint16) ([]gocan.Message, error) {
+	n := len(r.msgs)
+	if limit != 0 && n > int(limit) {
+		n = int(limit)
+	}
+	msgs := r.msgs[:n]
+	r.msgs = r.msgs[n:]
+	return msgs, nil
+}
+
+func (r *auxReceiver) ReadMessage() (*gocan.Message, error) {
+	if len(r.msgs) == 0 {
+		return nil, io.EOF
+	}
+	return r.Recv(0)
+}
+
+func auxMessages(ids ...gocan.MessageID) []gocan.Message {
+	msgs := make([]gocan.Message, len(ids))
+	for i, id := range ids {
+		msgs[i] = gocan.Message{ID: id, Data: []byte{byte(i)}, DLC: 1}
+	}
+	return msgs
+}
+
+func TestMessagesTakeFilter(t *testing.T) {
+	r := &auxReceiver{msgs: auxMessages(0x7E8, 0x100, 0x7E8, 0x7E9, 0x7E8, 0x7E8)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	msgs, err := gocan.Collect(gocan.Take(gocan.FilterIDs(gocan.Messages(ctx, r), 0x7E8), 3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %v", len(msgs))
+	}
+	for i, want := range []byte{0, 2, 4} {
+		if msgs[i].ID != 0x7E8 || msgs[i].Data[0] != want {
+			t.Errorf("unexpected message %v: %v", i, msgs[i])
+		}
+	}
+}
+
+func TestMessagesContextCancel(t *testing.T) {
+	r := &auxReceiver{msgs: auxMessages(0x1, 0x2)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	msgs, err := gocan.Collect(gocan.Take(gocan.Messages(ctx, r), 10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Errorf("expected 2 messages, got %v", len(msgs))
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("iteration did not stop on context cancellation")
+	}
+}
+
+func TestMessagesError(t *testing.T) {
+	errBus := errors.New("bus error")
+	r := &auxReceiver{msgs: auxMessages(0x1), err: errBus}
+
+	msgs, err := gocan.Collect(gocan.Messages(context.Background(), r))
+	if !errors.Is(err, errBus) {
+		t.Errorf("expected bus error, got %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Errorf("expected 1 message before error, got %v", len(msgs))
+	}
+}
+
+func TestBufferedMessages(t *testing.T) {
+	r := &auxReceiver{msgs: auxMessages(0x1, 0x2, 0x3, 0x4, 0x5)}
+
+	msgs, err := gocan.Collect(gocan.Take(gocan.BufferedMessages(context.Background(), r, 2), 5))
+	if err != nil || len(msgs) != 5 {
+		t.Fatalf("expected 5 messages, got %v, err: %v", len(msgs), err)
+	}
+	if msgs[4].ID != 0x5 {
+		t.Errorf("wrong message order: %v", msgs)
+	}
+}
+
+func TestReadMessages(t *testing.T) {
+	r := &auxReceiver{msgs: auxMessages(0x1, 0x2, 0x3)}
+
+	count := 0
+	for msg, err := range gocan.ReadMessages(context.Background(), r) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count++
+		if msg.ID != gocan.MessageID(count) {
+			t.Errorf("unexpected id 0x%X", msg.ID)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected 3 messages, got %v", count)
+	}
+}
+
+func TestCollectReusedMessage(t *testing.T) {
+	// iterator yielding the same message with new data, like receivers reusing their storage
+	seq := func(yield func(*gocan.Message, error) bool) {
+		var msg gocan.Message
+		for i := range 3 {
+			msg.ID = gocan.MessageID(i)
+			msg.SetData([]byte{byte(i)})
+			if !yield(&msg, nil) {
+				return
+			}
+		}
+	}
+
+	msgs, err := gocan.Collect(seq)
+	if err != nil || len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %v, err: %v", len(msgs), err)
+	}
+	for i := range msgs {
+		if msgs[i].Data[0] != byte(i) {
+			t.Errorf("message %v: data % X was overwritten", i, msgs[i].Data)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package timing
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+)
+
+// Interval in which Run() checks for timed out messages
+var CheckInterval = 10 * time.Millisecond
+
+// Kind of a timing event
+type EventType uint8
+
+const (
+	EventTimeout   EventType = iota // Expected message was not received within period and tolerance
+	EventTooEarly  EventType = iota // Message was received earlier than period minus tolerance
+	EventTooLate   EventType = iota // Message was received later than period plus tolerance
+	EventDLCChange EventType = iota // Message was received with a different DLC than before
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventTimeout:
+		return "timeout"
+	case EventTooEarly:
+		return "too early"
+	case EventTooLate:
+		return "too late"
+	case EventDLCChange:
+		return "dlc change"
+	default:
+		return "unknown"
+	}
+}
+
+// Identifies a message id, standard and extended ids with the same value are analyzed separately
+type IDKey struct {
+	ID         gocan.MessageID
+	IsExtended bool
+}
+
+// Timing event raised by the analyzer
+type Event struct {
+	Type       EventType
+	ID         gocan.MessageID
+	IsExtended bool
+	Time       uint64        // message timestamp in µs which raised the event, or the estimated bus time for a timeout
+	Interval   time.Duration // observed time since the previous message of this id
+	Expected   time.Duration // expected period, zero if none was configured
+	DLC        uint8         // DLC of the message, only set for EventDLCChange
+}
+
+// Expected timing of a single message id
+type Expectation struct {
+	Period    time.Duration
+	Tolerance time.Duration // maximum allowed deviation from Period
+}
+
+// Timing report of a single message id
+type Report struct {
+	ID            gocan.MessageID
+	IsExtended    bool
+	Count         uint64        // received messages
+	Expected      time.Duration // configured period, zero if none was configured
+	Period        time.Duration // mean observed period
+	MinPeriod     time.Duration // shortest observed period
+	MaxPeriod     time.Duration // longest observed period
+	MinJitter     time.Duration // largest negative deviation from the expected or mean period
+	MaxJitter     time.Duration // largest positive deviation from the expected or mean period
+	StdDev        time.Duration // standard deviation of the observed period
+	DLCChanges    uint64        // amount of DLC changes
+	MissingCycles uint64        // cycles without a message, only evaluated with an expected period
+	Violations    uint64        // messages outside of the tolerance, only evaluated with an expected period
+	Timeouts      uint64        // raised timeout events
+	OutOfOrder    uint64        // ignored messages with a timestamp older than the previous message of the id
+}
+
+// running state of a single id
+type idState struct {
+	report    Report
+	last      uint64 // timestamp of the previous message in µs
+	dlc       uint8
+	mean      float64 // running mean of the period in µs
+	m2        float64 // running sum of squared differences from the mean
+	intervals uint64
+	timedOut  bool // a timeout was already raised for the current gap
+}
+
+// Analyzer measures the cycle time and jitter of every message id
+// Messages are fed with Feed() or Run(), events are delivered to OnEvent which is called synchronously and never concurrently
+// The channel of a message is not evaluated, traffic of different channels needs an analyzer per channel
+type Analyzer struct {
+	OnEvent func(Event)
+
+	mu       sync.Mutex
+	raiseMu  sync.Mutex // serializes calls of OnEvent from Feed() and the timeout check of Run()
+	expected map[IDKey]Expectation
+	ids      map[IDKey]*idState
+	lastTime uint64    // timestamp of the latest message in µs
+	lastWall time.Time // wall clock time when the latest message was fed
+}
+
+// Creates an analyzer without expected periods
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		expected: make(map[IDKey]Expectation),
+		ids:      make(map[IDKey]*idState),
+	}
+}
+
+// Configures the expected period of an id, only ids with an expectation raise timeout and tolerance events
+func (a *Analyzer) Expect(key IDKey, period time.Duration, tolerance time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expected[key] = Expectation{Period: period, Tolerance: tolerance}
+}
+
+// Analyzes a single message, messages should be fed in order of their timestamps
+// A message older than the previous message of its id is counted as OutOfOrder and otherwise ignored
+func (a *Analyzer) Feed(msg *gocan.Message) {
+	if msg.Type == gocan.ErrorFrame {
+		return
+	}
+
+	a.mu.Lock()
+	var events []Event
+	defer func() {
+		a.mu.Unlock()
+		a.raise(events)
+	}()
+
+	now := msg.TimeStamp
+	key := IDKey{ID: msg.ID, IsExtended: msg.IsExtended}
+	state, ok := a.ids[key]
+	if ok && now < state.last {
+		state.report.OutOfOrder++
+		return
+	}
+	if now >= a.lastTime {
+		a.lastTime = now
+		a.lastWall = time.Now()
+	}
+	events = a.checkTimeouts(now)
+
+	exp, hasExp := a.expected[key]
+	if !ok {
+		state = &idState{report: Report{ID: msg.ID, IsExtended: msg.IsExtended, Expected: exp.Period}, dlc: msg.DLC}
+		a.ids[key] = state
+	}
+	state.report.Count++
+	state.report.Expected = exp.Period
+	state.timedOut = false
+
+	if msg.DLC != state.dlc {
+		state.report.DLCChanges++
+		events = append(events, Event{Type: EventDLCChange, ID: msg.ID, IsExtended: msg.IsExtended, Time: now, Expected: exp.Period, DLC: msg.DLC})
+		state.dlc = msg.DLC
+	}
+
+	if state.report.Count == 1 {
+		state.last = now
+		return
+	}
+
+	interval := time.Duration(now-state.last) * time.Microsecond
+	state.last = now
+	state.addInterval(interval)
+
+	if !hasExp || exp.Period <= 0 {
+		return
+	}
+	deviation := interval - exp.Period
+	if deviation > exp.Tolerance {
+		state.report.Violations++
+		if missing := uint64((interval + exp.Period/2) / exp.Period); missing > 1 {
+			state.report.MissingCycles += missing - 1
+		}
+		events = append(events, Event{Type: EventTooLate, ID: msg.ID, IsExtended: msg.IsExtended, Time: now, Interval: interval, Expected: exp.Period})
+	} else if -deviation > exp.Tolerance {
+		state.report.Violations++
+		events = append(events, Event{Type: EventTooEarly, ID: msg.ID, IsExtended: msg.IsExtended, Time: now, Interval: interval, Expected: exp.Period})
+	}
+}
+
+// Updates period statistics with a new interval
+func (s *idState) addInterval(interval time.Duration) {
+	s.intervals++
+	if s.intervals == 1 || interval < s.report.MinPeriod {
+		s.report.MinPeriod = interval
+	}
+	if interval > s.report.MaxPeriod {
+		s.report.MaxPeriod = interval
+	}
+
+	// Welford's online algorithm for mean and variance
+	x := float64(interval / time.Microsecond)
+	delta := x - s.mean
+	s.mean += delta / float64(s.intervals)
+	s.m2 += delta * (x - s.mean)
+
+	reference := s.report.Expected
+	if reference == 0 {
+		reference = time.Duration(s.mean) * time.Microsecond
+	}
+	jitter := interval - reference
+	if jitter < s.report.MinJitter {
+		s.report.MinJitter = jitter
+	}
+	if jitter > s.report.MaxJitter {
+		s.report.MaxJitter = jitter
+	}
+}
+
+// Raises timeout events for all expected ids without a message since period plus tolerance
+// Note: a.mu must be locked
+func (a *Analyzer) checkTimeouts(now uint64) []Event {
+	var events []Event
+	for key, exp := range a.expected {
+		state, ok := a.ids[key]
+		if !ok || state.timedOut || exp.Period <= 0 || now < state.last {
+			continue
+		}
+		elapsed := time.Duration(now-state.last) * time.Microsecond
+		if elapsed > exp.Period+exp.Tolerance {
+			state.timedOut = true
+			state.report.Timeouts++
+			events = append(events, Event{Type: EventTimeout, ID: key.ID, IsExtended: key.IsExtended, Time: now, Interval: elapsed, Expected: exp.Period})
+		}
+	}
+	return events
+}
+
+// Checks for timed out messages at the given bus time in µs, e.g. at the end of a log file
+func (a *Analyzer) Check(now uint64) {
+	a.mu.Lock()
+	events := a.checkTimeouts(now)
+	a.mu.Unlock()
+	a.raise(events)
+}
+
+// Checks for timed out messages at the bus time estimated from the wall clock since the latest message
+func (a *Analyzer) checkWall() {
+	a.mu.Lock()
+	if a.lastWall.IsZero() {
+		a.mu.Unlock()
+		return
+	}
+	now := a.lastTime + uint64(time.Since(a.lastWall)/time.Microsecond)
+	events := a.checkTimeouts(now)
+	a.mu.Unlock()
+	a.raise(events)
+}
+
+func (a *Analyzer) raise(events []Event) {
+	if a.OnEvent == nil || len(events) == 0 {
+		return
+	}
+	a.raiseMu.Lock()
+	defer a.raiseMu.Unlock()
+	for _, e := range events {
+		a.OnEvent(e)
+	}
+}
+
+// Feeds all messages of seq until it ends or ctx is cancelled, e.g. gocan.Messages(ctx, bus) or gocan.ReadMessages(ctx, log)
+// While running, timeouts are also detected when no messages are received
+func (a *Analyzer) Run(ctx context.Context, seq iter.Seq2[*gocan.Message, error]) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.checkWall()
+			}
+		}
+	}()
+
+	for msg, err := range seq {
+		if errors.Is(err, gocan.ErrRxOverrun) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		a.Feed(msg)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Returns the report of all ids sorted by id, standard ids first
+func (a *Analyzer) Report() []Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	reports := make([]Report, 0, len(a.ids))
+	for _, state := range a.ids {
+		report := state.report
+		if state.intervals > 0 {
+			report.Period = time.Duration(state.mean) * time.Microsecond
+		}
+		if state.intervals > 1 {
+			report.StdDev = time.Duration(math.Sqrt(state.m2/float64(state.intervals-1))) * time.Microsecond
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].IsExtended != reports[j].IsExtended {
+			return !reports[i].IsExtended
+		}
+		return reports[i].ID < reports[j].ID
+	})
+	return reports
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"context"
+	"iter"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/timing"
+)
+
+func auxMsg(id gocan.MessageID, timeStamp uint64, dlc uint8) *gocan.Message {
+	return &gocan.Message{ID: id, TimeStamp: timeStamp, DLC: dlc, Data: make([]byte, dlc)}
+}
+
+func TestPeriodAndJitter(t *testing.T) {
+	a := timing.NewAnalyzer()
+
+	// 100 ms cycle with +-2 ms jitter
+	ts := uint64(0)
+	for i := 0; i < 11; i++ {
+		a.Feed(auxMsg(0x100, ts, 8))
+		if i%2 == 0 {
+			ts += 102000
+		} else {
+			ts += 98000
+		}
+	}
+
+	reports := a.Report()
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %v", len(reports))
+	}
+	r := reports[0]
+	if r.Count != 11 || r.Period != 100*time.Millisecond || r.MinPeriod != 98*time.Millisecond || r.MaxPeriod != 102*time.Millisecond {
+		t.Errorf("wrong period: %+v", r)
+	}
+	if r.MinJitter != -2*time.Millisecond || r.MaxJitter > 2*time.Millisecond || r.StdDev < 2*time.Millisecond || r.StdDev > 3*time.Millisecond {
+		t.Errorf("wrong jitter: %+v", r)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	a := timing.NewAnalyzer()
+	a.Expect(timing.IDKey{ID: 0x200}, 10*time.Millisecond, time.Millisecond)
+
+	var events []timing.Event
+	a.OnEvent = func(e timing.Event) { events = append(events, e) }
+
+	a.Feed(auxMsg(0x200, 0, 8))
+	a.Feed(auxMsg(0x200, 10000, 8))
+	a.Feed(auxMsg(0x200, 15000, 8)) // too early
+	a.Feed(auxMsg(0x300, 30000, 8)) // other traffic detects timeout of 0x200
+	a.Feed(auxMsg(0x200, 45000, 4)) // two cycles missing and dlc change
+	a.Feed(auxMsg(0x200, 55000, 4)) // okay
+
+	expected := []timing.EventType{timing.EventTooEarly, timing.EventTimeout, timing.EventDLCChange, timing.EventTooLate}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v events, got %v", len(expected), events)
+	}
+	for i := range expected {
+		if events[i].Type != expected[i] || events[i].ID != 0x200 {
+			t.Errorf("event %v: expected %v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	r := a.Report()[0]
+	if r.MissingCycles != 2 || r.Violations != 2 || r.DLCChanges != 1 || r.Timeouts != 1 {
+		t.Errorf("wrong report: %+v", r)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	tx, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRunTimeout"})
+	rx, _ := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: "TestRunTimeout"})
+	defer tx.Close()
+	defer rx.Close()
+
+	a := timing.NewAnalyzer()
+	a.Expect(timing.IDKey{ID: 0x100}, 20*time.Millisecond, 5*time.Millisecond)
+	timeouts := make(chan timing.Event, 10)
+	a.OnEvent = func(e timing.Event) {
+		if e.Type == timing.EventTimeout {
+			timeouts <- e
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go a.Run(ctx, gocan.Messages(ctx, rx))
+
+	// the sender stops after a few cycles, the analyzer detects the timeout without further traffic
+	for i := 0; i < 3; i++ {
+		tx.Send(&gocan.Message{ID: 0x100, Data: []byte{1}})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case e := <-timeouts:
+		if e.ID != 0x100 {
+			t.Errorf("unexpected timeout event: %+v", e)
+		}
+	case <-ctx.Done():
+		t.Errorf("no timeout detected")
+	}
+}
+
+func TestExtendedIDs(t *testing.T) {
+	a := timing.NewAnalyzer()
+
+	// standard and extended 0x100 with different periods
+	for i := uint64(0); i < 5; i++ {
+		a.Feed(auxMsg(0x100, i*10000, 8))
+		ext := auxMsg(0x100, i*50000+1000, 8)
+		ext.IsExtended = true
+		a.Feed(ext)
+	}
+
+	reports := a.Report()
+	if len(reports) != 2 || reports[0].IsExtended || !reports[1].IsExtended {
+		t.Fatalf("expected a standard and an extended report, got %+v", reports)
+	}
+	if reports[0].Period != 10*time.Millisecond || reports[1].Period != 50*time.Millisecond {
+		t.Errorf("wrong periods: %v, %v", reports[0].Period, reports[1].Period)
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	a := timing.NewAnalyzer()
+	a.Expect(timing.IDKey{ID: 0x100}, 10*time.Millisecond, time.Millisecond)
+
+	var events []timing.Event
+	a.OnEvent = func(e timing.Event) { events = append(events, e) }
+
+	a.Feed(auxMsg(0x100, 20000, 8))
+	a.Feed(auxMsg(0x100, 30000, 8))
+	a.Feed(auxMsg(0x100, 5000, 8)) // older than the previous message
+	a.Feed(auxMsg(0x100, 40000, 8))
+
+	r := a.Report()[0]
+	if r.Count != 3 || r.OutOfOrder != 1 || r.MaxPeriod != 10*time.Millisecond {
+		t.Errorf("wrong report: %+v", r)
+	}
+	if len(events) != 0 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestRunSerializesEvents(t *testing.T) {
+	defer func(interval time.Duration) { timing.CheckInterval = interval }(timing.CheckInterval)
+	timing.CheckInterval = time.Millisecond
+
+	a := timing.NewAnalyzer()
+	for id := gocan.MessageID(0x100); id < 0x110; id++ {
+		a.Expect(timing.IDKey{ID: id}, time.Millisecond, 0)
+	}
+
+	// not thread-safe on purpose, the race detector reports concurrent calls
+	counts := make(map[timing.EventType]int)
+	a.OnEvent = func(e timing.Event) { counts[e.Type]++ }
+
+	// the expected ids time out in the pauses between bursts while 0x200 raises dlc changes on every message
+	seq := func(yield func(*gocan.Message, error) bool) {
+		start := time.Now()
+		for i := 0; i < 200; i++ {
+			ts := uint64(time.Since(start) / time.Microsecond)
+			if i%20 == 0 {
+				for id := gocan.MessageID(0x100); id < 0x110; id++ {
+					if !yield(auxMsg(id, ts, 8), nil) {
+						return
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if !yield(auxMsg(0x200, ts, uint8(i%2)+1), nil) {
+				return
+			}
+		}
+	}
+
+	if err := a.Run(context.Background(), iter.Seq2[*gocan.Message, error](seq)); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if counts[timing.EventTimeout] == 0 || counts[timing.EventDLCChange] == 0 {
+		t.Errorf("expected timeouts and dlc changes, got %v", counts)
+	}
+}
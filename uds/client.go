@@ -0,0 +1,392 @@
+package uds
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default values of the configuration
+const (
+	DefaultP2     = 50 * time.Millisecond
+	DefaultP2Star = 5 * time.Second
+)
+
+// Computes the key of a SecurityAccess level from the seed sent by the server
+type KeyFunc func(level uint8, seed []byte) ([]byte, error)
+
+// Config of a client
+type Config struct {
+	P2         time.Duration // time to wait for a response, default DefaultP2
+	P2Star     time.Duration // time to wait for a response after a response pending NRC, default DefaultP2Star
+	MaxPending int           // response pending NRCs accepted for a single request, 0 for no limit
+	KeepTiming bool          // ignore the P2 and P2* values reported by DiagnosticSessionControl
+	Key        KeyFunc       // key function used by SecurityAccess
+}
+
+// P2 and P2* reported by the server when changing the session
+type SessionTiming struct {
+	P2     time.Duration
+	P2Star time.Duration
+}
+
+// Client sends diagnostic requests to a server and waits for its responses
+type Client struct {
+	tp     Transport
+	cfg    Config
+	mu     sync.Mutex // serializes requests
+	p2     time.Duration
+	p2Star time.Duration
+
+	keepAlive     chan struct{}
+	keepAliveDone chan struct{}
+}
+
+// Creates a client on a transport, usually an isotp.Conn
+func NewClient(tp Transport, cfg *Config) *Client {
+	c := &Client{tp: tp}
+	if cfg != nil {
+		c.cfg = *cfg
+	}
+	if c.cfg.P2 <= 0 {
+		c.cfg.P2 = DefaultP2
+	}
+	if c.cfg.P2Star <= 0 {
+		c.cfg.P2Star = DefaultP2Star
+	}
+	c.p2, c.p2Star = c.cfg.P2, c.cfg.P2Star
+	return c
+}
+
+// Returns the P2 and P2* timeouts currently used
+func (c *Client) Timing() SessionTiming {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SessionTiming{P2: c.p2, P2Star: c.p2Star}
+}
+
+// Sends a request and returns the positive response including its service id
+// Response pending NRCs extend the timeout to P2*, other negative responses are returned as *NegativeResponseError.
+// Responses to other services are skipped.
+func (c *Client) Request(ctx context.Context, req []byte) ([]byte, error) {
+	if len(req) == 0 {
+		return nil, fmt.Errorf("%w: empty request", ErrRequest)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sid := ServiceID(req[0])
+	if err := c.tp.Send(req); err != nil {
+		return nil, err
+	}
+	timeout, pending := c.p2, 0
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		resp, err := c.tp.Recv(waitCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: service 0x%02X after %v", ErrTimeout, uint8(sid), timeout)
+			}
+			return nil, err
+		}
+		if len(resp) == 0 {
+			continue
+		}
+		if ServiceID(resp[0]) == NegativeResponse {
+			if len(resp) < 3 {
+				return nil, fmt.Errorf("%w: negative response % X", ErrResponse, resp)
+			}
+			if ServiceID(resp[1]) != sid {
+				continue
+			}
+			nrc := &NegativeResponseError{Service: sid, Code: NRC(resp[2])}
+			if nrc.Code != ResponsePending {
+				return nil, nrc
+			}
+			if pending++; c.cfg.MaxPending > 0 && pending > c.cfg.MaxPending {
+				return nil, nrc
+			}
+			timeout = c.p2Star
+			continue
+		}
+		if resp[0] == byte(sid)+PositiveResponse {
+			return resp, nil
+		}
+	}
+}
+
+// Sends a request with sub-function and checks the length and echoed sub-function of the response
+// If sub has the SuppressPositiveResponse bit set, the request is sent without waiting for a response and nil is returned
+func (c *Client) requestSub(ctx context.Context, sid ServiceID, sub uint8, minLength int, data ...byte) ([]byte, error) {
+	req := append([]byte{byte(sid), sub}, data...)
+	if sub&SuppressPositiveResponse != 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.tp.Send(req)
+	}
+	resp, err := c.Request(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < minLength || resp[1]&^SuppressPositiveResponse != sub {
+		return nil, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(sid), resp)
+	}
+	return resp, nil
+}
+
+// Changes the diagnostic session, reported P2 and P2* above the configured ones are used for further requests unless KeepTiming is set
+func (c *Client) DiagnosticSessionControl(ctx context.Context, session Session) (SessionTiming, error) {
+	resp, err := c.requestSub(ctx, DiagnosticSessionControl, uint8(session), 2)
+	if err != nil {
+		return SessionTiming{}, err
+	}
+	if len(resp) < 6 {
+		return c.Timing(), nil
+	}
+	timing := SessionTiming{
+		P2:     time.Duration(binary.BigEndian.Uint16(resp[2:4])) * time.Millisecond,
+		P2Star: time.Duration(binary.BigEndian.Uint16(resp[4:6])) * 10 * time.Millisecond,
+	}
+	if !c.cfg.KeepTiming {
+		c.mu.Lock()
+		c.p2, c.p2Star = max(timing.P2, c.cfg.P2), max(timing.P2Star, c.cfg.P2Star)
+		c.mu.Unlock()
+	}
+	return timing, nil
+}
+
+// Resets the server, returns the power down time in [s] reported for EnableRapidPowerShutDown
+func (c *Client) ECUReset(ctx context.Context, reset ResetType) (uint8, error) {
+	resp, err := c.requestSub(ctx, ECUReset, uint8(reset), 2)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) > 2 {
+		return resp[2], nil
+	}
+	return 0, nil
+}
+
+// Reads the record of a data identifier
+func (c *Client) ReadDataByIdentifier(ctx context.Context, did uint16) ([]byte, error) {
+	resp, err := c.Request(ctx, binary.BigEndian.AppendUint16([]byte{byte(ReadDataByIdentifier)}, did))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 3 || binary.BigEndian.Uint16(resp[1:3]) != did {
+		return nil, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(ReadDataByIdentifier), resp)
+	}
+	return resp[3:], nil
+}
+
+// Writes the record of a data identifier
+func (c *Client) WriteDataByIdentifier(ctx context.Context, did uint16, data []byte) error {
+	req := binary.BigEndian.AppendUint16([]byte{byte(WriteDataByIdentifier)}, did)
+	resp, err := c.Request(ctx, append(req, data...))
+	if err != nil {
+		return err
+	}
+	if len(resp) < 3 || binary.BigEndian.Uint16(resp[1:3]) != did {
+		return fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(WriteDataByIdentifier), resp)
+	}
+	return nil
+}
+
+// Unlocks a security level, level is the odd request seed sub-function and the key is sent with level+1
+// A seed of only zeros means the level is already unlocked, a response without seed is an ErrResponse.
+func (c *Client) SecurityAccess(ctx context.Context, level uint8) error {
+	if level%2 == 0 || level&SuppressPositiveResponse != 0 {
+		return fmt.Errorf("%w: security level 0x%02X is not a request seed sub-function", ErrRequest, level)
+	}
+	if c.cfg.Key == nil {
+		return ErrNoKey
+	}
+	resp, err := c.requestSub(ctx, SecurityAccess, level, 3)
+	if err != nil {
+		return err
+	}
+	seed := resp[2:]
+	unlocked := true
+	for _, b := range seed {
+		unlocked = unlocked && b == 0
+	}
+	if unlocked {
+		return nil
+	}
+	key, err := c.cfg.Key(level, seed)
+	if err != nil {
+		return fmt.Errorf("key of security level 0x%02X: %w", level, err)
+	}
+	_, err = c.requestSub(ctx, SecurityAccess, level+1, 2, key...)
+	return err
+}
+
+// Controls a routine, returns the routine info and status record following the routine identifier
+func (c *Client) RoutineControl(ctx context.Context, control RoutineControlType, id uint16, data []byte) ([]byte, error) {
+	req := binary.BigEndian.AppendUint16(nil, id)
+	resp, err := c.requestSub(ctx, RoutineControl, uint8(control), 4, append(req, data...)...)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(resp[2:4]) != id {
+		return nil, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(RoutineControl), resp)
+	}
+	return resp[4:], nil
+}
+
+// Sends a ReadDTCInformation request and returns the response following the report type
+func (c *Client) ReadDTCInformation(ctx context.Context, report DTCReportType, params ...byte) ([]byte, error) {
+	resp, err := c.requestSub(ctx, ReadDTCInformation, uint8(report), 2, params...)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return resp[2:], nil
+}
+
+// Returns the number of DTCs matching the status mask
+func (c *Client) ReadNumberOfDTCByStatusMask(ctx context.Context, mask DTCStatus) (uint16, error) {
+	data, err := c.ReadDTCInformation(ctx, ReportNumberOfDTCByStatusMask, byte(mask))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 4 {
+		return 0, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(ReadDTCInformation), data)
+	}
+	return binary.BigEndian.Uint16(data[2:4]), nil
+}
+
+// Returns the DTCs matching the status mask
+func (c *Client) ReadDTCByStatusMask(ctx context.Context, mask DTCStatus) ([]DTC, error) {
+	data, err := c.ReadDTCInformation(ctx, ReportDTCByStatusMask, byte(mask))
+	if err != nil {
+		return nil, err
+	}
+	return parseDTCs(data)
+}
+
+// Returns all DTCs supported by the server
+func (c *Client) ReadSupportedDTC(ctx context.Context) ([]DTC, error) {
+	data, err := c.ReadDTCInformation(ctx, ReportSupportedDTC)
+	if err != nil {
+		return nil, err
+	}
+	return parseDTCs(data)
+}
+
+// Parses the status availability mask followed by DTC and status records
+func parseDTCs(data []byte) ([]DTC, error) {
+	if len(data) < 1 || (len(data)-1)%4 != 0 {
+		return nil, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(ReadDTCInformation), data)
+	}
+	dtcs := make([]DTC, 0, (len(data)-1)/4)
+	for rec := data[1:]; len(rec) >= 4; rec = rec[4:] {
+		code := uint32(rec[0])<<16 | uint32(rec[1])<<8 | uint32(rec[2])
+		dtcs = append(dtcs, DTC{Code: code, Status: DTCStatus(rec[3])})
+	}
+	return dtcs, nil
+}
+
+// Clears the diagnostic information of a DTC group, AllDTCs clears everything
+func (c *Client) ClearDTC(ctx context.Context, group uint32) error {
+	_, err := c.Request(ctx, []byte{byte(ClearDiagnosticInfo), byte(group >> 16), byte(group >> 8), byte(group)})
+	return err
+}
+
+// Sends a tester present request and waits for the response
+func (c *Client) TesterPresent(ctx context.Context) error {
+	_, err := c.requestSub(ctx, TesterPresent, 0x00, 2)
+	return err
+}
+
+// Controls the transmission and reception of messages of the server
+func (c *Client) CommunicationControl(ctx context.Context, control ControlType, comm CommunicationType) error {
+	_, err := c.requestSub(ctx, CommunicationControl, uint8(control), 2, byte(comm))
+	return err
+}
+
+// Requests a download of size bytes to address with 4 byte address and size fields
+// Returns maxNumberOfBlockLength, the largest TransferData request including service id and block sequence counter.
+func (c *Client) RequestDownload(ctx context.Context, address, size uint32, dataFormat byte) (int, error) {
+	req := []byte{byte(RequestDownload), dataFormat, AddressAndLengthFormat}
+	req = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(req, address), size)
+	resp, err := c.Request(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	if len(resp) >= 2 {
+		n = int(resp[1] >> 4)
+	}
+	if n == 0 || n > 4 || len(resp) < 2+n {
+		return 0, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(RequestDownload), resp)
+	}
+	length := 0
+	for _, b := range resp[2 : 2+n] {
+		length = length<<8 | int(b)
+	}
+	if length < 3 {
+		return 0, fmt.Errorf("%w: maxNumberOfBlockLength %v", ErrResponse, length)
+	}
+	return length, nil
+}
+
+// Transfers a block of a download, returns the transfer response parameters
+func (c *Client) TransferData(ctx context.Context, counter uint8, data []byte) ([]byte, error) {
+	// the block sequence counter is no sub-function, so the SuppressPositiveResponse bit does not apply
+	resp, err := c.Request(ctx, append([]byte{byte(TransferData), counter}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || resp[1] != counter {
+		return nil, fmt.Errorf("%w: service 0x%02X: % X", ErrResponse, uint8(TransferData), resp)
+	}
+	return resp[2:], nil
+}
+
+// Ends a download, returns the transfer response parameters
+func (c *Client) RequestTransferExit(ctx context.Context, params []byte) ([]byte, error) {
+	resp, err := c.Request(ctx, append([]byte{byte(RequestTransferExit)}, params...))
+	if err != nil {
+		return nil, err
+	}
+	return resp[1:], nil
+}
+
+// Sends tester present requests without response in an interval to keep a non default session alive
+// A running keep-alive is restarted with the new interval.
+func (c *Client) StartTesterPresent(interval time.Duration) {
+	c.StopTesterPresent()
+	c.keepAlive, c.keepAliveDone = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.mu.Lock()
+				c.tp.Send([]byte{byte(TesterPresent), SuppressPositiveResponse})
+				c.mu.Unlock()
+			}
+		}
+	}(c.keepAlive, c.keepAliveDone)
+}
+
+// Stops sending tester present requests
+func (c *Client) StopTesterPresent() {
+	if c.keepAlive == nil {
+		return
+	}
+	close(c.keepAlive)
+	<-c.keepAliveDone
+	c.keepAlive, c.keepAliveDone = nil, nil
+}
@@ -0,0 +1,198 @@
+package sim
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/uds"
+)
+
+// Default values of the configuration
+const (
+	DefaultP2             = 50   // [ms]
+	DefaultP2Star         = 5000 // [ms]
+	DefaultSessionTimeout = 5000 // [ms]
+	DefaultMaxAttempts    = 3
+	DefaultSecurityDelay  = 1000 // [ms]
+	DefaultPendingDelay   = 20   // [ms]
+	DefaultSeedLength     = 4
+)
+
+// Number read from JSON numbers or strings like "0x7E0"
+type Number uint32
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	*n = Number(v)
+	return nil
+}
+
+// Bytes read from and written to JSON as hex string, spaces are allowed like "01 02 AB"
+type Hex []byte
+
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	*h = b
+	return nil
+}
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("% X", []byte(h)))
+}
+
+// Conditions to access a data identifier, routine or security level
+type Access struct {
+	Sessions []uds.Session `json:"sessions"` // sessions allowing the access, empty for all sessions
+	Security uint8         `json:"security"` // security level which must be unlocked, identified by its request seed sub-function, 0 for none
+}
+
+// Data identifier of the data store
+type DID struct {
+	ID       Number `json:"id"`
+	Data     Hex    `json:"data"`
+	ASCII    string `json:"ascii"`    // data given as text, used if Data is empty
+	Writable bool   `json:"writable"` // written data must have the length of the current data unless it is empty
+	Access
+}
+
+// Security level unlocked by SecurityAccess
+type SecurityLevel struct {
+	Level  uint8 `json:"level"`  // request seed sub-function, odd
+	Seed   Hex   `json:"seed"`   // fixed seed, random seeds of DefaultSeedLength bytes if empty
+	Key    Hex   `json:"key"`    // fixed expected key
+	KeyXOR Hex   `json:"keyXor"` // expected key is the seed xor this mask repeated over the seed, used if Key is empty
+	Access
+
+	SeedFunc func() []byte `json:"-"` // creates the seed, overrides Seed
+	KeyFunc  uds.KeyFunc   `json:"-"` // computes the expected key, overrides Key and KeyXOR
+}
+
+// Handles a RoutineControl request, returns the routine status record
+// Returning a uds.NRC sends this negative response, other errors send GeneralReject.
+type RoutineHandler func(control uds.RoutineControlType, data []byte) ([]byte, error)
+
+// Routine started, stopped and queried with RoutineControl
+type Routine struct {
+	ID     Number `json:"id"`
+	Result Hex    `json:"result"` // status record of every positive response
+	Access
+
+	Handler RoutineHandler `json:"-"` // overrides Result
+}
+
+// DTC of the DTC store
+type DTC struct {
+	Code   Number        `json:"code"`
+	Status uds.DTCStatus `json:"status"`
+}
+
+// Scripted negative response sent to requests starting with Request
+type NegativeResponse struct {
+	Request Hex     `json:"request"` // request prefix, at least the service id
+	NRC     uds.NRC `json:"nrc"`     // negative response code, 0 to process the request normally after the pending responses
+	Pending int     `json:"pending"` // response pending NRCs sent first
+	Count   int     `json:"count"`   // requests the response is used for, 0 for all
+}
+
+// Config of a server ready to be read from a json file
+type Config struct {
+	RxID           Number             `json:"rxId"`           // id of physical requests
+	TxID           Number             `json:"txId"`           // id of responses
+	FunctionalID   Number             `json:"functionalId"`   // id of functional requests, 0 for none
+	IsExtended     bool               `json:"isExtended"`     // 29-bit ids
+	FD             bool               `json:"fd"`             // respond with CAN FD frames
+	Padding        bool               `json:"padding"`        // pad classic frames to 8 bytes
+	P2             int                `json:"p2"`             // [ms] reported by DiagnosticSessionControl, default DefaultP2
+	P2Star         int                `json:"p2Star"`         // [ms] reported by DiagnosticSessionControl, default DefaultP2Star
+	SessionTimeout int                `json:"sessionTimeout"` // [ms] S3 timeout after which a non default session falls back to the default session, default DefaultSessionTimeout
+	PendingDelay   int                `json:"pendingDelay"`   // [ms] between scripted response pending NRCs, default DefaultPendingDelay
+	MaxAttempts    int                `json:"maxAttempts"`    // invalid keys until security access is delayed, default DefaultMaxAttempts
+	SecurityDelay  int                `json:"securityDelay"`  // [ms] security access is refused after exceeding the attempts, default DefaultSecurityDelay
+	Sessions       []uds.Session      `json:"sessions"`       // supported sessions besides the default session, default programming and extended session
+	Security       []SecurityLevel    `json:"security"`
+	DIDs           []DID              `json:"dids"`
+	DTCs           []DTC              `json:"dtcs"`
+	Routines       []Routine          `json:"routines"`
+	Responses      []NegativeResponse `json:"responses"`
+
+	ISOTP *isotp.Config `json:"-"` // transport configuration, overrides FD and Padding
+}
+
+// Returns the configuration with defaults applied
+func (c *Config) withDefaults() Config {
+	cfg := Config{}
+	if c != nil {
+		cfg = *c
+	}
+	if cfg.P2 <= 0 {
+		cfg.P2 = DefaultP2
+	}
+	if cfg.P2Star <= 0 {
+		cfg.P2Star = DefaultP2Star
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = DefaultSessionTimeout
+	}
+	if cfg.PendingDelay <= 0 {
+		cfg.PendingDelay = DefaultPendingDelay
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.SecurityDelay <= 0 {
+		cfg.SecurityDelay = DefaultSecurityDelay
+	}
+	if cfg.Sessions == nil {
+		cfg.Sessions = []uds.Session{uds.ProgrammingSession, uds.ExtendedDiagnosticSession}
+	}
+	if !slices.Contains(cfg.Sessions, uds.DefaultSession) {
+		cfg.Sessions = append(slices.Clone(cfg.Sessions), uds.DefaultSession)
+	}
+	return cfg
+}
+
+// Reads a server configuration from JSON, unknown fields are an error
+func ParseConfig(r io.Reader) (*Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	cfg := &Config{}
+	if err := dec.Decode(cfg); err != nil {
+		if errors.Is(err, ErrConfig) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	return cfg, nil
+}
+
+// Reads a server configuration from a JSON file
+func ParseConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
@@ -0,0 +1,349 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/uds"
+)
+
+// Interval in which Serve() checks the session timeout
+var CheckInterval = 10 * time.Millisecond
+
+// errors
+var (
+	ErrConfig = errors.New("invalid server configuration")
+)
+
+// Handles the requests of a service, returns the positive response including its service id
+// Returning a uds.NRC sends this negative response, other errors send GeneralReject.
+type Handler func(req []byte) ([]byte, error)
+
+// Received request
+type request struct {
+	data       []byte
+	functional bool
+}
+
+// Server simulates the diagnostic services of an ECU on a bus
+type Server struct {
+	cfg        Config
+	mux        *isotp.Mux
+	physical   *isotp.Conn
+	functional *isotp.Conn
+
+	mu          sync.Mutex
+	session     uds.Session
+	lastRequest time.Time
+	unlocked    uint8  // unlocked security level, 0 if locked
+	seedLevel   uint8  // level of the latest sent seed, 0 if no key is expected
+	seed        []byte // latest sent seed
+	attempts    int    // invalid keys in a row
+	delayUntil  time.Time
+	control     uds.ControlType
+	commType    uds.CommunicationType
+
+	dids      map[uint16]*DID
+	security  map[uint8]*SecurityLevel
+	routines  map[uint16]*Routine
+	dtcs      []uds.DTC
+	responses []NegativeResponse
+	handlers  map[uds.ServiceID]Handler
+}
+
+// Creates a server answering requests on the bus, the bus must not be read by anything else
+func NewServer(bus gocan.Transceiver, cfg *Config) (*Server, error) {
+	s := &Server{
+		cfg:      cfg.withDefaults(),
+		session:  uds.DefaultSession,
+		dids:     make(map[uint16]*DID),
+		security: make(map[uint8]*SecurityLevel),
+		routines: make(map[uint16]*Routine),
+		handlers: make(map[uds.ServiceID]Handler),
+	}
+	for _, d := range s.cfg.DIDs {
+		s.SetDID(d)
+	}
+	for _, l := range s.cfg.Security {
+		if err := s.SetSecurity(l); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range s.cfg.Routines {
+		s.SetRoutine(r)
+	}
+	for _, d := range s.cfg.DTCs {
+		s.SetDTC(uint32(d.Code), d.Status)
+	}
+	for _, r := range s.cfg.Responses {
+		if err := s.AddResponse(r); err != nil {
+			return nil, err
+		}
+	}
+
+	tpCfg := &isotp.Config{FD: s.cfg.FD, Padding: s.cfg.Padding}
+	if s.cfg.ISOTP != nil {
+		tpCfg = s.cfg.ISOTP
+	}
+	s.mux = isotp.NewMux(bus)
+	addr := isotp.Address{TxID: gocan.MessageID(s.cfg.TxID), RxID: gocan.MessageID(s.cfg.RxID), IsExtended: s.cfg.IsExtended}
+	var err error
+	if s.physical, err = s.mux.Open(addr, tpCfg); err != nil {
+		s.mux.Close()
+		return nil, err
+	}
+	if s.cfg.FunctionalID != 0 {
+		addr.RxID = gocan.MessageID(s.cfg.FunctionalID)
+		if s.functional, err = s.mux.Open(addr, tpCfg); err != nil {
+			s.mux.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Stops reading the bus
+func (s *Server) Close() error {
+	return s.mux.Close()
+}
+
+// Adds or replaces a data identifier
+func (s *Server) SetDID(d DID) {
+	if len(d.Data) == 0 && d.ASCII != "" {
+		d.Data = Hex(d.ASCII)
+	}
+	d.Data = slices.Clone(d.Data)
+	s.mu.Lock()
+	s.dids[uint16(d.ID)] = &d
+	s.mu.Unlock()
+}
+
+// Returns the current data of a data identifier
+func (s *Server) DID(id uint16) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dids[id]
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(d.Data), true
+}
+
+// Adds or replaces a security level
+func (s *Server) SetSecurity(l SecurityLevel) error {
+	if l.Level%2 == 0 || l.Level > 0x7F {
+		return fmt.Errorf("%w: security level 0x%02X is not a request seed sub-function", ErrConfig, l.Level)
+	}
+	s.mu.Lock()
+	s.security[l.Level] = &l
+	s.mu.Unlock()
+	return nil
+}
+
+// Adds or replaces a routine
+func (s *Server) SetRoutine(r Routine) {
+	s.mu.Lock()
+	s.routines[uint16(r.ID)] = &r
+	s.mu.Unlock()
+}
+
+// Adds a DTC or sets the status of a known DTC
+func (s *Server) SetDTC(code uint32, status uds.DTCStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.dtcs {
+		if s.dtcs[i].Code == code {
+			s.dtcs[i].Status = status
+			return
+		}
+	}
+	s.dtcs = append(s.dtcs, uds.DTC{Code: code, Status: status})
+}
+
+// Returns all DTCs with their status
+func (s *Server) DTCs() []uds.DTC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.dtcs)
+}
+
+// Adds a scripted negative response, matching responses are used in the order they were added
+func (s *Server) AddResponse(r NegativeResponse) error {
+	if len(r.Request) == 0 {
+		return fmt.Errorf("%w: scripted response without request", ErrConfig)
+	}
+	r.Request = slices.Clone(r.Request)
+	s.mu.Lock()
+	s.responses = append(s.responses, r)
+	s.mu.Unlock()
+	return nil
+}
+
+// Sets a handler for a service, replacing the built-in service if there is one
+func (s *Server) Handle(sid uds.ServiceID, h Handler) {
+	s.mu.Lock()
+	s.handlers[sid] = h
+	s.mu.Unlock()
+}
+
+// Returns the active session
+func (s *Server) Session() uds.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session
+}
+
+// Returns the unlocked security level, 0 if locked
+func (s *Server) SecurityLevel() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unlocked
+}
+
+// Returns the state set by CommunicationControl
+func (s *Server) Communication() (uds.ControlType, uds.CommunicationType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.control, s.commType
+}
+
+// Answers requests until the context is cancelled or the bus fails
+// A cancelled context ends serving without an error.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reqs := make(chan request)
+	errs := make(chan error, 2)
+	go s.read(ctx, s.physical, false, reqs, errs)
+	if s.functional != nil {
+		go s.read(ctx, s.functional, true, reqs, errs)
+	}
+	s.mu.Lock()
+	s.lastRequest = time.Now()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case r := <-reqs:
+			s.handle(r)
+		case now := <-ticker.C:
+			s.checkSession(now)
+		}
+	}
+}
+
+// Reads requests of a connection, failed transfers are skipped
+func (s *Server) read(ctx context.Context, c *isotp.Conn, functional bool, reqs chan<- request, errs chan<- error) {
+	for {
+		data, err := c.Recv(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, isotp.ErrTimeoutCr) || errors.Is(err, isotp.ErrSequence) || errors.Is(err, isotp.ErrTooLong) {
+			continue
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+		select {
+		case reqs <- request{data: data, functional: functional}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Falls back to the default session after the session timeout
+func (s *Server) checkSession(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session != uds.DefaultSession && now.Sub(s.lastRequest) > time.Duration(s.cfg.SessionTimeout)*time.Millisecond {
+		s.resetState()
+	}
+}
+
+// Returns to the default session with locked security and enabled communication, the lock must be held
+func (s *Server) resetState() {
+	s.session = uds.DefaultSession
+	s.unlocked, s.seedLevel, s.seed = 0, 0, nil
+	s.control, s.commType = uds.EnableRxAndTx, 0
+}
+
+// Returns the next scripted response matching the request, the lock must be held
+func (s *Server) scripted(req []byte) (NegativeResponse, bool) {
+	for i, r := range s.responses {
+		if !bytes.HasPrefix(req, r.Request) {
+			continue
+		}
+		if r.Count > 0 {
+			if s.responses[i].Count--; s.responses[i].Count == 0 {
+				s.responses = slices.Delete(s.responses, i, i+1)
+			}
+		}
+		return r, true
+	}
+	return NegativeResponse{}, false
+}
+
+// Answers a request
+func (s *Server) handle(r request) {
+	if len(r.data) == 0 {
+		return
+	}
+	sid := uds.ServiceID(r.data[0])
+	s.mu.Lock()
+	s.lastRequest = time.Now()
+	script, ok := s.scripted(r.data)
+	s.mu.Unlock()
+
+	if ok {
+		for i := 0; i < script.Pending; i++ {
+			if i > 0 {
+				time.Sleep(time.Duration(s.cfg.PendingDelay) * time.Millisecond)
+			}
+			s.physical.Send([]byte{byte(uds.NegativeResponse), byte(sid), byte(uds.ResponsePending)})
+		}
+		if script.Pending > 0 {
+			time.Sleep(time.Duration(s.cfg.PendingDelay) * time.Millisecond)
+		}
+		if script.NRC != 0 {
+			s.physical.Send([]byte{byte(uds.NegativeResponse), byte(sid), byte(script.NRC)})
+			return
+		}
+	}
+
+	resp, err := s.process(r.data)
+	if err != nil {
+		var nrc uds.NRC
+		if !errors.As(err, &nrc) {
+			nrc = uds.GeneralReject
+		}
+		// functional requests are not answered with these negative responses
+		if r.functional {
+			switch nrc {
+			case uds.ServiceNotSupported, uds.SubFunctionNotSupported, uds.RequestOutOfRange,
+				uds.SubFunctionNotSupportedInActiveSession, uds.ServiceNotSupportedInActiveSession:
+				return
+			}
+		}
+		s.physical.Send([]byte{byte(uds.NegativeResponse), byte(sid), byte(nrc)})
+		return
+	}
+	if resp != nil {
+		s.physical.Send(resp)
+	}
+}
@@ -0,0 +1,366 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/morgadow/gocan/uds"
+)
+
+// Services with a sub-function byte supporting the suppress positive response flag
+var subFunctionServices = map[uds.ServiceID]bool{
+	uds.DiagnosticSessionControl: true,
+	uds.ECUReset:                 true,
+	uds.SecurityAccess:           true,
+	uds.CommunicationControl:     true,
+	uds.RoutineControl:           true,
+	uds.TesterPresent:            true,
+	uds.ControlDTCSetting:        true,
+}
+
+// Status of cleared DTCs
+const clearedStatus = uds.TestNotCompletedSinceLastClear | uds.TestNotCompletedThisOperationCycle
+
+// Status bits supported by the DTC store
+const statusAvailability = 0xFF
+
+// Processes a request, returns the positive response or nil if it is suppressed
+func (s *Server) process(req []byte) ([]byte, error) {
+	sid := uds.ServiceID(req[0])
+	s.mu.Lock()
+	h := s.handlers[sid]
+	s.mu.Unlock()
+	if h != nil {
+		return h(req)
+	}
+
+	suppress := false
+	if subFunctionServices[sid] && len(req) > 1 && req[1]&uds.SuppressPositiveResponse != 0 {
+		req = slices.Clone(req)
+		req[1] &^= uds.SuppressPositiveResponse
+		suppress = true
+	}
+	var resp []byte
+	var err error
+	switch sid {
+	case uds.DiagnosticSessionControl:
+		resp, err = s.sessionControl(req)
+	case uds.ECUReset:
+		resp, err = s.ecuReset(req)
+	case uds.ReadDataByIdentifier:
+		resp, err = s.readDID(req)
+	case uds.WriteDataByIdentifier:
+		resp, err = s.writeDID(req)
+	case uds.SecurityAccess:
+		resp, err = s.securityAccess(req)
+	case uds.CommunicationControl:
+		resp, err = s.communicationControl(req)
+	case uds.RoutineControl:
+		resp, err = s.routineControl(req)
+	case uds.ReadDTCInformation:
+		resp, err = s.readDTCInformation(req)
+	case uds.ClearDiagnosticInfo:
+		resp, err = s.clearDTC(req)
+	case uds.TesterPresent:
+		resp, err = s.testerPresent(req)
+	default:
+		return nil, uds.ServiceNotSupported
+	}
+	if err != nil || suppress {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Checks the access conditions, a wrong session is reported with sessionNRC, the lock must be held
+func (s *Server) checkAccess(a Access, sessionNRC uds.NRC) error {
+	if len(a.Sessions) > 0 && !slices.Contains(a.Sessions, s.session) {
+		return sessionNRC
+	}
+	if a.Security != 0 && s.unlocked != a.Security {
+		return uds.SecurityAccessDenied
+	}
+	return nil
+}
+
+func (s *Server) sessionControl(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	session := uds.Session(req[1])
+	if !slices.Contains(s.cfg.Sessions, session) {
+		return nil, uds.SubFunctionNotSupported
+	}
+	s.mu.Lock()
+	if session != s.session {
+		s.resetState()
+		s.session = session
+	}
+	s.mu.Unlock()
+	resp := []byte{byte(uds.DiagnosticSessionControl) + uds.PositiveResponse, byte(session)}
+	resp = binary.BigEndian.AppendUint16(resp, uint16(s.cfg.P2))
+	return binary.BigEndian.AppendUint16(resp, uint16(s.cfg.P2Star/10)), nil
+}
+
+func (s *Server) ecuReset(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	switch uds.ResetType(req[1]) {
+	case uds.HardReset, uds.KeyOffOnReset, uds.SoftReset:
+	default:
+		return nil, uds.SubFunctionNotSupported
+	}
+	s.mu.Lock()
+	s.resetState()
+	s.mu.Unlock()
+	return []byte{byte(uds.ECUReset) + uds.PositiveResponse, req[1]}, nil
+}
+
+func (s *Server) readDID(req []byte) ([]byte, error) {
+	if len(req) < 3 || len(req)%2 == 0 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := []byte{byte(uds.ReadDataByIdentifier) + uds.PositiveResponse}
+	for ids := req[1:]; len(ids) >= 2; ids = ids[2:] {
+		id := binary.BigEndian.Uint16(ids)
+		d, ok := s.dids[id]
+		if !ok {
+			return nil, uds.RequestOutOfRange
+		}
+		if err := s.checkAccess(d.Access, uds.RequestOutOfRange); err != nil {
+			return nil, err
+		}
+		resp = append(binary.BigEndian.AppendUint16(resp, id), d.Data...)
+	}
+	return resp, nil
+}
+
+func (s *Server) writeDID(req []byte) ([]byte, error) {
+	if len(req) < 4 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	id := binary.BigEndian.Uint16(req[1:3])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dids[id]
+	if !ok || !d.Writable {
+		return nil, uds.RequestOutOfRange
+	}
+	if err := s.checkAccess(d.Access, uds.RequestOutOfRange); err != nil {
+		return nil, err
+	}
+	if len(d.Data) > 0 && len(req[3:]) != len(d.Data) {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	d.Data = slices.Clone(req[3:])
+	return []byte{byte(uds.WriteDataByIdentifier) + uds.PositiveResponse, req[1], req[2]}, nil
+}
+
+func (s *Server) securityAccess(req []byte) ([]byte, error) {
+	if len(req) < 2 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	level := req[1]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// request seed
+	if level%2 == 1 {
+		if len(req) != 2 {
+			return nil, uds.IncorrectMessageLengthOrFormat
+		}
+		l, ok := s.security[level]
+		if !ok {
+			return nil, uds.SubFunctionNotSupported
+		}
+		if err := s.checkAccess(Access{Sessions: l.Sessions}, uds.SubFunctionNotSupportedInActiveSession); err != nil {
+			return nil, err
+		}
+		if time.Now().Before(s.delayUntil) {
+			return nil, uds.RequiredTimeDelayNotExpired
+		}
+		seed := l.newSeed()
+		if s.unlocked == level {
+			seed = make([]byte, len(seed))
+		} else {
+			s.seedLevel, s.seed = level, seed
+		}
+		return append([]byte{byte(uds.SecurityAccess) + uds.PositiveResponse, level}, seed...), nil
+	}
+
+	// send key
+	l, ok := s.security[level-1]
+	if !ok {
+		return nil, uds.SubFunctionNotSupported
+	}
+	if s.seedLevel != level-1 {
+		return nil, uds.RequestSequenceError
+	}
+	s.seedLevel = 0
+	expected, err := l.expectedKey(s.seed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(req[2:], expected) {
+		if s.attempts++; s.attempts >= s.cfg.MaxAttempts {
+			s.attempts = 0
+			s.delayUntil = time.Now().Add(time.Duration(s.cfg.SecurityDelay) * time.Millisecond)
+			return nil, uds.ExceededNumberOfAttempts
+		}
+		return nil, uds.InvalidKey
+	}
+	s.attempts, s.unlocked = 0, level-1
+	return []byte{byte(uds.SecurityAccess) + uds.PositiveResponse, level}, nil
+}
+
+// Returns a new seed of the level
+func (l *SecurityLevel) newSeed() []byte {
+	switch {
+	case l.SeedFunc != nil:
+		return l.SeedFunc()
+	case len(l.Seed) > 0:
+		return slices.Clone(l.Seed)
+	}
+	seed := make([]byte, DefaultSeedLength)
+	rand.Read(seed)
+	// a seed of zeros would report the level as unlocked
+	seed[0] |= 0x01
+	return seed
+}
+
+// Returns the key expected for a seed, the seed itself if no key is configured
+func (l *SecurityLevel) expectedKey(seed []byte) ([]byte, error) {
+	switch {
+	case l.KeyFunc != nil:
+		return l.KeyFunc(l.Level, seed)
+	case len(l.Key) > 0:
+		return l.Key, nil
+	case len(l.KeyXOR) > 0:
+		key := make([]byte, len(seed))
+		for i := range seed {
+			key[i] = seed[i] ^ l.KeyXOR[i%len(l.KeyXOR)]
+		}
+		return key, nil
+	}
+	return seed, nil
+}
+
+func (s *Server) communicationControl(req []byte) ([]byte, error) {
+	if len(req) != 3 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	control := uds.ControlType(req[1])
+	if control > uds.DisableRxAndTx {
+		return nil, uds.SubFunctionNotSupported
+	}
+	s.mu.Lock()
+	s.control, s.commType = control, uds.CommunicationType(req[2])
+	s.mu.Unlock()
+	return []byte{byte(uds.CommunicationControl) + uds.PositiveResponse, req[1]}, nil
+}
+
+func (s *Server) routineControl(req []byte) ([]byte, error) {
+	if len(req) < 4 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	control := uds.RoutineControlType(req[1])
+	if control < uds.StartRoutine || control > uds.RequestRoutineResults {
+		return nil, uds.SubFunctionNotSupported
+	}
+	id := binary.BigEndian.Uint16(req[2:4])
+	s.mu.Lock()
+	r, ok := s.routines[id]
+	var err error
+	if ok {
+		err = s.checkAccess(r.Access, uds.RequestOutOfRange)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, uds.RequestOutOfRange
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := []byte(r.Result)
+	if r.Handler != nil {
+		if result, err = r.Handler(control, req[4:]); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte{byte(uds.RoutineControl) + uds.PositiveResponse, req[1], req[2], req[3]}, result...), nil
+}
+
+func (s *Server) readDTCInformation(req []byte) ([]byte, error) {
+	if len(req) < 2 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	report := uds.DTCReportType(req[1])
+	switch report {
+	case uds.ReportNumberOfDTCByStatusMask, uds.ReportDTCByStatusMask:
+		if len(req) != 3 {
+			return nil, uds.IncorrectMessageLengthOrFormat
+		}
+	case uds.ReportSupportedDTC:
+		if len(req) != 2 {
+			return nil, uds.IncorrectMessageLengthOrFormat
+		}
+	default:
+		return nil, uds.SubFunctionNotSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := []byte{byte(uds.ReadDTCInformation) + uds.PositiveResponse, req[1], statusAvailability}
+	count := uint16(0)
+	for _, d := range s.dtcs {
+		if report != uds.ReportSupportedDTC && d.Status&uds.DTCStatus(req[2]) == 0 {
+			continue
+		}
+		count++
+		if report != uds.ReportNumberOfDTCByStatusMask {
+			resp = append(resp, byte(d.Code>>16), byte(d.Code>>8), byte(d.Code), byte(d.Status))
+		}
+	}
+	if report == uds.ReportNumberOfDTCByStatusMask {
+		// DTC format identifier ISO 14229-1
+		resp = binary.BigEndian.AppendUint16(append(resp, 0x01), count)
+	}
+	return resp, nil
+}
+
+func (s *Server) clearDTC(req []byte) ([]byte, error) {
+	if len(req) != 4 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	group := uint32(req[1])<<16 | uint32(req[2])<<8 | uint32(req[3])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for i := range s.dtcs {
+		if group == uds.AllDTCs || s.dtcs[i].Code == group {
+			s.dtcs[i].Status = clearedStatus
+			found = true
+		}
+	}
+	if !found && group != uds.AllDTCs {
+		return nil, uds.RequestOutOfRange
+	}
+	return []byte{byte(uds.ClearDiagnosticInfo) + uds.PositiveResponse}, nil
+}
+
+func (s *Server) testerPresent(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, uds.IncorrectMessageLengthOrFormat
+	}
+	if req[1] != 0x00 {
+		return nil, uds.SubFunctionNotSupported
+	}
+	return []byte{byte(uds.TesterPresent) + uds.PositiveResponse, 0x00}, nil
+}
@@ -0,0 +1,306 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/uds"
+	"github.com/morgadow/gocan/uds/sim"
+)
+
+const auxConfig = `{
+	"rxId": "0x7E0",
+	"txId": "0x7E8",
+	"functionalId": "0x7DF",
+	"sessionTimeout": 100,
+	"security": [
+		{"level": 1, "seed": "12 34", "keyXor": "FF FF", "sessions": [3]}
+	],
+	"dids": [
+		{"id": "0xF190", "ascii": "WVWZZZ1JZXW000001"},
+		{"id": "0xF198", "data": "00 00 00 00", "writable": true, "sessions": [3], "security": 1}
+	],
+	"dtcs": [
+		{"code": "0x123456", "status": 9},
+		{"code": "0xC07300", "status": 80}
+	],
+	"routines": [
+		{"id": "0x0203", "result": "00", "sessions": [3]}
+	],
+	"responses": [
+		{"request": "31 01 02 03", "pending": 2, "count": 1},
+		{"request": "11", "nrc": 34}
+	]
+}`
+
+func auxBus(t *testing.T) gocan.Bus {
+	bus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name()})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+// Starts a server with the config and returns it together with a client
+func auxServer(t *testing.T, cfg *sim.Config) (*sim.Server, *uds.Client) {
+	server, err := sim.NewServer(auxBus(t), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+		server.Close()
+	})
+
+	conn, err := isotp.NewConn(auxBus(t), isotp.Address{TxID: 0x7E0, RxID: 0x7E8}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	key := func(level uint8, seed []byte) ([]byte, error) {
+		return []byte{seed[0] ^ 0xFF, seed[1] ^ 0xFF}, nil
+	}
+	return server, uds.NewClient(conn, &uds.Config{P2: 200 * time.Millisecond, Key: key})
+}
+
+func auxParse(t *testing.T) *sim.Config {
+	cfg, err := sim.ParseConfig(strings.NewReader(auxConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cfg
+}
+
+func auxContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg := auxParse(t)
+	if cfg.RxID != 0x7E0 || cfg.TxID != 0x7E8 || cfg.FunctionalID != 0x7DF {
+		t.Errorf("got ids 0x%X 0x%X 0x%X", cfg.RxID, cfg.TxID, cfg.FunctionalID)
+	}
+	if len(cfg.DIDs) != 2 || cfg.DIDs[1].ID != 0xF198 || !bytes.Equal(cfg.DIDs[1].Data, []byte{0, 0, 0, 0}) || cfg.DIDs[1].Security != 1 {
+		t.Errorf("got dids %+v", cfg.DIDs)
+	}
+	if len(cfg.Responses) != 2 || cfg.Responses[1].NRC != uds.ConditionsNotCorrect {
+		t.Errorf("got responses %+v", cfg.Responses)
+	}
+
+	for _, invalid := range []string{`{"rxId": "0xZZ"}`, `{"dids": [{"id": 1, "data": "0G"}]}`, `{"unknown": 1}`} {
+		if _, err := sim.ParseConfig(strings.NewReader(invalid)); !errors.Is(err, sim.ErrConfig) {
+			t.Errorf("%v: expected ErrConfig, got %v", invalid, err)
+		}
+	}
+	if _, err := sim.NewServer(auxBus(t), &sim.Config{Security: []sim.SecurityLevel{{Level: 2}}}); !errors.Is(err, sim.ErrConfig) {
+		t.Errorf("expected ErrConfig for even security level, got %v", err)
+	}
+}
+
+func TestSessionAndSecurity(t *testing.T) {
+	server, client := auxServer(t, auxParse(t))
+	ctx := auxContext(t)
+
+	if vin, err := client.ReadDataByIdentifier(ctx, 0xF190); err != nil || string(vin) != "WVWZZZ1JZXW000001" {
+		t.Errorf("got %q, %v", vin, err)
+	}
+	if err := client.WriteDataByIdentifier(ctx, 0xF198, []byte{1, 2, 3, 4}); !errors.Is(err, uds.RequestOutOfRange) {
+		t.Errorf("expected RequestOutOfRange in default session, got %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x01); !errors.Is(err, uds.SubFunctionNotSupportedInActiveSession) {
+		t.Errorf("expected SubFunctionNotSupportedInActiveSession, got %v", err)
+	}
+
+	timing, err := client.DiagnosticSessionControl(ctx, uds.ExtendedDiagnosticSession)
+	if err != nil || timing.P2 != 50*time.Millisecond || timing.P2Star != 5*time.Second {
+		t.Errorf("got %+v, %v", timing, err)
+	}
+	if err := client.WriteDataByIdentifier(ctx, 0xF198, []byte{1, 2, 3, 4}); !errors.Is(err, uds.SecurityAccessDenied) {
+		t.Errorf("expected SecurityAccessDenied, got %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x01); err != nil || server.SecurityLevel() != 1 {
+		t.Errorf("got level %v, %v", server.SecurityLevel(), err)
+	}
+	if err := client.WriteDataByIdentifier(ctx, 0xF198, []byte{1, 2, 3}); !errors.Is(err, uds.IncorrectMessageLengthOrFormat) {
+		t.Errorf("expected IncorrectMessageLengthOrFormat, got %v", err)
+	}
+	if err := client.WriteDataByIdentifier(ctx, 0xF198, []byte{1, 2, 3, 4}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if data, _ := server.DID(0xF198); !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+		t.Errorf("got % X", data)
+	}
+
+	// tester present keeps the session alive
+	client.StartTesterPresent(40 * time.Millisecond)
+	time.Sleep(250 * time.Millisecond)
+	client.StopTesterPresent()
+	if session := server.Session(); session != uds.ExtendedDiagnosticSession {
+		t.Errorf("got session %v, expected extended session", session)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if session, level := server.Session(), server.SecurityLevel(); session != uds.DefaultSession || level != 0 {
+		t.Errorf("got session %v level %v after session timeout, expected default session and locked", session, level)
+	}
+	if _, err := client.DiagnosticSessionControl(ctx, uds.SafetySystemSession); !errors.Is(err, uds.SubFunctionNotSupported) {
+		t.Errorf("expected SubFunctionNotSupported, got %v", err)
+	}
+}
+
+func TestSecurityDelay(t *testing.T) {
+	server, _ := auxServer(t, &sim.Config{RxID: 0x7E0, TxID: 0x7E8, MaxAttempts: 2, SecurityDelay: 100})
+	server.SetSecurity(sim.SecurityLevel{Level: 0x11, Seed: sim.Hex{0xAA, 0xBB}, Key: sim.Hex{0x01}})
+	conn, err := isotp.NewConn(auxBus(t), isotp.Address{TxID: 0x7E0, RxID: 0x7E8}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	client := uds.NewClient(conn, &uds.Config{P2: 200 * time.Millisecond, Key: func(uint8, []byte) ([]byte, error) { return []byte{0x02}, nil }})
+	ctx := auxContext(t)
+
+	if err := client.SecurityAccess(ctx, 0x11); !errors.Is(err, uds.InvalidKey) {
+		t.Errorf("expected InvalidKey, got %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x11); !errors.Is(err, uds.ExceededNumberOfAttempts) {
+		t.Errorf("expected ExceededNumberOfAttempts, got %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x11); !errors.Is(err, uds.RequiredTimeDelayNotExpired) {
+		t.Errorf("expected RequiredTimeDelayNotExpired, got %v", err)
+	}
+	if _, err := client.Request(ctx, []byte{0x27, 0x12, 0x01}); !errors.Is(err, uds.RequestSequenceError) {
+		t.Errorf("expected RequestSequenceError, got %v", err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if resp, err := client.Request(ctx, []byte{0x27, 0x11}); err != nil || !bytes.Equal(resp, []byte{0x67, 0x11, 0xAA, 0xBB}) {
+		t.Errorf("got % X, %v", resp, err)
+	}
+	if _, err := client.Request(ctx, []byte{0x27, 0x12, 0x01}); err != nil || server.SecurityLevel() != 0x11 {
+		t.Errorf("got level %v, %v", server.SecurityLevel(), err)
+	}
+}
+
+func TestDTCs(t *testing.T) {
+	server, client := auxServer(t, auxParse(t))
+	ctx := auxContext(t)
+
+	if n, err := client.ReadNumberOfDTCByStatusMask(ctx, uds.ConfirmedDTC); err != nil || n != 1 {
+		t.Errorf("got %v, %v", n, err)
+	}
+	if dtcs, err := client.ReadDTCByStatusMask(ctx, uds.ConfirmedDTC); err != nil || len(dtcs) != 1 || dtcs[0] != (uds.DTC{Code: 0x123456, Status: 0x09}) {
+		t.Errorf("got %v, %v", dtcs, err)
+	}
+	if dtcs, err := client.ReadSupportedDTC(ctx); err != nil || len(dtcs) != 2 {
+		t.Errorf("got %v, %v", dtcs, err)
+	}
+	if err := client.ClearDTC(ctx, 0x000001); !errors.Is(err, uds.RequestOutOfRange) {
+		t.Errorf("expected RequestOutOfRange, got %v", err)
+	}
+	if err := client.ClearDTC(ctx, uds.AllDTCs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, d := range server.DTCs() {
+		if d.Status != uds.TestNotCompletedSinceLastClear|uds.TestNotCompletedThisOperationCycle {
+			t.Errorf("got %v after clearing", d)
+		}
+	}
+	server.SetDTC(0xC07300, uds.TestFailed|uds.ConfirmedDTC)
+	if n, err := client.ReadNumberOfDTCByStatusMask(ctx, uds.TestFailed); err != nil || n != 1 {
+		t.Errorf("got %v, %v", n, err)
+	}
+}
+
+func TestRoutinesAndScripts(t *testing.T) {
+	server, client := auxServer(t, auxParse(t))
+	ctx := auxContext(t)
+
+	var started []byte
+	server.SetRoutine(sim.Routine{ID: 0xFF00, Handler: func(control uds.RoutineControlType, data []byte) ([]byte, error) {
+		if control != uds.StartRoutine {
+			return nil, uds.RequestSequenceError
+		}
+		started = data
+		return []byte{0x00, 0x42}, nil
+	}})
+	if result, err := client.RoutineControl(ctx, uds.StartRoutine, 0xFF00, []byte{0xAA}); err != nil || !bytes.Equal(result, []byte{0x00, 0x42}) || !bytes.Equal(started, []byte{0xAA}) {
+		t.Errorf("got % X, %v, started with % X", result, err, started)
+	}
+	if _, err := client.RoutineControl(ctx, uds.StopRoutine, 0xFF00, nil); !errors.Is(err, uds.RequestSequenceError) {
+		t.Errorf("expected RequestSequenceError, got %v", err)
+	}
+
+	// scripted response pending beyond P2 once, then answered normally
+	client.DiagnosticSessionControl(ctx, uds.ExtendedDiagnosticSession)
+	start := time.Now()
+	if result, err := client.RoutineControl(ctx, uds.StartRoutine, 0x0203, nil); err != nil || !bytes.Equal(result, []byte{0x00}) {
+		t.Errorf("got % X, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("got response after %v, expected two pending responses", elapsed)
+	}
+	client.DiagnosticSessionControl(ctx, uds.DefaultSession)
+	if _, err := client.RoutineControl(ctx, uds.StartRoutine, 0x0203, nil); !errors.Is(err, uds.RequestOutOfRange) {
+		t.Errorf("expected RequestOutOfRange in default session, got %v", err)
+	}
+	if _, err := client.ECUReset(ctx, uds.HardReset); !errors.Is(err, uds.ConditionsNotCorrect) {
+		t.Errorf("expected scripted ConditionsNotCorrect, got %v", err)
+	}
+
+	// custom service handler
+	server.Handle(0x85, func(req []byte) ([]byte, error) {
+		return []byte{0xC5, req[1]}, nil
+	})
+	if resp, err := client.Request(ctx, []byte{0x85, 0x02}); err != nil || !bytes.Equal(resp, []byte{0xC5, 0x02}) {
+		t.Errorf("got % X, %v", resp, err)
+	}
+	if _, err := client.Request(ctx, []byte{0x23, 0x00}); !errors.Is(err, uds.ServiceNotSupported) {
+		t.Errorf("expected ServiceNotSupported, got %v", err)
+	}
+	if err := client.CommunicationControl(ctx, uds.DisableRxAndTx, uds.AllCommunication); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if control, comm := server.Communication(); control != uds.DisableRxAndTx || comm != uds.AllCommunication {
+		t.Errorf("got %v %v", control, comm)
+	}
+}
+
+func TestFunctional(t *testing.T) {
+	_, _ = auxServer(t, auxParse(t))
+	raw := auxBus(t)
+	ctx := auxContext(t)
+
+	functional, err := isotp.NewConn(raw, isotp.Address{TxID: 0x7DF, RxID: 0x7E8}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer functional.Close()
+
+	// unsupported services are not answered on functional requests
+	functional.Send([]byte{0x23, 0x00})
+	functional.Send([]byte{0x3E, 0x00})
+	if resp, err := functional.Recv(ctx); err != nil || !bytes.Equal(resp, []byte{0x7E, 0x00}) {
+		t.Errorf("got % X, %v", resp, err)
+	}
+	// suppressed positive response
+	functional.Send([]byte{0x3E, 0x80})
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if resp, err := functional.Recv(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected no response, got % X, %v", resp, err)
+	}
+}
@@ -0,0 +1,296 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/morgadow/gocan"
+	"github.com/morgadow/gocan/interfaces/virtual"
+	"github.com/morgadow/gocan/isotp"
+	"github.com/morgadow/gocan/uds"
+)
+
+// Handles a request of the test server, responses are sent with send
+type auxHandler func(req []byte, send func(resp []byte))
+
+func auxConn(t *testing.T, addr isotp.Address) *isotp.Conn {
+	bus, err := virtual.NewVirtualBus(&gocan.Config{BusType: "virtual", Channel: t.Name()})
+	if err != nil {
+		t.Fatalf("error while creating bus: %v", err)
+	}
+	c, err := isotp.NewConn(bus, addr, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		bus.Close()
+	})
+	return c
+}
+
+// Creates a client and a server answering requests with handler
+func auxClient(t *testing.T, cfg *uds.Config, handler auxHandler) *uds.Client {
+	server := auxConn(t, isotp.Address{TxID: 0x7E8, RxID: 0x7E0})
+	client := auxConn(t, isotp.Address{TxID: 0x7E0, RxID: 0x7E8})
+	go func() {
+		for {
+			req, err := server.Recv(context.Background())
+			if err != nil {
+				return
+			}
+			handler(req, func(resp []byte) { server.Send(resp) })
+		}
+	}()
+	return uds.NewClient(client, cfg)
+}
+
+func auxContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestServices(t *testing.T) {
+	var requests [][]byte
+	client := auxClient(t, nil, func(req []byte, send func([]byte)) {
+		requests = append(requests, req)
+		switch uds.ServiceID(req[0]) {
+		case uds.DiagnosticSessionControl:
+			send([]byte{0x50, req[1], 0x00, 0x32, 0x01, 0xF4})
+		case uds.ECUReset:
+			send([]byte{0x51, req[1]})
+		case uds.ReadDataByIdentifier:
+			send(append([]byte{0x62, req[1], req[2]}, "WVWZZZ1JZXW000001"...))
+		case uds.WriteDataByIdentifier:
+			send([]byte{0x6E, req[1], req[2]})
+		case uds.RoutineControl:
+			send([]byte{0x71, req[1], req[2], req[3], 0x00})
+		case uds.ReadDTCInformation:
+			if req[1] == 0x01 {
+				send([]byte{0x59, 0x01, 0xFF, 0x01, 0x00, 0x02})
+			} else {
+				send([]byte{0x59, req[1], 0xFF, 0x12, 0x34, 0x56, 0x09, 0xC0, 0x73, 0x00, 0x2F})
+			}
+		case uds.ClearDiagnosticInfo:
+			send([]byte{0x54})
+		case uds.TesterPresent, uds.CommunicationControl:
+			send([]byte{req[0] + 0x40, req[1]})
+		}
+	})
+	ctx := auxContext(t)
+
+	timing, err := client.DiagnosticSessionControl(ctx, uds.ExtendedDiagnosticSession)
+	if err != nil || timing.P2 != 50*time.Millisecond || timing.P2Star != 5*time.Second {
+		t.Errorf("got %+v, %v", timing, err)
+	}
+	if _, err := client.ECUReset(ctx, uds.SoftReset); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if vin, err := client.ReadDataByIdentifier(ctx, 0xF190); err != nil || string(vin) != "WVWZZZ1JZXW000001" {
+		t.Errorf("got %q, %v", vin, err)
+	}
+	if err := client.WriteDataByIdentifier(ctx, 0xF198, []byte{1, 2, 3}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if status, err := client.RoutineControl(ctx, uds.StartRoutine, 0xFF00, []byte{0xAA}); err != nil || !bytes.Equal(status, []byte{0x00}) {
+		t.Errorf("got % X, %v", status, err)
+	}
+	if n, err := client.ReadNumberOfDTCByStatusMask(ctx, uds.ConfirmedDTC); err != nil || n != 2 {
+		t.Errorf("got %v, %v", n, err)
+	}
+	dtcs, err := client.ReadDTCByStatusMask(ctx, uds.ConfirmedDTC|uds.TestFailed)
+	expected := []uds.DTC{{Code: 0x123456, Status: 0x09}, {Code: 0xC07300, Status: 0x2F}}
+	if err != nil || len(dtcs) != 2 || dtcs[0] != expected[0] || dtcs[1] != expected[1] {
+		t.Errorf("got %v, %v, expected %v", dtcs, err, expected)
+	}
+	if err := client.ClearDTC(ctx, uds.AllDTCs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := client.TesterPresent(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := client.CommunicationControl(ctx, uds.EnableRxAndDisableTx, uds.NormalCommunication); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	expectedRequests := [][]byte{
+		{0x10, 0x03},
+		{0x11, 0x03},
+		{0x22, 0xF1, 0x90},
+		{0x2E, 0xF1, 0x98, 1, 2, 3},
+		{0x31, 0x01, 0xFF, 0x00, 0xAA},
+		{0x19, 0x01, 0x08},
+		{0x19, 0x02, 0x09},
+		{0x14, 0xFF, 0xFF, 0xFF},
+		{0x3E, 0x00},
+		{0x28, 0x01, 0x01},
+	}
+	for i, req := range expectedRequests {
+		if i >= len(requests) || !bytes.Equal(requests[i], req) {
+			t.Errorf("request %v: got % X, expected % X", i, requests[min(i, len(requests)-1)], req)
+		}
+	}
+}
+
+func TestNegativeResponse(t *testing.T) {
+	client := auxClient(t, &uds.Config{P2: 20 * time.Millisecond, P2Star: 200 * time.Millisecond, MaxPending: 3}, func(req []byte, send func([]byte)) {
+		switch binary.BigEndian.Uint16(req[1:3]) {
+		case 0x0001:
+			send([]byte{0x7F, 0x22, 0x31})
+		case 0x0002:
+			// response pending beyond P2, answered within P2*
+			for range 2 {
+				send([]byte{0x7F, 0x22, 0x78})
+				time.Sleep(50 * time.Millisecond)
+			}
+			send([]byte{0x62, 0x00, 0x02, 0xAB})
+		case 0x0003:
+			for range 5 {
+				send([]byte{0x7F, 0x22, 0x78})
+			}
+		case 0x0004:
+			// response to another service is skipped
+			send([]byte{0x7F, 0x2E, 0x31})
+			send([]byte{0x62, 0x00, 0x04, 0xCD})
+		}
+	})
+	ctx := auxContext(t)
+
+	_, err := client.ReadDataByIdentifier(ctx, 0x0001)
+	var nrc *uds.NegativeResponseError
+	if !errors.As(err, &nrc) || nrc.Service != uds.ReadDataByIdentifier || !errors.Is(err, uds.RequestOutOfRange) {
+		t.Errorf("expected request out of range, got %v", err)
+	}
+	if data, err := client.ReadDataByIdentifier(ctx, 0x0002); err != nil || !bytes.Equal(data, []byte{0xAB}) {
+		t.Errorf("got % X, %v", data, err)
+	}
+	if _, err := client.ReadDataByIdentifier(ctx, 0x0003); !errors.Is(err, uds.ResponsePending) {
+		t.Errorf("expected response pending after MaxPending, got %v", err)
+	}
+	if data, err := client.ReadDataByIdentifier(ctx, 0x0004); err != nil || !bytes.Equal(data, []byte{0xCD}) {
+		t.Errorf("got % X, %v", data, err)
+	}
+	start := time.Now()
+	if _, err := client.ReadDataByIdentifier(ctx, 0x0005); !errors.Is(err, uds.ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 150*time.Millisecond {
+		t.Errorf("timeout after %v, expected P2 of 20ms", elapsed)
+	}
+}
+
+func TestSecurityAccess(t *testing.T) {
+	key := func(level uint8, seed []byte) ([]byte, error) {
+		return []byte{seed[0] ^ 0xFF, seed[1] ^ level}, nil
+	}
+	unlocked := false
+	client := auxClient(t, &uds.Config{Key: key}, func(req []byte, send func([]byte)) {
+		switch {
+		case req[1] == 0x01 && unlocked:
+			send([]byte{0x67, 0x01, 0x00, 0x00})
+		case req[1] == 0x01:
+			send([]byte{0x67, 0x01, 0x12, 0x34})
+		case req[1] == 0x02 && bytes.Equal(req[2:], []byte{0xED, 0x35}):
+			unlocked = true
+			send([]byte{0x67, 0x02})
+		default:
+			send([]byte{0x7F, 0x27, 0x35})
+		}
+	})
+	ctx := auxContext(t)
+
+	if err := client.SecurityAccess(ctx, 0x01); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x01); err != nil {
+		t.Errorf("unexpected error for unlocked level: %v", err)
+	}
+	if err := client.SecurityAccess(ctx, 0x02); !errors.Is(err, uds.ErrRequest) {
+		t.Errorf("expected ErrRequest for even level, got %v", err)
+	}
+	if err := uds.NewClient(nil, nil).SecurityAccess(ctx, 0x01); !errors.Is(err, uds.ErrNoKey) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+
+	t.Run("EmptySeed", func(t *testing.T) {
+		empty := auxClient(t, &uds.Config{Key: key}, func(req []byte, send func([]byte)) {
+			send([]byte{0x67, 0x01})
+		})
+		if err := empty.SecurityAccess(ctx, 0x01); !errors.Is(err, uds.ErrResponse) {
+			t.Errorf("expected ErrResponse for a response without seed, got %v", err)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		unlocked = false
+		wrong := auxClient(t, &uds.Config{Key: func(uint8, []byte) ([]byte, error) { return []byte{0, 0}, nil }}, func(req []byte, send func([]byte)) {
+			if req[1] == 0x01 {
+				send([]byte{0x67, 0x01, 0x12, 0x34})
+				return
+			}
+			send([]byte{0x7F, 0x27, 0x35})
+		})
+		if err := wrong.SecurityAccess(ctx, 0x01); !errors.Is(err, uds.InvalidKey) {
+			t.Errorf("expected InvalidKey, got %v", err)
+		}
+	})
+}
+
+func TestTesterPresent(t *testing.T) {
+	var count atomic.Int32
+	client := auxClient(t, nil, func(req []byte, send func([]byte)) {
+		if bytes.Equal(req, []byte{0x3E, 0x80}) {
+			count.Add(1)
+		}
+	})
+	client.StartTesterPresent(20 * time.Millisecond)
+	time.Sleep(110 * time.Millisecond)
+	client.StopTesterPresent()
+	n := count.Load()
+	time.Sleep(50 * time.Millisecond)
+	if n < 3 || count.Load() != n {
+		t.Errorf("got %v keep-alive requests and %v after stop", n, count.Load())
+	}
+}
+
+func TestSuppressPositiveResponse(t *testing.T) {
+	requests := make(chan []byte, 1)
+	client := auxClient(t, &uds.Config{P2: time.Second}, func(req []byte, send func([]byte)) {
+		requests <- req
+	})
+
+	// the server does not answer, the client returns without waiting for P2
+	start := time.Now()
+	if _, err := client.ECUReset(auxContext(t), uds.HardReset|uds.SuppressPositiveResponse); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %v for a suppressed response", elapsed)
+	}
+	select {
+	case req := <-requests:
+		if !bytes.Equal(req, []byte{0x11, 0x81}) {
+			t.Errorf("got request % X, expected 11 81", req)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("request not sent")
+	}
+}
+
+func TestTimingFromSession(t *testing.T) {
+	client := auxClient(t, &uds.Config{P2: 10 * time.Millisecond}, func(req []byte, send func([]byte)) {
+		send([]byte{0x50, req[1], 0x00, 0x64, 0x00, 0x0A})
+	})
+	if _, err := client.DiagnosticSessionControl(auxContext(t), uds.ProgrammingSession); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if timing := client.Timing(); timing.P2 != 100*time.Millisecond || timing.P2Star != uds.DefaultP2Star {
+		t.Errorf("got %+v, expected P2 100ms and default P2*", timing)
+	}
+}
@@ -0,0 +1,211 @@
+package uds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type ServiceID uint8
+type NRC uint8
+type Session uint8
+type ResetType uint8
+type RoutineControlType uint8
+type ControlType uint8
+type CommunicationType uint8
+type DTCReportType uint8
+type DTCStatus uint8
+
+// Service identifiers of requests, positive responses add PositiveResponse
+const (
+	DiagnosticSessionControl ServiceID = 0x10
+	ECUReset                 ServiceID = 0x11
+	ClearDiagnosticInfo      ServiceID = 0x14
+	ReadDTCInformation       ServiceID = 0x19
+	ReadDataByIdentifier     ServiceID = 0x22
+	SecurityAccess           ServiceID = 0x27
+	CommunicationControl     ServiceID = 0x28
+	WriteDataByIdentifier    ServiceID = 0x2E
+	RoutineControl           ServiceID = 0x31
+	RequestDownload          ServiceID = 0x34
+	RequestUpload            ServiceID = 0x35
+	TransferData             ServiceID = 0x36
+	RequestTransferExit      ServiceID = 0x37
+	TesterPresent            ServiceID = 0x3E
+	ControlDTCSetting        ServiceID = 0x85
+
+	NegativeResponse ServiceID = 0x7F // first byte of a negative response
+	PositiveResponse           = 0x40 // added to the service id in positive responses
+)
+
+// Flag of the sub-function byte requesting the server not to send a positive response
+// Client methods taking a sub-function return without waiting for a response if it is set, e.g. ECUReset(ctx, HardReset|SuppressPositiveResponse)
+const SuppressPositiveResponse = 0x80
+
+// Negative response codes
+const (
+	GeneralReject                          NRC = 0x10
+	ServiceNotSupported                    NRC = 0x11
+	SubFunctionNotSupported                NRC = 0x12
+	IncorrectMessageLengthOrFormat         NRC = 0x13
+	ResponseTooLong                        NRC = 0x14
+	BusyRepeatRequest                      NRC = 0x21
+	ConditionsNotCorrect                   NRC = 0x22
+	RequestSequenceError                   NRC = 0x24
+	NoResponseFromSubnetComponent          NRC = 0x25
+	FailurePreventsExecution               NRC = 0x26
+	RequestOutOfRange                      NRC = 0x31
+	SecurityAccessDenied                   NRC = 0x33
+	InvalidKey                             NRC = 0x35
+	ExceededNumberOfAttempts               NRC = 0x36
+	RequiredTimeDelayNotExpired            NRC = 0x37
+	UploadDownloadNotAccepted              NRC = 0x70
+	TransferDataSuspended                  NRC = 0x71
+	GeneralProgrammingFailure              NRC = 0x72
+	WrongBlockSequenceCounter              NRC = 0x73
+	ResponsePending                        NRC = 0x78
+	SubFunctionNotSupportedInActiveSession NRC = 0x7E
+	ServiceNotSupportedInActiveSession     NRC = 0x7F
+)
+
+var nrcNames = map[NRC]string{
+	GeneralReject:                          "general reject",
+	ServiceNotSupported:                    "service not supported",
+	SubFunctionNotSupported:                "sub-function not supported",
+	IncorrectMessageLengthOrFormat:         "incorrect message length or invalid format",
+	ResponseTooLong:                        "response too long",
+	BusyRepeatRequest:                      "busy repeat request",
+	ConditionsNotCorrect:                   "conditions not correct",
+	RequestSequenceError:                   "request sequence error",
+	NoResponseFromSubnetComponent:          "no response from subnet component",
+	FailurePreventsExecution:               "failure prevents execution of requested action",
+	RequestOutOfRange:                      "request out of range",
+	SecurityAccessDenied:                   "security access denied",
+	InvalidKey:                             "invalid key",
+	ExceededNumberOfAttempts:               "exceeded number of attempts",
+	RequiredTimeDelayNotExpired:            "required time delay not expired",
+	UploadDownloadNotAccepted:              "upload download not accepted",
+	TransferDataSuspended:                  "transfer data suspended",
+	GeneralProgrammingFailure:              "general programming failure",
+	WrongBlockSequenceCounter:              "wrong block sequence counter",
+	ResponsePending:                        "request correctly received, response pending",
+	SubFunctionNotSupportedInActiveSession: "sub-function not supported in active session",
+	ServiceNotSupportedInActiveSession:     "service not supported in active session",
+}
+
+func (n NRC) String() string {
+	if name, ok := nrcNames[n]; ok {
+		return name
+	}
+	return fmt.Sprintf("NRC 0x%02X", uint8(n))
+}
+
+// A NRC is an error, so errors.Is(err, uds.InvalidKey) matches negative responses
+func (n NRC) Error() string {
+	return n.String()
+}
+
+// Diagnostic sessions
+const (
+	DefaultSession            Session = 0x01
+	ProgrammingSession        Session = 0x02
+	ExtendedDiagnosticSession Session = 0x03
+	SafetySystemSession       Session = 0x04
+)
+
+// Reset types of ECUReset
+const (
+	HardReset                 ResetType = 0x01
+	KeyOffOnReset             ResetType = 0x02
+	SoftReset                 ResetType = 0x03
+	EnableRapidPowerShutDown  ResetType = 0x04
+	DisableRapidPowerShutDown ResetType = 0x05
+)
+
+// Sub-functions of RoutineControl
+const (
+	StartRoutine          RoutineControlType = 0x01
+	StopRoutine           RoutineControlType = 0x02
+	RequestRoutineResults RoutineControlType = 0x03
+)
+
+// Control types of CommunicationControl
+const (
+	EnableRxAndTx                               ControlType = 0x00
+	EnableRxAndDisableTx                        ControlType = 0x01
+	DisableRxAndEnableTx                        ControlType = 0x02
+	DisableRxAndTx                              ControlType = 0x03
+	EnableRxAndDisableTxWithEnhancedAddressInfo ControlType = 0x04
+	EnableRxAndTxWithEnhancedAddressInfo        ControlType = 0x05
+)
+
+// Communication types of CommunicationControl, may be combined
+const (
+	NormalCommunication            CommunicationType = 0x01
+	NetworkManagementCommunication CommunicationType = 0x02
+	AllCommunication               CommunicationType = 0x03
+)
+
+// Sub-functions of ReadDTCInformation
+const (
+	ReportNumberOfDTCByStatusMask DTCReportType = 0x01
+	ReportDTCByStatusMask         DTCReportType = 0x02
+	ReportSupportedDTC            DTCReportType = 0x0A
+)
+
+// Status bits of a DTC
+const (
+	TestFailed                         DTCStatus = 0x01
+	TestFailedThisOperationCycle       DTCStatus = 0x02
+	PendingDTC                         DTCStatus = 0x04
+	ConfirmedDTC                       DTCStatus = 0x08
+	TestNotCompletedSinceLastClear     DTCStatus = 0x10
+	TestFailedSinceLastClear           DTCStatus = 0x20
+	TestNotCompletedThisOperationCycle DTCStatus = 0x40
+	WarningIndicatorRequested          DTCStatus = 0x80
+)
+
+// addressAndLengthFormatIdentifier of 4 byte memory size and 4 byte memory address
+const AddressAndLengthFormat = 0x44
+
+// Group of ClearDTC selecting all DTCs
+const AllDTCs = 0xFFFFFF
+
+// errors
+var (
+	ErrTimeout  = errors.New("no response within P2 timeout")
+	ErrRequest  = errors.New("invalid request")
+	ErrResponse = errors.New("invalid response")
+	ErrNoKey    = errors.New("no key function")
+)
+
+// NegativeResponseError is returned for a negative response of the server
+type NegativeResponseError struct {
+	Service ServiceID
+	Code    NRC
+}
+
+func (e *NegativeResponseError) Error() string {
+	return fmt.Sprintf("negative response to service 0x%02X: %v (0x%02X)", uint8(e.Service), e.Code, uint8(e.Code))
+}
+
+// Returns the negative response code
+func (e *NegativeResponseError) Unwrap() error {
+	return e.Code
+}
+
+// Transport carrying requests and responses, implemented by isotp.Conn
+type Transport interface {
+	Send(data []byte) error
+	Recv(ctx context.Context) ([]byte, error)
+}
+
+// Diagnostic trouble code with its status byte
+type DTC struct {
+	Code   uint32 // 3 byte DTC number
+	Status DTCStatus
+}
+
+func (d DTC) String() string {
+	return fmt.Sprintf("%06X (status 0x%02X)", d.Code, uint8(d.Status))
+}